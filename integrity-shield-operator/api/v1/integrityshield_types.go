@@ -24,6 +24,7 @@ import (
 const (
 	DefaultIShieldWebhookTimeout = 10
 	DefaultIShieldAPILabel       = "integrity-shield-api"
+	DefaultObserverPort          = 8080
 
 	CleanupFinalizerName = "cleanup.finalizers.integrityshield.io"
 	CsvPath              = "./bundle/manifests/integrity-shield-operator.clusterserviceversion.yaml"
@@ -117,19 +118,31 @@ type LogConfig struct {
 }
 
 type Observer struct {
-	Enabled                bool                    `json:"enabled,omitempty"`
-	Name                   string                  `json:"name,omitempty"`
-	SelectorLabels         map[string]string       `json:"selector,omitempty"`
-	ImagePullPolicy        v1.PullPolicy           `json:"imagePullPolicy,omitempty"`
-	Image                  string                  `json:"image,omitempty"`
-	Tag                    string                  `json:"imageTag,omitempty"`
-	SecurityContext        *v1.SecurityContext     `json:"securityContext,omitempty"`
-	LogLevel               string                  `json:"logLevel,omitempty"`
-	Interval               string                  `json:"interval,omitempty"`
-	ExportDetailResult     bool                    `json:"exportDetailResult,omitempty"`
-	Provenanece            bool                    `json:"provenanece,omitempty"`
+	Enabled         bool                `json:"enabled,omitempty"`
+	Name            string              `json:"name,omitempty"`
+	SelectorLabels  map[string]string   `json:"selector,omitempty"`
+	ImagePullPolicy v1.PullPolicy       `json:"imagePullPolicy,omitempty"`
+	Image           string              `json:"image,omitempty"`
+	Tag             string              `json:"imageTag,omitempty"`
+	SecurityContext *v1.SecurityContext `json:"securityContext,omitempty"`
+	// +kubebuilder:default=8080
+	Port int32 `json:"port,omitempty"`
+	// secret of the certificate for the observer api; "<name>-tls" if empty
+	TlsSecretName string `json:"tlsSecretName,omitempty"`
+	LogLevel      string `json:"logLevel,omitempty"`
+	// default schedule of observer scans; minutes (e.g. "5"), a duration (e.g. "10m") or a cron expression (e.g. "0 2 * * *")
+	Interval             string `json:"interval,omitempty"`
+	ExportDetailResult   bool   `json:"exportDetailResult,omitempty"`
+	CompressDetailResult bool   `json:"compressDetailResult,omitempty"`
+	Provenanece          bool   `json:"provenanece,omitempty"`
+	// restore resources which fail verification. The observer is allowed to patch any resource in the cluster,
+	// because the kinds matched by constraints are not known in advance; enable it only if the observer is as trusted as cluster admins.
+	EnableRemediation      bool                    `json:"enableRemediation,omitempty"`
 	ResultDetailConfigName string                  `json:"resultDetailConfigName,omitempty"`
 	ResultDetailConfigKey  string                  `json:"resultDetailConfigKey,omitempty"`
+	ConfigName             string                  `json:"configName,omitempty"`
+	ConfigKey              string                  `json:"configKey,omitempty"`
+	Config                 string                  `json:"config,omitempty"`
 	Resources              v1.ResourceRequirements `json:"resources,omitempty"`
 }
 
@@ -137,8 +150,18 @@ type Observer struct {
 type IntegrityShieldStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// condition types of IntegrityShield
+const (
+	// False if the observer interval is invalid; the observer is not deployed until it is fixed
+	ConditionObserverIntervalValid = "ObserverIntervalValid"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 
@@ -26,12 +26,14 @@ import (
 	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	cert "github.com/IBM/integrity-shield/integrity-shield-operator/cert"
 	res "github.com/IBM/integrity-shield/integrity-shield-operator/resources"
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
 	templatev1 "github.com/open-policy-agent/frameworks/constraint/pkg/apis/templates/v1beta1"
 	admregv1 "k8s.io/api/admissionregistration/v1"
 	appsv1 "k8s.io/api/apps/v1"
@@ -147,6 +149,18 @@ func (r *IntegrityShieldReconciler) deleteObserverResultCRD(
 	return r.deleteCRD(instance, expected)
 }
 
+func (r *IntegrityShieldReconciler) createOrUpdateObserverSummaryCRD(
+	instance *apiv1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildObserverSummaryCRD(instance)
+	return r.createOrUpdateCRD(instance, expected)
+}
+
+func (r *IntegrityShieldReconciler) deleteObserverSummaryCRD(
+	instance *apiv1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildObserverSummaryCRD(instance)
+	return r.deleteCRD(instance, expected)
+}
+
 /**********************************************
 
 				ConfigMap
@@ -218,6 +232,12 @@ func (r *IntegrityShieldReconciler) createOrUpdateACConfig(
 	return r.createOrUpdateConfigMap(instance, expected)
 }
 
+func (r *IntegrityShieldReconciler) createOrUpdateObserverConfig(
+	instance *apiv1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildObserverConfigForIShield(instance)
+	return r.createOrUpdateConfigMap(instance, expected)
+}
+
 /**********************************************
 
 				Role
@@ -300,6 +320,16 @@ func (r *IntegrityShieldReconciler) createOrUpdateClusterRole(instance *apiv1.In
 		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 1}, nil
 	} else if err != nil {
 		return ctrl.Result{}, err
+	} else {
+		// rules depend on the CR (e.g. observer side effects)
+		if !reflect.DeepEqual(expected.Rules, found.Rules) {
+			found.Rules = expected.Rules
+			err = r.Update(ctx, found)
+			if err != nil {
+				reqLogger.Error(err, "Failed to update the resource")
+				return ctrl.Result{}, err
+			}
+		}
 	}
 
 	// No extra validation
@@ -742,6 +772,14 @@ func (r *IntegrityShieldReconciler) createOrUpdateACTlsSecret(
 	return r.createOrUpdateSecret(instance, expected)
 }
 
+// observer
+func (r *IntegrityShieldReconciler) createOrUpdateObserverTlsSecret(
+	instance *apiv1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildObserverTlsSecretForIShield(instance)
+	expected = addCertValues(instance, expected, instance.Spec.Observer.Name)
+	return r.createOrUpdateSecret(instance, expected)
+}
+
 /**********************************************
 
 				Deployment
@@ -872,6 +910,11 @@ func (r *IntegrityShieldReconciler) createOrUpdateAPIService(instance *apiv1.Int
 	return r.createOrUpdateService(instance, expected)
 }
 
+func (r *IntegrityShieldReconciler) createOrUpdateObserverService(instance *apiv1.IntegrityShield) (ctrl.Result, error) {
+	expected := res.BuildObserverServiceForIShield(instance)
+	return r.createOrUpdateService(instance, expected)
+}
+
 /**********************************************
 
 				Webhook
@@ -907,7 +950,9 @@ func (r *IntegrityShieldReconciler) createOrUpdateWebhook(instance *apiv1.Integr
 		}
 		cabundle, ok := secret.Data["ca.crt"]
 		if ok {
-			expected.Webhooks[0].ClientConfig.CABundle = cabundle
+			for i := range expected.Webhooks {
+				expected.Webhooks[i].ClientConfig.CABundle = cabundle
+			}
 		}
 
 		err = r.Create(ctx, expected)
@@ -930,7 +975,29 @@ func (r *IntegrityShieldReconciler) createOrUpdateWebhook(instance *apiv1.Integr
 		return ctrl.Result{}, err
 	}
 
-	// No extra validation
+	// failure policy follows the admission controller config, and webhooks changed in a newer version are updated.
+	// The update waits for the new admission controller pods, because the old ones may not serve the new paths.
+	if res.ValidatingWebhooksChanged(found.Webhooks, expected.Webhooks) {
+		if !r.isDeploymentRolledOut(instance) {
+			reqLogger.Info("Waiting for the admission controller to be rolled out before updating the webhook")
+			return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 5}, nil
+		}
+		cabundle := []byte{}
+		if len(found.Webhooks) != 0 {
+			cabundle = found.Webhooks[0].ClientConfig.CABundle
+		}
+		for i := range expected.Webhooks {
+			expected.Webhooks[i].ClientConfig.CABundle = cabundle
+		}
+		found.Webhooks = expected.Webhooks
+		err = r.Update(ctx, found)
+		if err != nil {
+			reqLogger.Error(err, "Failed to update the webhook")
+			return ctrl.Result{}, err
+		}
+		reqLogger.Info("Updated the webhook", "FailurePolicy", *expected.Webhooks[0].FailurePolicy)
+		return ctrl.Result{Requeue: true}, nil
+	}
 
 	// No reconcile was necessary
 	return ctrl.Result{}, nil
@@ -964,6 +1031,115 @@ func (r *IntegrityShieldReconciler) deleteWebhook(instance *apiv1.IntegrityShiel
 	}
 }
 
+func (r *IntegrityShieldReconciler) createOrUpdateMutatingWebhook(instance *apiv1.IntegrityShield) (ctrl.Result, error) {
+	ctx := context.Background()
+	expected := res.BuildMutatingWebhookConfigurationForIShield(instance)
+	found := &admregv1.MutatingWebhookConfiguration{}
+
+	reqLogger := r.Log.WithValues(
+		"Instance.Name", instance.Name,
+		"MutatingWebhookConfiguration.Name", expected.Name)
+
+	// Set CR instance as the owner and controller
+	err := controllerutil.SetControllerReference(instance, expected, r.Scheme)
+	if err != nil {
+		reqLogger.Error(err, "Failed to define expected resource")
+		return ctrl.Result{}, err
+	}
+
+	err = r.Get(ctx, types.NamespacedName{Name: expected.Name}, found)
+
+	if err != nil && errors.IsNotFound(err) {
+		reqLogger.Info("Creating a new resource")
+		// locad cabundle
+		secret := &corev1.Secret{}
+		err = r.Get(ctx, types.NamespacedName{Name: instance.Spec.WebhookServerTlsSecretName, Namespace: instance.Namespace}, secret)
+		if err != nil {
+			reqLogger.Error(err, "Fail to load CABundle from Secret")
+		}
+		cabundle, ok := secret.Data["ca.crt"]
+		if ok {
+			for i := range expected.Webhooks {
+				expected.Webhooks[i].ClientConfig.CABundle = cabundle
+			}
+		}
+
+		err = r.Create(ctx, expected)
+		if err != nil && errors.IsAlreadyExists(err) {
+			// Already exists from previous reconcile, requeue.
+			reqLogger.Info("Skip reconcile: resource already exists")
+			return ctrl.Result{Requeue: true}, nil
+		} else if err != nil {
+			reqLogger.Error(err, "Failed to create new resource")
+			return ctrl.Result{}, err
+		}
+		// Created successfully - return and requeue
+
+		reqLogger.Info("Mutating webhook has been created.", "Name", instance.Name)
+		evtName := fmt.Sprintf("ishield-mutating-webhook-reconciled")
+		_ = r.createOrUpdateWebhookEvent(instance, evtName, expected.Name)
+
+		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 1}, nil
+	} else if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// webhooks changed in a newer version are updated after the new admission controller pods are available
+	if res.MutatingWebhooksChanged(found.Webhooks, expected.Webhooks) {
+		if !r.isDeploymentRolledOut(instance) {
+			reqLogger.Info("Waiting for the admission controller to be rolled out before updating the mutating webhook")
+			return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 5}, nil
+		}
+		cabundle := []byte{}
+		if len(found.Webhooks) != 0 {
+			cabundle = found.Webhooks[0].ClientConfig.CABundle
+		}
+		for i := range expected.Webhooks {
+			expected.Webhooks[i].ClientConfig.CABundle = cabundle
+		}
+		found.Webhooks = expected.Webhooks
+		err = r.Update(ctx, found)
+		if err != nil {
+			reqLogger.Error(err, "Failed to update the mutating webhook")
+			return ctrl.Result{}, err
+		}
+		reqLogger.Info("Updated the mutating webhook")
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// No reconcile was necessary
+	return ctrl.Result{}, nil
+
+}
+
+// delete mutating webhookconfiguration
+func (r *IntegrityShieldReconciler) deleteMutatingWebhook(instance *apiv1.IntegrityShield) (ctrl.Result, error) {
+	ctx := context.Background()
+	expected := res.BuildMutatingWebhookConfigurationForIShield(instance)
+	found := &admregv1.MutatingWebhookConfiguration{}
+
+	reqLogger := r.Log.WithValues(
+		"Instance.Name", instance.Name,
+		"MutatingWebhookConfiguration.Name", expected.Name)
+
+	err := r.Get(ctx, types.NamespacedName{Name: expected.Name}, found)
+
+	if err == nil {
+		reqLogger.Info("Deleting the IShield mutating webhook")
+		err = r.Delete(ctx, found)
+		if err != nil {
+			reqLogger.Error(err, "Failed to delete the IShield mutating webhook")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 1}, nil
+	} else if errors.IsNotFound(err) {
+		// signature injection is disabled in most cases, so do not requeue here
+		return ctrl.Result{}, nil
+	} else {
+		return ctrl.Result{}, err
+	}
+}
+
 // wait function
 func (r *IntegrityShieldReconciler) isDeploymentAvailable(instance *apiv1.IntegrityShield) bool {
 	ctx := context.Background()
@@ -985,6 +1161,31 @@ func (r *IntegrityShieldReconciler) isDeploymentAvailable(instance *apiv1.Integr
 	return false
 }
 
+// isDeploymentRolledOut returns true if the admission controller is available and all of its pods run the latest spec
+func (r *IntegrityShieldReconciler) isDeploymentRolledOut(instance *apiv1.IntegrityShield) bool {
+	if !r.isDeploymentAvailable(instance) {
+		return false
+	}
+	ctx := context.Background()
+	found := &appsv1.Deployment{}
+	expected := res.BuildDeploymentForAdmissionController(instance)
+	err := r.Get(ctx, types.NamespacedName{Name: expected.Name, Namespace: expected.Namespace}, found)
+	if err != nil {
+		return false
+	}
+	if found.Status.ObservedGeneration < found.Generation {
+		return false
+	}
+	replicas := int32(1)
+	if found.Spec.Replicas != nil {
+		replicas = *found.Spec.Replicas
+	}
+	// old pods are terminated and new ones are available
+	return found.Status.UpdatedReplicas == replicas &&
+		found.Status.Replicas == replicas &&
+		found.Status.AvailableReplicas >= replicas
+}
+
 func (r *IntegrityShieldReconciler) createOrUpdateWebhookEvent(instance *apiv1.IntegrityShield, evtName, webhookName string) error {
 	ctx := context.Background()
 	evtNamespace := instance.Namespace
@@ -1157,3 +1358,49 @@ func (r *IntegrityShieldReconciler) deleteConstraintTemplate(instance *apiv1.Int
 		return ctrl.Result{}, err
 	}
 }
+
+/**********************************************
+
+				Status
+
+***********************************************/
+
+// setStatusCondition updates the condition in the status of the instance if it is changed.
+func (r *IntegrityShieldReconciler) setStatusCondition(instance *apiv1.IntegrityShield, condition metav1.Condition) error {
+	condition.ObservedGeneration = instance.Generation
+	current := meta.FindStatusCondition(instance.Status.Conditions, condition.Type)
+	if current != nil && current.Status == condition.Status && current.Reason == condition.Reason &&
+		current.Message == condition.Message && current.ObservedGeneration == condition.ObservedGeneration {
+		return nil
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, condition)
+	return r.Status().Update(context.Background(), instance)
+}
+
+// validateObserverInterval checks the observer interval with the same parser as the observer,
+// and records the result in the status condition.
+func (r *IntegrityShieldReconciler) validateObserverInterval(instance *apiv1.IntegrityShield) (bool, error) {
+	reqLogger := r.Log.WithValues("Instance.Name", instance.Name)
+	condition := metav1.Condition{
+		Type:    apiv1.ConditionObserverIntervalValid,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Valid",
+		Message: "observer interval is valid",
+	}
+	interval := instance.Spec.Observer.Interval
+	valid := true
+	if interval != "" {
+		if _, err := k8smnfconfig.ParseSchedule(interval); err != nil {
+			reqLogger.Error(err, "Invalid observer interval")
+			valid = false
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = "InvalidInterval"
+			condition.Message = fmt.Sprintf("observer interval `%s` is invalid: %s", interval, err.Error())
+		}
+	}
+	if err := r.setStatusCondition(instance, condition); err != nil {
+		reqLogger.Error(err, "Failed to update the status")
+		return valid, err
+	}
+	return valid, nil
+}
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	apiv1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1"
+	res "github.com/IBM/integrity-shield/integrity-shield-operator/resources"
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -43,11 +44,12 @@ var log = logf.Log.WithName("controller_integrityshield")
 //+kubebuilder:rbac:groups=apis.integrityshield.io,resources=integrityshields/finalizers,verbs=update
 // +kubebuilder:rbac:groups=core,resources=services;serviceaccounts;events;configmaps;secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=apis.integrityshield.io,resources=integrityshields;integrityshields/finalizers;manifestintegrityprofiles,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apis.integrityshield.io,resources=integrityshields;integrityshields/finalizers;manifestintegrityprofiles;manifestintegrityprofiles/status,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=*
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings;roles;rolebindings,verbs=*
 // +kubebuilder:rbac:groups=policy,resources=podsecuritypolicies,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=validatingwebhookconfigurations,verbs=*
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=*
 // +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update;delete
 // +kubebuilder:rbac:groups=templates.gatekeeper.sh,resources=constrainttemplates,verbs=get;list;watch;create;update;delete
 
@@ -143,38 +145,7 @@ func (r *IntegrityShieldReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 
 	// Observer
 	if instance.Spec.Observer.Enabled {
-		//CRD
-		recResult, recErr = r.createOrUpdateObserverResultCRD(instance)
-		if recErr != nil || recResult.Requeue {
-			return recResult, recErr
-		}
-		//Service Account
-		recResult, recErr = r.createOrUpdateObserverServiceAccount(instance)
-		if recErr != nil || recResult.Requeue {
-			return recResult, recErr
-		}
-		//Cluster Role
-		recResult, recErr = r.createOrUpdateClusterRoleForObserver(instance)
-		if recErr != nil || recResult.Requeue {
-			return recResult, recErr
-		}
-		//Cluster Role Binding
-		recResult, recErr = r.createOrUpdateClusterRoleBindingForObserver(instance)
-		if recErr != nil || recResult.Requeue {
-			return recResult, recErr
-		}
-		//Role
-		recResult, recErr = r.createOrUpdateRoleForObserver(instance)
-		if recErr != nil || recResult.Requeue {
-			return recResult, recErr
-		}
-		//Role Binding
-		recResult, recErr = r.createOrUpdateRoleBindingForObserver(instance)
-		if recErr != nil || recResult.Requeue {
-			return recResult, recErr
-		}
-		//Deployment
-		recResult, recErr = r.createOrUpdateObserverDeployment(instance)
+		recResult, recErr = r.reconcileObserver(instance)
 		if recErr != nil || recResult.Requeue {
 			return recResult, recErr
 		}
@@ -245,7 +216,20 @@ func (r *IntegrityShieldReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 			if recErr != nil || recResult.Requeue {
 				return recResult, recErr
 			}
+			// Mutating Webhook Configuration for signature injection
+			if res.IsSignatureInjectionEnabled(instance) {
+				recResult, recErr = r.createOrUpdateMutatingWebhook(instance)
+			} else {
+				recResult, recErr = r.deleteMutatingWebhook(instance)
+			}
+			if recErr != nil || recResult.Requeue {
+				return recResult, recErr
+			}
 		} else {
+			recResult, recErr = r.deleteMutatingWebhook(instance)
+			if recErr != nil || recResult.Requeue {
+				return recResult, recErr
+			}
 			recResult, recErr = r.deleteWebhook(instance)
 			if recErr != nil || recResult.Requeue {
 				return recResult, recErr
@@ -260,6 +244,75 @@ func (r *IntegrityShieldReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 	return ctrl.Result{}, nil
 }
 
+// reconcileObserver reconciles the observer resources.
+// An invalid interval is reported in the status and not requeued; only the observer resources wait until the spec is fixed.
+func (r *IntegrityShieldReconciler) reconcileObserver(instance *apiv1.IntegrityShield) (ctrl.Result, error) {
+	valid, err := r.validateObserverInterval(instance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !valid {
+		return ctrl.Result{}, nil
+	}
+	//CRD
+	recResult, recErr := r.createOrUpdateObserverResultCRD(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	recResult, recErr = r.createOrUpdateObserverSummaryCRD(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	//Service Account
+	recResult, recErr = r.createOrUpdateObserverServiceAccount(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	//Cluster Role
+	recResult, recErr = r.createOrUpdateClusterRoleForObserver(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	//Cluster Role Binding
+	recResult, recErr = r.createOrUpdateClusterRoleBindingForObserver(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	//Role
+	recResult, recErr = r.createOrUpdateRoleForObserver(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	//Role Binding
+	recResult, recErr = r.createOrUpdateRoleBindingForObserver(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	//Observer Config
+	if instance.Spec.Observer.ConfigName != "" {
+		recResult, recErr = r.createOrUpdateObserverConfig(instance)
+		if recErr != nil || recResult.Requeue {
+			return recResult, recErr
+		}
+	}
+	//Secret
+	recResult, recErr = r.createOrUpdateObserverTlsSecret(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	//Deployment
+	recResult, recErr = r.createOrUpdateObserverDeployment(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	//Service
+	recResult, recErr = r.createOrUpdateObserverService(instance)
+	if recErr != nil || recResult.Requeue {
+		return recResult, recErr
+	}
+	return ctrl.Result{}, nil
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *IntegrityShieldReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
@@ -295,6 +348,10 @@ func (r *IntegrityShieldReconciler) deleteClusterScopedChildrenResources(instanc
 		if err != nil {
 			return err
 		}
+		_, err = r.deleteMutatingWebhook(instance)
+		if err != nil {
+			return err
+		}
 		// CRD
 		_, err = r.deleteManifestIntegrityProfileCRD(instance)
 		if err != nil {
@@ -319,6 +376,10 @@ func (r *IntegrityShieldReconciler) deleteClusterScopedChildrenResources(instanc
 		if err != nil {
 			return err
 		}
+		_, err = r.deleteObserverSummaryCRD(instance)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -0,0 +1,126 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	apiv1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1"
+	res "github.com/IBM/integrity-shield/integrity-shield-operator/resources"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+// clusterScopedClient drops the namespace of cluster scope resources on creation as the API server does.
+type clusterScopedClient struct {
+	client.Client
+}
+
+func (c clusterScopedClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	switch obj.(type) {
+	case *rbacv1.ClusterRole, *rbacv1.ClusterRoleBinding, *apiextensionsv1.CustomResourceDefinition,
+		*admregv1.ValidatingWebhookConfiguration, *admregv1.MutatingWebhookConfiguration:
+		obj.SetNamespace("")
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestReconcileWebhookWithInvalidObserverInterval(t *testing.T) {
+	sample, err := ioutil.ReadFile(filepath.Join("..", "config", "samples", "apis_v1_integrityshield_ac.yaml"))
+	if err != nil {
+		t.Errorf("failed to read the sample CR: %s", err.Error())
+		return
+	}
+	instance := &apiv1.IntegrityShield{}
+	err = yaml.Unmarshal(sample, instance)
+	if err != nil {
+		t.Errorf("failed to unmarshal the sample CR: %s", err.Error())
+		return
+	}
+	instance.Namespace = "integrity-shield-operator-system"
+	instance.Spec.Observer.Enabled = true
+	instance.Spec.Observer.Interval = "every banana"
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = apiv1.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	r := &IntegrityShieldReconciler{
+		Client: clusterScopedClient{fake.NewClientBuilder().WithScheme(scheme).WithObjects(instance).Build()},
+		Log:    ctrl.Log.WithName("test"),
+		Scheme: scheme,
+	}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}}
+
+	webhook := &admregv1.ValidatingWebhookConfiguration{}
+	webhookName := res.BuildValidatingWebhookConfigurationForIShield(instance).Name
+	reconciled := false
+	for i := 0; i < 100 && !reconciled; i++ {
+		result, err := r.Reconcile(ctx, req)
+		if err != nil {
+			t.Errorf("failed to reconcile: %s", err.Error())
+			return
+		}
+		// the fake client does not run the admission controller, so mark it available
+		deploy := &appsv1.Deployment{}
+		expected := res.BuildDeploymentForAdmissionController(instance)
+		if err := r.Get(ctx, types.NamespacedName{Name: expected.Name, Namespace: expected.Namespace}, deploy); err == nil && deploy.Status.AvailableReplicas == 0 {
+			deploy.Status.Replicas = 1
+			deploy.Status.UpdatedReplicas = 1
+			deploy.Status.AvailableReplicas = 1
+			deploy.Status.ObservedGeneration = deploy.Generation
+			if err := r.Status().Update(ctx, deploy); err != nil {
+				t.Errorf("failed to update the deployment status: %s", err.Error())
+				return
+			}
+		}
+		reconciled = !result.Requeue
+	}
+
+	if err := r.Get(ctx, types.NamespacedName{Name: webhookName}, webhook); err != nil {
+		t.Errorf("the webhook is not reconciled with an invalid observer interval: %s", err.Error())
+		return
+	}
+	observer := &appsv1.Deployment{}
+	err = r.Get(ctx, types.NamespacedName{Name: instance.Spec.Observer.Name, Namespace: instance.Namespace}, observer)
+	if err == nil {
+		t.Errorf("the observer is deployed with an invalid interval")
+		return
+	}
+	found := &apiv1.IntegrityShield{}
+	if err := r.Get(ctx, req.NamespacedName, found); err != nil {
+		t.Errorf("failed to get the instance: %s", err.Error())
+		return
+	}
+	if !meta.IsStatusConditionFalse(found.Status.Conditions, apiv1.ConditionObserverIntervalValid) {
+		t.Errorf("the invalid observer interval is not reported in the status: %v", found.Status.Conditions)
+	}
+}
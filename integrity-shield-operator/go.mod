@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/IBM/integrity-shield/integrity-shield-operator v0.0.0-00010101000000-000000000000
+	github.com/IBM/integrity-shield/shield v0.0.0-00010101000000-000000000000
 	github.com/IBM/integrity-shield/webhook/admission-controller v0.0.0-00010101000000-000000000000
 	github.com/ghodss/yaml v1.0.0
 	github.com/go-logr/logr v0.4.0
@@ -17,6 +18,7 @@ require (
 	k8s.io/client-go v0.21.3
 	k8s.io/klog v1.0.0
 	sigs.k8s.io/controller-runtime v0.9.0
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
@@ -51,3 +51,18 @@ func BuildACConfigForIShield(cr *apiv1.IntegrityShield) *corev1.ConfigMap {
 	}
 	return cm
 }
+
+// observer config
+func BuildObserverConfigForIShield(cr *apiv1.IntegrityShield) *corev1.ConfigMap {
+	data := map[string]string{
+		cr.Spec.Observer.ConfigKey: cr.Spec.Observer.Config,
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cr.Spec.Observer.ConfigName,
+			Namespace: cr.Namespace,
+		},
+		Data: data,
+	}
+	return cm
+}
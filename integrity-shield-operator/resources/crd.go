@@ -68,7 +68,23 @@ func BuildManifestIntegrityProfileCRD(cr *apiv1.IntegrityShield) *extv1.CustomRe
 		Singular:   "manifestintegrityprofile",
 		ShortNames: []string{"mip", "mips"},
 	}
-	return buildCRD("manifestintegrityprofiles.apis.integrityshield.io", cr.Namespace, crdNames, false)
+	crd := buildCRD("manifestintegrityprofiles.apis.integrityshield.io", cr.Namespace, crdNames, false)
+	// unknown fields in profiles are preserved by the schema and rejected by the profile validation webhook
+	crd.Spec.Versions[0].Schema = &extv1.CustomResourceValidation{
+		OpenAPIV3Schema: manifestIntegrityProfileSchema(),
+	}
+	// status is written by the admission controller via the status subresource, so spec writers do not conflict with it
+	crd.Spec.Versions[0].Subresources = &extv1.CustomResourceSubresources{
+		Status: &extv1.CustomResourceSubresourceStatus{},
+	}
+	crd.Spec.Versions[0].AdditionalPrinterColumns = []extv1.CustomResourceColumnDefinition{
+		{Name: "Denied", Type: "integer", JSONPath: ".status.denyCount"},
+		{Name: "Allowed", Type: "integer", JSONPath: ".status.allowCount"},
+		{Name: "Verified", Type: "integer", JSONPath: ".status.verifiedCount", Priority: 1},
+		{Name: "Last Verified", Type: "string", JSONPath: ".status.lastVerifiedTime", Priority: 1},
+		{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
+	}
+	return crd
 }
 
 //shield config crd
@@ -80,5 +96,32 @@ func BuildObserverResultCRD(cr *apiv1.IntegrityShield) *extv1.CustomResourceDefi
 		Singular:   "manifestintegritystate",
 		ShortNames: []string{"mis"},
 	}
-	return buildCRD("manifestintegritystates.apis.integrityshield.io", cr.Namespace, crdNames, true)
+	crd := buildCRD("manifestintegritystates.apis.integrityshield.io", cr.Namespace, crdNames, true)
+	crd.Spec.Versions[0].AdditionalPrinterColumns = []extv1.CustomResourceColumnDefinition{
+		{Name: "Compliant", Type: "string", JSONPath: `.status.conditions[?(@.type=="Compliant")].status`},
+		{Name: "Violations", Type: "integer", JSONPath: ".spec.totalViolations"},
+		{Name: "Scan Failed", Type: "string", JSONPath: `.status.conditions[?(@.type=="ScanFailed")].status`, Priority: 1},
+		{Name: "Observed", Type: "string", JSONPath: ".spec.observationTime"},
+		{Name: "Age", Type: "date", JSONPath: ".metadata.creationTimestamp"},
+	}
+	return crd
+}
+
+// cluster-wide summary of the observer results
+func BuildObserverSummaryCRD(cr *apiv1.IntegrityShield) *extv1.CustomResourceDefinition {
+	crdNames := extv1.CustomResourceDefinitionNames{
+		Kind:       "ManifestIntegritySummary",
+		Plural:     "manifestintegritysummaries",
+		ListKind:   "ManifestIntegritySummaryList",
+		Singular:   "manifestintegritysummary",
+		ShortNames: []string{"missummary"},
+	}
+	crd := buildCRD("manifestintegritysummaries.apis.integrityshield.io", cr.Namespace, crdNames, false)
+	crd.Spec.Versions[0].AdditionalPrinterColumns = []extv1.CustomResourceColumnDefinition{
+		{Name: "Constraints", Type: "integer", JSONPath: ".spec.totalConstraints"},
+		{Name: "Violated", Type: "integer", JSONPath: ".spec.violatedConstraints"},
+		{Name: "Violations", Type: "integer", JSONPath: ".spec.totalViolations"},
+		{Name: "Last Scan", Type: "string", JSONPath: ".spec.lastScanTime"},
+	}
+	return crd
 }
@@ -36,6 +36,9 @@ import (
 
 var log = logf.Log.WithName("controller_integrityshield")
 
+// admissionControllerProbePort is the port of /healthz and /readyz of the admission controller
+const admissionControllerProbePort int32 = 8081
+
 //deployment
 // shield api
 func BuildDeploymentForIShieldAPI(cr *apiv1.IntegrityShield) *appsv1.Deployment {
@@ -115,6 +118,10 @@ func BuildDeploymentForIShieldAPI(cr *apiv1.IntegrityShield) *appsv1.Deployment
 				Name:  "REQUEST_HANDLER_CONFIG_NAME",
 				Value: cr.Spec.RequestHandlerConfigName,
 			},
+			{
+				Name:  "OBSERVER_SERVICE_ACCOUNT",
+				Value: observerServiceAccount(cr),
+			},
 		},
 		Resources: cr.Spec.API.Resources,
 	}
@@ -197,19 +204,17 @@ func BuildDeploymentForAdmissionController(cr *apiv1.IntegrityShield) *appsv1.De
 		ImagePullPolicy: cr.Spec.ControllerContainer.ImagePullPolicy,
 		ReadinessProbe: &v1.Probe{
 			Handler: v1.Handler{
-				Exec: &v1.ExecAction{
-					Command: []string{
-						"ls",
-					},
+				HTTPGet: &v1.HTTPGetAction{
+					Path: "/readyz",
+					Port: intstr.IntOrString{IntVal: admissionControllerProbePort},
 				},
 			},
 		},
 		LivenessProbe: &v1.Probe{
 			Handler: v1.Handler{
-				Exec: &v1.ExecAction{
-					Command: []string{
-						"ls",
-					},
+				HTTPGet: &v1.HTTPGetAction{
+					Path: "/healthz",
+					Port: intstr.IntOrString{IntVal: admissionControllerProbePort},
 				},
 			},
 		},
@@ -219,6 +224,11 @@ func BuildDeploymentForAdmissionController(cr *apiv1.IntegrityShield) *appsv1.De
 				ContainerPort: cr.Spec.ControllerContainer.Port,
 				Protocol:      v1.ProtocolTCP,
 			},
+			{
+				Name:          "probe-port",
+				ContainerPort: admissionControllerProbePort,
+				Protocol:      v1.ProtocolTCP,
+			},
 		},
 		VolumeMounts: servervolumemounts,
 		Env: []v1.EnvVar{
@@ -242,6 +252,10 @@ func BuildDeploymentForAdmissionController(cr *apiv1.IntegrityShield) *appsv1.De
 				Name:  "CONTROLLER_CONFIG_NAME",
 				Value: cr.Spec.AdmissionControllerConfigName,
 			},
+			{
+				Name:  "FAILURE_POLICY",
+				Value: AdmissionControllerFailurePolicy(cr),
+			},
 			{
 				Name:  "REQUEST_HANDLER_CONFIG_KEY",
 				Value: cr.Spec.RequestHandlerConfigKey,
@@ -250,6 +264,10 @@ func BuildDeploymentForAdmissionController(cr *apiv1.IntegrityShield) *appsv1.De
 				Name:  "REQUEST_HANDLER_CONFIG_NAME",
 				Value: cr.Spec.RequestHandlerConfigName,
 			},
+			{
+				Name:  "OBSERVER_SERVICE_ACCOUNT",
+				Value: observerServiceAccount(cr),
+			},
 		},
 		Resources: cr.Spec.ControllerContainer.Resources,
 	}
@@ -293,17 +311,36 @@ func BuildDeploymentForAdmissionController(cr *apiv1.IntegrityShield) *appsv1.De
 	}
 }
 
+// observerServiceAccount returns the user name of the observer, which is the only requester allowed to change the violation marker
+func observerServiceAccount(cr *apiv1.IntegrityShield) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", cr.Namespace, cr.Spec.Security.ObserverServiceAccountName)
+}
+
+// observerPort returns the port of the observer api, or the default one for CRs created before the field was added
+func observerPort(cr *apiv1.IntegrityShield) int32 {
+	if cr.Spec.Observer.Port == 0 {
+		return apiv1.DefaultObserverPort
+	}
+	return cr.Spec.Observer.Port
+}
+
 // Observer
 func BuildDeploymentForObserver(cr *apiv1.IntegrityShield) *appsv1.Deployment {
 	labels := cr.Spec.MetaLabels
 	volumes := []v1.Volume{
 		EmptyDirVolume("tmp"),
+		SecretVolume("observer-tls", ObserverTlsSecretName(cr)),
 	}
 	servervolumemounts := []v1.VolumeMount{
 		{
 			MountPath: "/tmp",
 			Name:      "tmp",
 		},
+		{
+			MountPath: "/run/secrets/tls",
+			Name:      "observer-tls",
+			ReadOnly:  true,
+		},
 	}
 
 	var image string
@@ -319,7 +356,36 @@ func BuildDeploymentForObserver(cr *apiv1.IntegrityShield) *appsv1.Deployment {
 		SecurityContext: cr.Spec.Observer.SecurityContext,
 		Image:           image,
 		ImagePullPolicy: cr.Spec.Observer.ImagePullPolicy,
-		VolumeMounts:    servervolumemounts,
+		ReadinessProbe: &v1.Probe{
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       10,
+			Handler: v1.Handler{
+				HTTPGet: &v1.HTTPGetAction{
+					Path:   "/health/readiness",
+					Port:   intstr.IntOrString{IntVal: observerPort(cr)},
+					Scheme: v1.URISchemeHTTPS,
+				},
+			},
+		},
+		LivenessProbe: &v1.Probe{
+			InitialDelaySeconds: 10,
+			PeriodSeconds:       10,
+			Handler: v1.Handler{
+				HTTPGet: &v1.HTTPGetAction{
+					Path:   "/health/liveness",
+					Port:   intstr.IntOrString{IntVal: observerPort(cr)},
+					Scheme: v1.URISchemeHTTPS,
+				},
+			},
+		},
+		Ports: []v1.ContainerPort{
+			{
+				Name:          "observer-api",
+				ContainerPort: observerPort(cr),
+				Protocol:      v1.ProtocolTCP,
+			},
+		},
+		VolumeMounts: servervolumemounts,
 		Env: []v1.EnvVar{
 			{
 				Name:  "POD_NAMESPACE",
@@ -345,6 +411,14 @@ func BuildDeploymentForObserver(cr *apiv1.IntegrityShield) *appsv1.Deployment {
 				Name:  "ENABLE_PROVENANCE_RESULT",
 				Value: strconv.FormatBool(cr.Spec.Observer.Provenanece),
 			},
+			{
+				Name:  "ENABLE_REMEDIATION",
+				Value: strconv.FormatBool(cr.Spec.Observer.EnableRemediation),
+			},
+			{
+				Name:  "COMPRESS_DETAIL_RESULT",
+				Value: strconv.FormatBool(cr.Spec.Observer.CompressDetailResult),
+			},
 			{
 				Name:  "OBSERVER_RESULT_CONFIG_NAME",
 				Value: cr.Spec.Observer.ResultDetailConfigName,
@@ -353,6 +427,18 @@ func BuildDeploymentForObserver(cr *apiv1.IntegrityShield) *appsv1.Deployment {
 				Name:  "OBSERVER_RESULT_CONFIG_KEY",
 				Value: cr.Spec.Observer.ResultDetailConfigKey,
 			},
+			{
+				Name:  "OBSERVER_CONFIG_NAME",
+				Value: cr.Spec.Observer.ConfigName,
+			},
+			{
+				Name:  "OBSERVER_CONFIG_KEY",
+				Value: cr.Spec.Observer.ConfigKey,
+			},
+			{
+				Name:  "OBSERVER_API_PORT",
+				Value: strconv.Itoa(int(observerPort(cr))),
+			},
 			{
 				Name:  "INTERVAL",
 				Value: cr.Spec.Observer.Interval,
@@ -385,6 +471,13 @@ func BuildDeploymentForObserver(cr *apiv1.IntegrityShield) *appsv1.Deployment {
 			Template: v1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: cr.Spec.Observer.SelectorLabels,
+					// observer serves prometheus metrics on the api port over TLS; scrapers need a token allowed to get /metrics
+					Annotations: map[string]string{
+						"prometheus.io/scrape": "true",
+						"prometheus.io/scheme": "https",
+						"prometheus.io/port":   strconv.Itoa(int(observerPort(cr))),
+						"prometheus.io/path":   "/metrics",
+					},
 				},
 				Spec: v1.PodSpec{
 					ServiceAccountName: cr.Spec.Security.ObserverServiceAccountName,
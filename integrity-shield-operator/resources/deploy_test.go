@@ -0,0 +1,119 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package resources
+
+import (
+	"fmt"
+	"testing"
+
+	apiv1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestObserverPort(t *testing.T) {
+	testcases := []struct {
+		name string
+		port int32
+		want int32
+	}{
+		{name: "CR without port", port: 0, want: apiv1.DefaultObserverPort},
+		{name: "CR with port", port: 9090, want: 9090},
+	}
+	for _, tc := range testcases {
+		cr := testIntegrityShield("")
+		cr.Spec.Observer.Name = "integrity-shield-observer"
+		cr.Spec.Observer.Port = tc.port
+
+		deploy := BuildDeploymentForObserver(cr)
+		container := deploy.Spec.Template.Spec.Containers[0]
+		if len(container.Ports) == 0 || container.Ports[0].ContainerPort != tc.want {
+			t.Errorf("%s: unexpected container ports: got: %v\nwant: %d", tc.name, container.Ports, tc.want)
+		}
+		for _, probe := range []*v1.Probe{container.ReadinessProbe, container.LivenessProbe} {
+			if probe.HTTPGet.Port.IntVal != tc.want {
+				t.Errorf("%s: unexpected probe port: got: %d\nwant: %d", tc.name, probe.HTTPGet.Port.IntVal, tc.want)
+			}
+			if probe.HTTPGet.Scheme != v1.URISchemeHTTPS {
+				t.Errorf("%s: unexpected probe scheme: got: %s\nwant: %s", tc.name, probe.HTTPGet.Scheme, v1.URISchemeHTTPS)
+			}
+		}
+		env := ""
+		for _, e := range container.Env {
+			if e.Name == "OBSERVER_API_PORT" {
+				env = e.Value
+			}
+		}
+		if env != fmt.Sprint(tc.want) {
+			t.Errorf("%s: unexpected OBSERVER_API_PORT: got: %s\nwant: %d", tc.name, env, tc.want)
+		}
+
+		svc := BuildObserverServiceForIShield(cr)
+		if svc.Spec.Ports[0].Port != tc.want {
+			t.Errorf("%s: unexpected service port: got: %d\nwant: %d", tc.name, svc.Spec.Ports[0].Port, tc.want)
+		}
+	}
+}
+
+func TestObserverServiceAccountEnv(t *testing.T) {
+	cr := testIntegrityShield("")
+	cr.Spec.Security.ObserverServiceAccountName = "integrity-shield-observer-sa"
+	want := "system:serviceaccount:integrity-shield-operator-system:integrity-shield-observer-sa"
+	// the request handler runs in both the admission controller and the api
+	for _, deploy := range []*appsv1.Deployment{BuildDeploymentForAdmissionController(cr), BuildDeploymentForIShieldAPI(cr)} {
+		env := ""
+		for _, e := range deploy.Spec.Template.Spec.Containers[0].Env {
+			if e.Name == "OBSERVER_SERVICE_ACCOUNT" {
+				env = e.Value
+			}
+		}
+		if env != want {
+			t.Errorf("%s: unexpected OBSERVER_SERVICE_ACCOUNT: got: %s\nwant: %s", deploy.Name, env, want)
+		}
+	}
+}
+
+func TestObserverTlsSecret(t *testing.T) {
+	testcases := []struct {
+		name       string
+		secretName string
+		want       string
+	}{
+		{name: "CR without secret name", secretName: "", want: "integrity-shield-observer-tls"},
+		{name: "CR with secret name", secretName: "observer-certs", want: "observer-certs"},
+	}
+	for _, tc := range testcases {
+		cr := testIntegrityShield("")
+		cr.Spec.Observer.Name = "integrity-shield-observer"
+		cr.Spec.Observer.TlsSecretName = tc.secretName
+
+		if secret := BuildObserverTlsSecretForIShield(cr); secret.Name != tc.want {
+			t.Errorf("%s: unexpected secret name: got: %s\nwant: %s", tc.name, secret.Name, tc.want)
+		}
+		// the api is served with the certificate in the secret
+		deploy := BuildDeploymentForObserver(cr)
+		mounted := ""
+		for _, vol := range deploy.Spec.Template.Spec.Volumes {
+			if vol.Secret != nil {
+				mounted = vol.Secret.SecretName
+			}
+		}
+		if mounted != tc.want {
+			t.Errorf("%s: unexpected mounted secret: got: %s\nwant: %s", tc.name, mounted, tc.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package resources
+
+import (
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// OpenAPI schema of ManifestIntegrityProfile, which follows the types of the admission controller.
+// Unknown fields are preserved in every object so that the profile validation webhook can reject them;
+// API server prunes them before admission otherwise, and a typo in a profile is dropped silently.
+func manifestIntegrityProfileSchema() *extv1.JSONSchemaProps {
+	trueVar := true
+	resourceRef := objectSchema(map[string]extv1.JSONSchemaProps{
+		"name":      stringSchema(),
+		"namespace": stringSchema(),
+	})
+	keyConfigs := arraySchema(objectSchema(map[string]extv1.JSONSchemaProps{
+		"keySecretName":      stringSchema(),
+		"keySecretNamespace": stringSchema(),
+	}))
+	objectReferences := arraySchema(objectSchema(map[string]extv1.JSONSchemaProps{
+		"group":     stringSchema(),
+		"version":   stringSchema(),
+		"kind":      stringSchema(),
+		"name":      stringSchema(),
+		"namespace": stringSchema(),
+	}))
+	userBindings := arraySchema(objectSchema(map[string]extv1.JSONSchemaProps{
+		"objects": objectReferences,
+		"users":   stringArraySchema(),
+	}))
+
+	match := objectSchema(map[string]extv1.JSONSchemaProps{
+		"kinds": arraySchema(objectSchema(map[string]extv1.JSONSchemaProps{
+			"kinds":     stringArraySchema(),
+			"apiGroups": stringArraySchema(),
+		})),
+		"namespaces":         stringArraySchema(),
+		"excludedNamespaces": stringArraySchema(),
+		"labelSelector":      labelSelectorSchema(),
+		"namespaceSelector":  labelSelectorSchema(),
+	})
+
+	parameters := objectSchema(map[string]extv1.JSONSchemaProps{
+		"constraintName": stringSchema(),
+		"signatureRef": objectSchema(map[string]extv1.JSONSchemaProps{
+			"imageRef":              stringSchema(),
+			"signatureResourceRef":  resourceRef,
+			"provenanceResourceRef": resourceRef,
+			"bundleRef": objectSchema(map[string]extv1.JSONSchemaProps{
+				"path":      stringSchema(),
+				"configMap": resourceRef,
+				"key":       stringSchema(),
+			}),
+		}),
+		"keyConfigs":     keyConfigs,
+		"objectSelector": objectReferences,
+		"skipObjects":    objectReferences,
+		"skipUsers":      userBindings,
+		"inScopeUsers":   userBindings,
+		"imageProfile": objectSchema(map[string]extv1.JSONSchemaProps{
+			"keyConfigs": keyConfigs,
+			"match":      stringArraySchema(),
+			"exclude":    stringArraySchema(),
+		}),
+		"provenancePolicy": objectSchema(map[string]extv1.JSONSchemaProps{
+			"allowedBuilders":    stringArraySchema(),
+			"allowedSourceRepos": stringArraySchema(),
+			"allowedBranches":    stringArraySchema(),
+			"requiredMaterials":  stringArraySchema(),
+		}),
+		"annotationKeyDomain": stringSchema(),
+		"ignoreFields": arraySchema(objectSchema(map[string]extv1.JSONSchemaProps{
+			"fields":  stringArraySchema(),
+			"objects": objectReferences,
+		})),
+		"signers":                stringArraySchema(),
+		"maxResourceManifestNum": {Type: "integer"},
+		"action": objectSchema(map[string]extv1.JSONSchemaProps{
+			"audit": objectSchema(map[string]extv1.JSONSchemaProps{
+				"inform": {Type: "boolean"},
+			}),
+			"admissionControl": objectSchema(map[string]extv1.JSONSchemaProps{
+				"enforce": {Type: "boolean"},
+			}),
+		}),
+		"remediation": objectSchema(map[string]extv1.JSONSchemaProps{
+			"enabled":     {Type: "boolean"},
+			"dryRun":      {Type: "boolean"},
+			"useSnapshot": {Type: "boolean"},
+		}),
+	})
+
+	spec := objectSchema(map[string]extv1.JSONSchemaProps{
+		"match":      match,
+		"parameters": parameters,
+		"mode": {
+			Type: "string",
+			Enum: []extv1.JSON{
+				{Raw: []byte(`"enforce"`)},
+				{Raw: []byte(`"detect"`)},
+				{Raw: []byte(`"disabled"`)},
+			},
+		},
+		"priority":      {Type: "integer"},
+		"allowOverride": {Type: "boolean"},
+	})
+
+	return &extv1.JSONSchemaProps{
+		Type:                   "object",
+		XPreserveUnknownFields: &trueVar,
+		Properties: map[string]extv1.JSONSchemaProps{
+			"apiVersion": stringSchema(),
+			"kind":       stringSchema(),
+			"metadata":   {Type: "object"},
+			"spec":       spec,
+			// status is written by the admission controller
+			"status": {
+				Type:                   "object",
+				XPreserveUnknownFields: &trueVar,
+			},
+		},
+	}
+}
+
+func stringSchema() extv1.JSONSchemaProps {
+	return extv1.JSONSchemaProps{Type: "string"}
+}
+
+func stringArraySchema() extv1.JSONSchemaProps {
+	return arraySchema(stringSchema())
+}
+
+func arraySchema(item extv1.JSONSchemaProps) extv1.JSONSchemaProps {
+	return extv1.JSONSchemaProps{
+		Type:  "array",
+		Items: &extv1.JSONSchemaPropsOrArray{Schema: &item},
+	}
+}
+
+// objectSchema returns a schema of an object which keeps unknown fields.
+// Pruning switches back on at nested objects with properties, so every object sets it.
+func objectSchema(properties map[string]extv1.JSONSchemaProps) extv1.JSONSchemaProps {
+	trueVar := true
+	return extv1.JSONSchemaProps{
+		Type:                   "object",
+		Properties:             properties,
+		XPreserveUnknownFields: &trueVar,
+	}
+}
+
+func labelSelectorSchema() extv1.JSONSchemaProps {
+	str := stringSchema()
+	expression := objectSchema(map[string]extv1.JSONSchemaProps{
+		"key":      stringSchema(),
+		"operator": stringSchema(),
+		"values":   stringArraySchema(),
+	})
+	expression.Required = []string{"key", "operator"}
+	return objectSchema(map[string]extv1.JSONSchemaProps{
+		"matchLabels": {
+			Type:                 "object",
+			AdditionalProperties: &extv1.JSONSchemaPropsOrBool{Allows: true, Schema: &str},
+		},
+		"matchExpressions": arraySchema(expression),
+	})
+}
@@ -0,0 +1,82 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package resources
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/pruning"
+	"k8s.io/apimachinery/pkg/util/json"
+)
+
+func TestManifestIntegrityProfileSchemaKeepsUnknownFields(t *testing.T) {
+	internal := &apiextensions.JSONSchemaProps{}
+	if err := extv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(manifestIntegrityProfileSchema(), internal, nil); err != nil {
+		t.Fatalf("failed to convert schema: %s", err.Error())
+	}
+	ss, err := structuralschema.NewStructural(internal)
+	if err != nil {
+		t.Fatalf("failed to make structural schema: %s", err.Error())
+	}
+	if errs := structuralschema.ValidateStructural(nil, ss); len(errs) > 0 {
+		t.Fatalf("schema is not structural: %s", errs.ToAggregate().Error())
+	}
+
+	testcases := []struct {
+		name  string
+		spec  string
+		field string
+	}{
+		{
+			name:  "unknown field in spec",
+			spec:  `{"mode": "detect", "modes": "enforce"}`,
+			field: `"modes"`,
+		},
+		{
+			name:  "unknown field in parameters",
+			spec:  `{"parameters": {"objectSelecter": [{"name": "sample-cm"}]}}`,
+			field: `"objectSelecter"`,
+		},
+		{
+			name:  "unknown field in nested object",
+			spec:  `{"parameters": {"signatureRef": {"imageRef": "sample", "imageRefs": ["sample"]}}}`,
+			field: `"imageRefs"`,
+		},
+		{
+			name:  "unknown field in label selector",
+			spec:  `{"match": {"labelSelector": {"matchExpressions": [{"key": "app", "operator": "In", "value": ["sample"]}]}}}`,
+			field: `"value"`,
+		},
+	}
+	for _, tc := range testcases {
+		var obj map[string]interface{}
+		raw := `{"apiVersion": "apis.integrityshield.io/v1", "kind": "ManifestIntegrityProfile", "spec": ` + tc.spec + `}`
+		if err := json.Unmarshal([]byte(raw), &obj); err != nil {
+			t.Errorf("%s: failed to unmarshal: %s", tc.name, err.Error())
+			continue
+		}
+		pruning.Prune(obj, ss, true)
+		pruned, _ := json.Marshal(obj)
+		if !strings.Contains(string(pruned), tc.field) {
+			t.Errorf("%s: unknown field %s is pruned: %s", tc.name, tc.field, string(pruned))
+		}
+	}
+}
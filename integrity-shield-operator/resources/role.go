@@ -18,6 +18,7 @@ package resources
 
 import (
 	apiv1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1"
+	"github.com/ghodss/yaml"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -79,6 +80,7 @@ func BuildClusterRoleForIShield(cr *apiv1.IntegrityShield) *rbacv1.ClusterRole {
 				},
 				Resources: []string{
 					"manifestintegrityprofiles",
+					"manifestintegrityprofiles/status",
 				},
 				Verbs: []string{
 					"get", "list", "watch", "patch", "update",
@@ -95,6 +97,18 @@ func BuildClusterRoleForIShield(cr *apiv1.IntegrityShield) *rbacv1.ClusterRole {
 					"create", "update", "get",
 				},
 			},
+			// namespace selectors are evaluated with a namespace informer
+			{
+				APIGroups: []string{
+					"",
+				},
+				Resources: []string{
+					"namespaces",
+				},
+				Verbs: []string{
+					"get", "list", "watch",
+				},
+			},
 			// {
 			// 	APIGroups: []string{
 			// 		"apiextensions.k8s.io",
@@ -189,7 +203,7 @@ func BuildRoleForIShield(cr *apiv1.IntegrityShield) *rbacv1.Role {
 					"*",
 				},
 				Verbs: []string{
-					"get", "list", "create", "update",
+					"get", "list", "watch", "create", "update",
 				},
 			},
 		},
@@ -197,6 +211,14 @@ func BuildRoleForIShield(cr *apiv1.IntegrityShield) *rbacv1.Role {
 	return role
 }
 
+// observerSideEffect is a part of observer config which requires additional permissions
+type observerSideEffect struct {
+	SideEffect struct {
+		CreateViolationEvent bool   `json:"createViolationEvent,omitempty"`
+		ViolationMarker      string `json:"violationMarker,omitempty"`
+	} `json:"sideEffect,omitempty"`
+}
+
 func BuildClusterRoleForObserver(cr *apiv1.IntegrityShield) *rbacv1.ClusterRole {
 	labels := map[string]string{
 		"app":                          cr.Name,
@@ -204,25 +226,80 @@ func BuildClusterRoleForObserver(cr *apiv1.IntegrityShield) *rbacv1.ClusterRole
 		"app.kubernetes.io/managed-by": "operator",
 		"role":                         "security",
 	}
+	var sideEffect observerSideEffect
+	_ = yaml.Unmarshal([]byte(cr.Spec.Observer.Config), &sideEffect)
+
+	verbs := []string{"get", "list"}
+	// violation marker is patched on the observed resources, and remediation applies signed manifests to them
+	// with server-side apply. Any kind can be matched by constraints, so this is a cluster-wide write permission.
+	if sideEffect.SideEffect.ViolationMarker != "" || cr.Spec.Observer.EnableRemediation {
+		verbs = append(verbs, "patch")
+	}
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{
+				"*",
+			},
+			Resources: []string{
+				"*",
+			},
+			Verbs: verbs,
+		},
+	}
+	// cluster-scoped summary of the results
+	rules = append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{
+			"apis.integrityshield.io",
+		},
+		Resources: []string{
+			"manifestintegritysummaries",
+		},
+		Verbs: []string{
+			"get", "create", "update",
+		},
+	})
+	// requests to the observer API are authenticated and authorized by the API server
+	rules = append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{
+			"authentication.k8s.io",
+		},
+		Resources: []string{
+			"tokenreviews",
+		},
+		Verbs: []string{
+			"create",
+		},
+	}, rbacv1.PolicyRule{
+		APIGroups: []string{
+			"authorization.k8s.io",
+		},
+		Resources: []string{
+			"subjectaccessreviews",
+		},
+		Verbs: []string{
+			"create",
+		},
+	})
+	if sideEffect.SideEffect.CreateViolationEvent {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{
+				"",
+			},
+			Resources: []string{
+				"events",
+			},
+			Verbs: []string{
+				"get", "create", "update",
+			},
+		})
+	}
 	role := &rbacv1.ClusterRole{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      cr.Spec.Security.ObserverRole,
 			Namespace: cr.Namespace,
 			Labels:    labels,
 		},
-		Rules: []rbacv1.PolicyRule{
-			{
-				APIGroups: []string{
-					"*",
-				},
-				Resources: []string{
-					"*",
-				},
-				Verbs: []string{
-					"get", "list",
-				},
-			},
-		},
+		Rules: rules,
 	}
 	return role
 }
@@ -249,7 +326,7 @@ func BuildRoleForObserver(cr *apiv1.IntegrityShield) *rbacv1.Role {
 					"manifestintegritystates", "configmaps",
 				},
 				Verbs: []string{
-					"get", "list", "create", "watch", "patch", "update",
+					"get", "list", "create", "watch", "patch", "update", "delete",
 				},
 			},
 		},
@@ -0,0 +1,47 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package resources
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClusterRoleForObserverVerbs(t *testing.T) {
+	testcases := []struct {
+		name        string
+		config      string
+		remediation bool
+		verbs       []string
+	}{
+		{name: "read only", verbs: []string{"get", "list"}},
+		{name: "violation marker", config: "sideEffect:\n  violationMarker: annotation\n", verbs: []string{"get", "list", "patch"}},
+		{name: "remediation", remediation: true, verbs: []string{"get", "list", "patch"}},
+		{name: "violation marker and remediation", config: "sideEffect:\n  violationMarker: annotation\n", remediation: true, verbs: []string{"get", "list", "patch"}},
+	}
+	for _, tc := range testcases {
+		cr := testIntegrityShield("")
+		cr.Spec.Observer.Config = tc.config
+		cr.Spec.Observer.EnableRemediation = tc.remediation
+		role := BuildClusterRoleForObserver(cr)
+		// remediation uses server-side apply, so no update permission is granted on observed resources
+		if !reflect.DeepEqual(role.Rules[0].Verbs, tc.verbs) {
+			t.Errorf("%s: unexpected verbs: got: %v\nwant: %v", tc.name, role.Rules[0].Verbs, tc.verbs)
+			return
+		}
+	}
+}
@@ -57,3 +57,29 @@ func BuildAPITlsSecretForIShield(cr *apiv1.IntegrityShield) *corev1.Secret {
 	}
 	return sec
 }
+
+// observer-tls
+func BuildObserverTlsSecretForIShield(cr *apiv1.IntegrityShield) *corev1.Secret {
+	var empty []byte
+	sec := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ObserverTlsSecretName(cr),
+			Namespace: cr.Namespace,
+		},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       empty, // "tls.crt"
+			corev1.TLSPrivateKeyKey: empty,
+			"ca.crt":                empty,
+		},
+		Type: corev1.SecretTypeTLS,
+	}
+	return sec
+}
+
+// ObserverTlsSecretName returns the secret of the observer api certificate, or the default one for CRs created before the field was added
+func ObserverTlsSecretName(cr *apiv1.IntegrityShield) string {
+	if cr.Spec.Observer.TlsSecretName == "" {
+		return cr.Spec.Observer.Name + "-tls"
+	}
+	return cr.Spec.Observer.TlsSecretName
+}
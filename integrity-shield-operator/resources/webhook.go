@@ -18,8 +18,10 @@ package resources
 
 import (
 	"fmt"
+	"reflect"
 
 	apiv1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1"
+	"github.com/ghodss/yaml"
 	admregv1 "k8s.io/api/admissionregistration/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -72,41 +74,116 @@ func BuildAPIServiceForIShield(cr *apiv1.IntegrityShield) *corev1.Service {
 	return svc
 }
 
-//webhook configuration
-func BuildValidatingWebhookConfigurationForIShield(cr *apiv1.IntegrityShield) *admregv1.ValidatingWebhookConfiguration {
+// observer service
+func BuildObserverServiceForIShield(cr *apiv1.IntegrityShield) *corev1.Service {
+	var targetport intstr.IntOrString
+	targetport.Type = intstr.String
+	targetport.StrVal = "observer-api"
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cr.Spec.Observer.Name,
+			Namespace: cr.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Port:       observerPort(cr),
+					TargetPort: targetport, //"observer-api"
+				},
+			},
+			Selector: cr.Spec.Observer.SelectorLabels,
+		},
+	}
+	return svc
+}
 
-	namespaced := admregv1.NamespacedScope
-	cluster := admregv1.ClusterScope
+// admissionControllerFailurePolicy is a part of admission controller config which decides the webhook failure policy
+type admissionControllerFailurePolicy struct {
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+}
 
-	namespacedRule := cr.Spec.WebhookNamespacedResource
-	namespacedRule.Scope = &namespaced
+// AdmissionControllerFailurePolicy returns the failure policy in the admission controller config.
+// It is passed to the admission controller as env too, so that it is available even when the config cannot be loaded.
+func AdmissionControllerFailurePolicy(cr *apiv1.IntegrityShield) string {
+	var config admissionControllerFailurePolicy
+	_ = yaml.Unmarshal([]byte(cr.Spec.AdmissionControllerConfig), &config)
+	return config.FailurePolicy
+}
 
-	clusterRule := cr.Spec.WebhookClusterResource
-	clusterRule.Scope = &cluster
+// webhookFailurePolicy returns the failure policy of the webhook which matches the admission controller config.
+// Only `open` makes the webhook fail open. The default keeps `Fail`, which is the default of admissionregistration/v1,
+// and API server cannot tell protected namespaces, so `closedForProtectedNamespaces` fails closed for all of them.
+func webhookFailurePolicy(cr *apiv1.IntegrityShield) admregv1.FailurePolicyType {
+	if AdmissionControllerFailurePolicy(cr) == "open" {
+		return admregv1.Ignore
+	}
+	return admregv1.Fail
+}
 
-	var path *string
-	validate := "/validate-resource"
-	path = &validate
+// admissionControllerSignatureInjection is a part of admission controller config which enables the mutating webhook
+type admissionControllerSignatureInjection struct {
+	SignatureInjection struct {
+		Enabled bool `json:"enabled,omitempty"`
+	} `json:"signatureInjection,omitempty"`
+}
 
-	var empty []byte
+// IsSignatureInjectionEnabled returns true if the admission controller config enables signature injection.
+func IsSignatureInjectionEnabled(cr *apiv1.IntegrityShield) bool {
+	var config admissionControllerSignatureInjection
+	_ = yaml.Unmarshal([]byte(cr.Spec.AdmissionControllerConfig), &config)
+	return config.SignatureInjection.Enabled
+}
 
-	sideEffect := admregv1.SideEffectClassNoneOnDryRun
-	timeoutSeconds := int32(apiv1.DefaultIShieldWebhookTimeout)
+// webhook paths of the admission controller. Namespaced and cluster scope rules have their own entries.
+const (
+	validateNamespacedResourcePath = "/validate-namespaced-resource"
+	validateClusterResourcePath    = "/validate-cluster-resource"
+	validateProfilePath            = "/validate-profile"
+	mutateNamespacedResourcePath   = "/mutate-namespaced-resource"
+	mutateClusterResourcePath      = "/mutate-cluster-resource"
+)
 
-	rules := []admregv1.RuleWithOperations{
-		{
-			Operations: []admregv1.OperationType{
-				admregv1.Create, admregv1.Update,
-			},
-			Rule: namespacedRule,
+// admissionReviewVersions returns AdmissionReview versions which the admission controller accepts.
+// API server uses the first one which it supports.
+func admissionReviewVersions() []string {
+	return []string{"v1", "v1beta1"}
+}
+
+func webhookClientConfig(cr *apiv1.IntegrityShield, path string) admregv1.WebhookClientConfig {
+	var empty []byte
+	return admregv1.WebhookClientConfig{
+		Service: &admregv1.ServiceReference{
+			Name:      cr.Spec.WebhookServiceName,
+			Namespace: cr.Namespace,
+			Path:      &path,
 		},
+		CABundle: empty,
+	}
+}
+
+// webhookRules returns create and update rules for the resources of the scope
+func webhookRules(cr *apiv1.IntegrityShield, scope admregv1.ScopeType) []admregv1.RuleWithOperations {
+	rule := cr.Spec.WebhookNamespacedResource
+	if scope == admregv1.ClusterScope {
+		rule = cr.Spec.WebhookClusterResource
+	}
+	rule.Scope = &scope
+	return []admregv1.RuleWithOperations{
 		{
 			Operations: []admregv1.OperationType{
 				admregv1.Create, admregv1.Update,
 			},
-			Rule: clusterRule,
+			Rule: rule,
 		},
 	}
+}
+
+//webhook configuration
+func BuildValidatingWebhookConfigurationForIShield(cr *apiv1.IntegrityShield) *admregv1.ValidatingWebhookConfiguration {
+
+	sideEffect := admregv1.SideEffectClassNoneOnDryRun
+	failurePolicy := webhookFailurePolicy(cr)
+	timeoutSeconds := int32(apiv1.DefaultIShieldWebhookTimeout)
 
 	wc := &admregv1.ValidatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
@@ -115,21 +192,139 @@ func BuildValidatingWebhookConfigurationForIShield(cr *apiv1.IntegrityShield) *a
 		},
 		Webhooks: []admregv1.ValidatingWebhook{
 			{
-				Name: fmt.Sprintf("ac-server.%s.svc", cr.Namespace),
-				ClientConfig: admregv1.WebhookClientConfig{
-					Service: &admregv1.ServiceReference{
-						Name:      cr.Spec.WebhookServiceName,
-						Namespace: cr.Namespace,
-						Path:      path,
-					},
-					CABundle: empty,
+				Name:                    fmt.Sprintf("ac-server.%s.svc", cr.Namespace),
+				ClientConfig:            webhookClientConfig(cr, validateNamespacedResourcePath),
+				Rules:                   webhookRules(cr, admregv1.NamespacedScope),
+				SideEffects:             &sideEffect,
+				FailurePolicy:           &failurePolicy,
+				TimeoutSeconds:          &timeoutSeconds,
+				AdmissionReviewVersions: admissionReviewVersions(),
+			},
+			{
+				Name:                    fmt.Sprintf("cluster.ac-server.%s.svc", cr.Namespace),
+				ClientConfig:            webhookClientConfig(cr, validateClusterResourcePath),
+				Rules:                   webhookRules(cr, admregv1.ClusterScope),
+				SideEffects:             &sideEffect,
+				FailurePolicy:           &failurePolicy,
+				TimeoutSeconds:          &timeoutSeconds,
+				AdmissionReviewVersions: admissionReviewVersions(),
+			},
+			buildProfileValidatingWebhook(cr),
+		},
+	}
+	return wc
+}
+
+// validating webhook for ManifestIntegrityProfile itself
+func buildProfileValidatingWebhook(cr *apiv1.IntegrityShield) admregv1.ValidatingWebhook {
+	sideEffect := admregv1.SideEffectClassNone
+	// invalid profiles are accepted while the admission controller is not available
+	failurePolicy := admregv1.Ignore
+	timeoutSeconds := int32(apiv1.DefaultIShieldWebhookTimeout)
+	return admregv1.ValidatingWebhook{
+		Name:         fmt.Sprintf("profile.ac-server.%s.svc", cr.Namespace),
+		ClientConfig: webhookClientConfig(cr, validateProfilePath),
+		Rules: []admregv1.RuleWithOperations{
+			{
+				Operations: []admregv1.OperationType{
+					admregv1.Create, admregv1.Update,
+				},
+				Rule: admregv1.Rule{
+					APIGroups:   []string{"apis.integrityshield.io"},
+					APIVersions: []string{"*"},
+					Resources:   []string{"manifestintegrityprofiles"},
 				},
-				Rules:                   rules,
+			},
+		},
+		SideEffects:             &sideEffect,
+		FailurePolicy:           &failurePolicy,
+		TimeoutSeconds:          &timeoutSeconds,
+		AdmissionReviewVersions: admissionReviewVersions(),
+	}
+}
+
+// mutating webhook configuration for signature injection
+func BuildMutatingWebhookConfigurationForIShield(cr *apiv1.IntegrityShield) *admregv1.MutatingWebhookConfiguration {
+
+	sideEffect := admregv1.SideEffectClassNoneOnDryRun
+	// a resource without injected reference is still verified by the validating webhook
+	failurePolicy := admregv1.Ignore
+	timeoutSeconds := int32(apiv1.DefaultIShieldWebhookTimeout)
+
+	wc := &admregv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-mutating", cr.Spec.WebhookConfigName),
+			Namespace: cr.Namespace,
+		},
+		Webhooks: []admregv1.MutatingWebhook{
+			{
+				Name:                    fmt.Sprintf("signature.ac-server.%s.svc", cr.Namespace),
+				ClientConfig:            webhookClientConfig(cr, mutateNamespacedResourcePath),
+				Rules:                   webhookRules(cr, admregv1.NamespacedScope),
+				SideEffects:             &sideEffect,
+				FailurePolicy:           &failurePolicy,
+				TimeoutSeconds:          &timeoutSeconds,
+				AdmissionReviewVersions: admissionReviewVersions(),
+			},
+			{
+				Name:                    fmt.Sprintf("cluster.signature.ac-server.%s.svc", cr.Namespace),
+				ClientConfig:            webhookClientConfig(cr, mutateClusterResourcePath),
+				Rules:                   webhookRules(cr, admregv1.ClusterScope),
 				SideEffects:             &sideEffect,
+				FailurePolicy:           &failurePolicy,
 				TimeoutSeconds:          &timeoutSeconds,
-				AdmissionReviewVersions: []string{"v1beta1"},
+				AdmissionReviewVersions: admissionReviewVersions(),
 			},
 		},
 	}
 	return wc
 }
+
+// webhookFields are the fields of a webhook which are set by the operator.
+// Others are not compared because API server fills their defaults.
+type webhookFields struct {
+	Name                    string
+	Path                    *string
+	Rules                   []admregv1.RuleWithOperations
+	FailurePolicy           *admregv1.FailurePolicyType
+	AdmissionReviewVersions []string
+}
+
+func newWebhookFields(name string, config admregv1.WebhookClientConfig, rules []admregv1.RuleWithOperations, failurePolicy *admregv1.FailurePolicyType, versions []string) webhookFields {
+	fields := webhookFields{
+		Name:                    name,
+		Rules:                   rules,
+		FailurePolicy:           failurePolicy,
+		AdmissionReviewVersions: versions,
+	}
+	if config.Service != nil {
+		fields.Path = config.Service.Path
+	}
+	return fields
+}
+
+func validatingWebhookFields(webhooks []admregv1.ValidatingWebhook) []webhookFields {
+	fields := []webhookFields{}
+	for _, w := range webhooks {
+		fields = append(fields, newWebhookFields(w.Name, w.ClientConfig, w.Rules, w.FailurePolicy, w.AdmissionReviewVersions))
+	}
+	return fields
+}
+
+func mutatingWebhookFields(webhooks []admregv1.MutatingWebhook) []webhookFields {
+	fields := []webhookFields{}
+	for _, w := range webhooks {
+		fields = append(fields, newWebhookFields(w.Name, w.ClientConfig, w.Rules, w.FailurePolicy, w.AdmissionReviewVersions))
+	}
+	return fields
+}
+
+// ValidatingWebhooksChanged returns true if the found webhooks need to be updated to the expected ones
+func ValidatingWebhooksChanged(found, expected []admregv1.ValidatingWebhook) bool {
+	return !reflect.DeepEqual(validatingWebhookFields(found), validatingWebhookFields(expected))
+}
+
+// MutatingWebhooksChanged returns true if the found webhooks need to be updated to the expected ones
+func MutatingWebhooksChanged(found, expected []admregv1.MutatingWebhook) bool {
+	return !reflect.DeepEqual(mutatingWebhookFields(found), mutatingWebhookFields(expected))
+}
@@ -0,0 +1,167 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package resources
+
+import (
+	"testing"
+
+	apiv1 "github.com/IBM/integrity-shield/integrity-shield-operator/api/v1"
+	admregv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testIntegrityShield(acConfig string) *apiv1.IntegrityShield {
+	cr := &apiv1.IntegrityShield{ObjectMeta: metav1.ObjectMeta{Name: "integrity-shield", Namespace: "integrity-shield-operator-system"}}
+	cr.Spec.WebhookConfigName = "ishield-webhook-config"
+	cr.Spec.WebhookServiceName = "integrity-shield-webhook"
+	cr.Spec.AdmissionControllerConfig = acConfig
+	return cr
+}
+
+func TestValidatingWebhooksChanged(t *testing.T) {
+	cr := testIntegrityShield("")
+	testcases := []struct {
+		name   string
+		found  func() []admregv1.ValidatingWebhook
+		change bool
+	}{
+		{
+			name: "equal configs",
+			found: func() []admregv1.ValidatingWebhook {
+				return BuildValidatingWebhookConfigurationForIShield(cr).Webhooks
+			},
+			change: false,
+		},
+		{
+			name: "fields filled by API server are ignored",
+			found: func() []admregv1.ValidatingWebhook {
+				webhooks := BuildValidatingWebhookConfigurationForIShield(cr).Webhooks
+				matchPolicy := admregv1.Equivalent
+				for i := range webhooks {
+					webhooks[i].ClientConfig.CABundle = []byte("ca")
+					webhooks[i].MatchPolicy = &matchPolicy
+					webhooks[i].NamespaceSelector = &metav1.LabelSelector{}
+				}
+				return webhooks
+			},
+			change: false,
+		},
+		{
+			name: "changed path",
+			found: func() []admregv1.ValidatingWebhook {
+				webhooks := BuildValidatingWebhookConfigurationForIShield(cr).Webhooks
+				path := "/validate-resource"
+				webhooks[0].ClientConfig.Service.Path = &path
+				return webhooks
+			},
+			change: true,
+		},
+		{
+			name: "changed count",
+			found: func() []admregv1.ValidatingWebhook {
+				webhooks := BuildValidatingWebhookConfigurationForIShield(cr).Webhooks
+				return webhooks[:1]
+			},
+			change: true,
+		},
+		{
+			name: "changed failure policy",
+			found: func() []admregv1.ValidatingWebhook {
+				return BuildValidatingWebhookConfigurationForIShield(testIntegrityShield("failurePolicy: open")).Webhooks
+			},
+			change: true,
+		},
+	}
+	expected := BuildValidatingWebhookConfigurationForIShield(cr).Webhooks
+	for _, tc := range testcases {
+		if got := ValidatingWebhooksChanged(tc.found(), expected); got != tc.change {
+			t.Errorf("%s: got: %v\nwant: %v", tc.name, got, tc.change)
+		}
+	}
+}
+
+func TestMutatingWebhooksChanged(t *testing.T) {
+	cr := testIntegrityShield("")
+	testcases := []struct {
+		name   string
+		found  func() []admregv1.MutatingWebhook
+		change bool
+	}{
+		{
+			name: "equal configs",
+			found: func() []admregv1.MutatingWebhook {
+				return BuildMutatingWebhookConfigurationForIShield(cr).Webhooks
+			},
+			change: false,
+		},
+		{
+			name: "fields filled by API server are ignored",
+			found: func() []admregv1.MutatingWebhook {
+				webhooks := BuildMutatingWebhookConfigurationForIShield(cr).Webhooks
+				reinvocation := admregv1.NeverReinvocationPolicy
+				for i := range webhooks {
+					webhooks[i].ClientConfig.CABundle = []byte("ca")
+					webhooks[i].ReinvocationPolicy = &reinvocation
+				}
+				return webhooks
+			},
+			change: false,
+		},
+		{
+			name: "changed path",
+			found: func() []admregv1.MutatingWebhook {
+				webhooks := BuildMutatingWebhookConfigurationForIShield(cr).Webhooks
+				path := "/mutate-resource"
+				webhooks[1].ClientConfig.Service.Path = &path
+				return webhooks
+			},
+			change: true,
+		},
+		{
+			name: "changed count",
+			found: func() []admregv1.MutatingWebhook {
+				webhooks := BuildMutatingWebhookConfigurationForIShield(cr).Webhooks
+				return append(webhooks, webhooks[0])
+			},
+			change: true,
+		},
+	}
+	expected := BuildMutatingWebhookConfigurationForIShield(cr).Webhooks
+	for _, tc := range testcases {
+		if got := MutatingWebhooksChanged(tc.found(), expected); got != tc.change {
+			t.Errorf("%s: got: %v\nwant: %v", tc.name, got, tc.change)
+		}
+	}
+}
+
+func TestWebhookFailurePolicy(t *testing.T) {
+	testcases := []struct {
+		config string
+		want   admregv1.FailurePolicyType
+	}{
+		{config: "", want: admregv1.Fail},
+		{config: "failurePolicy: open", want: admregv1.Ignore},
+		{config: "failurePolicy: closedForProtectedNamespaces", want: admregv1.Fail},
+		{config: "failurePolicy: closed", want: admregv1.Fail},
+		{config: "failurePolicy: Closed", want: admregv1.Fail},
+	}
+	for _, tc := range testcases {
+		if got := webhookFailurePolicy(testIntegrityShield(tc.config)); got != tc.want {
+			t.Errorf("config `%s`: got: %s\nwant: %s", tc.config, got, tc.want)
+		}
+	}
+}
@@ -4,7 +4,10 @@ go 1.16
 
 require (
 	github.com/IBM/integrity-shield/shield v0.0.0-00010101000000-000000000000
+	github.com/ghodss/yaml v1.0.0
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.0
+	github.com/robfig/cron/v3 v3.0.1 // indirect
 	github.com/sigstore/cosign v1.1.0
 	github.com/sigstore/k8s-manifest-sigstore v0.0.0-20210909071548-2120192e4ff7
 	github.com/sirupsen/logrus v1.8.1
@@ -18,7 +21,6 @@ replace (
 	github.com/IBM/integrity-shield/shield => ../shield
 	github.com/IBM/integrity-shield/webhook/admission-controller => ../webhook/admission-controller
 	k8s.io/kubectl => k8s.io/kubectl v0.21.2
-
 )
 
 // replace github.com/docker/docker => github.com/moby/moby v0.7.3-0.20190826074503-38ab9da00309 // Required by Helm
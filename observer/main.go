@@ -15,35 +15,25 @@
 package main
 
 import (
-	"fmt"
-	"os"
-	"strconv"
-	"time"
-
 	"github.com/IBM/integrity-shield/observer/pkg/observer"
+	log "github.com/sirupsen/logrus"
 )
 
 func main() {
 	insp := observer.NewObserver()
+	// the api server starts first so that readiness reports the observer is not initialized yet
+	go func() {
+		if err := insp.StartAPIServer(); err != nil {
+			log.Errorf("failed to run observer api; err: %s", err.Error())
+		}
+	}()
 	err := insp.Init()
 	if err != nil {
-		fmt.Println("Failed to initialize Observer; err: ", err.Error())
+		log.Errorf("failed to initialize observer; err: %s", err.Error())
 		return
 	}
-	intervalInt, _ := strconv.Atoi(os.Getenv("INTERVAL"))
-	fmt.Println("observer started.")
-	insp.Run()
+	log.Info("observer started.")
 	abort := make(chan struct{})
-	ticker := time.NewTicker(time.Duration(intervalInt) * time.Minute)
-	for {
-		select {
-		case <-ticker.C:
-			insp.Run()
-
-		case <-abort:
-			fmt.Println("Launch aborted!")
-			return
-		}
-	}
-
+	insp.RunScheduler(abort)
+	log.Info("launch aborted!")
 }
@@ -48,6 +48,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&ManifestIntegrityState{},
 		&ManifestIntegrityStateList{},
+		&ManifestIntegritySummary{},
+		&ManifestIntegritySummaryList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -42,10 +42,36 @@ type VerifyResult struct {
 	Signer     string     `json:"signer,omitempty"`
 	SignedTime *time.Time `json:"signedTime,omitempty"`
 	SigRef     string     `json:"sigRef,omitempty"`
+	// provenance attestations of the resource, if provenance is checked in the observer
+	Provenances []Provenance `json:"provenances,omitempty"`
 }
 
+// Provenance is the summary of a provenance attestation such as in-toto/SLSA
+type Provenance struct {
+	Artifact     string   `json:"artifact,omitempty"`
+	ArtifactType string   `json:"artifactType,omitempty"`
+	Hash         string   `json:"hash,omitempty"`
+	BuilderID    string   `json:"builderId,omitempty"`
+	SourceRepo   string   `json:"sourceRepo,omitempty"`
+	Branch       string   `json:"branch,omitempty"`
+	Materials    []string `json:"materials,omitempty"`
+	// the ConfigMap which contains the attestation and whether its signature is verified
+	ConfigMapRef      string `json:"configMapRef,omitempty"`
+	ConfigMapVerified bool   `json:"configMapVerified,omitempty"`
+	// whether the attestation in the transparency log is signed with the keys or by the signers of the constraint
+	AttestationVerified bool `json:"attestationVerified,omitempty"`
+}
+
+const (
+	// ConditionCompliant is true if no resource violates the constraint
+	ConditionCompliant = "Compliant"
+	// ConditionScanFailed is true if some resources could not be verified in the last scan
+	ConditionScanFailed = "ScanFailed"
+)
+
 // ManifestIntegrityStateStatus defines the observed state of ManifestIntegrityState
 type ManifestIntegrityStateStatus struct {
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +genclient
@@ -74,3 +100,53 @@ type ManifestIntegrityStateList struct {
 	metav1.ListMeta `json:"metadata,omitempty"`
 	Items           []ManifestIntegrityState `json:"items"`
 }
+
+// ManifestIntegritySummarySpec is the cluster-wide summary of the ManifestIntegrityStates
+type ManifestIntegritySummarySpec struct {
+	LastScanTime        string              `json:"lastScanTime"`
+	TotalConstraints    int                 `json:"totalConstraints"`
+	ViolatedConstraints int                 `json:"violatedConstraints"`
+	TotalResources      int                 `json:"totalResources"`
+	TotalViolations     int                 `json:"totalViolations"`
+	Constraints         []ConstraintSummary `json:"constraints"`
+	// resources which violate the most constraints
+	WorstOffenders []Offender `json:"worstOffenders"`
+}
+
+type ConstraintSummary struct {
+	ConstraintName  string `json:"constraintName"`
+	Violation       bool   `json:"violation"`
+	TotalViolations int    `json:"totalViolations"`
+	TotalResources  int    `json:"totalResources"`
+}
+
+type Offender struct {
+	Namespace   string   `json:"namespace"`
+	Name        string   `json:"name"`
+	Kind        string   `json:"kind"`
+	ApiGroup    string   `json:"apiGroup"`
+	Constraints []string `json:"constraints"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +resource:path=manifestintegritysummary,scope=Cluster
+
+// ManifestIntegritySummary is the CRD which summarizes the observer results in the cluster.
+type ManifestIntegritySummary struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ManifestIntegritySummarySpec `json:"spec,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ManifestIntegritySummaryList contains a list of ManifestIntegritySummary
+type ManifestIntegritySummaryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ManifestIntegritySummary `json:"items"`
+}
@@ -21,16 +21,33 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConstraintSummary) DeepCopyInto(out *ConstraintSummary) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConstraintSummary.
+func (in *ConstraintSummary) DeepCopy() *ConstraintSummary {
+	if in == nil {
+		return nil
+	}
+	out := new(ConstraintSummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ManifestIntegrityState) DeepCopyInto(out *ManifestIntegrityState) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -118,6 +135,13 @@ func (in *ManifestIntegrityStateSpec) DeepCopy() *ManifestIntegrityStateSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ManifestIntegrityStateStatus) DeepCopyInto(out *ManifestIntegrityStateStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -131,6 +155,136 @@ func (in *ManifestIntegrityStateStatus) DeepCopy() *ManifestIntegrityStateStatus
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManifestIntegritySummary) DeepCopyInto(out *ManifestIntegritySummary) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManifestIntegritySummary.
+func (in *ManifestIntegritySummary) DeepCopy() *ManifestIntegritySummary {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestIntegritySummary)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ManifestIntegritySummary) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManifestIntegritySummaryList) DeepCopyInto(out *ManifestIntegritySummaryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ManifestIntegritySummary, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManifestIntegritySummaryList.
+func (in *ManifestIntegritySummaryList) DeepCopy() *ManifestIntegritySummaryList {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestIntegritySummaryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ManifestIntegritySummaryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManifestIntegritySummarySpec) DeepCopyInto(out *ManifestIntegritySummarySpec) {
+	*out = *in
+	if in.Constraints != nil {
+		in, out := &in.Constraints, &out.Constraints
+		*out = make([]ConstraintSummary, len(*in))
+		copy(*out, *in)
+	}
+	if in.WorstOffenders != nil {
+		in, out := &in.WorstOffenders, &out.WorstOffenders
+		*out = make([]Offender, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManifestIntegritySummarySpec.
+func (in *ManifestIntegritySummarySpec) DeepCopy() *ManifestIntegritySummarySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ManifestIntegritySummarySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Offender) DeepCopyInto(out *Offender) {
+	*out = *in
+	if in.Constraints != nil {
+		in, out := &in.Constraints, &out.Constraints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Offender.
+func (in *Offender) DeepCopy() *Offender {
+	if in == nil {
+		return nil
+	}
+	out := new(Offender)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Provenance) DeepCopyInto(out *Provenance) {
+	*out = *in
+	if in.Materials != nil {
+		in, out := &in.Materials, &out.Materials
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Provenance.
+func (in *Provenance) DeepCopy() *Provenance {
+	if in == nil {
+		return nil
+	}
+	out := new(Provenance)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VerifyResult) DeepCopyInto(out *VerifyResult) {
 	*out = *in
@@ -138,6 +292,13 @@ func (in *VerifyResult) DeepCopyInto(out *VerifyResult) {
 		in, out := &in.SignedTime, &out.SignedTime
 		*out = *in
 	}
+	if in.Provenances != nil {
+		in, out := &in.Provenances, &out.Provenances
+		*out = make([]Provenance, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -0,0 +1,141 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclient "k8s.io/client-go/kubernetes"
+)
+
+// the verbs which are checked for requests to the observer API.
+// a user needs a ClusterRole like below to read results or metrics, or to start a scan or a dry run.
+//
+//	rules:
+//	- nonResourceURLs: ["/api/scan", "/api/results", "/api/coverage", "/metrics"]
+//	  verbs: ["get"]
+//	- nonResourceURLs: ["/api/scan", "/api/dryrun"]
+//	  verbs: ["create"]
+const (
+	apiReadVerb  = "get"
+	apiWriteVerb = "create"
+)
+
+// apiAuthorizationVerb returns the verb of SubjectAccessReview for the request method
+func apiAuthorizationVerb(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return apiReadVerb
+	}
+	return apiWriteVerb
+}
+
+// apiAuthorizer decides whether a request to the observer API is allowed.
+// it returns an http status code to respond with when the request is rejected.
+type apiAuthorizer interface {
+	Authorize(r *http.Request) (int, error)
+}
+
+// kubeAuthorizer authenticates the bearer token of a request by TokenReview
+// and authorizes the user for the request path by SubjectAccessReview.
+type kubeAuthorizer struct {
+	client kubeclient.Interface
+}
+
+func newKubeAuthorizer(client kubeclient.Interface) *kubeAuthorizer {
+	return &kubeAuthorizer{client: client}
+}
+
+func (self *kubeAuthorizer) Authorize(r *http.Request) (int, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return http.StatusUnauthorized, errors.New("bearer token is required")
+	}
+	ctx := context.Background()
+	tokenReview := &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{Token: token},
+	}
+	tokenReview, err := self.client.AuthenticationV1().TokenReviews().Create(ctx, tokenReview, metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "failed to create TokenReview")
+	}
+	if !tokenReview.Status.Authenticated {
+		return http.StatusUnauthorized, errors.New(fmt.Sprintf("failed to authenticate the bearer token: %s", tokenReview.Status.Error))
+	}
+	userInfo := tokenReview.Status.User
+	verb := apiAuthorizationVerb(r)
+	extra := map[string]authzv1.ExtraValue{}
+	for k, v := range userInfo.Extra {
+		extra[k] = authzv1.ExtraValue(v)
+	}
+	sar := &authzv1.SubjectAccessReview{
+		Spec: authzv1.SubjectAccessReviewSpec{
+			User:   userInfo.Username,
+			UID:    userInfo.UID,
+			Groups: userInfo.Groups,
+			Extra:  extra,
+			NonResourceAttributes: &authzv1.NonResourceAttributes{
+				Path: r.URL.Path,
+				Verb: verb,
+			},
+		},
+	}
+	sar, err = self.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, sar, metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, errors.Wrap(err, "failed to create SubjectAccessReview")
+	}
+	if !sar.Status.Allowed {
+		return http.StatusForbidden, errors.New(fmt.Sprintf("user `%s` is not allowed to %s `%s`", userInfo.Username, verb, r.URL.Path))
+	}
+	return http.StatusOK, nil
+}
+
+func bearerToken(r *http.Request) string {
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// requireAuthorization wraps a handler so that requests are served only for authorized users.
+func (self *Observer) requireAuthorization(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		self.lock.RLock()
+		authorizer := self.authorizer
+		self.lock.RUnlock()
+		if authorizer == nil {
+			http.Error(w, "observer is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		code, err := authorizer.Authorize(r)
+		if err != nil {
+			log.Warningf("rejected %s %s; %s", r.Method, r.URL.Path, err.Error())
+			http.Error(w, err.Error(), code)
+			return
+		}
+		next(w, r)
+	}
+}
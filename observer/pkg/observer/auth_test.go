@@ -0,0 +1,79 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	authnv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubeAuthorizer(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authnv1.TokenReview)
+		if review.Spec.Token == "valid" {
+			review.Status.Authenticated = true
+			review.Status.User = authnv1.UserInfo{Username: "test-user"}
+		}
+		return true, review, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+		attrs := review.Spec.NonResourceAttributes
+		review.Status.Allowed = review.Spec.User == "test-user" && attrs != nil && ((attrs.Path == "/api/scan" && attrs.Verb == apiWriteVerb) || (attrs.Path == "/api/results" && attrs.Verb == apiReadVerb))
+		return true, review, nil
+	})
+	authorizer := newKubeAuthorizer(client)
+
+	testcases := []struct {
+		name     string
+		method   string
+		path     string
+		header   string
+		expected int
+	}{
+		{name: "no token", method: http.MethodPost, path: "/api/scan", header: "", expected: http.StatusUnauthorized},
+		{name: "invalid token", method: http.MethodPost, path: "/api/scan", header: "Bearer invalid", expected: http.StatusUnauthorized},
+		{name: "allowed", method: http.MethodPost, path: "/api/scan", header: "Bearer valid", expected: http.StatusOK},
+		{name: "forbidden path", method: http.MethodPost, path: "/api/dryrun", header: "Bearer valid", expected: http.StatusForbidden},
+		{name: "read allowed", method: http.MethodGet, path: "/api/results", header: "Bearer valid", expected: http.StatusOK},
+		{name: "read without token", method: http.MethodGet, path: "/api/results", header: "", expected: http.StatusUnauthorized},
+		{name: "read is checked with get verb", method: http.MethodGet, path: "/api/scan", header: "Bearer valid", expected: http.StatusForbidden},
+	}
+	for _, tc := range testcases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		code, err := authorizer.Authorize(req)
+		if code != tc.expected {
+			t.Errorf("%s: unexpected status code: got: %v\nwant: %v; err: %v", tc.name, code, tc.expected, err)
+			return
+		}
+		if (err == nil) != (tc.expected == http.StatusOK) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,119 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclient "k8s.io/client-go/kubernetes"
+)
+
+const observerConfigName = "OBSERVER_CONFIG_NAME"
+const observerConfigKey = "OBSERVER_CONFIG_KEY"
+const defaultObserverConfigName = "observer-config"
+
+type ObserverConfig struct {
+	Exporters  ExporterConfig   `json:"exporters,omitempty"`
+	SideEffect SideEffectConfig `json:"sideEffect,omitempty"`
+	Schedule   ScheduleConfig   `json:"schedule,omitempty"`
+	Coverage   CoverageConfig   `json:"coverage,omitempty"`
+	Provenance ProvenanceConfig `json:"provenance,omitempty"`
+}
+
+type ExporterConfig struct {
+	File   FileExporterConfig   `json:"file,omitempty"`
+	HTTP   HTTPExporterConfig   `json:"http,omitempty"`
+	Syslog SyslogExporterConfig `json:"syslog,omitempty"`
+}
+
+type FileExporterConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Path    string `json:"path,omitempty"`
+}
+
+type HTTPExporterConfig struct {
+	Enabled  bool              `json:"enabled,omitempty"`
+	Endpoint string            `json:"endpoint,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// request timeout as a duration string (e.g. "10s")
+	Timeout    string `json:"timeout,omitempty"`
+	MaxRetries *int   `json:"maxRetries,omitempty"`
+	// wait before the first retry; it is doubled for each further retry
+	InitialBackoff string `json:"initialBackoff,omitempty"`
+}
+
+type SyslogExporterConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// "udp" or "tcp"
+	Network  string `json:"network,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Facility string `json:"facility,omitempty"`
+	AppName  string `json:"appName,omitempty"`
+}
+
+// LoadObserverConfig loads the observer config from the ConfigMap specified by env values.
+// Default config is returned if the ConfigMap does not exist.
+func LoadObserverConfig() (*ObserverConfig, error) {
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultPodNamespace
+	}
+	configName := os.Getenv(observerConfigName)
+	if configName == "" {
+		configName = defaultObserverConfigName
+	}
+	configKey := os.Getenv(observerConfigKey)
+	if configKey == "" {
+		configKey = defaultKeyInConfigMap
+	}
+
+	config, err := kubeutil.GetKubeConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configName, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return &ObserverConfig{}, nil
+		}
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", configName, namespace))
+	}
+	cfgBytes, found := cm.Data[configKey]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("`%s` is not found in configmap", configKey))
+	}
+	var oc *ObserverConfig
+	err = yaml.Unmarshal([]byte(cfgBytes), &oc)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to unmarshal config.yaml into %T", oc))
+	}
+	if oc == nil {
+		oc = &ObserverConfig{}
+	}
+	return oc, nil
+}
@@ -0,0 +1,498 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubeclient "k8s.io/client-go/kubernetes"
+)
+
+const defaultCoverageReportConfigName = "observer-coverage-report"
+
+// CoverageReportShardLabel is set on ConfigMaps which hold a part of the coverage report
+const CoverageReportShardLabel = "integrityshield.io/coverageReport"
+
+const coverageReportFormatSharded = "sharded/v1"
+const defaultMaxCoverageResources = 1000
+
+const (
+	CoverageProtected  = "protected"
+	CoverageSkipped    = "skipped"
+	CoverageNotCovered = "notCovered"
+)
+
+// kinds which change frequently and are never signed
+var defaultCoverageExcludedKinds = []string{
+	"Event",
+	"Lease",
+	"Endpoints",
+	"EndpointSlice",
+	"ManifestIntegrityState",
+}
+
+type CoverageConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// name of the ConfigMap which stores the latest report
+	ConfigName string `json:"configName,omitempty"`
+	// kinds which are not reported; default kinds are excluded if empty
+	ExcludedKinds      []string `json:"excludedKinds,omitempty"`
+	ExcludedNamespaces []string `json:"excludedNamespaces,omitempty"`
+	// max number of skipped / not covered resources listed in the report
+	MaxResources int `json:"maxResources,omitempty"`
+}
+
+// CoverageReportIndex is stored in the coverage report ConfigMap and lists the shards
+// which hold the gzip compressed CoverageReport in order.
+type CoverageReportIndex struct {
+	Format      string        `json:"format"`
+	Time        string        `json:"time"`
+	Compression string        `json:"compression"`
+	Total       CoverageCount `json:"total"`
+	Truncated   bool          `json:"truncated,omitempty"`
+	Shards      []string      `json:"shards"`
+}
+
+// CoverageReport shows which resources in the cluster are protected by constraints.
+// A resource is "protected" if a constraint verifies it, "skipped" if constraints match it
+// but skip it by skipObjects or objectSelector, and "notCovered" if no constraint matches it.
+type CoverageReport struct {
+	Time       string             `json:"time"`
+	Total      CoverageCount      `json:"total"`
+	Summaries  []CoverageSummary  `json:"summaries"`
+	Skipped    []CoverageResource `json:"skipped"`
+	NotCovered []CoverageResource `json:"notCovered"`
+	// true if the resource lists are cut at MaxResources
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type CoverageCount struct {
+	Protected  int `json:"protected"`
+	Skipped    int `json:"skipped"`
+	NotCovered int `json:"notCovered"`
+	// resources with signature annotations which are not covered by any constraint
+	SignedNotCovered int `json:"signedNotCovered"`
+}
+
+// CoverageSummary is the count of resources in a namespace for a kind
+type CoverageSummary struct {
+	Namespace string `json:"namespace"`
+	ApiGroup  string `json:"apiGroup"`
+	Kind      string `json:"kind"`
+	CoverageCount
+}
+
+type CoverageResource struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	ApiGroup  string `json:"apiGroup"`
+	Signed    bool   `json:"signed"`
+	// constraints which match but skip the resource
+	Constraints []string `json:"constraints,omitempty"`
+}
+
+func (self *CoverageCount) add(status string, signed bool) {
+	switch status {
+	case CoverageProtected:
+		self.Protected++
+	case CoverageSkipped:
+		self.Skipped++
+	case CoverageNotCovered:
+		self.NotCovered++
+		if signed {
+			self.SignedNotCovered++
+		}
+	}
+}
+
+// updateCoverage lists all resources in the cluster and stores the coverage report of the constraints.
+func (self *Observer) updateCoverage(config CoverageConfig, constraints []ConstraintSpec, rhconfig *k8smnfconfig.RequestHandlerConfig) error {
+	resources, nsLabels, err := self.listResourcesForCoverage(config)
+	if err != nil {
+		return err
+	}
+	report := makeCoverageReport(config, resources, constraints, rhconfig, nsLabels)
+	self.lock.Lock()
+	self.coverage = &report
+	self.lock.Unlock()
+	updateCoverageMetrics(report)
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultPodNamespace
+	}
+	configName := config.ConfigName
+	if configName == "" {
+		configName = defaultCoverageReportConfigName
+	}
+	kubeconf, _ := kubeutil.GetKubeConfig()
+	clientset, err := kubeclient.NewForConfig(kubeconf)
+	if err != nil {
+		return errors.Wrap(err, "failed to create a client for coverage report")
+	}
+	return writeCoverageReport(clientset, namespace, configName, report)
+}
+
+// refreshCoverage updates the coverage report with all constraints if it is enabled.
+// It is called after scans of a part of constraints, which do not update the report.
+func (self *Observer) refreshCoverage() {
+	oconfig, err := LoadObserverConfig()
+	if err != nil {
+		log.Error("Failed to load ObserverConfig; err: ", err.Error())
+		return
+	}
+	if !oconfig.Coverage.Enabled {
+		return
+	}
+	rhconfig, err := k8smnfconfig.LoadRequestHandlerConfig()
+	if err != nil {
+		log.Error("Failed to load RequestHandlerConfig; err: ", err.Error())
+	}
+	if rhconfig == nil {
+		rhconfig = &k8smnfconfig.RequestHandlerConfig{}
+	}
+	constraints, err := self.loadConstraints()
+	if err != nil {
+		log.Error("Failed to load constraints; err: ", err.Error())
+		return
+	}
+	err = self.updateCoverage(oconfig.Coverage, constraints, rhconfig)
+	if err != nil {
+		log.Error("Failed to update coverage report; err: ", err.Error())
+	}
+}
+
+// GetCoverageReport returns the latest coverage report; nil if it is not created yet.
+func (self *Observer) GetCoverageReport() *CoverageReport {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.coverage
+}
+
+// listResourcesForCoverage lists resources of all listable kinds and labels of all namespaces.
+func (self *Observer) listResourcesForCoverage(config CoverageConfig) ([]unstructured.Unstructured, map[string]map[string]string, error) {
+	nsLabels, err := self.getNamespaceLabels()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	excludedKinds := config.ExcludedKinds
+	if len(excludedKinds) == 0 {
+		excludedKinds = defaultCoverageExcludedKinds
+	}
+	resources := []unstructured.Unstructured{}
+	for _, apiResource := range self.APIResources {
+		// skip subresources and resources which cannot be listed
+		if strings.Contains(apiResource.APIResource.Name, "/") || !Contains(apiResource.APIResource.Verbs, "list") {
+			continue
+		}
+		if Contains(excludedKinds, apiResource.APIResource.Kind) {
+			continue
+		}
+		gvr := schema.GroupVersionResource{Group: apiResource.APIGroup, Version: apiResource.APIVersion, Resource: apiResource.APIResource.Name}
+		list, err := self.dynamicClient.Resource(gvr).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			// e.g. RBAC error; the report covers the other kinds
+			log.Warningf("failed to list %s for coverage report; %s", gvr.String(), err.Error())
+			continue
+		}
+		for _, obj := range list.Items {
+			if obj.GetNamespace() != "" && k8smnfutil.MatchWithPatternArray(obj.GetNamespace(), config.ExcludedNamespaces) {
+				continue
+			}
+			resources = append(resources, obj)
+		}
+	}
+	return resources, nsLabels, nil
+}
+
+// getNamespaceLabels returns labels of all namespaces to check namespaceSelector of constraints.
+func (self *Observer) getNamespaceLabels() (map[string]map[string]string, error) {
+	nsGVR := schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	nsList, err := self.dynamicClient.Resource(nsGVR).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list namespaces")
+	}
+	nsLabels := map[string]map[string]string{}
+	for _, ns := range nsList.Items {
+		nsLabels[ns.GetName()] = ns.GetLabels()
+	}
+	return nsLabels, nil
+}
+
+func makeCoverageReport(config CoverageConfig, resources []unstructured.Unstructured, constraints []ConstraintSpec, rhconfig *k8smnfconfig.RequestHandlerConfig, nsLabels map[string]map[string]string) CoverageReport {
+	maxResources := config.MaxResources
+	if maxResources <= 0 {
+		maxResources = defaultMaxCoverageResources
+	}
+	report := CoverageReport{
+		Time:       time.Now().Format(timeFormat),
+		Summaries:  []CoverageSummary{},
+		Skipped:    []CoverageResource{},
+		NotCovered: []CoverageResource{},
+	}
+	type summaryKey struct {
+		namespace string
+		apiGroup  string
+		kind      string
+	}
+	counts := map[summaryKey]*CoverageCount{}
+	verifiers := make([]*shield.Verifier, len(constraints))
+	for i, constraint := range constraints {
+		verifiers[i] = newVerifier(constraint, rhconfig)
+	}
+	for _, obj := range resources {
+		status, skippedBy := classifyCoverage(obj, constraints, verifiers, nsLabels)
+		signed := isSignedResource(obj)
+		gvk := obj.GroupVersionKind()
+		report.Total.add(status, signed)
+		key := summaryKey{namespace: obj.GetNamespace(), apiGroup: gvk.Group, kind: gvk.Kind}
+		if _, ok := counts[key]; !ok {
+			counts[key] = &CoverageCount{}
+		}
+		counts[key].add(status, signed)
+
+		if status == CoverageProtected {
+			continue
+		}
+		res := CoverageResource{
+			Namespace:   obj.GetNamespace(),
+			Name:        obj.GetName(),
+			Kind:        gvk.Kind,
+			ApiGroup:    gvk.Group,
+			Signed:      signed,
+			Constraints: skippedBy,
+		}
+		if status == CoverageSkipped {
+			if len(report.Skipped) < maxResources {
+				report.Skipped = append(report.Skipped, res)
+			} else {
+				report.Truncated = true
+			}
+		} else {
+			if len(report.NotCovered) < maxResources {
+				report.NotCovered = append(report.NotCovered, res)
+			} else {
+				report.Truncated = true
+			}
+		}
+	}
+	for key, count := range counts {
+		report.Summaries = append(report.Summaries, CoverageSummary{
+			Namespace:     key.namespace,
+			ApiGroup:      key.apiGroup,
+			Kind:          key.kind,
+			CoverageCount: *count,
+		})
+	}
+	sort.Slice(report.Summaries, func(i, j int) bool {
+		si, sj := report.Summaries[i], report.Summaries[j]
+		if si.Namespace != sj.Namespace {
+			return si.Namespace < sj.Namespace
+		}
+		if si.ApiGroup != sj.ApiGroup {
+			return si.ApiGroup < sj.ApiGroup
+		}
+		return si.Kind < sj.Kind
+	})
+	return report
+}
+
+// classifyCoverage returns the coverage status of the resource and the constraints which skip it.
+// Filters of the constraints are checked in the same way as the admission controller.
+func classifyCoverage(obj unstructured.Unstructured, constraints []ConstraintSpec, verifiers []*shield.Verifier, nsLabels map[string]map[string]string) (string, []string) {
+	matched := false
+	skippedBy := []string{}
+	for i, constraint := range constraints {
+		if !matchResource(constraint.Match, obj, nsLabels) {
+			continue
+		}
+		matched = true
+		if ok, _ := verifiers[i].CheckFilters(obj, ""); !ok {
+			skippedBy = append(skippedBy, constraint.Parameters.ConstraintName)
+			continue
+		}
+		return CoverageProtected, nil
+	}
+	if matched {
+		return CoverageSkipped, skippedBy
+	}
+	return CoverageNotCovered, nil
+}
+
+// matchResource checks the match condition of a constraint in the same way as admission requests.
+func matchResource(match MatchCondition, obj unstructured.Unstructured, nsLabels map[string]map[string]string) bool {
+	gvk := obj.GroupVersionKind()
+	if !matchKinds(match.Kinds, gvk.Group, gvk.Kind) {
+		return false
+	}
+	namespace := obj.GetNamespace()
+	if namespace != "" {
+		if k8smnfutil.MatchWithPatternArray(namespace, match.ExcludedNamespaces) {
+			return false
+		}
+		if len(match.Namespaces) > 0 && !k8smnfutil.MatchWithPatternArray(namespace, match.Namespaces) {
+			return false
+		}
+		if !matchLabelSelector(match.NamespaceSelector, nsLabels[namespace]) {
+			return false
+		}
+	}
+	return matchLabelSelector(match.LabelSelector, obj.GetLabels())
+}
+
+func matchKinds(kindsList []Kinds, group, kind string) bool {
+	for _, kinds := range kindsList {
+		groupMatched := len(kinds.ApiGroups) == 0 || Contains(kinds.ApiGroups, "*") || Contains(kinds.ApiGroups, group)
+		kindMatched := len(kinds.Kinds) == 0 || Contains(kinds.Kinds, "*") || Contains(kinds.Kinds, kind)
+		if groupMatched && kindMatched {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLabelSelector returns true if the selector is empty or matches the labels.
+// An invalid selector matches no labels as the admission controller does.
+func matchLabelSelector(labelSelector *metav1.LabelSelector, labelsMap map[string]string) bool {
+	if labelSelector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		log.Errorf("failed to convert the LabelSelector api type into a struct that implements labels.Selector; %s", err.Error())
+		return false
+	}
+	return selector.Matches(labels.Set(labelsMap))
+}
+
+// isSignedResource checks if the resource has a signature annotation of k8s-manifest-sigstore or integrity shield.
+func isSignedResource(obj unstructured.Unstructured) bool {
+	baseNames := []string{
+		k8smanifest.MessageAnnotationBaseName,
+		k8smanifest.SignatureAnnotationBaseName,
+		k8smanifest.ImageRefAnnotationBaseName,
+		k8smanifest.BundleAnnotationBaseName,
+	}
+	annotations := obj.GetAnnotations()
+	for _, domain := range []string{k8smanifest.DefaultAnnotationKeyDomain, AnnotationKeyDomain} {
+		for _, base := range baseNames {
+			if _, ok := annotations[fmt.Sprintf("%s/%s", domain, base)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writeCoverageReport stores the gzip compressed report into shards which fit into a ConfigMap,
+// and then updates the index ConfigMap `configName`. Shards which are no longer referenced are removed.
+func writeCoverageReport(client kubeclient.Interface, namespace, configName string, report CoverageReport) error {
+	reportBytes, err := json.Marshal(report)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal coverage report")
+	}
+	data, err := gzipData(reportBytes)
+	if err != nil {
+		return errors.Wrap(err, "failed to compress coverage report")
+	}
+	index := CoverageReportIndex{
+		Format:      coverageReportFormatSharded,
+		Time:        report.Time,
+		Compression: resultDetailCompressionGzip,
+		Total:       report.Total,
+		Truncated:   report.Truncated,
+		Shards:      []string{},
+	}
+	currentShards := map[string]bool{}
+	labels := map[string]string{
+		CoverageReportShardLabel: configName,
+	}
+	for i := 0; i*maxResultDetailShardSize < len(data); i++ {
+		end := (i + 1) * maxResultDetailShardSize
+		if end > len(data) {
+			end = len(data)
+		}
+		shardName := fmt.Sprintf("%s-%d", configName, i)
+		err = applyResultDetailConfigMap(client, namespace, shardName, defaultKeyInConfigMap, data[i*maxResultDetailShardSize:end], true, labels)
+		if err != nil {
+			return err
+		}
+		currentShards[shardName] = true
+		index.Shards = append(index.Shards, shardName)
+	}
+
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal coverage report index")
+	}
+	err = applyResultDetailConfigMap(client, namespace, configName, defaultKeyInConfigMap, indexBytes, false, nil)
+	if err != nil {
+		return err
+	}
+	deleteStaleShards(client, namespace, CoverageReportShardLabel, configName, currentShards)
+	return nil
+}
+
+// LoadCoverageReport reads the coverage report index ConfigMap `configName` and reassembles the report from its shards.
+func LoadCoverageReport(client kubeclient.Interface, namespace, configName string) (*CoverageReport, error) {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), configName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", configName, namespace))
+	}
+	var index CoverageReportIndex
+	err = json.Unmarshal([]byte(cm.Data[defaultKeyInConfigMap]), &index)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal coverage report index")
+	}
+	var data []byte
+	for _, shardName := range index.Shards {
+		shard, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), shardName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to get a coverage report shard `%s`", shardName))
+		}
+		data = append(data, shard.BinaryData[defaultKeyInConfigMap]...)
+	}
+	reportBytes, err := gunzipData(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress coverage report")
+	}
+	var report CoverageReport
+	err = json.Unmarshal(reportBytes, &report)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal coverage report")
+	}
+	return &report, nil
+}
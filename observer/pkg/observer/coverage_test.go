@@ -0,0 +1,212 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func makeTestNamespace(name string, labels map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("Namespace")
+	obj.SetName(name)
+	obj.SetLabels(labels)
+	return obj
+}
+
+func makeTestCoverageConstraints() []ConstraintSpec {
+	return []ConstraintSpec{
+		{
+			Match: MatchCondition{
+				Kinds:             []Kinds{{Kinds: []string{"ConfigMap"}, ApiGroups: []string{""}}},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"protected": "true"}},
+			},
+			Parameters: k8smnfconfig.ParameterObject{
+				ConstraintName: "configmap-constraint",
+				VerifyResourceOption: k8smanifest.VerifyResourceOption{
+					SkipObjects: k8smanifest.ObjectReferenceList{{Kind: "ConfigMap", Name: "cm-skip"}},
+				},
+			},
+		},
+	}
+}
+
+func TestMakeCoverageReport(t *testing.T) {
+	signed := map[string]string{"cosign.sigstore.dev/message": "dummy"}
+	protected := makeTestConfigMap("cm-0", nil)
+	skipped := makeTestConfigMap("cm-skip", nil)
+	// ns-1 is not selected by the namespaceSelector
+	notCovered := makeTestConfigMap("cm-1", signed)
+	notCovered.SetNamespace("ns-1")
+	nsLabels := map[string]map[string]string{
+		"ns-0": {"protected": "true"},
+		"ns-1": {},
+	}
+	resources := []unstructured.Unstructured{*protected, *skipped, *notCovered}
+	report := makeCoverageReport(CoverageConfig{}, resources, makeTestCoverageConstraints(), nil, nsLabels)
+
+	want := CoverageCount{Protected: 1, Skipped: 1, NotCovered: 1, SignedNotCovered: 1}
+	if report.Total != want {
+		t.Errorf("unexpected total: got: %v\nwant: %v", report.Total, want)
+		return
+	}
+	if len(report.Summaries) != 2 || report.Summaries[0].Namespace != "ns-0" || report.Summaries[0].Protected != 1 || report.Summaries[1].NotCovered != 1 {
+		t.Errorf("unexpected summaries: got: %v", report.Summaries)
+		return
+	}
+	if len(report.Skipped) != 1 || len(report.Skipped[0].Constraints) != 1 || report.Skipped[0].Constraints[0] != "configmap-constraint" {
+		t.Errorf("unexpected skipped resources: got: %v", report.Skipped)
+		return
+	}
+	if len(report.NotCovered) != 1 || report.NotCovered[0].Name != "cm-1" || !report.NotCovered[0].Signed {
+		t.Errorf("unexpected not covered resources: got: %v", report.NotCovered)
+		return
+	}
+
+	// resource lists are cut at MaxResources
+	report = makeCoverageReport(CoverageConfig{MaxResources: 1}, []unstructured.Unstructured{*notCovered, *notCovered}, nil, nil, nsLabels)
+	if len(report.NotCovered) != 1 || !report.Truncated || report.Total.NotCovered != 2 {
+		t.Errorf("unexpected truncation: got: %v, %v, %v", len(report.NotCovered), report.Truncated, report.Total.NotCovered)
+		return
+	}
+}
+
+func TestListResourcesForCoverage(t *testing.T) {
+	objs := []runtime.Object{
+		makeTestNamespace("ns-0", map[string]string{"protected": "true"}),
+		makeTestConfigMap("cm-0", nil),
+	}
+	excluded := makeTestConfigMap("cm-1", nil)
+	excluded.SetNamespace("kube-system")
+	objs = append(objs, excluded)
+	listKinds := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "namespaces"}: "NamespaceList",
+		testConfigMapGVR:                        "ConfigMapList",
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objs...)
+	insp := &Observer{
+		dynamicClient: client,
+		APIResources: []groupResource{
+			{APIVersion: "v1", APIResource: metav1.APIResource{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: []string{"get", "list"}}},
+			// subresources and excluded kinds are not listed
+			{APIVersion: "v1", APIResource: metav1.APIResource{Name: "pods/log", Kind: "Pod", Namespaced: true, Verbs: []string{"get", "list"}}},
+			{APIVersion: "v1", APIResource: metav1.APIResource{Name: "events", Kind: "Event", Namespaced: true, Verbs: []string{"get", "list"}}},
+		},
+	}
+	resources, nsLabels, err := insp.listResourcesForCoverage(CoverageConfig{ExcludedNamespaces: []string{"kube-*"}})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(resources) != 1 || resources[0].GetName() != "cm-0" {
+		t.Errorf("unexpected resources: got: %v", resources)
+		return
+	}
+	if nsLabels["ns-0"]["protected"] != "true" {
+		t.Errorf("unexpected namespace labels: got: %v", nsLabels)
+		return
+	}
+}
+
+func TestWriteAndLoadCoverageReport(t *testing.T) {
+	orgMaxSize := maxResultDetailShardSize
+	maxResultDetailShardSize = 256
+	defer func() { maxResultDetailShardSize = orgMaxSize }()
+
+	report := CoverageReport{Time: "2021-09-01 00:00:00", Total: CoverageCount{NotCovered: 200}}
+	for i := 0; i < 200; i++ {
+		report.NotCovered = append(report.NotCovered, CoverageResource{
+			Namespace: fmt.Sprintf("ns-%d", i%7),
+			Name:      fmt.Sprintf("cm-%d", i),
+			Kind:      "ConfigMap",
+		})
+	}
+	client := fake.NewSimpleClientset()
+	err := writeCoverageReport(client, testNamespace, defaultCoverageReportConfigName, report)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	selector := fmt.Sprintf("%s=%s", CoverageReportShardLabel, defaultCoverageReportConfigName)
+	shards, _ := client.CoreV1().ConfigMaps(testNamespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if len(shards.Items) < 2 {
+		t.Errorf("report should be split into multiple shards: got: %v", len(shards.Items))
+		return
+	}
+	for _, shard := range shards.Items {
+		if len(shard.BinaryData[defaultKeyInConfigMap]) > maxResultDetailShardSize {
+			t.Errorf("shard %s exceeds the max size: got: %v", shard.Name, len(shard.BinaryData[defaultKeyInConfigMap]))
+			return
+		}
+	}
+	loaded, err := LoadCoverageReport(client, testNamespace, defaultCoverageReportConfigName)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !reflect.DeepEqual(*loaded, report) {
+		t.Errorf("reassembled report does not match: got: %v\nwant: %v", *loaded, report)
+		return
+	}
+
+	// a smaller report removes stale shards
+	maxResultDetailShardSize = orgMaxSize
+	err = writeCoverageReport(client, testNamespace, defaultCoverageReportConfigName, CoverageReport{Time: "2021-09-02 00:00:00"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	shards, _ = client.CoreV1().ConfigMaps(testNamespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if len(shards.Items) != 1 {
+		t.Errorf("stale shards are not removed: got: %v\nwant: %v", len(shards.Items), 1)
+		return
+	}
+}
+
+func TestMatchLabelSelector(t *testing.T) {
+	testcases := []struct {
+		name     string
+		selector *metav1.LabelSelector
+		labels   map[string]string
+		matched  bool
+	}{
+		{name: "no selector", selector: nil, labels: nil, matched: true},
+		{name: "matched", selector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, labels: map[string]string{"env": "prod"}, matched: true},
+		{name: "not matched", selector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}, labels: map[string]string{"env": "dev"}, matched: false},
+		// invalid selector is treated as not matched as the admission controller does
+		{name: "invalid", selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Unknown"}}}, labels: map[string]string{"env": "dev"}, matched: false},
+	}
+	for _, tc := range testcases {
+		if matched := matchLabelSelector(tc.selector, tc.labels); matched != tc.matched {
+			t.Errorf("%s: unexpected result: got: %v\nwant: %v", tc.name, matched, tc.matched)
+			return
+		}
+	}
+}
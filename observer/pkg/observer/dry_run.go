@@ -0,0 +1,197 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	DryRunSourceCluster = "cluster"
+	DryRunSourceRequest = "request"
+)
+
+// DryRunRequest is a candidate constraint which is evaluated without installing it.
+type DryRunRequest struct {
+	Constraint ConstraintSpec `json:"constraint"`
+	// recorded admission requests which are evaluated in addition to the current cluster objects
+	AdmissionRequests []admissionv1.AdmissionRequest `json:"admissionRequests,omitempty"`
+}
+
+// DryRunReport lists the objects and requests which the candidate constraint would deny.
+type DryRunReport struct {
+	Time           string `json:"time"`
+	ConstraintName string `json:"constraintName"`
+	// number of cluster objects / requests matched by the constraint
+	MatchedObjects  int            `json:"matchedObjects"`
+	MatchedRequests int            `json:"matchedRequests"`
+	WouldDeny       int            `json:"wouldDeny"`
+	Results         []DryRunResult `json:"results"`
+}
+
+type DryRunResult struct {
+	// "cluster" or "request"
+	Source    string `json:"source"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	ApiGroup  string `json:"apiGroup"`
+	Operation string `json:"operation,omitempty"`
+	UserName  string `json:"userName,omitempty"`
+	Message   string `json:"message"`
+}
+
+// DryRun evaluates the candidate constraint against all current cluster objects
+// and the recorded admission requests, and reports what it would deny.
+func (self *Observer) DryRun(req DryRunRequest) (*DryRunReport, error) {
+	rhconfig, err := k8smnfconfig.LoadRequestHandlerConfig()
+	if err != nil {
+		log.Error("Failed to load RequestHandlerConfig; err: ", err.Error())
+	}
+	if rhconfig == nil {
+		rhconfig = &k8smnfconfig.RequestHandlerConfig{}
+	}
+	nsLabels, err := self.getNamespaceLabels()
+	if err != nil {
+		return nil, err
+	}
+	objects := self.listResourcesForConstraint(req.Constraint.Match)
+	report := evaluateDryRun(req.Constraint, objects, req.AdmissionRequests, rhconfig, nsLabels)
+	return &report, nil
+}
+
+// listResourcesForConstraint lists resources of all kinds matched by the constraint in all namespaces.
+// Namespaces and selectors are checked for each object later.
+func (self *Observer) listResourcesForConstraint(match MatchCondition) []unstructured.Unstructured {
+	resources := []unstructured.Unstructured{}
+	for _, apiResource := range self.APIResources {
+		if strings.Contains(apiResource.APIResource.Name, "/") || !Contains(apiResource.APIResource.Verbs, "list") {
+			continue
+		}
+		if !matchKinds(match.Kinds, apiResource.APIGroup, apiResource.APIResource.Kind) {
+			continue
+		}
+		gvr := schema.GroupVersionResource{Group: apiResource.APIGroup, Version: apiResource.APIVersion, Resource: apiResource.APIResource.Name}
+		list, err := self.dynamicClient.Resource(gvr).List(context.Background(), metav1.ListOptions{})
+		if err != nil {
+			log.Warningf("failed to list %s for dry-run; %s", gvr.String(), err.Error())
+			continue
+		}
+		resources = append(resources, list.Items...)
+	}
+	return resources
+}
+
+func evaluateDryRun(constraint ConstraintSpec, objects []unstructured.Unstructured, requests []admissionv1.AdmissionRequest, rhconfig *k8smnfconfig.RequestHandlerConfig, nsLabels map[string]map[string]string) DryRunReport {
+	verifier := newVerifier(constraint, rhconfig)
+	report := DryRunReport{
+		Time:           time.Now().Format(timeFormat),
+		ConstraintName: constraint.Parameters.ConstraintName,
+		Results:        []DryRunResult{},
+	}
+	for _, obj := range objects {
+		if !matchResource(constraint.Match, obj, nsLabels) {
+			continue
+		}
+		report.MatchedObjects++
+		result := ObserveResource(obj, verifier)
+		if !result.Violation {
+			continue
+		}
+		report.Results = append(report.Results, makeDryRunResult(DryRunSourceCluster, obj, result.Message))
+	}
+	for _, areq := range requests {
+		// only objects in create / update requests are verified
+		if areq.Operation != admissionv1.Create && areq.Operation != admissionv1.Update {
+			continue
+		}
+		var obj unstructured.Unstructured
+		if err := json.Unmarshal(areq.Object.Raw, &obj.Object); err != nil {
+			log.Warningf("failed to unmarshal the object in the recorded request `%s`; %s", areq.UID, err.Error())
+			continue
+		}
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(areq.Namespace)
+		}
+		if !matchResource(constraint.Match, obj, nsLabels) {
+			continue
+		}
+		report.MatchedRequests++
+		// the admission controller decides with the requester
+		result := verifier.Verify(obj, areq.UserInfo.Username)
+		if result.Allow {
+			continue
+		}
+		res := makeDryRunResult(DryRunSourceRequest, obj, result.Message)
+		res.Operation = string(areq.Operation)
+		res.UserName = areq.UserInfo.Username
+		report.Results = append(report.Results, res)
+	}
+	report.WouldDeny = len(report.Results)
+	return report
+}
+
+func makeDryRunResult(source string, obj unstructured.Unstructured, msg string) DryRunResult {
+	gvk := obj.GroupVersionKind()
+	return DryRunResult{
+		Source:    source,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Kind:      gvk.Kind,
+		ApiGroup:  gvk.Group,
+		Message:   msg,
+	}
+}
+
+// DecodeDryRunRequest reads a DryRunRequest.
+// The constraint can be either a ConstraintSpec or a ManifestIntegrityConstraint manifest.
+func DecodeDryRunRequest(data []byte) (DryRunRequest, error) {
+	var raw struct {
+		Constraint        json.RawMessage                `json:"constraint"`
+		AdmissionRequests []admissionv1.AdmissionRequest `json:"admissionRequests,omitempty"`
+	}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return DryRunRequest{}, errors.Wrap(err, "failed to unmarshal dry-run request")
+	}
+	var mic struct {
+		Kind string         `json:"kind"`
+		Spec ConstraintSpec `json:"spec"`
+	}
+	var constraint ConstraintSpec
+	if err := json.Unmarshal(raw.Constraint, &mic); err == nil && mic.Kind != "" {
+		constraint = mic.Spec
+	} else if err := json.Unmarshal(raw.Constraint, &constraint); err != nil {
+		return DryRunRequest{}, errors.Wrap(err, "failed to unmarshal the constraint in dry-run request")
+	}
+	if len(constraint.Match.Kinds) == 0 {
+		return DryRunRequest{}, errors.New(fmt.Sprintf("`match.kinds` is required in the constraint `%s`", constraint.Parameters.ConstraintName))
+	}
+	return DryRunRequest{Constraint: constraint, AdmissionRequests: raw.AdmissionRequests}, nil
+}
@@ -0,0 +1,110 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"encoding/json"
+	"testing"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func makeTestAdmissionRequest(t *testing.T, operation admissionv1.Operation, obj *unstructured.Unstructured, userName string) admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(obj.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admissionv1.AdmissionRequest{
+		UID:       types.UID("uid-" + obj.GetName()),
+		Operation: operation,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Object:    runtime.RawExtension{Raw: raw},
+		UserInfo:  authenticationv1.UserInfo{Username: userName},
+	}
+}
+
+func TestEvaluateDryRun(t *testing.T) {
+	constraint := ConstraintSpec{
+		Match: MatchCondition{
+			Kinds:      []Kinds{{Kinds: []string{"ConfigMap"}}},
+			Namespaces: []string{"ns-0"},
+		},
+		Parameters: k8smnfconfig.ParameterObject{
+			ConstraintName: "candidate",
+			SkipUsers:      k8smnfconfig.ObjectUserBindingList{{Users: []string{"system:admin"}}},
+			VerifyResourceOption: k8smanifest.VerifyResourceOption{
+				SkipObjects: k8smanifest.ObjectReferenceList{{Kind: "ConfigMap", Name: "cm-skip"}},
+			},
+		},
+	}
+	// ns-1 is out of the constraint
+	other := makeTestConfigMap("cm-0", nil)
+	other.SetNamespace("ns-1")
+	objects := []unstructured.Unstructured{*makeTestConfigMap("cm-0", nil), *makeTestConfigMap("cm-skip", nil), *other}
+	requests := []admissionv1.AdmissionRequest{
+		makeTestAdmissionRequest(t, admissionv1.Create, makeTestConfigMap("cm-1", nil), "system:admin"),
+		makeTestAdmissionRequest(t, admissionv1.Update, makeTestConfigMap("cm-2", nil), "developer"),
+		makeTestAdmissionRequest(t, admissionv1.Delete, makeTestConfigMap("cm-3", nil), "developer"),
+	}
+	report := evaluateDryRun(constraint, objects, requests, &k8smnfconfig.RequestHandlerConfig{}, nil)
+
+	if report.MatchedObjects != 2 || report.MatchedRequests != 2 {
+		t.Errorf("unexpected matched count: got: %v objects, %v requests\nwant: 2 objects, 2 requests", report.MatchedObjects, report.MatchedRequests)
+		return
+	}
+	// unsigned cm-0 in the cluster and unsigned cm-2 updated by a developer would be denied
+	if report.WouldDeny != 2 {
+		t.Errorf("unexpected number of denials: got: %v\nwant: %v", report.WouldDeny, 2)
+		return
+	}
+	if report.Results[0].Source != DryRunSourceCluster || report.Results[0].Name != "cm-0" {
+		t.Errorf("unexpected result: got: %v", report.Results[0])
+		return
+	}
+	if report.Results[1].Source != DryRunSourceRequest || report.Results[1].Name != "cm-2" || report.Results[1].UserName != "developer" {
+		t.Errorf("unexpected result: got: %v", report.Results[1])
+		return
+	}
+}
+
+func TestDecodeDryRunRequest(t *testing.T) {
+	mic := `{"constraint": {"apiVersion": "constraints.gatekeeper.sh/v1beta1", "kind": "ManifestIntegrityConstraint",
+		"metadata": {"name": "candidate"},
+		"spec": {"match": {"kinds": [{"kinds": ["ConfigMap"]}]}, "parameters": {"constraintName": "candidate"}}}}`
+	req, err := DecodeDryRunRequest([]byte(mic))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if req.Constraint.Parameters.ConstraintName != "candidate" || len(req.Constraint.Match.Kinds) != 1 {
+		t.Errorf("unexpected constraint: got: %v", req.Constraint)
+		return
+	}
+
+	_, err = DecodeDryRunRequest([]byte(`{"constraint": {"parameters": {"constraintName": "candidate"}}}`))
+	if err == nil {
+		t.Errorf("constraint without kinds should be rejected")
+		return
+	}
+}
@@ -0,0 +1,440 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultHTTPExporterTimeout = 10 * time.Second
+const defaultHTTPExporterMaxRetries = 3
+const defaultHTTPExporterInitialBackoff = 1 * time.Second
+
+// number of scan results waiting for the export worker; results of later scans are dropped while it is full
+const exportQueueSize = 16
+
+const defaultSyslogNetwork = "udp"
+const defaultSyslogFacility = "local0"
+const defaultSyslogAppName = "integrity-shield-observer"
+const syslogDialTimeout = 10 * time.Second
+
+// sd-id of the structured data in syslog messages; 32473 is the private enterprise number reserved for documentation
+const syslogStructuredDataID = "ishield@32473"
+
+// Exporter sends observation results to an external system.
+type Exporter interface {
+	Name() string
+	Export(records []ExportRecord) error
+}
+
+// the message of a signature mismatch is followed by the diff of the resource
+const diffMessagePrefix = "diff found:"
+
+// ExportRecord is a verify result of a single resource observed for a constraint.
+type ExportRecord struct {
+	ObservationTime string `json:"observationTime"`
+	ConstraintName  string `json:"constraintName"`
+	VerifyResultDetail
+}
+
+func makeExportRecords(results ObservationDetailResults) []ExportRecord {
+	records := []ExportRecord{}
+	for _, cres := range results.ConstraintResults {
+		for _, res := range cres.Results {
+			records = append(records, ExportRecord{
+				ObservationTime: results.Time,
+				ConstraintName:  cres.ConstraintName,
+				// full verify result (e.g. diff) is available in the result detail ConfigMap;
+				// diffs may contain values of Secrets, so they are removed from records
+				VerifyResultDetail: VerifyResultDetail{
+					Time:        res.Time,
+					Namespace:   res.Namespace,
+					Name:        res.Name,
+					Kind:        res.Kind,
+					ApiGroup:    res.ApiGroup,
+					ApiVersion:  res.ApiVersion,
+					Error:       res.Error,
+					Message:     stripDiffFromMessage(res.Message),
+					Violation:   res.Violation,
+					Remediation: stripDiffFromRemediation(res.Remediation),
+				},
+			})
+		}
+	}
+	return records
+}
+
+// stripDiffFromMessage removes the diff which is embedded in the message of a signature mismatch.
+func stripDiffFromMessage(msg string) string {
+	if i := strings.Index(msg, diffMessagePrefix); i >= 0 {
+		return msg[:i+len(diffMessagePrefix)] + " (omitted)"
+	}
+	return msg
+}
+
+// stripDiffFromRemediation returns a copy of the remediation report without the diff.
+func stripDiffFromRemediation(res *RemediationResult) *RemediationResult {
+	if res == nil {
+		return nil
+	}
+	stripped := *res
+	stripped.Diff = ""
+	return &stripped
+}
+
+// NewExporters returns the exporters enabled in the config.
+func NewExporters(config ExporterConfig) ([]Exporter, error) {
+	exporters := []Exporter{}
+	if config.File.Enabled {
+		e, err := NewFileExporter(config.File)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, e)
+	}
+	if config.HTTP.Enabled {
+		e, err := NewHTTPExporter(config.HTTP)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, e)
+	}
+	if config.Syslog.Enabled {
+		e, err := NewSyslogExporter(config.Syslog)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, e)
+	}
+	return exporters, nil
+}
+
+// exportJob is a scan result queued for the export worker.
+type exportJob struct {
+	exporters []Exporter
+	results   ObservationDetailResults
+}
+
+// exportAsync queues the results for the export worker, so that retries to slow or dead endpoints do not delay scans.
+func (self *Observer) exportAsync(exporters []Exporter, results ObservationDetailResults) {
+	if len(exporters) == 0 {
+		return
+	}
+	self.exportOnce.Do(func() {
+		self.exportJobs = make(chan exportJob, exportQueueSize)
+		go func() {
+			for job := range self.exportJobs {
+				exportToExporters(job.exporters, job.results)
+			}
+		}()
+	})
+	select {
+	case self.exportJobs <- exportJob{exporters: exporters, results: results}:
+	default:
+		log.Warnf("export queue is full; results observed at %s are not exported", results.Time)
+	}
+}
+
+func exportToExporters(exporters []Exporter, results ObservationDetailResults) {
+	if len(exporters) == 0 {
+		return
+	}
+	records := makeExportRecords(results)
+	for _, e := range exporters {
+		err := e.Export(records)
+		if err != nil {
+			log.Errorf("failed to export results with %s exporter: %s", e.Name(), err.Error())
+			continue
+		}
+		log.Debugf("exported %d results with %s exporter", len(records), e.Name())
+	}
+}
+
+//
+// File exporter
+//
+
+// FileExporter appends results to a file in JSON lines format.
+type FileExporter struct {
+	path string
+	lock sync.Mutex
+}
+
+func NewFileExporter(config FileExporterConfig) (*FileExporter, error) {
+	if config.Path == "" {
+		return nil, errors.New("path is required for file exporter")
+	}
+	return &FileExporter{path: config.Path}, nil
+}
+
+func (e *FileExporter) Name() string {
+	return "file"
+}
+
+func (e *FileExporter) Export(records []ExportRecord) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	err := os.MkdirAll(filepath.Dir(e.path), os.ModePerm)
+	if err != nil {
+		return errors.Wrap(err, "failed to create a directory for file exporter")
+	}
+	f, err := os.OpenFile(e.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to open `%s`", e.path))
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to write a result to `%s`", e.path))
+		}
+	}
+	return nil
+}
+
+//
+// HTTP exporter
+//
+
+// HTTPExporter posts results as a JSON array to an endpoint.
+// Requests are retried with exponential backoff on connection errors, 429 and 5xx responses.
+type HTTPExporter struct {
+	endpoint       string
+	headers        map[string]string
+	maxRetries     int
+	initialBackoff time.Duration
+	client         *http.Client
+}
+
+func NewHTTPExporter(config HTTPExporterConfig) (*HTTPExporter, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("endpoint is required for http exporter")
+	}
+	timeout := defaultHTTPExporterTimeout
+	if config.Timeout != "" {
+		d, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse timeout of http exporter")
+		}
+		timeout = d
+	}
+	backoff := defaultHTTPExporterInitialBackoff
+	if config.InitialBackoff != "" {
+		d, err := time.ParseDuration(config.InitialBackoff)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse initialBackoff of http exporter")
+		}
+		backoff = d
+	}
+	maxRetries := defaultHTTPExporterMaxRetries
+	if config.MaxRetries != nil {
+		maxRetries = *config.MaxRetries
+	}
+	return &HTTPExporter{
+		endpoint:       config.Endpoint,
+		headers:        config.Headers,
+		maxRetries:     maxRetries,
+		initialBackoff: backoff,
+		client:         &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (e *HTTPExporter) Name() string {
+	return "http"
+}
+
+func (e *HTTPExporter) Export(records []ExportRecord) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal results")
+	}
+	backoff := e.initialBackoff
+	var lastErr error
+	for attempt := 0; attempt <= e.maxRetries; attempt++ {
+		if attempt > 0 {
+			log.Debugf("retrying http export in %s (attempt %d/%d)", backoff.String(), attempt, e.maxRetries)
+			time.Sleep(backoff)
+			backoff = backoff * 2
+		}
+		retry, err := e.post(body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+// post sends the body once and returns whether the request can be retried on error.
+func (e *HTTPExporter) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create a request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, errors.Wrap(err, fmt.Sprintf("failed to post results to `%s`", e.endpoint))
+	}
+	defer resp.Body.Close()
+	_, _ = ioutil.ReadAll(resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, errors.New(fmt.Sprintf("`%s` returned status %d", e.endpoint, resp.StatusCode))
+}
+
+//
+// Syslog exporter
+//
+
+var syslogFacilityMap = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"authpriv": 10,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+const (
+	syslogSeverityError   = 3
+	syslogSeverityWarning = 4
+	syslogSeverityInfo    = 6
+)
+
+// SyslogExporter sends each result as an RFC5424 message.
+// Messages over TCP are framed with octet counting (RFC6587).
+type SyslogExporter struct {
+	network  string
+	address  string
+	facility int
+	appName  string
+	hostname string
+}
+
+func NewSyslogExporter(config SyslogExporterConfig) (*SyslogExporter, error) {
+	if config.Address == "" {
+		return nil, errors.New("address is required for syslog exporter")
+	}
+	network := config.Network
+	if network == "" {
+		network = defaultSyslogNetwork
+	}
+	if network != "udp" && network != "tcp" {
+		return nil, errors.New(fmt.Sprintf("unsupported network for syslog exporter: %s", network))
+	}
+	facilityName := config.Facility
+	if facilityName == "" {
+		facilityName = defaultSyslogFacility
+	}
+	facility, ok := syslogFacilityMap[facilityName]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unsupported facility for syslog exporter: %s", facilityName))
+	}
+	appName := config.AppName
+	if appName == "" {
+		appName = defaultSyslogAppName
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	return &SyslogExporter{
+		network:  network,
+		address:  config.Address,
+		facility: facility,
+		appName:  appName,
+		hostname: hostname,
+	}, nil
+}
+
+func (e *SyslogExporter) Name() string {
+	return "syslog"
+}
+
+func (e *SyslogExporter) Export(records []ExportRecord) error {
+	conn, err := net.DialTimeout(e.network, e.address, syslogDialTimeout)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to connect to syslog server `%s`", e.address))
+	}
+	defer conn.Close()
+	for _, r := range records {
+		msg := e.format(r, time.Now())
+		if e.network == "tcp" {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		_, err = conn.Write([]byte(msg))
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to send a message to syslog server `%s`", e.address))
+		}
+	}
+	return nil
+}
+
+func (e *SyslogExporter) format(r ExportRecord, now time.Time) string {
+	severity := syslogSeverityInfo
+	if r.Error {
+		severity = syslogSeverityError
+	} else if r.Violation {
+		severity = syslogSeverityWarning
+	}
+	pri := e.facility*8 + severity
+	params := []string{
+		fmt.Sprintf("constraint=\"%s\"", escapeSyslogParamValue(r.ConstraintName)),
+		fmt.Sprintf("kind=\"%s\"", escapeSyslogParamValue(r.Kind)),
+		fmt.Sprintf("namespace=\"%s\"", escapeSyslogParamValue(r.Namespace)),
+		fmt.Sprintf("name=\"%s\"", escapeSyslogParamValue(r.Name)),
+		fmt.Sprintf("violation=\"%t\"", r.Violation),
+	}
+	sd := fmt.Sprintf("[%s %s]", syslogStructuredDataID, strings.Join(params, " "))
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	return fmt.Sprintf("<%d>1 %s %s %s %d observation %s %s", pri, now.UTC().Format(time.RFC3339), e.hostname, e.appName, os.Getpid(), sd, r.Message)
+}
+
+func escapeSyslogParamValue(v string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+	return r.Replace(v)
+}
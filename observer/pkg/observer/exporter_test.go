@@ -0,0 +1,280 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileExporter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "file-exporter")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "results", "observer.jsonl")
+	e, err := NewFileExporter(FileExporterConfig{Enabled: true, Path: path})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	records := makeExportRecords(makeTestDetailResults(1, 2, 2))
+	// export twice to check records are appended
+	for i := 0; i < 2; i++ {
+		if err := e.Export(records); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer f.Close()
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r ExportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Error(err)
+			return
+		}
+		if r.ConstraintName != "constraint-0" {
+			t.Errorf("unexpected constraint name: got: %v\nwant: %v", r.ConstraintName, "constraint-0")
+			return
+		}
+		lines++
+	}
+	if lines != 8 {
+		t.Errorf("unexpected number of lines: got: %v\nwant: %v", lines, 8)
+		return
+	}
+}
+
+func TestHTTPExporterRetry(t *testing.T) {
+	var count int32
+	var received []ExportRecord
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// fail first 2 requests
+		if atomic.AddInt32(&count, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	maxRetries := 3
+	e, err := NewHTTPExporter(HTTPExporterConfig{
+		Enabled:        true,
+		Endpoint:       server.URL,
+		Headers:        map[string]string{"Authorization": "Bearer token"},
+		MaxRetries:     &maxRetries,
+		InitialBackoff: "1ms",
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	records := makeExportRecords(makeTestDetailResults(1, 1, 3))
+	if err := e.Export(records); err != nil {
+		t.Error(err)
+		return
+	}
+	if atomic.LoadInt32(&count) != 3 {
+		t.Errorf("unexpected number of requests: got: %v\nwant: %v", count, 3)
+		return
+	}
+	if len(received) != 3 {
+		t.Errorf("unexpected number of records: got: %v\nwant: %v", len(received), 3)
+		return
+	}
+}
+
+func TestHTTPExporterNoRetryOnClientError(t *testing.T) {
+	var count int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	e, err := NewHTTPExporter(HTTPExporterConfig{Enabled: true, Endpoint: server.URL, InitialBackoff: "1ms"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = e.Export(makeExportRecords(makeTestDetailResults(1, 1, 1)))
+	if err == nil {
+		t.Errorf("export should fail with status 400")
+		return
+	}
+	if atomic.LoadInt32(&count) != 1 {
+		t.Errorf("unexpected number of requests: got: %v\nwant: %v", count, 1)
+		return
+	}
+}
+
+func TestSyslogExporterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	e, err := NewSyslogExporter(SyslogExporterConfig{Enabled: true, Address: conn.LocalAddr().String(), AppName: "test-observer"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	records := makeExportRecords(makeTestDetailResults(1, 1, 2))
+	if err := e.Export(records); err != nil {
+		t.Error(err)
+		return
+	}
+	buf := make([]byte, 2048)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msgs := []string{}
+	for i := 0; i < len(records); i++ {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		msgs = append(msgs, string(buf[:n]))
+	}
+	// first record is a violation: local0(16) * 8 + warning(4)
+	if !strings.HasPrefix(msgs[0], "<132>1 ") {
+		t.Errorf("unexpected syslog header: got: %v", msgs[0])
+		return
+	}
+	// second record is not a violation: local0(16) * 8 + info(6)
+	if !strings.HasPrefix(msgs[1], "<134>1 ") {
+		t.Errorf("unexpected syslog header: got: %v", msgs[1])
+		return
+	}
+	if !strings.Contains(msgs[0], " test-observer ") || !strings.Contains(msgs[0], `[ishield@32473 constraint="constraint-0" kind="ConfigMap" namespace="ns-0" name="cm-0" violation="true"]`) {
+		t.Errorf("unexpected syslog message: got: %v", msgs[0])
+		return
+	}
+}
+
+func TestSyslogExporterTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		data, _ := ioutil.ReadAll(c)
+		received <- string(data)
+	}()
+
+	e, err := NewSyslogExporter(SyslogExporterConfig{Enabled: true, Network: "tcp", Address: ln.Addr().String()})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := e.Export(makeExportRecords(makeTestDetailResults(1, 1, 1))); err != nil {
+		t.Error(err)
+		return
+	}
+	select {
+	case data := <-received:
+		// octet counting framing: "MSG-LEN SP SYSLOG-MSG"
+		parts := strings.SplitN(data, " ", 2)
+		if len(parts) != 2 || parts[0] != strconv.Itoa(len(parts[1])) {
+			t.Errorf("unexpected framing: got: %v", data)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("syslog message is not received")
+	}
+}
+
+type blockingExporter struct {
+	started  chan struct{}
+	release  chan struct{}
+	exported int32
+}
+
+func (e *blockingExporter) Name() string {
+	return "blocking"
+}
+
+func (e *blockingExporter) Export(records []ExportRecord) error {
+	e.started <- struct{}{}
+	<-e.release
+	atomic.AddInt32(&e.exported, 1)
+	return nil
+}
+
+func TestExportAsync(t *testing.T) {
+	e := &blockingExporter{started: make(chan struct{}, exportQueueSize+2), release: make(chan struct{})}
+	o := NewObserver()
+	start := time.Now()
+	o.exportAsync([]Exporter{e}, makeTestDetailResults(1, 1, 1))
+	<-e.started
+	// the first result is taken by the worker and the others fill the queue, so the last one is dropped
+	total := exportQueueSize + 2
+	for i := 1; i < total; i++ {
+		o.exportAsync([]Exporter{e}, makeTestDetailResults(1, 1, 1))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("exports blocked the caller for %s", elapsed.String())
+		return
+	}
+	close(e.release)
+	want := int32(total - 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&e.exported) < want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&e.exported); got != want {
+		t.Errorf("unexpected number of exports: got: %d\nwant: %d", got, want)
+	}
+}
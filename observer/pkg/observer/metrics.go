@@ -0,0 +1,167 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "integrity_shield_observer"
+
+// reasons of observer errors
+const (
+	errorReasonRBAC       = "rbac"
+	errorReasonList       = "list_error"
+	errorReasonVerify     = "verify_error"
+	errorReasonImage      = "image_error"
+	errorReasonScan       = "scan_error"
+	errorReasonProvenance = "provenance_error"
+)
+
+var (
+	violationsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "violations",
+		Help:      "Number of resources which violate the constraint in the latest results.",
+	}, []string{"constraint", "namespace", "kind"})
+
+	nonViolationsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "non_violations",
+		Help:      "Number of resources which comply with the constraint in the latest results.",
+	}, []string{"constraint", "namespace", "kind"})
+
+	coverageGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "coverage_resources",
+		Help:      "Number of resources by coverage status (protected, skipped, notCovered) in the latest coverage report.",
+	}, []string{"namespace", "kind", "status"})
+
+	scanDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "scan_duration_seconds",
+		Help:      "Duration of scans.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"result"})
+
+	lastSuccessfulScanGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_successful_scan_timestamp_seconds",
+		Help:      "Unix time of the last successful scan.",
+	})
+
+	remediationsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "remediations_total",
+		Help:      "Number of remediations by result (applied, dry_run, failed) and manifest source.",
+	}, []string{"result", "source"})
+
+	errorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Number of errors during scans by reason.",
+	}, []string{"reason"})
+)
+
+var metricsRegistry = prometheus.NewRegistry()
+
+func init() {
+	metricsRegistry.MustRegister(
+		violationsGauge,
+		nonViolationsGauge,
+		coverageGauge,
+		scanDurationHistogram,
+		lastSuccessfulScanGauge,
+		remediationsCounter,
+		errorsCounter,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+func recordError(reason string) {
+	errorsCounter.WithLabelValues(reason).Inc()
+}
+
+func recordRemediation(res *RemediationResult) {
+	result := "applied"
+	if res.Error != "" {
+		result = "failed"
+	} else if res.DryRun {
+		result = "dry_run"
+	}
+	remediationsCounter.WithLabelValues(result, res.Source).Inc()
+}
+
+func recordScan(start, end time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+		recordError(errorReasonScan)
+	} else {
+		lastSuccessfulScanGauge.Set(float64(end.Unix()))
+	}
+	scanDurationHistogram.WithLabelValues(result).Observe(end.Sub(start).Seconds())
+}
+
+// updateResultMetrics replaces the result gauges with the counts in the latest results.
+// Gauges are reset so that deleted constraints, namespaces or kinds do not remain.
+func updateResultMetrics(results ObservationDetailResults) {
+	type key struct {
+		constraint string
+		namespace  string
+		kind       string
+	}
+	// [violations, non-violations]
+	counts := map[key][2]int{}
+	for _, cres := range results.ConstraintResults {
+		for _, res := range cres.Results {
+			k := key{constraint: cres.ConstraintName, namespace: res.Namespace, kind: res.Kind}
+			c := counts[k]
+			if res.Violation {
+				c[0]++
+			} else {
+				c[1]++
+			}
+			counts[k] = c
+		}
+	}
+	violationsGauge.Reset()
+	nonViolationsGauge.Reset()
+	for k, c := range counts {
+		violationsGauge.WithLabelValues(k.constraint, k.namespace, k.kind).Set(float64(c[0]))
+		nonViolationsGauge.WithLabelValues(k.constraint, k.namespace, k.kind).Set(float64(c[1]))
+	}
+}
+
+func updateCoverageMetrics(report CoverageReport) {
+	coverageGauge.Reset()
+	// kinds of different api groups are summed up
+	for _, summary := range report.Summaries {
+		coverageGauge.WithLabelValues(summary.Namespace, summary.Kind, CoverageProtected).Add(float64(summary.Protected))
+		coverageGauge.WithLabelValues(summary.Namespace, summary.Kind, CoverageSkipped).Add(float64(summary.Skipped))
+		coverageGauge.WithLabelValues(summary.Namespace, summary.Kind, CoverageNotCovered).Add(float64(summary.NotCovered))
+	}
+}
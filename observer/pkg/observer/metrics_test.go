@@ -0,0 +1,76 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUpdateResultMetrics(t *testing.T) {
+	updateResultMetrics(makeTestDetailResults(2, 2, 3))
+	// cm-0 and cm-2 are violations
+	v := testutil.ToFloat64(violationsGauge.WithLabelValues("constraint-1", "ns-0", "ConfigMap"))
+	nv := testutil.ToFloat64(nonViolationsGauge.WithLabelValues("constraint-1", "ns-0", "ConfigMap"))
+	if v != 2 || nv != 1 {
+		t.Errorf("unexpected gauge values: got: %v, %v\nwant: %v, %v", v, nv, 2, 1)
+		return
+	}
+
+	// series of removed constraints should not remain
+	updateResultMetrics(makeTestDetailResults(1, 1, 1))
+	count := testutil.CollectAndCount(violationsGauge)
+	if count != 1 {
+		t.Errorf("unexpected number of series: got: %v\nwant: %v", count, 1)
+		return
+	}
+
+	o := NewObserver()
+	server := httptest.NewServer(o.NewAPIHandler())
+	defer server.Close()
+	// metrics are not served to unauthorized users
+	o.authorizer = testAuthorizer{code: http.StatusForbidden, err: errors.New("forbidden")}
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected status code: got: %v\nwant: %v", resp.StatusCode, http.StatusForbidden)
+		return
+	}
+	o.authorizer = testAuthorizer{code: http.StatusOK}
+	resp, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	expected := `integrity_shield_observer_violations{constraint="constraint-0",kind="ConfigMap",namespace="ns-0"} 1`
+	if !strings.Contains(string(body), expected) {
+		t.Errorf("metrics do not contain `%s`", expected)
+		return
+	}
+}
@@ -20,11 +20,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	vrc "github.com/IBM/integrity-shield/observer/pkg/apis/manifestintegritystate/v1"
@@ -35,7 +33,7 @@ import (
 	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
 	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
 	log "github.com/sirupsen/logrus"
-	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
@@ -51,11 +49,15 @@ const timeFormat = "2006-01-02 15:04:05"
 const exportDetailResult = "ENABLE_DETAIL_RESULT"
 const detailResultConfigName = "OBSERVER_RESULT_CONFIG_NAME"
 const detailResultConfigKey = "OBSERVER_RESULT_CONFIG_KEY"
+const compressDetailResult = "COMPRESS_DETAIL_RESULT"
+const enableRemediation = "ENABLE_REMEDIATION"
 
 const defaultKeyInConfigMap = "config.yaml"
 const defaultPodNamespace = "integrity-shield-operator-system"
 const defaultExportDetailResult = true
 const defaultObserverResultDetailConfigName = "verify-result-detail"
+const defaultCompressDetailResult = false
+const defaultEnableRemediation = false
 
 const logLevelEnvKey = "LOG_LEVEL"
 const k8sLogLevelEnvKey = "K8S_MANIFEST_SIGSTORE_LOG_LEVEL"
@@ -71,6 +73,23 @@ type Observer struct {
 	APIResources []groupResource
 
 	dynamicClient dynamic.Interface
+
+	lock          sync.RWMutex
+	initialized   bool
+	authorizer    apiAuthorizer
+	status        ScanStatus
+	latestResults map[string]ConstraintResult
+	latestTime    string
+	// last verified states of resources per constraint for remediation
+	snapshots map[string]map[resourceKey][]byte
+	coverage  *CoverageReport
+	// longest interval of the configured schedules, which decides the liveness and readiness thresholds
+	schedulePeriod time.Duration
+	// last time when the scheduler found no constraints, which counts as a healthy scan for readiness
+	noConstraintsTime *time.Time
+	// results are exported by a worker to keep scans independent of external systems
+	exportOnce sync.Once
+	exportJobs chan exportJob
 }
 
 // Observer Result Detail
@@ -85,6 +104,8 @@ type VerifyResultDetail struct {
 	Message              string                            `json:"message"`
 	Violation            bool                              `json:"violation"`
 	VerifyResourceResult *k8smanifest.VerifyResourceResult `json:"verifyResourceResult"`
+	Remediation          *RemediationResult                `json:"remediation,omitempty"`
+	Provenance           *ProvenanceResult                 `json:"provenance,omitempty"`
 }
 type ConstraintResult struct {
 	ConstraintName  string               `json:"constraintName"`
@@ -143,6 +164,11 @@ func (self *Observer) Init() error {
 	}
 	self.dynamicClient = dynamicClient
 
+	kubeClient, err := kubeclient.NewForConfig(kubeconf)
+	if err != nil {
+		return err
+	}
+
 	// log
 	if os.Getenv("LOG_FORMAT") == "json" {
 		log.SetFormatter(&log.JSONFormatter{TimestampFormat: time.RFC3339Nano})
@@ -162,26 +188,88 @@ func (self *Observer) Init() error {
 	cmd := cosign.Init()
 	cmd.Exec(context.Background(), []string{})
 
+	self.lock.Lock()
+	self.authorizer = newKubeAuthorizer(kubeClient)
+	self.initialized = true
+	self.lock.Unlock()
 	return nil
 }
 
+// Run scans all constraints. It is skipped if another scan is in progress.
 func (self *Observer) Run() {
+	err := self.Scan(ScanRequest{})
+	if err != nil {
+		log.Error("Failed to scan resources; err: ", err.Error())
+	}
+}
+
+// Scan runs a scan for the requested scope and waits for it to finish.
+func (self *Observer) Scan(req ScanRequest) error {
+	if !self.beginScan(req) {
+		return ErrScanInProgress
+	}
+	err := self.scan(req)
+	self.endScan(err)
+	return err
+}
+
+// StartScan runs a scan for the requested scope in background.
+func (self *Observer) StartScan(req ScanRequest) error {
+	if !self.beginScan(req) {
+		return ErrScanInProgress
+	}
+	go func() {
+		err := self.scan(req)
+		if err != nil {
+			log.Error("Failed to scan resources; err: ", err.Error())
+		}
+		self.endScan(err)
+	}()
+	return nil
+}
+
+func (self *Observer) scan(req ScanRequest) error {
 	// load config -> requestHandlerConfig
 	rhconfig, err := k8smnfconfig.LoadRequestHandlerConfig()
 	if err != nil {
 		log.Error("Failed to load RequestHandlerConfig; err: ", err.Error())
 	}
+	if rhconfig == nil {
+		rhconfig = &k8smnfconfig.RequestHandlerConfig{}
+	}
+
+	// load observer config
+	oconfig, err := LoadObserverConfig()
+	if err != nil {
+		log.Error("Failed to load ObserverConfig; err: ", err.Error())
+		oconfig = &ObserverConfig{}
+	}
 
 	// load constraints
 	constraints, err := self.loadConstraints()
 	if err != nil {
 		if err.Error() == "the server could not find the requested resource" {
 			log.Info("no observation results")
-			return
+			return nil
 		} else {
-			log.Error("Failed to load constraints; err: ", err.Error())
+			return errors.Wrap(err, "failed to load constraints")
 		}
 	}
+	// results of deleted constraints are removed in any scan, because constraints may be scanned only one by one
+	self.pruneLatestResults(constraints)
+	if req.ConstraintName != "" {
+		filtered := []ConstraintSpec{}
+		for _, constraint := range constraints {
+			if constraint.Parameters.ConstraintName == req.ConstraintName {
+				filtered = append(filtered, constraint)
+			}
+		}
+		if len(filtered) == 0 {
+			return errors.New(fmt.Sprintf("constraint `%s` is not found", req.ConstraintName))
+		}
+		constraints = filtered
+	}
+	self.setScanProgress(len(constraints), 0, 0)
 
 	// setup env value for sigstore
 	if rhconfig.SigStoreConfig.RekorServer != "" {
@@ -194,133 +282,266 @@ func (self *Observer) Run() {
 		log.Debug("REKOR_SERVER is set as ", debug)
 	}
 
+	// results of a namespace scan are kept in memory only,
+	// because they are not complete results of the constraint.
+	exportToCluster := req.Namespace == ""
+
+	// remediation must be allowed in the observer in addition to the constraint
+	remediationAllowed := defaultEnableRemediation
+	if v, err := strconv.ParseBool(os.Getenv(enableRemediation)); err == nil {
+		remediationAllowed = v
+	}
+
 	// ObservationDetailResults
 	var constraintResults []ConstraintResult
-	for _, constraint := range constraints {
+	// resources observed in this scan; used for violation markers
+	observed := map[resourceKey]observedResource{}
+	scannedResources := 0
+	for i, constraint := range constraints {
 		constraintName := constraint.Parameters.ConstraintName
-		var violations []vrc.VerifyResult
-		var nonViolations []vrc.VerifyResult
 		narrowedGVKList := self.getPossibleProtectedGVKs(constraint.Match)
-		if narrowedGVKList == nil {
+		if len(narrowedGVKList) == 0 {
 			log.Info("there is no resources to observe in the constraint:", constraint.Parameters.ConstraintName)
-			return
+			self.setScanProgress(len(constraints), i+1, scannedResources)
+			continue
 		}
 		// get all resources of extracted GVKs
 		resources := []unstructured.Unstructured{}
+		resourceGVRs := map[resourceKey]schema.GroupVersionResource{}
 		for _, gResource := range narrowedGVKList {
+			if req.Namespace != "" {
+				var ok bool
+				gResource, ok = narrowTargetNamespace(gResource, req.Namespace)
+				if !ok {
+					continue
+				}
+			}
 			tmpResources, _ := self.getAllResoucesByGroupResource(gResource)
 			resources = append(resources, tmpResources...)
+			gvr := schema.GroupVersionResource{Group: gResource.APIGroup, Version: gResource.APIVersion, Resource: gResource.APIResource.Name}
+			for _, obj := range tmpResources {
+				resourceGVRs[resourceKeyFromObject(obj)] = gvr
+				if oconfig.SideEffect.ViolationMarker != "" {
+					observed[resourceKeyFromObject(obj)] = observedResource{GVR: gvr, Marker: currentViolationMarker(obj, oconfig.SideEffect.ViolationMarker)}
+				}
+			}
 		}
 
-		// check all resources by verifyResource
-		ignoreFields := constraint.Parameters.IgnoreFields
-		secrets := constraint.Parameters.KeyConfigs
-		ignoreFields = append(ignoreFields, rhconfig.RequestFilterProfile.IgnoreFields...)
-		skipObjects := rhconfig.RequestFilterProfile.SkipObjects
-		skipObjects = append(skipObjects, constraint.Parameters.SkipObjects...)
+		// check all resources in the same way as the admission controller
+		verifier := newVerifier(constraint, rhconfig)
 		results := []VerifyResultDetail{}
+		remediation := constraint.Parameters.Remediation
+		if remediation.IsEnabled() && !remediationAllowed {
+			log.Warningf("remediation is enabled in the constraint %s, but it is not allowed in the observer", constraintName)
+			remediation = nil
+		}
+		snapshots := map[resourceKey][]byte{}
 		for _, resource := range resources {
-			// skip object
-			result := ObserveResource(resource, constraint.Parameters.SignatureRef, ignoreFields, skipObjects, secrets)
-			imgAllow, imgMsg := ObserveImage(resource, constraint.Parameters.ImageProfile)
-			if !imgAllow {
+			result := ObserveResource(resource, verifier)
+			if result.Error {
+				recordError(errorReasonVerify)
+			}
+			if remediation.IsEnabled() {
+				key := resourceKeyFromObject(resource)
 				if !result.Violation {
-					result.Violation = true
-					result.Message = imgMsg
+					if remediation.UseSnapshot {
+						snapshots[key] = makeSnapshot(resource)
+					}
 				} else {
-					result.Message = fmt.Sprintf("%s, [Image]%s", result.Message, imgMsg)
+					result.Remediation = self.remediate(resource, resourceGVRs[key], constraint, verifier)
+					recordRemediation(result.Remediation)
+					// keep the last verified state until the resource is verified again
+					if snapshot := self.getSnapshot(constraintName, key); snapshot != nil {
+						snapshots[key] = snapshot
+					}
 				}
 			}
+			if oconfig.Provenance.IsEnabled() {
+				self.checkProvenance(&result, resource, verifier, oconfig.Provenance)
+			}
 
 			log.Debug("VerifyResultDetail", result)
+			log.WithFields(log.Fields{
+				"constraintName": constraintName,
+				"violation":      result.Violation,
+				"kind":           result.Kind,
+				"name":           result.Name,
+				"namespace":      result.Namespace,
+			}).Info(result.Message)
 			results = append(results, result)
 		}
+		scannedResources += len(results)
+		if remediation.IsEnabled() && remediation.UseSnapshot {
+			self.storeSnapshots(constraintName, req.Namespace, snapshots)
+		}
+
+		cres := ConstraintResult{
+			ConstraintName: constraintName,
+			Results:        results,
+			Constraint:     constraint,
+		}
+		cres.summarize()
+		constraintResults = append(constraintResults, cres)
+
+		// merge into the latest results
+		merged := self.storeConstraintResult(cres, req.Namespace)
+
+		if exportToCluster {
+			// check if targeted constraint
+			ignored := false
+			if constraint.Parameters.Action == nil {
+				ignored = !rhconfig.DefaultConstraintAction.Audit.Inform
 
-		// prepare for manifest integrity state
-		for _, res := range results {
-			// simple result
-			if res.Violation {
-				vres := vrc.VerifyResult{
-					Namespace:  res.Namespace,
-					Name:       res.Name,
-					Kind:       res.Kind,
-					ApiGroup:   res.ApiGroup,
-					ApiVersion: res.ApiVersion,
-					Result:     res.Message,
-				}
-				violations = append(violations, vres)
 			} else {
-				vres := vrc.VerifyResult{
-					Namespace:  res.Namespace,
-					Name:       res.Name,
-					Kind:       res.Kind,
-					ApiGroup:   res.ApiGroup,
-					ApiVersion: res.ApiVersion,
-					Signer:     res.VerifyResourceResult.Signer,
-					SigRef:     res.VerifyResourceResult.SigRef,
-					SignedTime: res.VerifyResourceResult.SignedTime,
-					Result:     res.Message,
-				}
-				nonViolations = append(nonViolations, vres)
+				ignored = !constraint.Parameters.Action.Audit.Inform
 			}
-			log.WithFields(log.Fields{
-				"constraintName": constraintName,
-				"violation":      res.Violation,
-				"kind":           res.Kind,
-				"name":           res.Name,
-				"namespace":      res.Namespace,
-			}).Info(res.Message)
-		}
-		// summarize results
-		var violated bool
-		if len(violations) != 0 {
-			violated = true
-		} else {
-			violated = false
+
+			// export VerifyResult
+			vrr := makeManifestIntegrityStateSpec(merged)
+			_ = exportVerifyResult(vrr, ignored, merged.Violation, countErrors(merged))
 		}
-		count := len(violations)
+		self.setScanProgress(len(constraints), i+1, scannedResources)
+	}
 
-		vrr := vrc.ManifestIntegrityStateSpec{
-			ConstraintName:  constraintName,
-			Violation:       violated,
-			TotalViolations: count,
-			Violations:      violations,
-			NonViolations:   nonViolations,
-			ObservationTime: time.Now().Format(timeFormat),
+	// export ConstraintResult
+	res := ObservationDetailResults{
+		ConstraintResults: constraintResults,
+		Time:              time.Now().Format(timeFormat),
+	}
+	latest := self.GetLatestResults()
+	updateResultMetrics(latest)
+	if exportToCluster {
+		_ = exportResultDetail(latest)
+		err = exportSummary(self.dynamicClient, makeManifestIntegritySummarySpec(latest))
+		if err != nil {
+			log.Error("Failed to export ManifestIntegritySummary; err: ", err.Error())
 		}
+	}
+
+	// coverage report needs all constraints
+	if oconfig.Coverage.Enabled && req.ConstraintName == "" && req.Namespace == "" {
+		err = self.updateCoverage(oconfig.Coverage, constraints, rhconfig)
+		if err != nil {
+			log.Error("Failed to update coverage report; err: ", err.Error())
+		}
+	}
+
+	// side effects on the observed resources
+	if oconfig.SideEffect.Enabled() {
+		self.applySideEffects(oconfig.SideEffect, constraintResults, observed, latest)
+	}
 
-		// check if targeted constraint
-		ignored := false
-		if constraint.Parameters.Action == nil {
-			ignored = !rhconfig.DefaultConstraintAction.Audit.Inform
+	// export results to external systems
+	exporters, err := NewExporters(oconfig.Exporters)
+	if err != nil {
+		log.Error("Failed to setup exporters; err: ", err.Error())
+	} else {
+		self.exportAsync(exporters, res)
+	}
+	return nil
+}
 
+func (self *Observer) applySideEffects(config SideEffectConfig, results []ConstraintResult, observed map[resourceKey]observedResource, latest ObservationDetailResults) {
+	if config.CreateViolationEvent {
+		kubeconf, _ := kubeutil.GetKubeConfig()
+		client, err := kubeclient.NewForConfig(kubeconf)
+		if err != nil {
+			log.Error("Failed to create a client for violation events; err: ", err.Error())
 		} else {
-			ignored = !constraint.Parameters.Action.Audit.Inform
+			namespace := os.Getenv("POD_NAMESPACE")
+			if namespace == "" {
+				namespace = defaultPodNamespace
+			}
+			err = createOrUpdateViolationEvents(client, namespace, results)
+			if err != nil {
+				log.Error(err.Error())
+			}
 		}
+	}
+	if config.ViolationMarker != "" {
+		// markers reflect violations of all constraints in the latest results
+		err := updateViolationMarkers(self.dynamicClient, config.ViolationMarker, observed, latest)
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}
+}
 
-		// export VerifyResult
-		_ = exportVerifyResult(vrr, ignored, violated)
-		// VerifyResultDetail
-		cres := ConstraintResult{
-			ConstraintName:  constraintName,
-			Results:         results,
-			Violation:       violated,
-			TotalViolations: count,
-			Constraint:      constraint,
+// summarize updates the violation summary from the results
+func (self *ConstraintResult) summarize() {
+	count := 0
+	for _, res := range self.Results {
+		if res.Violation {
+			count++
 		}
-		constraintResults = append(constraintResults, cres)
 	}
+	self.TotalViolations = count
+	self.Violation = count != 0
+}
 
-	// export ConstraintResult
-	res := ObservationDetailResults{
-		ConstraintResults: constraintResults,
-		Time:              time.Now().Format(timeFormat),
+func makeManifestIntegrityStateSpec(cres ConstraintResult) vrc.ManifestIntegrityStateSpec {
+	var violations []vrc.VerifyResult
+	var nonViolations []vrc.VerifyResult
+	// prepare for manifest integrity state
+	for _, res := range cres.Results {
+		// simple result
+		if res.Violation {
+			vres := vrc.VerifyResult{
+				Namespace:  res.Namespace,
+				Name:       res.Name,
+				Kind:       res.Kind,
+				ApiGroup:   res.ApiGroup,
+				ApiVersion: res.ApiVersion,
+				Result:     res.Message,
+			}
+			if res.Provenance != nil {
+				vres.Provenances = res.Provenance.Provenances
+			}
+			violations = append(violations, vres)
+		} else {
+			vres := vrc.VerifyResult{
+				Namespace:  res.Namespace,
+				Name:       res.Name,
+				Kind:       res.Kind,
+				ApiGroup:   res.ApiGroup,
+				ApiVersion: res.ApiVersion,
+				Result:     res.Message,
+			}
+			if res.VerifyResourceResult != nil {
+				vres.Signer = res.VerifyResourceResult.Signer
+				vres.SigRef = res.VerifyResourceResult.SigRef
+				vres.SignedTime = res.VerifyResourceResult.SignedTime
+			}
+			if res.Provenance != nil {
+				vres.Provenances = res.Provenance.Provenances
+			}
+			nonViolations = append(nonViolations, vres)
+		}
+	}
+	return vrc.ManifestIntegrityStateSpec{
+		ConstraintName:  cres.ConstraintName,
+		Violation:       cres.Violation,
+		TotalViolations: cres.TotalViolations,
+		Violations:      violations,
+		NonViolations:   nonViolations,
+		ObservationTime: time.Now().Format(timeFormat),
 	}
-	_ = exportResultDetail(res)
-	return
 }
 
-func exportVerifyResult(vrr vrc.ManifestIntegrityStateSpec, ignored bool, violated bool) error {
+// narrowTargetNamespace limits the target namespaces of the group resource to the specified namespace.
+// It returns false if the group resource is not scanned in the namespace.
+func narrowTargetNamespace(gResource groupResourceWithTargetNS, namespace string) (groupResourceWithTargetNS, bool) {
+	if !gResource.APIResource.Namespaced {
+		return gResource, false
+	}
+	if !Contains(gResource.TargetNamespaces, namespace) {
+		return gResource, false
+	}
+	gResource.TargetNamespaces = []string{namespace}
+	return gResource, true
+}
+
+func exportVerifyResult(vrr vrc.ManifestIntegrityStateSpec, ignored bool, violated bool, scanErrors int) error {
 	config, err := kubeutil.GetKubeConfig()
 	if err != nil {
 		log.Error(err)
@@ -359,6 +580,7 @@ func exportVerifyResult(vrr vrc.ManifestIntegrityStateSpec, ignored bool, violat
 			},
 			Spec: vrr,
 		}
+		setManifestIntegrityStateConditions(&newVRC.Status, violated, scanErrors)
 
 		newVRC.Labels = labels
 		_, err = clientset.ManifestIntegrityStates(namespace).Create(context.Background(), newVRC, metav1.CreateOptions{})
@@ -370,6 +592,7 @@ func exportVerifyResult(vrr vrc.ManifestIntegrityStateSpec, ignored bool, violat
 		log.Info("updating ManifestIntegrityStatees resource...")
 		obj.Spec = vrr
 		obj.Labels = labels
+		setManifestIntegrityStateConditions(&obj.Status, violated, scanErrors)
 		_, err = clientset.ManifestIntegrityStates(namespace).Update(context.Background(), obj, metav1.UpdateOptions{})
 		if err != nil {
 			log.Error("failed to update ManifestIntegrityStates:", err.Error())
@@ -379,78 +602,6 @@ func exportVerifyResult(vrr vrc.ManifestIntegrityStateSpec, ignored bool, violat
 	return nil
 }
 
-func exportResultDetail(results ObservationDetailResults) error {
-	exportStr := os.Getenv(exportDetailResult)
-	export := defaultExportDetailResult
-	if exportStr != "" {
-		export, _ = strconv.ParseBool(exportStr)
-	}
-	if !export {
-		return nil
-	}
-
-	if len(results.ConstraintResults) == 0 {
-		log.Info("no observation results")
-		return nil
-	}
-	namespace := os.Getenv("POD_NAMESPACE")
-	if namespace == "" {
-		namespace = defaultPodNamespace
-	}
-	configName := os.Getenv(detailResultConfigName)
-	if configName == "" {
-		configName = defaultObserverResultDetailConfigName
-	}
-	configKey := os.Getenv(detailResultConfigKey)
-	if configKey == "" {
-		configKey = defaultKeyInConfigMap
-	}
-
-	// load
-	config, err := kubeutil.GetKubeConfig()
-	if err != nil {
-		return nil
-	}
-	clientset, err := kubeclient.NewForConfig(config)
-	if err != nil {
-		log.Error(err)
-		return nil
-	}
-	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configName, metav1.GetOptions{})
-	if err != nil {
-		// create
-		log.Info("creating new configmap to store verify result...", configName)
-		newcm := &v1.ConfigMap{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: configName,
-			},
-		}
-		resByte, _ := json.Marshal(results)
-		newcm.Data = map[string]string{
-			configKey: string(resByte),
-		}
-		_, err := clientset.CoreV1().ConfigMaps(namespace).Create(context.Background(), newcm, metav1.CreateOptions{})
-		if err != nil {
-			log.Error("failed to create configmap", err.Error())
-			return err
-		}
-		return nil
-	} else {
-		// update
-		log.Info("updating configmap ...", configName)
-		resByte, _ := json.Marshal(results)
-		cm.Data = map[string]string{
-			configKey: string(resByte),
-		}
-		_, err := clientset.CoreV1().ConfigMaps(namespace).Update(context.Background(), cm, metav1.UpdateOptions{})
-		if err != nil {
-			log.Error("failed to update configmap", err.Error())
-			return err
-		}
-	}
-	return nil
-}
-
 func (self *Observer) getAPIResources(kubeconfig *rest.Config) error {
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(kubeconfig)
 	if err != nil {
@@ -511,49 +662,24 @@ func (self *Observer) getAllResoucesByGroupResource(gResourceWithTargetNS groupR
 
 	} else {
 		tmpResourceList, err = self.dynamicClient.Resource(gvr).List(context.Background(), metav1.ListOptions{})
-		resources = append(resources, tmpResourceList.Items...)
+		if err == nil {
+			resources = append(resources, tmpResourceList.Items...)
+		}
 	}
 	if err != nil {
-		// ignore RBAC error - IShield SA
-		log.Error("RBAC error when listing resources; error:", err.Error())
+		if k8serrors.IsForbidden(err) {
+			// ignore RBAC error - IShield SA
+			log.Error("RBAC error when listing resources; error:", err.Error())
+			recordError(errorReasonRBAC)
+		} else {
+			log.Error("failed to list resources; error:", err.Error())
+			recordError(errorReasonList)
+		}
 		return []unstructured.Unstructured{}, nil
 	}
 	return resources, nil
 }
 
-func LoadKeySecret(keySecertNamespace, keySecertName string) (string, error) {
-	obj, err := kubeutil.GetResource("v1", "Secret", keySecertNamespace, keySecertName)
-	if err != nil {
-		return "", errors.Wrap(err, fmt.Sprintf("failed to get a secret `%s` in `%s` namespace", keySecertName, keySecertNamespace))
-	}
-	objBytes, _ := json.Marshal(obj.Object)
-	var secret v1.Secret
-	_ = json.Unmarshal(objBytes, &secret)
-	keyDir := fmt.Sprintf("/tmp/%s/%s/", keySecertNamespace, keySecertName)
-	log.Debug("keyDir", keyDir)
-	sumErr := []string{}
-	keyPath := ""
-	for fname, keyData := range secret.Data {
-		os.MkdirAll(keyDir, os.ModePerm)
-		fpath := filepath.Join(keyDir, fname)
-		err = ioutil.WriteFile(fpath, keyData, 0644)
-		if err != nil {
-			sumErr = append(sumErr, err.Error())
-			continue
-		}
-		keyPath = fpath
-		break
-	}
-	if keyPath == "" && len(sumErr) > 0 {
-		return "", errors.New(fmt.Sprintf("failed to save secret data as a file; %s", strings.Join(sumErr, "; ")))
-	}
-	if keyPath == "" {
-		return "", errors.New(fmt.Sprintf("no key files are found in the secret `%s` in `%s` namespace", keySecertName, keySecertNamespace))
-	}
-
-	return keyPath, nil
-}
-
 //
 // Constraint
 //
@@ -0,0 +1,170 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	vrc "github.com/IBM/integrity-shield/observer/pkg/apis/manifestintegritystate/v1"
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const provenanceEnvKey = "ENABLE_PROVENANCE_RESULT"
+
+type ProvenanceConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// if true, verified resources without any provenance are reported as violations
+	Required bool `json:"required,omitempty"`
+	// patterns of builder IDs and source repositories which are allowed. If empty, any is allowed.
+	AllowedBuilders    []string `json:"allowedBuilders,omitempty"`
+	AllowedSourceRepos []string `json:"allowedSourceRepos,omitempty"`
+}
+
+// IsEnabled returns true if provenance is checked by the config or the `ENABLE_PROVENANCE_RESULT` env.
+func (self ProvenanceConfig) IsEnabled() bool {
+	if self.Enabled {
+		return true
+	}
+	enabled, _ := strconv.ParseBool(os.Getenv(provenanceEnvKey))
+	return enabled
+}
+
+// ProvenanceResult is the result of the provenance check of a resource.
+type ProvenanceResult struct {
+	Found       bool             `json:"found"`
+	Allow       bool             `json:"allow"`
+	Message     string           `json:"message,omitempty"`
+	Provenances []vrc.Provenance `json:"provenances,omitempty"`
+}
+
+// checkProvenance gets the provenances of the verified resource and checks them against the config.
+// A resource which is not verified is not checked because it is already a violation.
+func (self *Observer) checkProvenance(result *VerifyResultDetail, resource unstructured.Unstructured, verifier *shield.Verifier, config ProvenanceConfig) {
+	if result.Violation || result.VerifyResourceResult == nil {
+		return
+	}
+	vo := verifier.VerifyOption(resource)
+	provs, err := k8smanifest.NewProvenanceGetter(&resource, result.VerifyResourceResult.SigRef, "", vo.ProvenanceResourceRef).Get()
+	if err != nil {
+		log.Debugf("failed to get provenance of %s %s; %s", resource.GetKind(), resource.GetName(), err.Error())
+		provs = nil
+	}
+	summaries := []vrc.Provenance{}
+	for _, prov := range provs {
+		if prov == nil {
+			continue
+		}
+		summary := summarizeProvenance(prov)
+		if summary.ConfigMapRef != "" {
+			verified, err := verifier.VerifyProvenanceConfigMap(summary.ConfigMapRef)
+			if err != nil {
+				log.Warnf("failed to verify the provenance configmap %s; %s", summary.ConfigMapRef, err.Error())
+			}
+			summary.ConfigMapVerified = verified
+		} else {
+			verified, err := verifier.VerifyProvenanceAttestation(resource, prov)
+			if err != nil {
+				log.Warnf("failed to verify the attestation of %s; %s", summary.Artifact, err.Error())
+			}
+			summary.AttestationVerified = verified
+		}
+		summaries = append(summaries, summary)
+	}
+	pres := evaluateProvenance(summaries, config)
+	result.Provenance = &pres
+	if !pres.Allow {
+		recordError(errorReasonProvenance)
+		result.Violation = true
+		result.Message = fmt.Sprintf("%s, [Provenance]%s", result.Message, pres.Message)
+	}
+}
+
+// evaluateProvenance decides whether the provenances of the resource match the config.
+func evaluateProvenance(provs []vrc.Provenance, config ProvenanceConfig) ProvenanceResult {
+	pres := ProvenanceResult{
+		Found:       len(provs) > 0,
+		Allow:       true,
+		Provenances: provs,
+	}
+	if len(provs) == 0 {
+		if config.Required {
+			pres.Allow = false
+			pres.Message = "no provenance is found"
+		}
+		return pres
+	}
+	for _, prov := range provs {
+		if prov.ConfigMapRef != "" && !prov.ConfigMapVerified {
+			pres.Allow = false
+			pres.Message = fmt.Sprintf("provenance configmap `%s` is not signed by a valid key", prov.ConfigMapRef)
+			return pres
+		}
+		if prov.ConfigMapRef == "" && !prov.AttestationVerified {
+			pres.Allow = false
+			pres.Message = fmt.Sprintf("the attestation of %s is not signed by a valid key", prov.Artifact)
+			return pres
+		}
+		if len(config.AllowedBuilders) > 0 && !k8smnfutil.MatchWithPatternArray(prov.BuilderID, config.AllowedBuilders) {
+			pres.Allow = false
+			pres.Message = fmt.Sprintf("builder `%s` of %s is not allowed", prov.BuilderID, prov.Artifact)
+			return pres
+		}
+		if len(config.AllowedSourceRepos) > 0 && !k8smnfutil.MatchWithPatternArray(prov.SourceRepo, config.AllowedSourceRepos) {
+			pres.Allow = false
+			pres.Message = fmt.Sprintf("source repository `%s` of %s is not allowed", prov.SourceRepo, prov.Artifact)
+			return pres
+		}
+	}
+	return pres
+}
+
+// summarizeProvenance converts the provenance into the summary in the result.
+func summarizeProvenance(prov *k8smanifest.Provenance) vrc.Provenance {
+	info := shield.ParseProvenance(prov)
+	summary := vrc.Provenance{
+		Artifact:     info.Artifact,
+		ArtifactType: info.ArtifactType,
+		Hash:         info.Hash,
+		BuilderID:    info.BuilderID,
+		SourceRepo:   info.SourceRepo,
+		Branch:       info.Branch,
+		ConfigMapRef: info.ConfigMapRef,
+	}
+	for _, m := range info.Materials {
+		summary.Materials = append(summary.Materials, materialString(m.URI, m.Digest))
+	}
+	return summary
+}
+
+func materialString(uri string, digest map[string]string) string {
+	if len(digest) == 0 {
+		return uri
+	}
+	algs := []string{}
+	for alg := range digest {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	return fmt.Sprintf("%s@%s:%s", uri, algs[0], digest[algs[0]])
+}
@@ -0,0 +1,106 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"testing"
+
+	vrc "github.com/IBM/integrity-shield/observer/pkg/apis/manifestintegritystate/v1"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+)
+
+const testSLSAAttestation = `{
+  "_type": "https://in-toto.io/Statement/v0.1",
+  "predicateType": "https://slsa.dev/provenance/v0.1",
+  "subject": [{"name": "sample-app.yaml", "digest": {"sha256": "abcdef"}}],
+  "predicate": {
+    "builder": {"id": "https://github.com/Attestations/GitHubHostedActions@v1"},
+    "recipe": {"type": "https://github.com/Attestations/GitHubActionsWorkflow@v1", "definedInMaterial": 0},
+    "materials": [
+      {"uri": "git+https://github.com/sample/sample-app@refs/heads/main", "digest": {"sha1": "1234567"}},
+      {"uri": "https://github.com/actions/checkout"}
+    ]
+  }
+}`
+
+func TestSummarizeProvenance(t *testing.T) {
+	prov := &k8smanifest.Provenance{
+		Artifact:       "sample-app.yaml",
+		Hash:           "abcdef",
+		RawAttestation: testSLSAAttestation,
+		ConfigMapRef:   "k8s://ConfigMap/sample-ns/sample-app-provenance",
+	}
+	summary := summarizeProvenance(prov)
+	if summary.BuilderID != "https://github.com/Attestations/GitHubHostedActions@v1" {
+		t.Errorf("unexpected builder: got: %v", summary.BuilderID)
+		return
+	}
+	if summary.SourceRepo != "git+https://github.com/sample/sample-app" {
+		t.Errorf("unexpected source repository: got: %v", summary.SourceRepo)
+		return
+	}
+	if summary.Branch != "main" {
+		t.Errorf("unexpected branch: got: %v", summary.Branch)
+		return
+	}
+	expectedMaterials := []string{"git+https://github.com/sample/sample-app@refs/heads/main@sha1:1234567", "https://github.com/actions/checkout"}
+	if len(summary.Materials) != len(expectedMaterials) || summary.Materials[0] != expectedMaterials[0] || summary.Materials[1] != expectedMaterials[1] {
+		t.Errorf("unexpected materials: got: %v\nwant: %v", summary.Materials, expectedMaterials)
+		return
+	}
+}
+
+func TestEvaluateProvenance(t *testing.T) {
+	prov := vrc.Provenance{
+		Artifact:          "sample-app.yaml",
+		BuilderID:         "https://github.com/Attestations/GitHubHostedActions@v1",
+		SourceRepo:        "git+https://github.com/sample/sample-app",
+		ConfigMapRef:      "k8s://ConfigMap/sample-ns/sample-app-provenance",
+		ConfigMapVerified: true,
+	}
+	unsigned := prov
+	unsigned.ConfigMapVerified = false
+	attestation := prov
+	attestation.ConfigMapRef = ""
+	attestation.ConfigMapVerified = false
+	attestation.AttestationVerified = true
+	unsignedAttestation := attestation
+	unsignedAttestation.AttestationVerified = false
+
+	testcases := []struct {
+		name   string
+		provs  []vrc.Provenance
+		config ProvenanceConfig
+		allow  bool
+	}{
+		{name: "missing but not required", provs: nil, config: ProvenanceConfig{}, allow: true},
+		{name: "missing and required", provs: nil, config: ProvenanceConfig{Required: true}, allow: false},
+		{name: "allowed builder and repository", provs: []vrc.Provenance{prov}, config: ProvenanceConfig{AllowedBuilders: []string{"https://github.com/Attestations/*"}, AllowedSourceRepos: []string{"git+https://github.com/sample/*"}}, allow: true},
+		{name: "builder not allowed", provs: []vrc.Provenance{prov}, config: ProvenanceConfig{AllowedBuilders: []string{"https://tekton.dev/*"}}, allow: false},
+		{name: "repository not allowed", provs: []vrc.Provenance{prov}, config: ProvenanceConfig{AllowedSourceRepos: []string{"git+https://github.com/other/*"}}, allow: false},
+		{name: "unsigned configmap", provs: []vrc.Provenance{unsigned}, config: ProvenanceConfig{}, allow: false},
+		{name: "signed attestation", provs: []vrc.Provenance{attestation}, config: ProvenanceConfig{}, allow: true},
+		{name: "unsigned attestation", provs: []vrc.Provenance{unsignedAttestation}, config: ProvenanceConfig{}, allow: false},
+	}
+	for _, tc := range testcases {
+		pres := evaluateProvenance(tc.provs, tc.config)
+		if pres.Allow != tc.allow {
+			t.Errorf("%s: unexpected result: got: %v\nwant: %v (%s)", tc.name, pres.Allow, tc.allow, pres.Message)
+			return
+		}
+	}
+}
@@ -0,0 +1,284 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const remediationFieldManager = "integrity-shield-observer"
+
+// sources of restored manifests
+const (
+	RemediationSourceSignedManifest = "signedManifest"
+	RemediationSourceSnapshot       = "snapshot"
+)
+
+// fields which are managed by the cluster and never restored
+var remediationSystemFields = []string{
+	"status",
+	"metadata.resourceVersion",
+	"metadata.uid",
+	"metadata.creationTimestamp",
+	"metadata.generation",
+	"metadata.managedFields",
+	"metadata.selfLink",
+}
+
+// RemediationResult is a report of a restore of the resource.
+type RemediationResult struct {
+	DryRun  bool   `json:"dryRun"`
+	Applied bool   `json:"applied"`
+	Source  string `json:"source,omitempty"`
+	SigRef  string `json:"sigRef,omitempty"`
+	Diff    string `json:"diff,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// fetchSignedManifest returns a manifest of the resource whose signature is valid for the verify option.
+func fetchSignedManifest(resource unstructured.Unstructured, vo *k8smanifest.VerifyResourceOption) ([]byte, string, error) {
+	objBytes, _ := yaml.Marshal(resource.Object)
+	vo.SetAnnotationIgnoreFields()
+	ignoreFields := []string{}
+	if ok, fields := vo.IgnoreFields.Match(resource); ok {
+		ignoreFields = fields
+	}
+	imageRef := vo.ImageRef
+	if imageRef == "" {
+		imageRef = resource.GetAnnotations()[vo.AnnotationConfig.ImageRefAnnotationKey()]
+	}
+	manifests, sigRef, err := k8smanifest.NewManifestFetcher(imageRef, vo.SignatureResourceRef, vo.AnnotationConfig, ignoreFields, vo.MaxResourceManifestNum).Fetch(objBytes)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to fetch a signed manifest")
+	}
+	manifest, found := findManifest(manifests, resource)
+	if !found {
+		return nil, "", errors.New(fmt.Sprintf("no signed manifest is found for %s `%s`", resource.GetKind(), resource.GetName()))
+	}
+	var keyPath *string
+	if vo.KeyPath != "" {
+		keyPath = &(vo.KeyPath)
+	}
+	verified, signer, _, err := k8smanifest.NewSignatureVerifier(objBytes, sigRef, keyPath, vo.AnnotationConfig).Verify()
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to verify the signature of the manifest")
+	}
+	if !verified {
+		return nil, "", errors.New(fmt.Sprintf("signature of the manifest `%s` is not valid", sigRef))
+	}
+	if !vo.Signers.Match(signer) {
+		return nil, "", errors.New(fmt.Sprintf("signer `%s` is not allowed", signer))
+	}
+	return manifest, sigRef, nil
+}
+
+// findManifest returns the manifest whose kind, name and namespace match the resource.
+// A manifest without namespace matches the resource in any namespace.
+func findManifest(manifests [][]byte, resource unstructured.Unstructured) ([]byte, bool) {
+	for _, manifest := range manifests {
+		var obj unstructured.Unstructured
+		if err := yaml.Unmarshal(manifest, &obj.Object); err != nil || obj.Object == nil {
+			continue
+		}
+		if obj.GetKind() != resource.GetKind() || obj.GetName() != resource.GetName() {
+			continue
+		}
+		if obj.GetNamespace() != "" && obj.GetNamespace() != resource.GetNamespace() {
+			continue
+		}
+		return manifest, true
+	}
+	return nil, false
+}
+
+// sanitizeManifest removes cluster managed fields and the ignore fields, so that they are not overwritten.
+func sanitizeManifest(obj map[string]interface{}, ignoreFields []string) (map[string]interface{}, error) {
+	node, err := mapnode.NewFromMap(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the manifest")
+	}
+	maskKeys := append([]string{}, remediationSystemFields...)
+	maskKeys = append(maskKeys, ignoreFields...)
+	return node.Mask(maskKeys).ToMap(), nil
+}
+
+// makeSnapshot returns the state of a verified resource to be restored later
+func makeSnapshot(resource unstructured.Unstructured) []byte {
+	obj, err := sanitizeManifest(resource.Object, nil)
+	if err != nil {
+		return nil
+	}
+	snapshot, _ := json.Marshal(obj)
+	return snapshot
+}
+
+// diffManifest returns the difference of the manifest from the current resource.
+// Fields which are not in the manifest are also reported, because the restore does not remove the ones owned by others.
+func diffManifest(current, manifest map[string]interface{}) string {
+	curNode, err := mapnode.NewFromMap(current)
+	if err != nil {
+		return ""
+	}
+	mnfNode, err := mapnode.NewFromMap(manifest)
+	if err != nil {
+		return ""
+	}
+	diff := curNode.Diff(mnfNode)
+	if diff == nil || diff.Size() == 0 {
+		return ""
+	}
+	return diff.String()
+}
+
+// buildApplyObject returns the object to be applied to restore the resource. It has only the fields in the manifest,
+// in which the cluster managed fields and the ignore fields are already removed, so that they keep the current values.
+func buildApplyObject(resource unstructured.Unstructured, manifest map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(manifest)}
+	obj.SetName(resource.GetName())
+	obj.SetNamespace(resource.GetNamespace())
+	if obj.GetAPIVersion() == "" {
+		obj.SetAPIVersion(resource.GetAPIVersion())
+	}
+	if obj.GetKind() == "" {
+		obj.SetKind(resource.GetKind())
+	}
+	return obj
+}
+
+// restoreResource applies the signed fields to the resource with server-side apply, and returns the resulting resource.
+// The observer takes over the ownership of the fields changed by other managers; fields which are not in the manifest
+// and owned by other managers are not removed, so the caller must verify the returned resource again.
+func restoreResource(client dynamic.Interface, gvr schema.GroupVersionResource, obj *unstructured.Unstructured, dryRun bool) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to marshal %s `%s`", obj.GetKind(), obj.GetName()))
+	}
+	force := true
+	opts := metav1.PatchOptions{FieldManager: remediationFieldManager, Force: &force}
+	if dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	var restored *unstructured.Unstructured
+	if obj.GetNamespace() != "" {
+		restored, err = client.Resource(gvr).Namespace(obj.GetNamespace()).Patch(context.Background(), obj.GetName(), types.ApplyPatchType, data, opts)
+	} else {
+		restored, err = client.Resource(gvr).Patch(context.Background(), obj.GetName(), types.ApplyPatchType, data, opts)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to restore %s `%s`", obj.GetKind(), obj.GetName()))
+	}
+	return restored, nil
+}
+
+// remediate restores the resource which failed verification with a signed manifest, or with the snapshot
+// taken when the resource was verified last time.
+// The restored resource is verified again, and it is not reported as applied if it is still in violation,
+// e.g. when fields which are not in the manifest were added by other managers.
+// Snapshots are kept only in memory, so they are lost when the observer restarts and are not a durable backup.
+func (self *Observer) remediate(resource unstructured.Unstructured, gvr schema.GroupVersionResource, constraint ConstraintSpec, verifier *shield.Verifier) *RemediationResult {
+	config := constraint.Parameters.Remediation
+	res := &RemediationResult{DryRun: config.DryRun}
+
+	vo := verifier.VerifyOption(resource)
+	var manifest map[string]interface{}
+	manifestBytes, sigRef, err := fetchSignedManifest(resource, vo)
+	if err == nil {
+		err = yaml.Unmarshal(manifestBytes, &manifest)
+	}
+	if err == nil {
+		res.Source = RemediationSourceSignedManifest
+		res.SigRef = sigRef
+	} else if snapshot := self.getSnapshot(constraint.Parameters.ConstraintName, resourceKeyFromObject(resource)); config.UseSnapshot && snapshot != nil {
+		log.Debugf("no signed manifest for %s `%s`; use the snapshot: %s", resource.GetKind(), resource.GetName(), err.Error())
+		_ = json.Unmarshal(snapshot, &manifest)
+		res.Source = RemediationSourceSnapshot
+	} else {
+		res.Error = fmt.Sprintf("no manifest to restore: %s", err.Error())
+		return res
+	}
+
+	fields := []string{}
+	if ok, matched := vo.IgnoreFields.Match(resource); ok {
+		fields = matched
+	}
+	manifest, err = sanitizeManifest(manifest, fields)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	current, _ := sanitizeManifest(resource.Object, fields)
+	res.Diff = diffManifest(current, manifest)
+
+	// server-side dry-run is used for the report, so that invalid manifests are also reported
+	restored := buildApplyObject(resource, manifest)
+	result, err := restoreResource(self.dynamicClient, gvr, restored, config.DryRun)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if vres := verifier.Verify(*result, ""); !vres.Allow {
+		res.Error = fmt.Sprintf("%s `%s` is still in violation after restore: %s", result.GetKind(), result.GetName(), vres.Message)
+		return res
+	}
+	res.Applied = !config.DryRun
+	return res
+}
+
+func (self *Observer) getSnapshot(constraintName string, key resourceKey) []byte {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.snapshots[constraintName][key]
+}
+
+// storeSnapshots updates the snapshots of the constraint.
+// If namespace is specified, only the snapshots in the namespace are replaced.
+func (self *Observer) storeSnapshots(constraintName, namespace string, snapshots map[resourceKey][]byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.snapshots == nil {
+		self.snapshots = map[string]map[resourceKey][]byte{}
+	}
+	if namespace == "" {
+		self.snapshots[constraintName] = snapshots
+		return
+	}
+	merged := map[resourceKey][]byte{}
+	for key, snapshot := range self.snapshots[constraintName] {
+		if key.Namespace != namespace {
+			merged[key] = snapshot
+		}
+	}
+	for key, snapshot := range snapshots {
+		merged[key] = snapshot
+	}
+	self.snapshots[constraintName] = merged
+}
@@ -0,0 +1,191 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestSanitizeManifest(t *testing.T) {
+	obj := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":            "sample",
+			"namespace":       "ns-0",
+			"resourceVersion": "1234",
+			"uid":             "0000-1111",
+		},
+		"spec": map[string]interface{}{
+			"replicas": 3,
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"serviceAccountName": "sample",
+				},
+			},
+		},
+		"status": map[string]interface{}{
+			"readyReplicas": 3,
+		},
+	}
+	sanitized, err := sanitizeManifest(obj, []string{"spec.replicas"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	metadata := sanitized["metadata"].(map[string]interface{})
+	spec := sanitized["spec"].(map[string]interface{})
+	if _, ok := sanitized["status"]; ok {
+		t.Errorf("status should be removed: got: %v", sanitized)
+		return
+	}
+	if _, ok := metadata["resourceVersion"]; ok {
+		t.Errorf("resourceVersion should be removed: got: %v", metadata)
+		return
+	}
+	if _, ok := spec["replicas"]; ok {
+		t.Errorf("ignore field should be removed: got: %v", spec)
+		return
+	}
+	if metadata["name"] != "sample" || spec["template"] == nil {
+		t.Errorf("unexpected sanitized manifest: got: %v", sanitized)
+		return
+	}
+}
+
+func TestDiffManifest(t *testing.T) {
+	current := map[string]interface{}{
+		"kind":     "ConfigMap",
+		"metadata": map[string]interface{}{"name": "cm-0", "labels": map[string]interface{}{"app": "sample"}},
+		"data":     map[string]interface{}{"key": "changed"},
+	}
+	manifest := map[string]interface{}{
+		"kind":     "ConfigMap",
+		"metadata": map[string]interface{}{"name": "cm-0"},
+		"data":     map[string]interface{}{"key": "signed"},
+	}
+	diff := diffManifest(current, manifest)
+	// fields which are not in the manifest are reported because they are not restored
+	if !strings.Contains(diff, "data.key") || !strings.Contains(diff, "labels") {
+		t.Errorf("unexpected diff: got: %v", diff)
+		return
+	}
+	if d := diffManifest(manifest, manifest); d != "" {
+		t.Errorf("diff should be empty: got: %v", d)
+		return
+	}
+}
+
+func TestStoreSnapshots(t *testing.T) {
+	o := NewObserver()
+	key0 := resourceKey{Kind: "ConfigMap", Namespace: "ns-0", Name: "cm-0"}
+	key1 := resourceKey{Kind: "ConfigMap", Namespace: "ns-1", Name: "cm-0"}
+	o.storeSnapshots("constraint-0", "", map[resourceKey][]byte{key0: []byte("a"), key1: []byte("b")})
+	// namespace scan keeps the snapshots in other namespaces
+	o.storeSnapshots("constraint-0", "ns-1", map[resourceKey][]byte{})
+	if o.getSnapshot("constraint-0", key0) == nil || o.getSnapshot("constraint-0", key1) != nil {
+		t.Errorf("unexpected snapshots: got: %v", o.snapshots)
+		return
+	}
+	// full scan replaces all snapshots of the constraint
+	o.storeSnapshots("constraint-0", "", map[resourceKey][]byte{key1: []byte("c")})
+	if o.getSnapshot("constraint-0", key0) != nil || string(o.getSnapshot("constraint-0", key1)) != "c" {
+		t.Errorf("unexpected snapshots: got: %v", o.snapshots)
+		return
+	}
+}
+
+func TestRestoreResource(t *testing.T) {
+	resource := makeTestConfigMap("cm-0", map[string]string{ViolationMarkerKey: "constraint-0"})
+	resource.SetResourceVersion("1234")
+	_ = unstructured.SetNestedField(resource.Object, "changed", "data", "key")
+	manifest := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":            "cm-0",
+			"resourceVersion": "1",
+		},
+		"data": map[string]interface{}{"key": "signed"},
+	}
+	manifest, err := sanitizeManifest(manifest, []string{"metadata.annotations." + ViolationMarkerKey})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	applied := buildApplyObject(*resource, manifest)
+
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), resource)
+	var patch k8stesting.PatchAction
+	client.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch = action.(k8stesting.PatchAction)
+		return true, applied, nil
+	})
+	result, err := restoreResource(client, testConfigMapGVR, applied, false)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result == nil || result.GetName() != "cm-0" {
+		t.Errorf("the restored resource should be returned: got: %v", result)
+		return
+	}
+	if patch == nil || patch.GetPatchType() != types.ApplyPatchType || patch.GetNamespace() != "ns-0" || patch.GetName() != "cm-0" {
+		t.Errorf("the resource should be restored with server-side apply: got: %v", patch)
+		return
+	}
+	var obj map[string]interface{}
+	_ = json.Unmarshal(patch.GetPatch(), &obj)
+	restored := unstructured.Unstructured{Object: obj}
+	// only the signed fields are applied, so that cluster managed fields and ignore fields keep the current values
+	if restored.GetResourceVersion() != "" || len(restored.GetAnnotations()) != 0 {
+		t.Errorf("only signed fields should be applied: got: %v", restored.Object["metadata"])
+		return
+	}
+	value, _, _ := unstructured.NestedString(restored.Object, "data", "key")
+	if value != "signed" || restored.GetKind() != "ConfigMap" || restored.GetNamespace() != "ns-0" {
+		t.Errorf("unexpected applied object: got: %v", restored.Object)
+		return
+	}
+}
+
+func TestFindManifest(t *testing.T) {
+	resource := makeTestConfigMap("cm-1", nil)
+	manifests := [][]byte{
+		[]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm-0\n"),
+		[]byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: cm-1\n"),
+		[]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm-1\n  namespace: ns-1\n"),
+		[]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm-1\ndata:\n  key: signed\n"),
+	}
+	manifest, found := findManifest(manifests, *resource)
+	if !found || string(manifest) != string(manifests[3]) {
+		t.Errorf("the manifest of the resource should be found: got: %s", string(manifest))
+		return
+	}
+	if _, found := findManifest(manifests[:3], *resource); found {
+		t.Errorf("manifests of other resources should not be found")
+		return
+	}
+}
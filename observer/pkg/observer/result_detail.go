@@ -0,0 +1,406 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclient "k8s.io/client-go/kubernetes"
+)
+
+const ResultDetailShardLabel = "integrityshield.io/verifyResultDetail"
+
+const resultDetailFormatSharded = "sharded/v1"
+const resultDetailCompressionGzip = "gzip"
+
+// A ConfigMap can hold 1 MiB at most including its metadata,
+// so the data in a single shard is kept below this size.
+var maxResultDetailShardSize = 900 * 1024
+
+// ResultDetailIndex is stored in the result detail ConfigMap and lists
+// the shards which hold the VerifyResultDetail of each constraint.
+type ResultDetailIndex struct {
+	Format      string                  `json:"format"`
+	Time        string                  `json:"time"`
+	Compression string                  `json:"compression,omitempty"`
+	Constraints []ConstraintResultIndex `json:"constraints"`
+}
+
+type ConstraintResultIndex struct {
+	ConstraintName  string              `json:"constraintName"`
+	Violation       bool                `json:"violation"`
+	TotalViolations int                 `json:"totalViolations"`
+	Constraint      ConstraintSpec      `json:"constraint"`
+	Shards          []ResultDetailShard `json:"shards"`
+}
+
+type ResultDetailShard struct {
+	// name of the ConfigMap which stores this shard
+	Name string `json:"name"`
+	// namespace of the observed resources; empty for cluster-scoped resources
+	Namespace string `json:"namespace"`
+	Count     int    `json:"count"`
+}
+
+type resultDetailChunk struct {
+	data  []byte
+	count int
+}
+
+func exportResultDetail(results ObservationDetailResults) error {
+	exportStr := os.Getenv(exportDetailResult)
+	export := defaultExportDetailResult
+	if exportStr != "" {
+		export, _ = strconv.ParseBool(exportStr)
+	}
+	if !export {
+		return nil
+	}
+
+	if len(results.ConstraintResults) == 0 {
+		log.Info("no observation results")
+		return nil
+	}
+	compressStr := os.Getenv(compressDetailResult)
+	compress := defaultCompressDetailResult
+	if compressStr != "" {
+		compress, _ = strconv.ParseBool(compressStr)
+	}
+	namespace, configName, configKey := getResultDetailConfigLocation()
+
+	config, err := kubeutil.GetKubeConfig()
+	if err != nil {
+		return nil
+	}
+	clientset, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	err = writeResultDetail(clientset, namespace, configName, configKey, compress, results)
+	if err != nil {
+		log.Error("failed to export verify result detail: ", err.Error())
+		return err
+	}
+	return nil
+}
+
+func getResultDetailConfigLocation() (string, string, string) {
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultPodNamespace
+	}
+	configName := os.Getenv(detailResultConfigName)
+	if configName == "" {
+		configName = defaultObserverResultDetailConfigName
+	}
+	configKey := os.Getenv(detailResultConfigKey)
+	if configKey == "" {
+		configKey = defaultKeyInConfigMap
+	}
+	return namespace, configName, configKey
+}
+
+// writeResultDetail stores the results of each constraint and namespace into separate ConfigMaps (shards)
+// and then updates the index ConfigMap `configName`. Shards which are no longer referenced are removed.
+func writeResultDetail(client kubeclient.Interface, namespace, configName, configKey string, compress bool, results ObservationDetailResults) error {
+	index := ResultDetailIndex{
+		Format: resultDetailFormatSharded,
+		Time:   results.Time,
+	}
+	if compress {
+		index.Compression = resultDetailCompressionGzip
+	}
+	currentShards := map[string]bool{}
+	for _, cres := range results.ConstraintResults {
+		cindex := ConstraintResultIndex{
+			ConstraintName:  cres.ConstraintName,
+			Violation:       cres.Violation,
+			TotalViolations: cres.TotalViolations,
+			Constraint:      cres.Constraint,
+			Shards:          []ResultDetailShard{},
+		}
+		resultsByNamespace := map[string][]VerifyResultDetail{}
+		for _, res := range cres.Results {
+			resultsByNamespace[res.Namespace] = append(resultsByNamespace[res.Namespace], res)
+		}
+		namespaces := []string{}
+		for ns := range resultsByNamespace {
+			namespaces = append(namespaces, ns)
+		}
+		sort.Strings(namespaces)
+		for _, ns := range namespaces {
+			chunks, err := splitResultDetails(resultsByNamespace[ns], compress)
+			if err != nil {
+				return err
+			}
+			for i, chunk := range chunks {
+				shardName := resultDetailShardName(configName, cres.ConstraintName, ns, i)
+				labels := map[string]string{
+					ResultDetailShardLabel: configName,
+				}
+				err = applyResultDetailConfigMap(client, namespace, shardName, configKey, chunk.data, compress, labels)
+				if err != nil {
+					return err
+				}
+				currentShards[shardName] = true
+				cindex.Shards = append(cindex.Shards, ResultDetailShard{
+					Name:      shardName,
+					Namespace: ns,
+					Count:     chunk.count,
+				})
+			}
+		}
+		index.Constraints = append(index.Constraints, cindex)
+	}
+
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal result detail index")
+	}
+	err = applyResultDetailConfigMap(client, namespace, configName, configKey, indexBytes, false, nil)
+	if err != nil {
+		return err
+	}
+
+	// remove shards of the previous observation which are not used anymore
+	deleteStaleShards(client, namespace, ResultDetailShardLabel, configName, currentShards)
+	return nil
+}
+
+// deleteStaleShards removes the shards labeled with `shardLabel=configName` which are not in currentShards.
+func deleteStaleShards(client kubeclient.Interface, namespace, shardLabel, configName string, currentShards map[string]bool) {
+	selector := fmt.Sprintf("%s=%s", shardLabel, configName)
+	cmList, err := client.CoreV1().ConfigMaps(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		log.Warning("failed to list shards: ", err.Error())
+		return
+	}
+	for _, cm := range cmList.Items {
+		if currentShards[cm.Name] {
+			continue
+		}
+		err = client.CoreV1().ConfigMaps(namespace).Delete(context.Background(), cm.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			log.Warning("failed to delete stale shard: ", err.Error())
+		}
+	}
+}
+
+// splitResultDetails encodes results into one or more chunks so that each chunk fits into a shard.
+func splitResultDetails(results []VerifyResultDetail, compress bool) ([]resultDetailChunk, error) {
+	data, err := encodeResultDetails(results, compress)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= maxResultDetailShardSize {
+		return []resultDetailChunk{{data: data, count: len(results)}}, nil
+	}
+	if len(results) == 1 {
+		// a single result is too large (e.g. a huge diff), so drop the raw verify result and keep the message only
+		log.Warningf("verify result detail of %s %s/%s is too large to be stored; the verifyResourceResult is omitted", results[0].Kind, results[0].Namespace, results[0].Name)
+		trimmed := results[0]
+		trimmed.VerifyResourceResult = nil
+		data, err = encodeResultDetails([]VerifyResultDetail{trimmed}, compress)
+		if err != nil {
+			return nil, err
+		}
+		return []resultDetailChunk{{data: data, count: 1}}, nil
+	}
+	half := len(results) / 2
+	first, err := splitResultDetails(results[:half], compress)
+	if err != nil {
+		return nil, err
+	}
+	second, err := splitResultDetails(results[half:], compress)
+	if err != nil {
+		return nil, err
+	}
+	return append(first, second...), nil
+}
+
+func encodeResultDetails(results []VerifyResultDetail, compress bool) ([]byte, error) {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal verify result detail")
+	}
+	if !compress {
+		return data, nil
+	}
+	data, err = gzipData(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compress verify result detail")
+	}
+	return data, nil
+}
+
+func gzipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipData(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+func decodeResultDetails(data []byte, compression string) ([]VerifyResultDetail, error) {
+	if compression == resultDetailCompressionGzip {
+		var err error
+		data, err = gunzipData(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decompress verify result detail")
+		}
+	}
+	var results []VerifyResultDetail
+	err := json.Unmarshal(data, &results)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal verify result detail")
+	}
+	return results, nil
+}
+
+func resultDetailShardName(configName, constraintName, namespace string, part int) string {
+	sum := sha256.Sum256([]byte(constraintName + "/" + namespace))
+	return fmt.Sprintf("%s-%s-%d", configName, hex.EncodeToString(sum[:])[:10], part)
+}
+
+func applyResultDetailConfigMap(client kubeclient.Interface, namespace, name, key string, data []byte, binary bool, labels map[string]string) error {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		// create
+		log.Debug("creating new configmap to store verify result...", name)
+		newcm := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: labels,
+			},
+		}
+		setResultDetailData(newcm, key, data, binary)
+		_, err := client.CoreV1().ConfigMaps(namespace).Create(context.Background(), newcm, metav1.CreateOptions{})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to create configmap `%s`", name))
+		}
+		return nil
+	}
+	// update
+	log.Debug("updating configmap ...", name)
+	cm.Labels = labels
+	setResultDetailData(cm, key, data, binary)
+	_, err = client.CoreV1().ConfigMaps(namespace).Update(context.Background(), cm, metav1.UpdateOptions{})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to update configmap `%s`", name))
+	}
+	return nil
+}
+
+func setResultDetailData(cm *v1.ConfigMap, key string, data []byte, binary bool) {
+	if binary {
+		cm.Data = nil
+		cm.BinaryData = map[string][]byte{key: data}
+	} else {
+		cm.Data = map[string]string{key: string(data)}
+		cm.BinaryData = nil
+	}
+}
+
+// LoadObservationDetailResults reads the result detail ConfigMap `configName` and
+// reassembles ObservationDetailResults from the shards listed in it.
+// The single ConfigMap format written by older observers is also supported.
+func LoadObservationDetailResults(client kubeclient.Interface, namespace, configName, configKey string) (*ObservationDetailResults, error) {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), configName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", configName, namespace))
+	}
+	indexStr, found := cm.Data[configKey]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("`%s` is not found in configmap", configKey))
+	}
+	var index ResultDetailIndex
+	err = json.Unmarshal([]byte(indexStr), &index)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal result detail index")
+	}
+	if index.Format != resultDetailFormatSharded {
+		var legacy ObservationDetailResults
+		err = json.Unmarshal([]byte(indexStr), &legacy)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to unmarshal %s into %T", configKey, legacy))
+		}
+		return &legacy, nil
+	}
+
+	results := &ObservationDetailResults{
+		Time:              index.Time,
+		ConstraintResults: []ConstraintResult{},
+	}
+	for _, cindex := range index.Constraints {
+		cres := ConstraintResult{
+			ConstraintName:  cindex.ConstraintName,
+			Violation:       cindex.Violation,
+			TotalViolations: cindex.TotalViolations,
+			Constraint:      cindex.Constraint,
+			Results:         []VerifyResultDetail{},
+		}
+		for _, shard := range cindex.Shards {
+			shardCM, err := client.CoreV1().ConfigMaps(namespace).Get(context.Background(), shard.Name, metav1.GetOptions{})
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed to get a result detail shard `%s`", shard.Name))
+			}
+			var data []byte
+			if bdata, ok := shardCM.BinaryData[configKey]; ok {
+				data = bdata
+			} else {
+				data = []byte(shardCM.Data[configKey])
+			}
+			shardResults, err := decodeResultDetails(data, index.Compression)
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed to read a result detail shard `%s`", shard.Name))
+			}
+			cres.Results = append(cres.Results, shardResults...)
+		}
+		results.ConstraintResults = append(results.ConstraintResults, cres)
+	}
+	return results, nil
+}
@@ -0,0 +1,157 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testNamespace  = "integrity-shield-operator-system"
+	testConfigName = "verify-result-detail"
+	testConfigKey  = "config.yaml"
+)
+
+func makeTestDetailResults(constraints, namespaces, resources int) ObservationDetailResults {
+	res := ObservationDetailResults{Time: "2021-09-01 00:00:00"}
+	for c := 0; c < constraints; c++ {
+		cres := ConstraintResult{
+			ConstraintName: fmt.Sprintf("constraint-%d", c),
+		}
+		for n := 0; n < namespaces; n++ {
+			for r := 0; r < resources; r++ {
+				cres.Results = append(cres.Results, VerifyResultDetail{
+					Namespace: fmt.Sprintf("ns-%d", n),
+					Name:      fmt.Sprintf("cm-%d", r),
+					Kind:      "ConfigMap",
+					Violation: r%2 == 0,
+					Message:   "no signature found",
+				})
+			}
+		}
+		res.ConstraintResults = append(res.ConstraintResults, cres)
+	}
+	return res
+}
+
+func TestWriteAndLoadResultDetail(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		client := fake.NewSimpleClientset()
+		results := makeTestDetailResults(2, 3, 4)
+		err := writeResultDetail(client, testNamespace, testConfigName, testConfigKey, compress, results)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		cmList, _ := client.CoreV1().ConfigMaps(testNamespace).List(context.Background(), metav1.ListOptions{})
+		// 1 index + 2 constraints x 3 namespaces
+		if len(cmList.Items) != 7 {
+			t.Errorf("unexpected number of configmaps: got: %v\nwant: %v", len(cmList.Items), 7)
+			return
+		}
+		loaded, err := LoadObservationDetailResults(client, testNamespace, testConfigName, testConfigKey)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected, _ := json.Marshal(results)
+		actual, _ := json.Marshal(loaded)
+		if string(expected) != string(actual) {
+			t.Errorf("reassembled results do not match (compress: %v): got: %s\nwant: %s", compress, string(actual), string(expected))
+			return
+		}
+	}
+}
+
+func TestResultDetailShardSplitAndCleanup(t *testing.T) {
+	orgMaxSize := maxResultDetailShardSize
+	maxResultDetailShardSize = 512
+	defer func() { maxResultDetailShardSize = orgMaxSize }()
+
+	client := fake.NewSimpleClientset()
+	results := makeTestDetailResults(1, 1, 20)
+	err := writeResultDetail(client, testNamespace, testConfigName, testConfigKey, false, results)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	selector := fmt.Sprintf("%s=%s", ResultDetailShardLabel, testConfigName)
+	shards, _ := client.CoreV1().ConfigMaps(testNamespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if len(shards.Items) < 2 {
+		t.Errorf("results should be split into multiple shards: got: %v", len(shards.Items))
+		return
+	}
+	for _, shard := range shards.Items {
+		if len(shard.Data[testConfigKey]) > maxResultDetailShardSize {
+			t.Errorf("shard %s exceeds the max size: got: %v", shard.Name, len(shard.Data[testConfigKey]))
+			return
+		}
+	}
+	loaded, err := LoadObservationDetailResults(client, testNamespace, testConfigName, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(loaded.ConstraintResults[0].Results) != 20 {
+		t.Errorf("unexpected number of results: got: %v\nwant: %v", len(loaded.ConstraintResults[0].Results), 20)
+		return
+	}
+
+	// smaller results should remove stale shards
+	maxResultDetailShardSize = orgMaxSize
+	err = writeResultDetail(client, testNamespace, testConfigName, testConfigKey, false, makeTestDetailResults(1, 1, 2))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	shards, _ = client.CoreV1().ConfigMaps(testNamespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if len(shards.Items) != 1 {
+		t.Errorf("stale shards are not removed: got: %v\nwant: %v", len(shards.Items), 1)
+		return
+	}
+}
+
+func TestLoadLegacyResultDetail(t *testing.T) {
+	results := makeTestDetailResults(1, 1, 2)
+	resByte, _ := json.Marshal(results)
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testConfigName,
+			Namespace: testNamespace,
+		},
+		Data: map[string]string{
+			testConfigKey: string(resByte),
+		},
+	}
+	client := fake.NewSimpleClientset(cm)
+	loaded, err := LoadObservationDetailResults(client, testNamespace, testConfigName, testConfigKey)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(loaded.ConstraintResults) != 1 || len(loaded.ConstraintResults[0].Results) != 2 {
+		t.Errorf("failed to load legacy result detail: got: %v", loaded)
+		return
+	}
+}
@@ -0,0 +1,262 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"math/rand"
+	"os"
+	"time"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const intervalEnvKey = "INTERVAL"
+
+const defaultSchedule = "5m"
+
+// the scheduler wakes up at least this often to pick up changes of config and constraints
+const maxSchedulerSleep = 1 * time.Minute
+
+// wait before retrying a scheduled scan which could not start because another scan is running
+const schedulerRetryDelay = 30 * time.Second
+
+type ScheduleConfig struct {
+	// default schedule of constraints; INTERVAL env value is used if empty
+	Default string `json:"default,omitempty"`
+	// max random delay added to each scheduled scan as a duration (e.g. "30s")
+	Jitter string `json:"jitter,omitempty"`
+	// schedules per constraint name
+	Constraints map[string]string `json:"constraints,omitempty"`
+}
+
+// scheduler decides which constraints are due to be scanned.
+type scheduler struct {
+	defaultSpec string
+	jitter      time.Duration
+	specs       map[string]string
+
+	// next scan time and the schedule used for it per constraint
+	next     map[string]time.Time
+	nextSpec map[string]string
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		defaultSpec: defaultSchedule,
+		specs:       map[string]string{},
+		next:        map[string]time.Time{},
+		nextSpec:    map[string]string{},
+	}
+}
+
+// configure updates schedules with the config; INTERVAL env value is used as the default if not configured.
+func (s *scheduler) configure(config ScheduleConfig) {
+	defaultSpec := config.Default
+	if defaultSpec == "" {
+		defaultSpec = os.Getenv(intervalEnvKey)
+	}
+	if _, err := k8smnfconfig.ParseSchedule(defaultSpec); err != nil {
+		if defaultSpec != "" {
+			log.Errorf("invalid default schedule; use %s: %s", defaultSchedule, err.Error())
+		}
+		defaultSpec = defaultSchedule
+	}
+	s.defaultSpec = defaultSpec
+
+	s.jitter = 0
+	if config.Jitter != "" {
+		jitter, err := time.ParseDuration(config.Jitter)
+		if err != nil || jitter < 0 {
+			log.Errorf("invalid jitter `%s` is ignored", config.Jitter)
+		} else {
+			s.jitter = jitter
+		}
+	}
+
+	s.specs = map[string]string{}
+	for name, spec := range config.Constraints {
+		if _, err := k8smnfconfig.ParseSchedule(spec); err != nil {
+			log.Errorf("invalid schedule of constraint %s; use default: %s", name, err.Error())
+			continue
+		}
+		s.specs[name] = spec
+	}
+}
+
+func (s *scheduler) specOf(constraintName string) string {
+	if spec, ok := s.specs[constraintName]; ok {
+		return spec
+	}
+	return s.defaultSpec
+}
+
+// due returns the constraints to be scanned at now.
+// Constraints which have not been scheduled yet are due immediately, and removed constraints are forgotten.
+func (s *scheduler) due(now time.Time, constraintNames []string) []string {
+	exists := map[string]bool{}
+	dueNames := []string{}
+	for _, name := range constraintNames {
+		exists[name] = true
+		next, ok := s.next[name]
+		if ok && s.nextSpec[name] != s.specOf(name) {
+			// schedule is changed; reschedule without waiting for the old one
+			s.schedule(name, now)
+			next = s.next[name]
+		}
+		if !ok || !now.Before(next) {
+			dueNames = append(dueNames, name)
+		}
+	}
+	for name := range s.next {
+		if !exists[name] {
+			delete(s.next, name)
+			delete(s.nextSpec, name)
+		}
+	}
+	return dueNames
+}
+
+// schedule sets the next scan time of the constraint after now.
+func (s *scheduler) schedule(constraintName string, now time.Time) {
+	spec := s.specOf(constraintName)
+	schedule, err := k8smnfconfig.ParseSchedule(spec)
+	if err != nil {
+		// specs are validated in configure
+		schedule, _ = k8smnfconfig.ParseSchedule(defaultSchedule)
+	}
+	next := schedule.Next(now)
+	if s.jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+	}
+	s.next[constraintName] = next
+	s.nextSpec[constraintName] = spec
+}
+
+// retry schedules the constraint again after a short delay
+func (s *scheduler) retry(constraintName string, now time.Time) {
+	s.next[constraintName] = now.Add(schedulerRetryDelay)
+	s.nextSpec[constraintName] = s.specOf(constraintName)
+}
+
+// period returns the longest interval between scheduled scans among the configured schedules
+func (s *scheduler) period(now time.Time) time.Duration {
+	specs := []string{s.defaultSpec}
+	for _, spec := range s.specs {
+		specs = append(specs, spec)
+	}
+	longest := time.Duration(0)
+	for _, spec := range specs {
+		schedule, err := k8smnfconfig.ParseSchedule(spec)
+		if err != nil {
+			continue
+		}
+		next := schedule.Next(now)
+		if d := schedule.Next(next).Sub(next); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// wakeup returns when the scheduler should check due constraints next time.
+func (s *scheduler) wakeup(now time.Time) time.Time {
+	wakeup := now.Add(maxSchedulerSleep)
+	for _, next := range s.next {
+		if next.Before(wakeup) {
+			wakeup = next
+		}
+	}
+	return wakeup
+}
+
+// RunScheduler scans constraints on their schedules until stop is closed.
+func (self *Observer) RunScheduler(stop <-chan struct{}) {
+	s := newScheduler()
+	for {
+		now := time.Now()
+		oconfig, err := LoadObserverConfig()
+		if err != nil {
+			log.Error("Failed to load ObserverConfig; err: ", err.Error())
+			oconfig = &ObserverConfig{}
+		}
+		s.configure(oconfig.Schedule)
+		self.setSchedulePeriod(s.period(now))
+
+		constraintNames := []string{}
+		constraints, err := self.loadConstraints()
+		if err != nil {
+			log.Debug("Failed to load constraints; err: ", err.Error())
+		} else if len(constraints) == 0 {
+			self.setNoConstraintsTime(now)
+		}
+		for _, c := range constraints {
+			constraintNames = append(constraintNames, c.Parameters.ConstraintName)
+		}
+
+		self.runDueScans(s, now, constraintNames, self.Scan, self.refreshCoverage)
+
+		wait := time.Until(s.wakeup(time.Now()))
+		if wait < 0 {
+			wait = 0
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runDueScans scans the constraints which are due at now.
+// If all constraints are due, they are scanned together in one scan. Otherwise each due constraint is scanned
+// separately, and then refresh is called once, because the coverage report is updated only in scans of all constraints.
+func (self *Observer) runDueScans(s *scheduler, now time.Time, constraintNames []string, scan func(ScanRequest) error, refresh func()) {
+	dueNames := s.due(now, constraintNames)
+	if len(dueNames) > 0 && len(dueNames) == len(constraintNames) {
+		err := scan(ScanRequest{})
+		self.handleScheduledScanResult(s, dueNames, err)
+		return
+	}
+	scanned := false
+	for _, name := range dueNames {
+		err := scan(ScanRequest{ConstraintName: name})
+		self.handleScheduledScanResult(s, []string{name}, err)
+		if err != ErrScanInProgress {
+			scanned = true
+		}
+	}
+	if scanned {
+		refresh()
+	}
+}
+
+func (self *Observer) handleScheduledScanResult(s *scheduler, constraintNames []string, err error) {
+	now := time.Now()
+	for _, name := range constraintNames {
+		if err == ErrScanInProgress {
+			s.retry(name, now)
+		} else {
+			s.schedule(name, now)
+		}
+	}
+	if err == ErrScanInProgress {
+		log.Info("scheduled scan is postponed; ", err.Error())
+	} else if err != nil {
+		log.Error("Failed to scan resources; err: ", err.Error())
+	}
+}
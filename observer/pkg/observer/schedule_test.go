@@ -0,0 +1,170 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSchedulerDue(t *testing.T) {
+	os.Unsetenv(intervalEnvKey)
+	s := newScheduler()
+	s.configure(ScheduleConfig{
+		Default:     "1h",
+		Constraints: map[string]string{"critical": "5m", "broken": "never"},
+	})
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	constraints := []string{"critical", "normal", "broken"}
+
+	// all constraints are due at first
+	due := s.due(now, constraints)
+	if len(due) != 3 {
+		t.Errorf("unexpected due constraints: got: %v", due)
+		return
+	}
+	for _, name := range due {
+		s.schedule(name, now)
+	}
+	// invalid schedule falls back to the default
+	if !s.next["broken"].Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected next time: got: %v\nwant: %v", s.next["broken"], now.Add(time.Hour))
+		return
+	}
+
+	due = s.due(now.Add(6*time.Minute), constraints)
+	if len(due) != 1 || due[0] != "critical" {
+		t.Errorf("unexpected due constraints: got: %v\nwant: [critical]", due)
+		return
+	}
+	if wakeup := s.wakeup(now.Add(6 * time.Minute)); !wakeup.Equal(now.Add(5 * time.Minute)) {
+		t.Errorf("unexpected wakeup: got: %v\nwant: %v", wakeup, now.Add(5*time.Minute))
+		return
+	}
+
+	// removed constraints are forgotten
+	s.due(now, []string{"critical"})
+	if len(s.next) != 1 {
+		t.Errorf("removed constraints should be forgotten: got: %v", s.next)
+		return
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	s := newScheduler()
+	s.configure(ScheduleConfig{Default: "10m", Jitter: "1m"})
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		s.schedule("c", now)
+		next := s.next["c"]
+		if next.Before(now.Add(10*time.Minute)) || !next.Before(now.Add(11*time.Minute)) {
+			t.Errorf("next time is out of jitter range: got: %v", next)
+			return
+		}
+	}
+}
+
+func TestSchedulerPeriodAndScanTimeout(t *testing.T) {
+	os.Unsetenv(intervalEnvKey)
+	now := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	testcases := []struct {
+		name    string
+		config  ScheduleConfig
+		period  time.Duration
+		timeout time.Duration
+	}{
+		{
+			name:    "short default schedule keeps the minimum timeout",
+			config:  ScheduleConfig{Default: "5m"},
+			period:  5 * time.Minute,
+			timeout: minScanTimeout,
+		},
+		{
+			name:    "longest constraint schedule is used",
+			config:  ScheduleConfig{Default: "5m", Constraints: map[string]string{"slow": "2h"}},
+			period:  2 * time.Hour,
+			timeout: 6 * time.Hour,
+		},
+		{
+			name:    "cron schedule",
+			config:  ScheduleConfig{Default: "0 2 * * *"},
+			period:  24 * time.Hour,
+			timeout: 72 * time.Hour,
+		},
+	}
+	for _, tc := range testcases {
+		s := newScheduler()
+		s.configure(tc.config)
+		period := s.period(now)
+		if period != tc.period {
+			t.Errorf("%s: unexpected period: got: %s\nwant: %s", tc.name, period, tc.period)
+			continue
+		}
+		o := NewObserver()
+		o.setSchedulePeriod(period)
+		if timeout := o.scanTimeout(); timeout != tc.timeout {
+			t.Errorf("%s: unexpected scan timeout: got: %s\nwant: %s", tc.name, timeout, tc.timeout)
+		}
+	}
+}
+
+func TestRunDueScansWithDifferentSchedules(t *testing.T) {
+	os.Unsetenv(intervalEnvKey)
+	s := newScheduler()
+	s.configure(ScheduleConfig{
+		Default:     "1h",
+		Constraints: map[string]string{"critical": "5m"},
+	})
+	constraints := []string{"critical", "normal"}
+	o := NewObserver()
+	scans := []ScanRequest{}
+	scan := func(req ScanRequest) error {
+		scans = append(scans, req)
+		return nil
+	}
+	refreshed := 0
+	refresh := func() { refreshed++ }
+
+	// all constraints are due at first, so they are scanned together and the scan updates the coverage
+	now := time.Now()
+	o.runDueScans(s, now, constraints, scan, refresh)
+	if len(scans) != 1 || scans[0].ConstraintName != "" || refreshed != 0 {
+		t.Errorf("unexpected scans: got: %v, refreshed %d times", scans, refreshed)
+		return
+	}
+
+	// only the critical one is due; coverage is refreshed after the constraint scan
+	scans = []ScanRequest{}
+	o.runDueScans(s, now.Add(6*time.Minute), constraints, scan, refresh)
+	if len(scans) != 1 || scans[0].ConstraintName != "critical" || refreshed != 1 {
+		t.Errorf("unexpected scans: got: %v, refreshed %d times", scans, refreshed)
+		return
+	}
+
+	// no refresh if the constraint scan could not start
+	scans = []ScanRequest{}
+	busy := func(req ScanRequest) error {
+		scans = append(scans, req)
+		return ErrScanInProgress
+	}
+	o.runDueScans(s, now.Add(12*time.Minute), constraints, busy, refresh)
+	if len(scans) != 1 || refreshed != 1 {
+		t.Errorf("unexpected scans: got: %v, refreshed %d times", scans, refreshed)
+		return
+	}
+}
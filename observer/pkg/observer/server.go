@@ -0,0 +1,232 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const apiPortEnvKey = "OBSERVER_API_PORT"
+const scanTimeoutEnvKey = "SCAN_TIMEOUT"
+
+const defaultAPIPort = 8080
+
+// the certificate of the observer api, which is issued by the operator
+const apiTLSDir = "/run/secrets/tls"
+
+// a running scan is considered to be stuck after this number of schedule periods, but not before minScanTimeout.
+// the observer is not ready either if no scan has succeeded for the same time. SCAN_TIMEOUT env overrides it.
+const scanTimeoutPeriods = 3
+const minScanTimeout = 1 * time.Hour
+
+// NewAPIHandler returns a handler for the observer API and health checks.
+//
+//	POST /api/scan?constraint=<name>&namespace=<ns>  start a scan
+//	GET  /api/scan                                   status of the current or last scan
+//	GET  /api/results?constraint=&namespace=&kind=&violation=  latest results
+//	GET  /api/coverage                               latest coverage report
+//	POST /api/dryrun                                 evaluate a candidate constraint (DryRunRequest)
+//	GET  /metrics                                    prometheus metrics
+//
+// API and metrics requests require a bearer token of a user who is allowed to `get` (GET) or `create` (POST)
+// the path as a nonResourceURL. Results do not contain diffs of resources, which may include values of Secrets.
+func (self *Observer) NewAPIHandler() http.Handler {
+	scanTimeoutOverride := time.Duration(0)
+	if v := os.Getenv(scanTimeoutEnvKey); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Warningf("failed to parse %s `%s`; follow the schedule instead", scanTimeoutEnvKey, v)
+		} else {
+			scanTimeoutOverride = d
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/scan", self.requireAuthorization(self.scanHandler))
+	mux.HandleFunc("/api/results", self.requireAuthorization(self.resultsHandler))
+	mux.HandleFunc("/api/coverage", self.requireAuthorization(self.coverageHandler))
+	mux.HandleFunc("/api/dryrun", self.requireAuthorization(self.dryRunHandler))
+	mux.HandleFunc("/metrics", self.requireAuthorization(metricsHandler().ServeHTTP))
+	scanTimeout := func() time.Duration {
+		if scanTimeoutOverride != 0 {
+			return scanTimeoutOverride
+		}
+		return self.scanTimeout()
+	}
+	mux.HandleFunc("/health/liveness", func(w http.ResponseWriter, r *http.Request) {
+		self.checkLiveness(w, r, scanTimeout())
+	})
+	mux.HandleFunc("/health/readiness", func(w http.ResponseWriter, r *http.Request) {
+		self.checkReadiness(w, r, scanTimeout())
+	})
+	return mux
+}
+
+// StartAPIServer serves the observer API over TLS on the port specified by env value.
+// Bearer tokens are sent to the API, so it is never served in plain HTTP.
+func (self *Observer) StartAPIServer() error {
+	port := defaultAPIPort
+	if v := os.Getenv(apiPortEnvKey); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to parse %s `%s`", apiPortEnvKey, v))
+		}
+		port = p
+	}
+	serverObj := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   self.NewAPIHandler(),
+		TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	log.Infof("observer api is listening on %s", serverObj.Addr)
+	return serverObj.ListenAndServeTLS(filepath.Join(apiTLSDir, "tls.crt"), filepath.Join(apiTLSDir, "tls.key"))
+}
+
+func (self *Observer) scanHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, self.GetScanStatus())
+	case http.MethodPost:
+		req := ScanRequest{
+			ConstraintName: r.URL.Query().Get("constraint"),
+			Namespace:      r.URL.Query().Get("namespace"),
+		}
+		err := self.StartScan(req)
+		if err == ErrScanInProgress {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, self.GetScanStatus())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (self *Observer) resultsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	filter := ResultFilter{
+		ConstraintName: query.Get("constraint"),
+		Namespace:      query.Get("namespace"),
+		Kind:           query.Get("kind"),
+	}
+	if v := query.Get("violation"); v != "" {
+		violation, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid value for `violation`: %s", v), http.StatusBadRequest)
+			return
+		}
+		filter.Violation = &violation
+	}
+	writeJSON(w, http.StatusOK, self.GetResults(filter))
+}
+
+func (self *Observer) coverageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	report := self.GetCoverageReport()
+	if report == nil {
+		http.Error(w, "coverage report is not available; enable it in the observer config", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (self *Observer) dryRunHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req, err := DecodeDryRunRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := self.DryRun(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// liveness fails if a scan seems to be stuck
+func (self *Observer) checkLiveness(w http.ResponseWriter, r *http.Request, scanTimeout time.Duration) {
+	status := self.GetScanStatus()
+	if status.Running && status.StartTime != nil && time.Since(*status.StartTime) > scanTimeout {
+		http.Error(w, fmt.Sprintf("scan has been running since %s", status.StartTime.Format(time.RFC3339)), http.StatusServiceUnavailable)
+		return
+	}
+	msg := "liveness ok"
+	_, _ = w.Write([]byte(msg))
+}
+
+// readiness fails until the observer is initialized and completes a scan, and when no scan has succeeded
+// within the scan timeout, e.g. because API server is unreachable. A single failed scan does not make it unready.
+func (self *Observer) checkReadiness(w http.ResponseWriter, r *http.Request, scanTimeout time.Duration) {
+	if !self.isInitialized() {
+		http.Error(w, "observer is not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	last := self.lastHealthyTime()
+	if last == nil {
+		http.Error(w, "no scan has completed yet", http.StatusServiceUnavailable)
+		return
+	}
+	if time.Since(*last) > scanTimeout {
+		http.Error(w, fmt.Sprintf("no scan has succeeded since %s", last.Format(time.RFC3339)), http.StatusServiceUnavailable)
+		return
+	}
+	msg := "readiness ok"
+	_, _ = w.Write([]byte(msg))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("marshaling response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(resp)
+}
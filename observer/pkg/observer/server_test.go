@@ -0,0 +1,330 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+)
+
+func TestResultsAPI(t *testing.T) {
+	o := NewObserver()
+	for _, cres := range makeTestDetailResults(2, 2, 2).ConstraintResults {
+		cres.summarize()
+		o.storeConstraintResult(cres, "")
+	}
+	// namespace scan replaces only the results in the namespace
+	nsResult := ConstraintResult{
+		ConstraintName: "constraint-0",
+		Results: []VerifyResultDetail{
+			{Namespace: "ns-1", Name: "cm-0", Kind: "ConfigMap", Violation: false},
+		},
+	}
+	merged := o.storeConstraintResult(nsResult, "ns-1")
+	if len(merged.Results) != 3 || merged.TotalViolations != 1 {
+		t.Errorf("unexpected merged result: got: %v results, %v violations\nwant: 3 results, 1 violations", len(merged.Results), merged.TotalViolations)
+		return
+	}
+
+	server := httptest.NewServer(o.NewAPIHandler())
+	defer server.Close()
+
+	// results are not served to unauthorized users
+	for _, tc := range []struct {
+		authorizer apiAuthorizer
+		expected   int
+	}{
+		{authorizer: nil, expected: http.StatusServiceUnavailable},
+		{authorizer: testAuthorizer{code: http.StatusUnauthorized, err: errors.New("bearer token is required")}, expected: http.StatusUnauthorized},
+	} {
+		o.authorizer = tc.authorizer
+		for _, path := range []string{"/api/results", "/api/coverage"} {
+			resp, err := http.Get(server.URL + path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.expected {
+				t.Errorf("unexpected status code of %s: got: %v\nwant: %v", path, resp.StatusCode, tc.expected)
+			}
+		}
+	}
+	o.authorizer = testAuthorizer{code: http.StatusOK}
+
+	testcases := []struct {
+		query    string
+		expected int
+	}{
+		{query: "", expected: 7},
+		{query: "?constraint=constraint-1", expected: 4},
+		{query: "?namespace=ns-1&violation=true", expected: 1},
+		{query: "?kind=Secret", expected: 0},
+	}
+	for _, tc := range testcases {
+		resp, err := http.Get(server.URL + "/api/results" + tc.query)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var records []ExportRecord
+		err = json.NewDecoder(resp.Body).Decode(&records)
+		resp.Body.Close()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(records) != tc.expected {
+			t.Errorf("unexpected number of results for `%s`: got: %v\nwant: %v", tc.query, len(records), tc.expected)
+			return
+		}
+	}
+
+	// diffs may contain values of Secrets, so they are not served
+	secretResult := ConstraintResult{
+		ConstraintName: "constraint-secret",
+		Results: []VerifyResultDetail{
+			{
+				Namespace:            "ns-0",
+				Name:                 "secret-0",
+				Kind:                 "Secret",
+				Violation:            true,
+				Message:              "Signature verification is required for this request, but failed to verify signature. diff found: {\"items\":[{\"key\":\"data.password\",\"values\":{\"after\":\"c2VjcmV0\"}}]}",
+				VerifyResourceResult: &k8smanifest.VerifyResourceResult{Diff: &mapnode.DiffResult{Items: []mapnode.Difference{{Key: "data.password", Values: map[string]interface{}{"after": "c2VjcmV0"}}}}},
+				Remediation:          &RemediationResult{DryRun: true, Diff: "data.password: c2VjcmV0"},
+			},
+		},
+	}
+	o.storeConstraintResult(secretResult, "")
+	resp, err := http.Get(server.URL + "/api/results?kind=Secret")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(body), "c2VjcmV0") || !strings.Contains(string(body), "secret-0") {
+		t.Errorf("diffs should be removed from results: got: %s", string(body))
+		return
+	}
+
+	resp, err = http.Get(server.URL + "/api/results?violation=maybe")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status code: got: %v\nwant: %v", resp.StatusCode, http.StatusBadRequest)
+		return
+	}
+}
+
+type testAuthorizer struct {
+	code int
+	err  error
+}
+
+func (self testAuthorizer) Authorize(r *http.Request) (int, error) {
+	return self.code, self.err
+}
+
+func TestScanAPIConflictAndHealth(t *testing.T) {
+	o := NewObserver()
+	server := httptest.NewServer(o.NewAPIHandler())
+	defer server.Close()
+
+	// not ready before initialization
+	resp, err := http.Get(server.URL + "/health/readiness")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected readiness status: got: %v\nwant: %v", resp.StatusCode, http.StatusServiceUnavailable)
+		return
+	}
+
+	// a scan which has been running for too long
+	if !o.beginScan(ScanRequest{}) {
+		t.Errorf("failed to begin a scan")
+		return
+	}
+	started := time.Now().Add(-2 * minScanTimeout)
+	o.status.StartTime = &started
+
+	// POST is rejected until an authorizer is available
+	resp, err = http.Post(server.URL+"/api/scan?constraint=constraint-0", "application/json", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected scan status code: got: %v\nwant: %v", resp.StatusCode, http.StatusServiceUnavailable)
+		return
+	}
+
+	o.authorizer = testAuthorizer{code: http.StatusForbidden, err: errors.New("forbidden")}
+	resp, err = http.Post(server.URL+"/api/dryrun", "application/json", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unexpected dryrun status code: got: %v\nwant: %v", resp.StatusCode, http.StatusForbidden)
+		return
+	}
+
+	o.authorizer = testAuthorizer{code: http.StatusOK}
+	resp, err = http.Post(server.URL+"/api/scan?constraint=constraint-0", "application/json", nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("unexpected scan status code: got: %v\nwant: %v", resp.StatusCode, http.StatusConflict)
+		return
+	}
+
+	resp, err = http.Get(server.URL + "/health/liveness")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected liveness status: got: %v\nwant: %v", resp.StatusCode, http.StatusServiceUnavailable)
+		return
+	}
+
+	// the threshold follows the schedule, so the scan is alive on a daily schedule
+	o.setSchedulePeriod(24 * time.Hour)
+	resp, err = http.Get(server.URL + "/health/liveness")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected liveness status on daily schedule: got: %v\nwant: %v", resp.StatusCode, http.StatusOK)
+		return
+	}
+
+	o.endScan(nil)
+	resp, err = http.Get(server.URL + "/api/scan")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var status ScanStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if status.Running || status.LastSuccessfulScanTime == nil {
+		t.Errorf("unexpected scan status: got: %v", status)
+		return
+	}
+
+	// a failed scan does not make the observer unready
+	o.initialized = true
+	o.beginScan(ScanRequest{})
+	o.endScan(errors.New("failed to list resources"))
+	resp, err = http.Get(server.URL + "/health/readiness")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected readiness status: got: %v\nwant: %v", resp.StatusCode, http.StatusOK)
+		return
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	o := NewObserver()
+	o.initialized = true
+	server := httptest.NewServer(o.NewAPIHandler())
+	defer server.Close()
+
+	stale := time.Now().Add(-2 * minScanTimeout)
+	testcases := []struct {
+		name   string
+		setup  func()
+		status int
+	}{
+		{
+			name:   "no scan has completed",
+			setup:  func() {},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "first scan failed",
+			setup: func() {
+				o.beginScan(ScanRequest{})
+				o.endScan(errors.New("failed to list resources"))
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "scan succeeded",
+			setup: func() {
+				o.beginScan(ScanRequest{})
+				o.endScan(nil)
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:   "no scan has succeeded for the scan timeout",
+			setup:  func() { o.status.LastSuccessfulScanTime = &stale },
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name:   "no constraints to scan",
+			setup:  func() { o.setNoConstraintsTime(time.Now()) },
+			status: http.StatusOK,
+		},
+	}
+	for _, tc := range testcases {
+		tc.setup()
+		resp, err := http.Get(server.URL + "/health/readiness")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: unexpected readiness status: got: %v\nwant: %v", tc.name, resp.StatusCode, tc.status)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	kubeclient "k8s.io/client-go/kubernetes"
+)
+
+// ViolationMarkerKey is an annotation or a label key set on resources which violate constraints.
+// The annotation value is a comma separated list of the constraint names, and the label value is "true".
+// The marker is ignored in signature verification and mutation check, so that the observer can patch it.
+const ViolationMarkerKey = shield.ViolationMarkerKey
+
+const (
+	ViolationMarkerAnnotation = "annotation"
+	ViolationMarkerLabel      = "label"
+)
+
+const violationEventReason = "IntegrityShieldViolation"
+const violationEventSource = "IntegrityShieldObserver"
+
+type SideEffectConfig struct {
+	// create a Warning event on the resource which violates a constraint
+	CreateViolationEvent bool `json:"createViolationEvent,omitempty"`
+	// mark the resource which violates a constraint with "annotation" or "label"; no marker if empty
+	ViolationMarker string `json:"violationMarker,omitempty"`
+}
+
+func (c SideEffectConfig) Enabled() bool {
+	return c.CreateViolationEvent || c.ViolationMarker != ""
+}
+
+// resourceKey identifies an observed resource regardless of its api version
+type resourceKey struct {
+	ApiGroup  string
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// observedResource is a resource observed in a scan with the current marker value
+type observedResource struct {
+	GVR    schema.GroupVersionResource
+	Marker string
+}
+
+func resourceKeyFromDetail(res VerifyResultDetail) resourceKey {
+	return resourceKey{ApiGroup: res.ApiGroup, Kind: res.Kind, Namespace: res.Namespace, Name: res.Name}
+}
+
+func resourceKeyFromObject(obj unstructured.Unstructured) resourceKey {
+	return resourceKey{ApiGroup: obj.GroupVersionKind().Group, Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+}
+
+func currentViolationMarker(obj unstructured.Unstructured, marker string) string {
+	switch marker {
+	case ViolationMarkerAnnotation:
+		return obj.GetAnnotations()[ViolationMarkerKey]
+	case ViolationMarkerLabel:
+		return obj.GetLabels()[ViolationMarkerKey]
+	}
+	return ""
+}
+
+// updateViolationMarkers sets the marker on the observed resources which violate any constraint in the results,
+// and removes it from the observed resources which no longer violate.
+func updateViolationMarkers(client dynamic.Interface, marker string, observed map[resourceKey]observedResource, results ObservationDetailResults) error {
+	if marker != ViolationMarkerAnnotation && marker != ViolationMarkerLabel {
+		return errors.New(fmt.Sprintf("unsupported violation marker: %s", marker))
+	}
+	violated := map[resourceKey][]string{}
+	for _, cres := range results.ConstraintResults {
+		for _, res := range cres.Results {
+			if res.Violation {
+				key := resourceKeyFromDetail(res)
+				violated[key] = append(violated[key], cres.ConstraintName)
+			}
+		}
+	}
+	sumErr := []string{}
+	for key, obj := range observed {
+		desired := ""
+		if constraints, ok := violated[key]; ok {
+			if marker == ViolationMarkerLabel {
+				desired = "true"
+			} else {
+				sort.Strings(constraints)
+				desired = strings.Join(constraints, ",")
+			}
+		}
+		if desired == obj.Marker {
+			continue
+		}
+		err := patchViolationMarker(client, obj.GVR, key, marker, desired)
+		if err != nil {
+			sumErr = append(sumErr, err.Error())
+			continue
+		}
+		log.WithFields(log.Fields{
+			"kind":      key.Kind,
+			"name":      key.Name,
+			"namespace": key.Namespace,
+			"marker":    desired,
+		}).Debug("violation marker is updated")
+	}
+	if len(sumErr) > 0 {
+		return errors.New(fmt.Sprintf("failed to update violation markers: %s", strings.Join(sumErr, "; ")))
+	}
+	return nil
+}
+
+// patchViolationMarker sets the marker value, or removes the marker if the value is empty
+func patchViolationMarker(client dynamic.Interface, gvr schema.GroupVersionResource, key resourceKey, marker, value string) error {
+	var markerValue interface{}
+	if value != "" {
+		markerValue = value
+	}
+	field := "annotations"
+	if marker == ViolationMarkerLabel {
+		field = "labels"
+	}
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			field: map[string]interface{}{
+				ViolationMarkerKey: markerValue,
+			},
+		},
+	}
+	patchBytes, _ := json.Marshal(patch)
+	var err error
+	if key.Namespace != "" {
+		_, err = client.Resource(gvr).Namespace(key.Namespace).Patch(context.Background(), key.Name, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+	} else {
+		_, err = client.Resource(gvr).Patch(context.Background(), key.Name, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to patch %s `%s`", key.Kind, key.Name))
+	}
+	return nil
+}
+
+// createOrUpdateViolationEvents generates a Warning event for each violation in the results.
+// Events of cluster scope resources are created in the observer namespace.
+func createOrUpdateViolationEvents(client kubeclient.Interface, podNamespace string, results []ConstraintResult) error {
+	sumErr := []string{}
+	for _, cres := range results {
+		for _, res := range cres.Results {
+			if !res.Violation {
+				continue
+			}
+			err := createOrUpdateViolationEvent(client, podNamespace, cres.ConstraintName, res)
+			if err != nil {
+				sumErr = append(sumErr, err.Error())
+			}
+		}
+	}
+	if len(sumErr) > 0 {
+		return errors.New(fmt.Sprintf("failed to generate violation events: %s", strings.Join(sumErr, "; ")))
+	}
+	return nil
+}
+
+func createOrUpdateViolationEvent(client kubeclient.Interface, podNamespace, constraintName string, res VerifyResultDetail) error {
+	gv := schema.GroupVersion{Group: res.ApiGroup, Version: res.ApiVersion}
+	evtNamespace := res.Namespace
+	if evtNamespace == "" {
+		evtNamespace = podNamespace
+	}
+	involvedObject := corev1.ObjectReference{
+		Namespace:  res.Namespace,
+		APIVersion: gv.String(),
+		Kind:       res.Kind,
+		Name:       res.Name,
+	}
+	evtName := fmt.Sprintf("ishield-violation-%s-%s-%s", constraintName, strings.ToLower(res.Kind), res.Name)
+
+	now := time.Now()
+	evt := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      evtName,
+			Namespace: evtNamespace,
+		},
+		InvolvedObject:      involvedObject,
+		Type:                corev1.EventTypeWarning,
+		Source:              corev1.EventSource{Component: violationEventSource},
+		ReportingController: violationEventSource,
+		ReportingInstance:   evtName,
+		Action:              "Observe",
+		Reason:              violationEventReason,
+		FirstTimestamp:      metav1.NewTime(now),
+	}
+	isExistingEvent := false
+	current, getErr := client.CoreV1().Events(evtNamespace).Get(context.Background(), evtName, metav1.GetOptions{})
+	if current != nil && getErr == nil {
+		isExistingEvent = true
+		evt = current
+	}
+
+	tmpMessage := "[" + constraintName + "]" + res.Message
+	// Event.Message can have 1024 chars at most
+	if len(tmpMessage) > 1024 {
+		tmpMessage = tmpMessage[:950] + " ... Trimmed. `Event.Message` can have 1024 chars at maximum."
+	}
+	evt.Message = tmpMessage
+	evt.Count = evt.Count + 1
+	evt.LastTimestamp = metav1.NewTime(now)
+
+	var err error
+	if isExistingEvent {
+		_, err = client.CoreV1().Events(evtNamespace).Update(context.Background(), evt, metav1.UpdateOptions{})
+	} else {
+		_, err = client.CoreV1().Events(evtNamespace).Create(context.Background(), evt, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to generate violation event `%s`", evtName))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var testConfigMapGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+func makeTestConfigMap(name string, annotations map[string]string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("ns-0")
+	obj.SetName(name)
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+func TestUpdateViolationMarkers(t *testing.T) {
+	// cm-1 was marked in the previous scan and is compliant now
+	objs := []*unstructured.Unstructured{
+		makeTestConfigMap("cm-0", nil),
+		makeTestConfigMap("cm-1", map[string]string{ViolationMarkerKey: "constraint-0"}),
+	}
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objs[0], objs[1])
+	observed := map[resourceKey]observedResource{}
+	for _, obj := range objs {
+		observed[resourceKeyFromObject(*obj)] = observedResource{GVR: testConfigMapGVR, Marker: currentViolationMarker(*obj, ViolationMarkerAnnotation)}
+	}
+	// cm-0 violates both constraints, cm-1 violates nothing
+	results := makeTestDetailResults(2, 1, 2)
+	err := updateViolationMarkers(client, ViolationMarkerAnnotation, observed, results)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cm0, _ := client.Resource(testConfigMapGVR).Namespace("ns-0").Get(context.Background(), "cm-0", metav1.GetOptions{})
+	if v := cm0.GetAnnotations()[ViolationMarkerKey]; v != "constraint-0,constraint-1" {
+		t.Errorf("unexpected marker on cm-0: got: %v\nwant: %v", v, "constraint-0,constraint-1")
+		return
+	}
+	cm1, _ := client.Resource(testConfigMapGVR).Namespace("ns-0").Get(context.Background(), "cm-1", metav1.GetOptions{})
+	if _, ok := cm1.GetAnnotations()[ViolationMarkerKey]; ok {
+		t.Errorf("marker on cm-1 should be removed: got: %v", cm1.GetAnnotations())
+		return
+	}
+}
+
+func TestCreateOrUpdateViolationEvents(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	results := makeTestDetailResults(1, 1, 2).ConstraintResults
+	// run twice to check the event is updated
+	for i := 0; i < 2; i++ {
+		err := createOrUpdateViolationEvents(client, testNamespace, results)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	events, _ := client.CoreV1().Events("ns-0").List(context.Background(), metav1.ListOptions{})
+	if len(events.Items) != 1 {
+		t.Errorf("unexpected number of events: got: %v\nwant: %v", len(events.Items), 1)
+		return
+	}
+	evt := events.Items[0]
+	if evt.Type != "Warning" || evt.InvolvedObject.Name != "cm-0" || evt.Count != 2 {
+		t.Errorf("unexpected event: got: type %v, object %v, count %v", evt.Type, evt.InvolvedObject.Name, evt.Count)
+		return
+	}
+}
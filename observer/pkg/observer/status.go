@@ -0,0 +1,219 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrScanInProgress is returned when a scan is requested while another scan is running.
+var ErrScanInProgress = errors.New("another scan is in progress")
+
+// ScanRequest is a scope of a scan; empty fields mean all constraints / namespaces.
+type ScanRequest struct {
+	ConstraintName string `json:"constraintName,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+}
+
+type ScanStatus struct {
+	Running                bool        `json:"running"`
+	Request                ScanRequest `json:"request"`
+	StartTime              *time.Time  `json:"startTime,omitempty"`
+	EndTime                *time.Time  `json:"endTime,omitempty"`
+	TotalConstraints       int         `json:"totalConstraints"`
+	ScannedConstraints     int         `json:"scannedConstraints"`
+	ScannedResources       int         `json:"scannedResources"`
+	LastError              string      `json:"lastError,omitempty"`
+	LastSuccessfulScanTime *time.Time  `json:"lastSuccessfulScanTime,omitempty"`
+}
+
+// ResultFilter narrows the latest results; empty fields match everything.
+type ResultFilter struct {
+	ConstraintName string
+	Namespace      string
+	Kind           string
+	Violation      *bool
+}
+
+func (self *Observer) beginScan(req ScanRequest) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.status.Running {
+		return false
+	}
+	now := time.Now()
+	self.status.Running = true
+	self.status.Request = req
+	self.status.StartTime = &now
+	self.status.EndTime = nil
+	self.status.TotalConstraints = 0
+	self.status.ScannedConstraints = 0
+	self.status.ScannedResources = 0
+	return true
+}
+
+func (self *Observer) endScan(err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := time.Now()
+	if self.status.StartTime != nil {
+		recordScan(*self.status.StartTime, now, err)
+	}
+	self.status.Running = false
+	self.status.EndTime = &now
+	if err != nil {
+		self.status.LastError = err.Error()
+	} else {
+		self.status.LastError = ""
+		self.status.LastSuccessfulScanTime = &now
+	}
+}
+
+func (self *Observer) setScanProgress(total, constraints, resources int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.status.TotalConstraints = total
+	self.status.ScannedConstraints = constraints
+	self.status.ScannedResources = resources
+}
+
+// GetScanStatus returns the status of the current or last scan.
+func (self *Observer) GetScanStatus() ScanStatus {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.status
+}
+
+func (self *Observer) setSchedulePeriod(period time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.schedulePeriod = period
+}
+
+// scanTimeout returns how long a scan can run before it is considered to be stuck.
+// It follows the schedule so that scans of infrequent schedules are not killed by liveness.
+func (self *Observer) scanTimeout() time.Duration {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	timeout := time.Duration(scanTimeoutPeriods) * self.schedulePeriod
+	if timeout < minScanTimeout {
+		timeout = minScanTimeout
+	}
+	return timeout
+}
+
+// setNoConstraintsTime records when the scheduler loaded constraints and found none, so there is nothing to scan.
+func (self *Observer) setNoConstraintsTime(t time.Time) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.noConstraintsTime = &t
+}
+
+// lastHealthyTime returns when the observer last completed a scan successfully,
+// or found no constraints to scan, whichever is later.
+func (self *Observer) lastHealthyTime() *time.Time {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	last := self.status.LastSuccessfulScanTime
+	if self.noConstraintsTime != nil && (last == nil || self.noConstraintsTime.After(*last)) {
+		last = self.noConstraintsTime
+	}
+	return last
+}
+
+func (self *Observer) isInitialized() bool {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.initialized
+}
+
+// pruneLatestResults removes results of constraints which no longer exist.
+func (self *Observer) pruneLatestResults(constraints []ConstraintSpec) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	names := map[string]bool{}
+	for _, c := range constraints {
+		names[c.Parameters.ConstraintName] = true
+	}
+	for name := range self.latestResults {
+		if !names[name] {
+			delete(self.latestResults, name)
+		}
+	}
+}
+
+// storeConstraintResult merges the result into the latest results and returns the merged one.
+// If namespace is specified, only the results in the namespace are replaced.
+func (self *Observer) storeConstraintResult(cres ConstraintResult, namespace string) ConstraintResult {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.latestResults == nil {
+		self.latestResults = map[string]ConstraintResult{}
+	}
+	merged := cres
+	if current, ok := self.latestResults[cres.ConstraintName]; ok && namespace != "" {
+		results := []VerifyResultDetail{}
+		for _, res := range current.Results {
+			if res.Namespace != namespace {
+				results = append(results, res)
+			}
+		}
+		merged.Results = append(results, cres.Results...)
+		merged.summarize()
+	}
+	self.latestResults[cres.ConstraintName] = merged
+	self.latestTime = time.Now().Format(timeFormat)
+	return merged
+}
+
+// GetLatestResults returns the latest results of all constraints sorted by constraint name.
+func (self *Observer) GetLatestResults() ObservationDetailResults {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	res := ObservationDetailResults{Time: self.latestTime}
+	for _, cres := range self.latestResults {
+		res.ConstraintResults = append(res.ConstraintResults, cres)
+	}
+	sort.Slice(res.ConstraintResults, func(i, j int) bool {
+		return res.ConstraintResults[i].ConstraintName < res.ConstraintResults[j].ConstraintName
+	})
+	return res
+}
+
+// GetResults returns the latest results of single resources which match the filter.
+func (self *Observer) GetResults(filter ResultFilter) []ExportRecord {
+	records := []ExportRecord{}
+	for _, r := range makeExportRecords(self.GetLatestResults()) {
+		if filter.ConstraintName != "" && r.ConstraintName != filter.ConstraintName {
+			continue
+		}
+		if filter.Namespace != "" && r.Namespace != filter.Namespace {
+			continue
+		}
+		if filter.Kind != "" && r.Kind != filter.Kind {
+			continue
+		}
+		if filter.Violation != nil && r.Violation != *filter.Violation {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records
+}
@@ -0,0 +1,177 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	vrc "github.com/IBM/integrity-shield/observer/pkg/apis/manifestintegritystate/v1"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+)
+
+const manifestIntegritySummaryName = "cluster-summary"
+
+// number of resources listed in the worst offenders of the summary
+const maxWorstOffenders = 10
+
+var manifestIntegritySummaryGVR = vrc.SchemeGroupVersion.WithResource("manifestintegritysummaries")
+
+func countErrors(cres ConstraintResult) int {
+	count := 0
+	for _, res := range cres.Results {
+		if res.Error {
+			count++
+		}
+	}
+	return count
+}
+
+// setManifestIntegrityStateConditions updates the conditions; the transition time is kept if the status is not changed.
+func setManifestIntegrityStateConditions(status *vrc.ManifestIntegrityStateStatus, violated bool, scanErrors int) {
+	compliant := metav1.Condition{
+		Type:    vrc.ConditionCompliant,
+		Status:  metav1.ConditionTrue,
+		Reason:  "NoViolation",
+		Message: "all resources comply with the constraint",
+	}
+	if violated {
+		compliant.Status = metav1.ConditionFalse
+		compliant.Reason = "ViolationFound"
+		compliant.Message = "some resources violate the constraint"
+	}
+	meta.SetStatusCondition(&status.Conditions, compliant)
+
+	scanFailed := metav1.Condition{
+		Type:    vrc.ConditionScanFailed,
+		Status:  metav1.ConditionFalse,
+		Reason:  "ScanSucceeded",
+		Message: "all resources are verified",
+	}
+	if scanErrors > 0 {
+		scanFailed.Status = metav1.ConditionTrue
+		scanFailed.Reason = "VerificationError"
+		scanFailed.Message = fmt.Sprintf("failed to verify %d resources", scanErrors)
+	}
+	meta.SetStatusCondition(&status.Conditions, scanFailed)
+}
+
+func makeManifestIntegritySummarySpec(results ObservationDetailResults) vrc.ManifestIntegritySummarySpec {
+	spec := vrc.ManifestIntegritySummarySpec{
+		LastScanTime:   results.Time,
+		Constraints:    []vrc.ConstraintSummary{},
+		WorstOffenders: []vrc.Offender{},
+	}
+	offenders := map[resourceKey]*vrc.Offender{}
+	for _, cres := range results.ConstraintResults {
+		spec.TotalConstraints++
+		if cres.Violation {
+			spec.ViolatedConstraints++
+		}
+		spec.TotalResources += len(cres.Results)
+		spec.TotalViolations += cres.TotalViolations
+		spec.Constraints = append(spec.Constraints, vrc.ConstraintSummary{
+			ConstraintName:  cres.ConstraintName,
+			Violation:       cres.Violation,
+			TotalViolations: cres.TotalViolations,
+			TotalResources:  len(cres.Results),
+		})
+		for _, res := range cres.Results {
+			if !res.Violation {
+				continue
+			}
+			key := resourceKeyFromDetail(res)
+			if _, ok := offenders[key]; !ok {
+				offenders[key] = &vrc.Offender{
+					Namespace: res.Namespace,
+					Name:      res.Name,
+					Kind:      res.Kind,
+					ApiGroup:  res.ApiGroup,
+				}
+			}
+			offenders[key].Constraints = append(offenders[key].Constraints, cres.ConstraintName)
+		}
+	}
+	for _, o := range offenders {
+		sort.Strings(o.Constraints)
+		spec.WorstOffenders = append(spec.WorstOffenders, *o)
+	}
+	// resources which violate more constraints come first
+	sort.Slice(spec.WorstOffenders, func(i, j int) bool {
+		oi, oj := spec.WorstOffenders[i], spec.WorstOffenders[j]
+		if len(oi.Constraints) != len(oj.Constraints) {
+			return len(oi.Constraints) > len(oj.Constraints)
+		}
+		if oi.Namespace != oj.Namespace {
+			return oi.Namespace < oj.Namespace
+		}
+		if oi.Kind != oj.Kind {
+			return oi.Kind < oj.Kind
+		}
+		return oi.Name < oj.Name
+	})
+	if len(spec.WorstOffenders) > maxWorstOffenders {
+		spec.WorstOffenders = spec.WorstOffenders[:maxWorstOffenders]
+	}
+	return spec
+}
+
+// exportSummary creates or updates the cluster-scoped ManifestIntegritySummary.
+func exportSummary(client dynamic.Interface, spec vrc.ManifestIntegritySummarySpec) error {
+	summary := &vrc.ManifestIntegritySummary{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: vrc.SchemeGroupVersion.String(),
+			Kind:       "ManifestIntegritySummary",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: manifestIntegritySummaryName,
+		},
+		Spec: spec,
+	}
+	exists := false
+	current, err := client.Resource(manifestIntegritySummaryGVR).Get(context.Background(), manifestIntegritySummaryName, metav1.GetOptions{})
+	if err == nil {
+		exists = true
+		summary.ObjectMeta.ResourceVersion = current.GetResourceVersion()
+	} else if !k8serrors.IsNotFound(err) {
+		return errors.Wrap(err, fmt.Sprintf("failed to get ManifestIntegritySummary `%s`", manifestIntegritySummaryName))
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(summary)
+	if err != nil {
+		return errors.Wrap(err, "failed to convert ManifestIntegritySummary")
+	}
+	u := &unstructured.Unstructured{Object: obj}
+	if exists {
+		log.Info("updating ManifestIntegritySummary resource...")
+		_, err = client.Resource(manifestIntegritySummaryGVR).Update(context.Background(), u, metav1.UpdateOptions{})
+	} else {
+		log.Info("creating new ManifestIntegritySummary resource...")
+		_, err = client.Resource(manifestIntegritySummaryGVR).Create(context.Background(), u, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to export ManifestIntegritySummary `%s`", manifestIntegritySummaryName))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package observer
+
+import (
+	"context"
+	"testing"
+
+	vrc "github.com/IBM/integrity-shield/observer/pkg/apis/manifestintegritystate/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestSetManifestIntegrityStateConditions(t *testing.T) {
+	status := &vrc.ManifestIntegrityStateStatus{}
+	setManifestIntegrityStateConditions(status, true, 0)
+	compliant := meta.FindStatusCondition(status.Conditions, vrc.ConditionCompliant)
+	if compliant == nil || compliant.Status != metav1.ConditionFalse {
+		t.Errorf("unexpected Compliant condition: got: %v", compliant)
+		return
+	}
+	transitionTime := compliant.LastTransitionTime
+
+	// transition time is kept while the status is not changed
+	setManifestIntegrityStateConditions(status, true, 2)
+	compliant = meta.FindStatusCondition(status.Conditions, vrc.ConditionCompliant)
+	if !compliant.LastTransitionTime.Equal(&transitionTime) {
+		t.Errorf("unexpected transition time: got: %v\nwant: %v", compliant.LastTransitionTime, transitionTime)
+		return
+	}
+	if !meta.IsStatusConditionTrue(status.Conditions, vrc.ConditionScanFailed) {
+		t.Errorf("ScanFailed condition should be true: got: %v", status.Conditions)
+		return
+	}
+	if len(status.Conditions) != 2 {
+		t.Errorf("unexpected number of conditions: got: %v\nwant: %v", len(status.Conditions), 2)
+		return
+	}
+}
+
+func TestManifestIntegritySummary(t *testing.T) {
+	results := makeTestDetailResults(2, 1, 3)
+	// cm-2 violates only constraint-1
+	results.ConstraintResults[0].Results[2].Violation = false
+	for i := range results.ConstraintResults {
+		results.ConstraintResults[i].summarize()
+	}
+	spec := makeManifestIntegritySummarySpec(results)
+	if spec.TotalConstraints != 2 || spec.ViolatedConstraints != 2 || spec.TotalResources != 6 || spec.TotalViolations != 3 {
+		t.Errorf("unexpected totals: got: %v", spec)
+		return
+	}
+	if len(spec.WorstOffenders) != 2 || spec.WorstOffenders[0].Name != "cm-0" || len(spec.WorstOffenders[0].Constraints) != 2 {
+		t.Errorf("unexpected worst offenders: got: %v", spec.WorstOffenders)
+		return
+	}
+
+	listKinds := map[schema.GroupVersionResource]string{manifestIntegritySummaryGVR: "ManifestIntegritySummaryList"}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds)
+	// create, then update
+	for i := 0; i < 2; i++ {
+		if err := exportSummary(client, spec); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	obj, err := client.Resource(manifestIntegritySummaryGVR).Get(context.Background(), manifestIntegritySummaryName, metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	violated, _, _ := unstructured.NestedInt64(obj.Object, "spec", "violatedConstraints")
+	if violated != 2 {
+		t.Errorf("unexpected violatedConstraints: got: %v\nwant: %v", violated, 2)
+		return
+	}
+}
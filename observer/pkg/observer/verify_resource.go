@@ -18,148 +18,55 @@ package observer
 
 import (
 	"fmt"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 
 	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
-	ishieldimage "github.com/IBM/integrity-shield/shield/pkg/image"
-	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 const AnnotationKeyDomain = "integrityshield.io"
-const ImageRefAnnotationKeyShield = "integrityshield.io/signature"
-const provenanceEnvKey = "ENABLE_PROVENANCE_RESULT"
 
-func ObserveResource(resource unstructured.Unstructured, signatureRef k8smnfconfig.SignatureRef, ignoreFields k8smanifest.ObjectFieldBindingList, skipObjects k8smanifest.ObjectReferenceList, secrets []k8smnfconfig.KeyConfig) VerifyResultDetail {
-	namespace := os.Getenv("POD_NAMESPACE")
-	if namespace == "" {
-		namespace = defaultPodNamespace
-	}
-	log.Debug("Observed Resource:", resource.GetAPIVersion(), resource.GetKind(), resource.GetNamespace(), resource.GetName())
-	vo := &k8smanifest.VerifyResourceOption{}
-	vo.IgnoreFields = ignoreFields
-	vo.SkipObjects = skipObjects
-	// vo.CheckDryRunForApply = true
-	provStr := os.Getenv(provenanceEnvKey)
-	prov, _ := strconv.ParseBool(provStr)
-	if prov {
-		vo.Provenance = true
-	}
-	vo.DryRunNamespace = namespace
-
-	if signatureRef.ImageRef != "" {
-		vo.ImageRef = signatureRef.ImageRef
-	}
-	if signatureRef.SignatureResourceRef.Name != "" && signatureRef.SignatureResourceRef.Namespace != "" {
-		ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", signatureRef.SignatureResourceRef.Namespace, signatureRef.SignatureResourceRef.Name)
-		vo.SignatureResourceRef = ref
-	}
-	if signatureRef.ProvenanceResourceRef.Name != "" && signatureRef.ProvenanceResourceRef.Namespace != "" {
-		ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", signatureRef.ProvenanceResourceRef.Namespace, signatureRef.ProvenanceResourceRef.Name)
-		vo.ProvenanceResourceRef = ref
-	}
+// newVerifier returns the verifier of the constraint, which is shared with the admission controller.
+func newVerifier(constraint ConstraintSpec, rhconfig *k8smnfconfig.RequestHandlerConfig) *shield.Verifier {
+	param := constraint.Parameters
+	return shield.NewVerifier(&param, rhconfig)
+}
 
-	// set Signature type
-	annotations := resource.GetAnnotations()
-	_, found := annotations[ImageRefAnnotationKeyShield]
-	if found {
-		vo.AnnotationConfig.AnnotationKeyDomain = AnnotationKeyDomain
-	}
-	// secret
-	for _, s := range secrets {
-		if s.KeySecretNamespace == resource.GetNamespace() {
-			pubkey, err := LoadKeySecret(s.KeySecretNamespace, s.KeySecretName)
-			if err != nil {
-				fmt.Println("Failed to load pubkey; err: ", err.Error())
-			}
-			vo.KeyPath = pubkey
-			break
-		}
-	}
-	log.Debug("VerifyResourceOption", vo)
-	result, err := k8smanifest.VerifyResource(resource, vo)
-	log.Debug("VerifyResource result: ", result)
-	if err != nil {
-		log.Warning("Signature verification is required for this request, but verifyResource return error ; %s", err.Error())
-		return VerifyResultDetail{
-			Time:                 time.Now().Format(timeFormat),
-			Kind:                 resource.GroupVersionKind().Kind,
-			ApiGroup:             resource.GetObjectKind().GroupVersionKind().Group,
-			ApiVersion:           resource.GetObjectKind().GroupVersionKind().Version,
-			Name:                 resource.GetName(),
-			Namespace:            resource.GetNamespace(),
-			Error:                true,
-			Message:              err.Error(),
-			Violation:            true,
-			VerifyResourceResult: nil,
-		}
-	}
-	message := ""
-	if result.InScope {
-		if result.Verified {
-			message = fmt.Sprintf("singed by a valid signer: %s", result.Signer)
-		} else {
-			message = "no signature found"
-			if result.Diff != nil && result.Diff.Size() > 0 {
-				message = fmt.Sprintf("diff found: %s", result.Diff.String())
-			} else if result.Signer != "" {
-				message = fmt.Sprintf("signer config not matched, this is signed by %s", result.Signer)
-			}
-		}
-	} else {
-		message = "not protected"
+// ObserveResource verifies the existing resource in the same way as admission requests.
+// Skip user rules are applied only if they match any user, because the requester of the resource is unknown.
+func ObserveResource(resource unstructured.Unstructured, verifier *shield.Verifier) VerifyResultDetail {
+	log.Debug("Observed Resource:", resource.GetAPIVersion(), resource.GetKind(), resource.GetNamespace(), resource.GetName())
+	result := verifier.Verify(resource, "")
+	log.Debug("VerifyResource result: ", result.VerifyResourceResult)
+	// unverified images are counted as a violation of the resource.
+	// only a failure of image verification itself is counted as an error.
+	if result.ImageError != nil {
+		recordError(errorReasonImage)
 	}
 
-	tmpMsg := strings.Split(message, " (Request: {")
+	tmpMsg := strings.Split(result.Message, " (Request: {")
 	resultMsg := ""
 	if len(tmpMsg) > 0 {
 		resultMsg = tmpMsg[0]
 	}
-
-	violation := true
-	if result.Verified {
-		violation = false
+	// both the resource and its images are not verified
+	if !result.Allow && !result.ImageAllow && result.Message != result.ImageMessage {
+		resultMsg = fmt.Sprintf("%s, [Image]%s", resultMsg, result.ImageMessage)
 	}
 
 	return VerifyResultDetail{
-		Time: time.Now().Format(timeFormat),
-		// Resource:             resource,
+		Time:                 time.Now().Format(timeFormat),
 		Kind:                 resource.GroupVersionKind().Kind,
+		ApiGroup:             resource.GroupVersionKind().Group,
+		ApiVersion:           resource.GroupVersionKind().Version,
 		Name:                 resource.GetName(),
 		Namespace:            resource.GetNamespace(),
-		Error:                false,
+		Error:                result.Error != nil,
 		Message:              resultMsg,
-		VerifyResourceResult: result,
-		Violation:            violation,
+		VerifyResourceResult: result.VerifyResourceResult,
+		Violation:            !result.Allow,
 	}
 }
-
-func ObserveImage(resource unstructured.Unstructured, profile k8smnfconfig.ImageProfile) (bool, string) {
-	// image verify
-	imageAllow := true
-	imageMessage := ""
-	var imageVerifyResults []ishieldimage.ImageVerifyResult
-	if profile.Enabled() {
-		_, err := ishieldimage.VerifyImageInManifest(resource, profile)
-		if err != nil {
-			log.Errorf("failed to verify images: %s", err.Error())
-			imageAllow = false
-			imageMessage = "Image signature verification is required, but failed to verify signature: " + err.Error()
-
-		} else {
-			for _, res := range imageVerifyResults {
-				if res.InScope && !res.Verified {
-					imageAllow = false
-					imageMessage = "Image signature verification is required, but failed to verify signature: " + res.FailReason
-					break
-				}
-			}
-		}
-	}
-
-	return imageAllow, imageMessage
-}
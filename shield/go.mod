@@ -7,9 +7,12 @@ require (
 	github.com/ghodss/yaml v1.0.0
 	github.com/jinzhu/copier v0.3.2
 	github.com/pkg/errors v0.9.1
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sigstore/cosign v1.1.0
 	github.com/sigstore/k8s-manifest-sigstore v0.0.0-20210909071548-2120192e4ff7
+	github.com/sigstore/rekor v0.3.0
 	github.com/sirupsen/logrus v1.8.1
+	golang.org/x/crypto v0.0.0-20210711020723-a769d52b0f97
 	k8s.io/api v0.21.3
 	k8s.io/apimachinery v0.21.3
 	k8s.io/client-go v0.21.3
@@ -0,0 +1,419 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const DefaultConfigMapKey = "bundle.tar.gz"
+
+// MaxBundleSize is the limit of the total size of the files in a bundle after decompression.
+const MaxBundleSize = 32 * 1024 * 1024
+
+const (
+	ociIndexFileName = "index.json"
+	ociBlobsDirName  = "blobs"
+)
+
+// annotations in the OCI image layout written by `cosign save`
+const (
+	ociKindAnnotationKey         = "kind"
+	cosignImageKind              = "dev.cosignproject.cosign/image"
+	cosignSignaturesKind         = "dev.cosignproject.cosign/sigs"
+	cosignSignatureAnnotationKey = "dev.cosignproject.cosign/signature"
+	cosignCertAnnotationKey      = "dev.sigstore.cosign/certificate"
+	cosignBundleAnnotationKey    = "dev.sigstore.cosign/bundle"
+)
+
+// Bundle is a signed manifest bundle.
+// The fields are in the same format as the signature ConfigMap of k8s-manifest-sigstore;
+// Message is the base64 encoded gzip of the tar.gz of YAML manifests, and the others are base64 encoded.
+//
+// A bundle is either a directory / tarball which contains the files `message`, `signature`,
+// `certificate` (optional) and `bundle` (optional), or an OCI image layout of a manifest image
+// and its signature written by `cosign save`.
+type Bundle struct {
+	Ref         string
+	Message     string
+	Signature   string
+	Certificate string
+	Bundle      string
+	// simple signing payload which is signed instead of the message in an OCI image layout.
+	// it refers to the digest of the manifest image in Message.
+	Payload string
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+type cachedBundle struct {
+	digest string
+	bundle *Bundle
+}
+
+// loaded bundles by reference; a bundle is parsed again only if its content is changed
+var bundleCache = struct {
+	sync.Mutex
+	items map[string]cachedBundle
+}{items: map[string]cachedBundle{}}
+
+type ociIndex struct {
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+// Load loads the bundle from the path or the ConfigMap of the reference.
+func Load(ref k8smnfconfig.BundleRef) (*Bundle, error) {
+	if ref.Path != "" {
+		return LoadFromPath(ref.Path)
+	}
+	if ref.ConfigMap.Name != "" && ref.ConfigMap.Namespace != "" {
+		return LoadFromConfigMap(ref.ConfigMap.Namespace, ref.ConfigMap.Name, ref.Key)
+	}
+	return nil, errors.New("no bundle path or configmap is specified")
+}
+
+// LoadFromPath loads the bundle from a directory or a tarball.
+func LoadFromPath(path string) (*Bundle, error) {
+	ref := fmt.Sprintf("file://%s", path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to find the bundle `%s`", path))
+	}
+	if info.IsDir() {
+		files, err := readDir(path)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to read the bundle `%s`", path))
+		}
+		return loadCached(ref, filesDigest(files), func() (*Bundle, error) {
+			return loadFromFiles(files, ref)
+		})
+	}
+	if info.Size() > MaxBundleSize {
+		return nil, fmt.Errorf("the bundle `%s` exceeds the limit of %d bytes", path, MaxBundleSize)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read the bundle `%s`", path))
+	}
+	return loadTarCached(data, ref)
+}
+
+// LoadFromConfigMap loads the bundle tarball embedded in the ConfigMap.
+func LoadFromConfigMap(namespace, name, key string) (*Bundle, error) {
+	if key == "" {
+		key = DefaultConfigMapKey
+	}
+	ref := fmt.Sprintf("%sConfigMap/%s/%s", k8smanifest.InClusterObjectPrefix, namespace, name)
+	obj, err := kubeutil.GetResource("v1", "ConfigMap", namespace, name)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", name, namespace))
+	}
+	objBytes, _ := json.Marshal(obj.Object)
+	var cm v1.ConfigMap
+	_ = json.Unmarshal(objBytes, &cm)
+	return loadFromConfigMap(&cm, key, ref)
+}
+
+func loadFromConfigMap(cm *v1.ConfigMap, key, ref string) (*Bundle, error) {
+	var data []byte
+	if binData, ok := cm.BinaryData[key]; ok {
+		data = binData
+	} else if strData, ok := cm.Data[key]; ok {
+		decoded, err := base64.StdEncoding.DecodeString(strData)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to decode `%s` in the configmap `%s`", key, ref))
+		}
+		data = decoded
+	} else {
+		return nil, fmt.Errorf("`%s` is not found in the configmap `%s`", key, ref)
+	}
+	return loadTarCached(data, ref)
+}
+
+// loadTarCached returns the cached bundle if the tarball is not changed since it is loaded.
+func loadTarCached(data []byte, ref string) (*Bundle, error) {
+	return loadCached(ref, fmt.Sprintf("sha256:%x", sha256.Sum256(data)), func() (*Bundle, error) {
+		files, err := readTar(data)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to read the bundle `%s`", ref))
+		}
+		return loadFromFiles(files, ref)
+	})
+}
+
+func loadCached(ref, digest string, load func() (*Bundle, error)) (*Bundle, error) {
+	bundleCache.Lock()
+	cached, found := bundleCache.items[ref]
+	bundleCache.Unlock()
+	if found && cached.digest == digest {
+		return cached.bundle, nil
+	}
+	b, err := load()
+	if err != nil {
+		return nil, err
+	}
+	bundleCache.Lock()
+	bundleCache.items[ref] = cachedBundle{digest: digest, bundle: b}
+	bundleCache.Unlock()
+	return b, nil
+}
+
+// filesDigest returns the digest of the names and the contents of the files.
+func filesDigest(files map[string][]byte) string {
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\x00%d\x00", name, len(files[name]))
+		_, _ = h.Write(files[name])
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil))
+}
+
+func loadFromFiles(files map[string][]byte, ref string) (*Bundle, error) {
+	if _, ok := files[ociIndexFileName]; ok {
+		return loadFromOCILayout(files, ref)
+	}
+	b := &Bundle{
+		Ref:         ref,
+		Message:     strings.TrimSpace(string(files[k8smanifest.MessageAnnotationBaseName])),
+		Signature:   strings.TrimSpace(string(files[k8smanifest.SignatureAnnotationBaseName])),
+		Certificate: strings.TrimSpace(string(files[k8smanifest.CertificateAnnotationBaseName])),
+		Bundle:      strings.TrimSpace(string(files[k8smanifest.BundleAnnotationBaseName])),
+	}
+	if b.Message == "" || b.Signature == "" {
+		return nil, fmt.Errorf("`%s` and `%s` are required in the bundle `%s`", k8smanifest.MessageAnnotationBaseName, k8smanifest.SignatureAnnotationBaseName, ref)
+	}
+	return b, nil
+}
+
+// loadFromOCILayout loads the manifest image and its signature in the OCI image layout written by `cosign save`.
+// The blobs are checked against their digests, and the signed payload must refer to the manifest image.
+func loadFromOCILayout(files map[string][]byte, ref string) (*Bundle, error) {
+	var index ociIndex
+	err := json.Unmarshal(files[ociIndexFileName], &index)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to parse %s in the bundle `%s`", ociIndexFileName, ref))
+	}
+	var imageDesc, sigsDesc *ociDescriptor
+	for i := range index.Manifests {
+		switch index.Manifests[i].Annotations[ociKindAnnotationKey] {
+		case cosignImageKind:
+			imageDesc = &index.Manifests[i]
+		case cosignSignaturesKind:
+			sigsDesc = &index.Manifests[i]
+		}
+	}
+	if imageDesc == nil || sigsDesc == nil {
+		return nil, fmt.Errorf("no image and signatures saved by `cosign save` are found in the OCI layout `%s`", ref)
+	}
+
+	var image ociManifest
+	if err := readBlobJSON(files, imageDesc.Digest, &image); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read the image manifest in the bundle `%s`", ref))
+	}
+	if len(image.Layers) == 0 {
+		return nil, fmt.Errorf("the image in the bundle `%s` has no layer", ref)
+	}
+	layerBytes, err := readBlob(files, image.Layers[0].Digest)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read the layer in the bundle `%s`", ref))
+	}
+
+	var sigs ociManifest
+	if err := readBlobJSON(files, sigsDesc.Digest, &sigs); err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to read the signature manifest in the bundle `%s`", ref))
+	}
+	for _, layer := range sigs.Layers {
+		sig, ok := layer.Annotations[cosignSignatureAnnotationKey]
+		if !ok {
+			continue
+		}
+		payload, err := readBlob(files, layer.Digest)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to read the signed payload in the bundle `%s`", ref))
+		}
+		var ssp simpleSigningPayload
+		if err := json.Unmarshal(payload, &ssp); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to parse the signed payload in the bundle `%s`", ref))
+		}
+		if ssp.Critical.Image.DockerManifestDigest != imageDesc.Digest {
+			continue
+		}
+		b := &Bundle{
+			Ref:       ref,
+			Message:   base64.StdEncoding.EncodeToString(k8smnfutil.GzipCompress(layerBytes)),
+			Signature: sig,
+			Payload:   base64.StdEncoding.EncodeToString(payload),
+		}
+		if cert := layer.Annotations[cosignCertAnnotationKey]; cert != "" {
+			b.Certificate = base64.StdEncoding.EncodeToString([]byte(cert))
+		}
+		if rekorBundle := layer.Annotations[cosignBundleAnnotationKey]; rekorBundle != "" {
+			b.Bundle = base64.StdEncoding.EncodeToString([]byte(rekorBundle))
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("no signature of the image `%s` is found in the OCI layout `%s`", imageDesc.Digest, ref)
+}
+
+// readBlob returns the blob in the OCI image layout after checking its digest.
+func readBlob(files map[string][]byte, digest string) ([]byte, error) {
+	data, ok := files[blobPath(digest)]
+	if !ok {
+		return nil, fmt.Errorf("blob `%s` is not found", digest)
+	}
+	if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); actual != digest {
+		return nil, fmt.Errorf("digest of the blob `%s` does not match: %s", digest, actual)
+	}
+	return data, nil
+}
+
+func readBlobJSON(files map[string][]byte, digest string, v interface{}) error {
+	data, err := readBlob(files, digest)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func blobPath(digest string) string {
+	return filepath.Join(ociBlobsDirName, strings.Replace(digest, ":", "/", 1))
+}
+
+func readDir(dir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	var total int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		total += info.Size()
+		if total > MaxBundleSize {
+			return fmt.Errorf("the bundle exceeds the limit of %d bytes", MaxBundleSize)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	return files, err
+}
+
+// readTar reads the files in a tarball, which may be gzipped.
+// It fails if the decompressed files exceed MaxBundleSize.
+func readTar(data []byte) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	var decompressed io.Reader = bytes.NewReader(data)
+	if gr, err := gzip.NewReader(bytes.NewReader(data)); err == nil {
+		defer gr.Close()
+		decompressed = gr
+	}
+	limited := &io.LimitedReader{R: decompressed, N: MaxBundleSize + 1}
+	reader := tar.NewReader(limited)
+	for {
+		header, err := reader.Next()
+		if limited.N <= 0 {
+			return nil, fmt.Errorf("the bundle exceeds the limit of %d bytes", MaxBundleSize)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := ioutil.ReadAll(reader)
+		if limited.N <= 0 {
+			return nil, fmt.Errorf("the bundle exceeds the limit of %d bytes", MaxBundleSize)
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[filepath.ToSlash(filepath.Clean(header.Name))] = content
+	}
+	return files, nil
+}
+
+// Embed returns a copy of the resource which has the signature of the bundle in its annotations,
+// so that it is verified in the same way as resources with embedded signatures.
+func (b *Bundle) Embed(resource unstructured.Unstructured, annotationConfig k8smanifest.AnnotationConfig) unstructured.Unstructured {
+	obj := resource.DeepCopy()
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, annotationConfig.ImageRefAnnotationKey())
+	annotations[annotationConfig.MessageAnnotationKey()] = b.Message
+	annotations[annotationConfig.SignatureAnnotationKey()] = b.Signature
+	if b.Certificate != "" {
+		annotations[annotationConfig.CertificateAnnotationKey()] = b.Certificate
+	}
+	if b.Bundle != "" {
+		annotations[annotationConfig.BundleAnnotationKey()] = b.Bundle
+	}
+	obj.SetAnnotations(annotations)
+	return *obj
+}
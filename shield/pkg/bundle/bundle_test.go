@@ -0,0 +1,344 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	testMessage   = "dGVzdC1tZXNzYWdl"
+	testSignature = "dGVzdC1zaWduYXR1cmU="
+)
+
+func writeTarGz(files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for name, data := range files {
+		_ = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		_, _ = tw.Write(data)
+	}
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+// makeOCILayout returns the files of the OCI image layout which `cosign save` writes for the manifest image.
+func makeOCILayout(layer []byte, signature string, sign func(payload []byte) string) map[string][]byte {
+	layerDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(layer))
+	image, _ := json.Marshal(ociManifest{Layers: []ociDescriptor{{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: layerDigest}}})
+	imageDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(image))
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"sample-registry/sample-manifest"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, imageDigest))
+	payloadDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(payload))
+	if sign != nil {
+		signature = sign(payload)
+	}
+	sigs, _ := json.Marshal(ociManifest{Layers: []ociDescriptor{{
+		MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+		Digest:      payloadDigest,
+		Annotations: map[string]string{cosignSignatureAnnotationKey: signature},
+	}}})
+	sigsDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(sigs))
+	index, _ := json.Marshal(ociIndex{Manifests: []ociDescriptor{
+		{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: imageDigest, Annotations: map[string]string{ociKindAnnotationKey: cosignImageKind}},
+		{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: sigsDigest, Annotations: map[string]string{ociKindAnnotationKey: cosignSignaturesKind}},
+	}})
+	return map[string][]byte{
+		ociIndexFileName:        index,
+		"oci-layout":            []byte(`{"imageLayoutVersion":"1.0.0"}`),
+		blobPath(imageDigest):   image,
+		blobPath(layerDigest):   layer,
+		blobPath(sigsDigest):    sigs,
+		blobPath(payloadDigest): payload,
+	}
+}
+
+func TestLoadFromPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{
+		k8smanifest.MessageAnnotationBaseName:   []byte(testMessage + "\n"),
+		k8smanifest.SignatureAnnotationBaseName: []byte(testSignature),
+	}
+	bundleDir := filepath.Join(dir, "bundle")
+	_ = os.MkdirAll(bundleDir, os.ModePerm)
+	for name, data := range files {
+		_ = ioutil.WriteFile(filepath.Join(bundleDir, name), data, 0644)
+	}
+	tarPath := filepath.Join(dir, "bundle.tar.gz")
+	_ = ioutil.WriteFile(tarPath, writeTarGz(files), 0644)
+
+	for _, path := range []string{bundleDir, tarPath} {
+		b, err := LoadFromPath(path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if b.Message != testMessage || b.Signature != testSignature || b.Ref != "file://"+path {
+			t.Errorf("unexpected bundle: got: %v", b)
+			return
+		}
+	}
+
+	_, err = LoadFromPath(filepath.Join(dir, "not-found"))
+	if err == nil {
+		t.Errorf("missing bundle should be an error")
+		return
+	}
+}
+
+func TestLoadFromConfigMapOCILayout(t *testing.T) {
+	layer := []byte("sample-layer")
+	cm := &v1.ConfigMap{BinaryData: map[string][]byte{DefaultConfigMapKey: writeTarGz(makeOCILayout(layer, testSignature, nil))}}
+	b, err := loadFromConfigMap(cm, DefaultConfigMapKey, "k8s://ConfigMap/sample-ns/sample-bundle")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	msg, _ := base64.StdEncoding.DecodeString(b.Message)
+	payload, _ := base64.StdEncoding.DecodeString(b.Payload)
+	if string(k8smnfutil.GzipDecompress(msg)) != string(layer) || b.Signature != testSignature || !bytes.Contains(payload, []byte("docker-manifest-digest")) {
+		t.Errorf("unexpected bundle: got: %v", b)
+		return
+	}
+
+	_, err = loadFromConfigMap(cm, "other-key", "k8s://ConfigMap/sample-ns/sample-bundle")
+	if err == nil {
+		t.Errorf("missing key should be an error")
+		return
+	}
+}
+
+func TestLoadFromOCILayoutInvalid(t *testing.T) {
+	testcases := []struct {
+		name   string
+		modify func(files map[string][]byte)
+	}{
+		{
+			name: "tampered layer",
+			modify: func(files map[string][]byte) {
+				for name, data := range files {
+					if string(data) == "sample-layer" {
+						files[name] = []byte("tampered-layer")
+					}
+				}
+			},
+		},
+		{
+			name: "signature of other image",
+			modify: func(files map[string][]byte) {
+				other := makeOCILayout([]byte("other-layer"), testSignature, nil)
+				var index ociIndex
+				_ = json.Unmarshal(other[ociIndexFileName], &index)
+				for _, desc := range index.Manifests {
+					if desc.Annotations[ociKindAnnotationKey] == cosignSignaturesKind {
+						files[blobPath(desc.Digest)] = other[blobPath(desc.Digest)]
+						var sigs ociManifest
+						_ = json.Unmarshal(other[blobPath(desc.Digest)], &sigs)
+						files[blobPath(sigs.Layers[0].Digest)] = other[blobPath(sigs.Layers[0].Digest)]
+					}
+				}
+				var own ociIndex
+				_ = json.Unmarshal(files[ociIndexFileName], &own)
+				for i, desc := range own.Manifests {
+					if desc.Annotations[ociKindAnnotationKey] == cosignSignaturesKind {
+						for _, otherDesc := range index.Manifests {
+							if otherDesc.Annotations[ociKindAnnotationKey] == cosignSignaturesKind {
+								own.Manifests[i] = otherDesc
+							}
+						}
+					}
+				}
+				files[ociIndexFileName], _ = json.Marshal(own)
+			},
+		},
+		{
+			name: "no signatures",
+			modify: func(files map[string][]byte) {
+				var index ociIndex
+				_ = json.Unmarshal(files[ociIndexFileName], &index)
+				index.Manifests = index.Manifests[:1]
+				files[ociIndexFileName], _ = json.Marshal(index)
+			},
+		},
+	}
+	for _, tc := range testcases {
+		files := makeOCILayout([]byte("sample-layer"), testSignature, nil)
+		tc.modify(files)
+		_, err := loadFromOCILayout(files, "file://sample-bundle")
+		if err == nil {
+			t.Errorf("%s: loading the layout should fail", tc.name)
+			return
+		}
+	}
+}
+
+func TestReadTarLimit(t *testing.T) {
+	data := writeTarGz(map[string][]byte{"large": make([]byte, MaxBundleSize+1)})
+	_, err := readTar(data)
+	if err == nil {
+		t.Errorf("bundle larger than %d bytes should be an error", MaxBundleSize)
+		return
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-cache-test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	tarPath := filepath.Join(dir, "bundle.tar.gz")
+	files := map[string][]byte{
+		k8smanifest.MessageAnnotationBaseName:   []byte(testMessage),
+		k8smanifest.SignatureAnnotationBaseName: []byte(testSignature),
+	}
+	_ = ioutil.WriteFile(tarPath, writeTarGz(files), 0644)
+	b1, err := LoadFromPath(tarPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	b2, _ := LoadFromPath(tarPath)
+	if b1 != b2 {
+		t.Errorf("unchanged bundle should be loaded from the cache")
+		return
+	}
+	files[k8smanifest.SignatureAnnotationBaseName] = []byte("dXBkYXRlZA==")
+	_ = ioutil.WriteFile(tarPath, writeTarGz(files), 0644)
+	b3, err := LoadFromPath(tarPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if b3 == b1 || b3.Signature != "dXBkYXRlZA==" {
+		t.Errorf("updated bundle should be loaded again: got: %v", b3)
+		return
+	}
+}
+
+func TestVerifyOCILayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-verify-test")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	pubKeyBytes, _ := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	keyPath := filepath.Join(dir, "cosign.pub")
+	_ = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKeyBytes}), 0644)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKeyBytes, _ := x509.MarshalPKIXPublicKey(&otherKey.PublicKey)
+	otherKeyPath := filepath.Join(dir, "other.pub")
+	_ = ioutil.WriteFile(otherKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: otherKeyBytes}), 0644)
+
+	resource := unstructured.Unstructured{}
+	resource.SetAPIVersion("v1")
+	resource.SetKind("ConfigMap")
+	resource.SetName("sample-cm")
+	_ = unstructured.SetNestedStringMap(resource.Object, map[string]string{"key": "value"}, "data")
+	manifest, _ := yaml.Marshal(resource.Object)
+	layer := writeTarGz(map[string][]byte{"configmap.yaml": manifest})
+	sign := func(payload []byte) string {
+		digest := sha256.Sum256(payload)
+		sig, _ := ecdsa.SignASN1(rand.Reader, privKey, digest[:])
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+	b, err := loadFromOCILayout(makeOCILayout(layer, "", sign), "file://sample-bundle")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	vo := &k8smanifest.VerifyResourceOption{}
+	vo.KeyPath = keyPath
+	result, err := b.Verify(resource, vo)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !result.Verified || result.SigRef != "file://sample-bundle" {
+		t.Errorf("resource in the bundle should be verified: got: %v", result)
+		return
+	}
+
+	vo = &k8smanifest.VerifyResourceOption{}
+	vo.KeyPath = otherKeyPath
+	result, err = b.Verify(resource, vo)
+	if err == nil && result.Verified {
+		t.Errorf("resource signed by other key should not be verified")
+		return
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	annotationConfig := k8smanifest.AnnotationConfig{}
+	obj := unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetName("sample-cm")
+	obj.SetAnnotations(map[string]string{annotationConfig.ImageRefAnnotationKey(): "sample-registry/sample-image:0.1.0"})
+
+	b := &Bundle{Message: testMessage, Signature: testSignature}
+	embedded := b.Embed(obj, annotationConfig)
+	annotations := embedded.GetAnnotations()
+	if _, found := annotations[annotationConfig.ImageRefAnnotationKey()]; found {
+		t.Errorf("imageRef annotation should be removed: got: %v", annotations)
+		return
+	}
+	if annotations[annotationConfig.MessageAnnotationKey()] != testMessage || annotations[annotationConfig.SignatureAnnotationKey()] != testSignature {
+		t.Errorf("unexpected annotations: got: %v", annotations)
+		return
+	}
+	// the original resource is not changed
+	if len(obj.GetAnnotations()) != 1 {
+		t.Errorf("original annotations are changed: got: %v", obj.GetAnnotations())
+		return
+	}
+}
@@ -0,0 +1,228 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/cmd/cosign/cli"
+	k8smnfcosign "github.com/sigstore/k8s-manifest-sigstore/pkg/cosign"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const defaultDryRunNamespace = "default"
+
+var verifyBlobMutex sync.Mutex
+
+// Verify verifies the resource with the signed manifest in the bundle.
+// A bundle with the signature of the message is verified as if the signature is embedded in the resource.
+// A bundle saved by `cosign save` has the signature of the payload which refers to the manifest image,
+// so the payload signature is verified, and the resource is matched with the manifest in the image.
+func (b *Bundle) Verify(resource unstructured.Unstructured, vo *k8smanifest.VerifyResourceOption) (*k8smanifest.VerifyResourceResult, error) {
+	target := b.Embed(resource, vo.AnnotationConfig)
+	if b.Payload == "" {
+		return k8smanifest.VerifyResource(target, vo)
+	}
+	if len(vo.SkipObjects) > 0 && vo.SkipObjects.Match(resource) {
+		return &k8smanifest.VerifyResourceResult{InScope: false}, nil
+	}
+	vo.SetAnnotationIgnoreFields()
+	ignoreFields := []string{}
+	if ok, fields := vo.IgnoreFields.Match(resource); ok {
+		ignoreFields = fields
+	}
+	targetBytes, _ := yaml.Marshal(target.Object)
+	manifests, _, err := k8smanifest.NewManifestFetcher("", "", vo.AnnotationConfig, ignoreFields, vo.MaxResourceManifestNum).Fetch(targetBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "YAML manifest not found for this resource")
+	}
+	matched := false
+	var diff *mapnode.DiffResult
+	for _, manifest := range manifests {
+		cndMatched, cndDiff, err := matchManifest(resource, manifest, ignoreFields, vo.DryRunNamespace)
+		if err != nil {
+			return nil, errors.Wrap(err, "error occurred during matching manifest")
+		}
+		if cndMatched {
+			matched = true
+			diff = nil
+			break
+		}
+		if diff == nil {
+			diff = cndDiff
+		}
+	}
+	sigVerified, signer, signedTimestamp, err := b.verifyPayload(vo.KeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to verify signature")
+	}
+	var signedTime *time.Time
+	if signedTimestamp != nil {
+		t := time.Unix(*signedTimestamp, 0)
+		signedTime = &t
+	}
+	containerImages, err := kubeutil.GetAllImagesFromObject(&resource)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get container images")
+	}
+	return &k8smanifest.VerifyResourceResult{
+		Verified:        matched && sigVerified && vo.Signers.Match(signer),
+		InScope:         true,
+		Signer:          signer,
+		SignedTime:      signedTime,
+		SigRef:          b.Ref,
+		Diff:            diff,
+		ContainerImages: containerImages,
+	}, nil
+}
+
+// verifyPayload verifies the signature of the payload with one of the cosign public keys, or keyless if no key is given.
+func (b *Bundle) verifyPayload(keyPathString string) (bool, string, *int64, error) {
+	var certBytes, bundleBytes []byte
+	if b.Certificate != "" {
+		certBytes = []byte(b.Certificate)
+	}
+	if b.Bundle != "" {
+		bundleBytes = []byte(b.Bundle)
+	}
+	keyPaths := k8smnfutil.SplitCommaSeparatedString(keyPathString)
+	if len(keyPaths) == 0 {
+		return verifyBlob([]byte(b.Payload), []byte(b.Signature), certBytes, bundleBytes, nil)
+	}
+	var lastErr error
+	for i := range keyPaths {
+		var verified bool
+		var signer string
+		var signedTimestamp *int64
+		var err error
+		if bundleBytes == nil && !cli.EnableExperimental() {
+			verified, err = verifyBlobWithKey(b.Payload, b.Signature, keyPaths[i])
+		} else {
+			verified, signer, signedTimestamp, err = verifyBlob([]byte(b.Payload), []byte(b.Signature), nil, bundleBytes, &keyPaths[i])
+		}
+		if err != nil {
+			log.Debugf("failed to verify the payload with the key `%s`; %s", keyPaths[i], err.Error())
+			lastErr = err
+			continue
+		}
+		if verified {
+			return verified, signer, signedTimestamp, nil
+		}
+	}
+	return false, "", nil, lastErr
+}
+
+// verifyBlob verifies the payload with k8s-manifest-sigstore, which replaces os.Stdout and os.Stderr
+// while cosign runs to capture its logs. Bundles are verified concurrently in the admission controller,
+// so the calls are serialized not to lose the original outputs.
+func verifyBlob(payload, sig, certBytes, bundleBytes []byte, keyPath *string) (bool, string, *int64, error) {
+	verifyBlobMutex.Lock()
+	defer verifyBlobMutex.Unlock()
+	return k8smnfcosign.VerifyBlob(payload, sig, certBytes, bundleBytes, keyPath)
+}
+
+// verifyBlobWithKey verifies the signature of the payload with the public key in the same way as `cosign verify-blob`
+// without a transparency log, so that os.Stdout and os.Stderr are not replaced.
+func verifyBlobWithKey(b64Payload, b64Sig, keyPath string) (bool, error) {
+	verifier, err := cli.LoadPublicKey(context.Background(), keyPath)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to load the public key")
+	}
+	gzipPayload, err := base64.StdEncoding.DecodeString(b64Payload)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to decode the payload")
+	}
+	sig, err := base64.StdEncoding.DecodeString(b64Sig)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to decode the signature")
+	}
+	payload := k8smnfutil.GzipDecompress(gzipPayload)
+	err = verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// matchManifest compares the resource with the manifest directly, and then with the result of dry-run create of the manifest
+// in the same way as k8smanifest.VerifyResource.
+func matchManifest(resource unstructured.Unstructured, manifest []byte, ignoreFields []string, dryRunNamespace string) (bool, *mapnode.DiffResult, error) {
+	objBytes, _ := json.Marshal(resource.Object)
+	objNode, err := mapnode.NewFromBytes(objBytes)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to initialize object node")
+	}
+	mnfNode, err := mapnode.NewFromYamlBytes(manifest)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to initialize manifest node")
+	}
+	diff := filterDiff(objNode.Diff(mnfNode), ignoreFields)
+	if diff == nil {
+		return true, nil, nil
+	}
+
+	clusterScope := resource.GetNamespace() == ""
+	namespace := ""
+	if !clusterScope {
+		namespace = dryRunNamespace
+		if namespace == "" {
+			namespace = defaultDryRunNamespace
+		}
+	}
+	nsMaskedManifest := mnfNode.Mask([]string{"metadata.namespace"}).ToYaml()
+	simBytes, err := kubeutil.DryRunCreate([]byte(nsMaskedManifest), namespace)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to dryrun with the found YAML in the bundle")
+	}
+	simNode, err := mapnode.NewFromYamlBytes(simBytes)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "failed to initialize dry-run-generated object node")
+	}
+	// name and namespace are overwritten for dryrun
+	mask := []string{"metadata.name"}
+	if !clusterScope {
+		mask = append(mask, "metadata.namespace")
+	}
+	if resource.GetKind() == "CustomResourceDefinition" {
+		mask = append(mask, "spec.names.kind", "spec.names.listKind", "spec.names.singular", "spec.names.plural")
+	}
+	diff = filterDiff(objNode.Mask(mask).Diff(simNode.Mask(mask)), ignoreFields)
+	return diff == nil, diff, nil
+}
+
+// filterDiff returns nil if there is no difference except the ignore fields.
+func filterDiff(diff *mapnode.DiffResult, ignoreFields []string) *mapnode.DiffResult {
+	if diff != nil && len(ignoreFields) > 0 {
+		_, diff, _ = diff.Filter(ignoreFields)
+	}
+	if diff == nil || diff.Size() == 0 {
+		return nil
+	}
+	return diff
+}
@@ -0,0 +1,165 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeclient "k8s.io/client-go/kubernetes"
+)
+
+const defaultNamespaceDefaultsConfigMapName = "integrity-shield-defaults"
+const defaultNamespaceDefaultsKey = "defaults.yaml"
+
+// NamespaceDefaultsConfig enables the defaults which tenants put in their namespaces.
+type NamespaceDefaultsConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// name of the ConfigMap in each namespace. If empty, "integrity-shield-defaults" is used.
+	ConfigMapName string `json:"configMapName,omitempty"`
+	// key of the defaults in the ConfigMap. If empty, "defaults.yaml" is used.
+	Key string `json:"key,omitempty"`
+	// keys which sign the ConfigMaps. Tenants can edit the ConfigMap in their namespace,
+	// so the defaults are used only if the ConfigMap is signed with one of these keys.
+	KeyConfigs []KeyConfig `json:"keyConfigs,omitempty"`
+}
+
+// NamespaceDefaults is the signing setup of the namespace, which is used for resources in the namespace
+// if the constraint does not specify it. Ignore fields are added to the ones of the constraint.
+// The key secrets must be in the namespace itself or in the namespace of integrity shield.
+type NamespaceDefaults struct {
+	SignatureRef SignatureRef                       `json:"signatureRef,omitempty"`
+	KeyConfigs   []KeyConfig                        `json:"keyConfigs,omitempty"`
+	IgnoreFields k8smanifest.ObjectFieldBindingList `json:"ignoreFields,omitempty"`
+}
+
+// LoadNamespaceDefaults loads the defaults of the namespace. It returns nil if the namespace has no defaults.
+// verify checks the signature of the ConfigMap with the keys in the config; the defaults are not used if it is not signed.
+func LoadNamespaceDefaults(namespace string, c NamespaceDefaultsConfig, verify func(cm unstructured.Unstructured) (bool, error)) (*NamespaceDefaults, error) {
+	if !c.Enabled || namespace == "" {
+		return nil, nil
+	}
+	if len(c.KeyConfigs) == 0 {
+		return nil, errors.New("no key is configured to verify the namespace defaults")
+	}
+	name := c.ConfigMapName
+	if name == "" {
+		name = defaultNamespaceDefaultsConfigMapName
+	}
+	config, err := kubeutil.GetKubeConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get k8s config")
+	}
+	clientset, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a clientset")
+	}
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", name, namespace))
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(cm)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to convert configmap `%s` into unstructured", name))
+	}
+	cmObj := unstructured.Unstructured{Object: obj}
+	cmObj.SetAPIVersion("v1")
+	cmObj.SetKind("ConfigMap")
+	verified, err := verify(cmObj)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to verify configmap `%s` in `%s` namespace", name, namespace))
+	}
+	if !verified {
+		return nil, errors.New(fmt.Sprintf("configmap `%s` in `%s` namespace is not signed by a valid key", name, namespace))
+	}
+	return parseNamespaceDefaults(cm, c.Key)
+}
+
+func parseNamespaceDefaults(cm *v1.ConfigMap, key string) (*NamespaceDefaults, error) {
+	if key == "" {
+		key = defaultNamespaceDefaultsKey
+	}
+	data, found := cm.Data[key]
+	if !found {
+		return nil, errors.New(fmt.Sprintf("`%s` is not found in configmap `%s`", key, cm.GetName()))
+	}
+	var defaults *NamespaceDefaults
+	err := yaml.Unmarshal([]byte(data), &defaults)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to unmarshal %s into %T", key, defaults))
+	}
+	if defaults == nil {
+		return nil, nil
+	}
+	// a tenant must not refer to the keys of other tenants
+	shieldNamespace := os.Getenv("POD_NAMESPACE")
+	if shieldNamespace == "" {
+		shieldNamespace = defaultPodNamespace
+	}
+	for i, keyConfig := range defaults.KeyConfigs {
+		if keyConfig.KeySecretNamespace == "" {
+			defaults.KeyConfigs[i].KeySecretNamespace = cm.GetNamespace()
+			continue
+		}
+		if keyConfig.KeySecretNamespace != cm.GetNamespace() && keyConfig.KeySecretNamespace != shieldNamespace {
+			return nil, errors.New(fmt.Sprintf("key secret `%s` must be in `%s` or `%s` namespace, but in `%s`", keyConfig.KeySecretName, cm.GetNamespace(), shieldNamespace, keyConfig.KeySecretNamespace))
+		}
+	}
+	return defaults, nil
+}
+
+// hasSignatureSource returns true if any of image, signature resource or bundle is specified.
+func (r SignatureRef) hasSignatureSource() bool {
+	return r.ImageRef != "" || r.SignatureResourceRef.Name != "" || r.BundleRef.Enabled()
+}
+
+// WithNamespaceDefaults returns a copy of the parameters merged with the namespace defaults.
+// The defaults fill in the signature ref and keys which the constraint does not specify, and add the ignore fields.
+func (p *ParameterObject) WithNamespaceDefaults(d *NamespaceDefaults) *ParameterObject {
+	merged := *p
+	if d == nil {
+		return &merged
+	}
+	if !p.SignatureRef.hasSignatureSource() {
+		merged.SignatureRef.ImageRef = d.SignatureRef.ImageRef
+		merged.SignatureRef.SignatureResourceRef = d.SignatureRef.SignatureResourceRef
+		merged.SignatureRef.BundleRef = d.SignatureRef.BundleRef
+	}
+	if p.SignatureRef.ProvenanceResourceRef.Name == "" {
+		merged.SignatureRef.ProvenanceResourceRef = d.SignatureRef.ProvenanceResourceRef
+	}
+	if len(p.KeyConfigs) == 0 {
+		merged.KeyConfigs = d.KeyConfigs
+	}
+	if len(d.IgnoreFields) > 0 {
+		merged.IgnoreFields = append(append(k8smanifest.ObjectFieldBindingList{}, p.IgnoreFields...), d.IgnoreFields...)
+	}
+	return &merged
+}
@@ -0,0 +1,142 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testNamespaceDefaults = `
+signatureRef:
+  signatureResourceRef:
+    name: team-a-signatures
+    namespace: team-a
+keyConfigs:
+- keySecretName: team-a-pubkey
+  keySecretNamespace: integrity-shield-operator-system
+ignoreFields:
+- objects:
+  - kind: ConfigMap
+  fields:
+  - data.timestamp
+`
+
+func TestParseNamespaceDefaults(t *testing.T) {
+	cm := &v1.ConfigMap{Data: map[string]string{defaultNamespaceDefaultsKey: testNamespaceDefaults}}
+	cm.SetNamespace("team-a")
+	defaults, err := parseNamespaceDefaults(cm, "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if defaults.SignatureRef.SignatureResourceRef.Name != "team-a-signatures" || len(defaults.KeyConfigs) != 1 || len(defaults.IgnoreFields) != 1 {
+		t.Errorf("unexpected defaults: got: %v", defaults)
+		return
+	}
+	_, err = parseNamespaceDefaults(cm, "other.yaml")
+	if err == nil {
+		t.Errorf("missing key should be an error")
+		return
+	}
+}
+
+func TestNamespaceDefaultsKeySecretNamespace(t *testing.T) {
+	testcases := []struct {
+		name               string
+		keySecretNamespace string
+		expected           string
+		valid              bool
+	}{
+		{name: "shield namespace", keySecretNamespace: defaultPodNamespace, expected: defaultPodNamespace, valid: true},
+		{name: "own namespace", keySecretNamespace: "team-a", expected: "team-a", valid: true},
+		{name: "empty means own namespace", keySecretNamespace: "", expected: "team-a", valid: true},
+		{name: "other tenant", keySecretNamespace: "team-b", valid: false},
+	}
+	for _, tc := range testcases {
+		data := fmt.Sprintf("keyConfigs:\n- keySecretName: team-a-pubkey\n  keySecretNamespace: %q\n", tc.keySecretNamespace)
+		cm := &v1.ConfigMap{Data: map[string]string{defaultNamespaceDefaultsKey: data}}
+		cm.SetNamespace("team-a")
+		defaults, err := parseNamespaceDefaults(cm, "")
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: unexpected result: got: %v\nwant valid: %v", tc.name, err, tc.valid)
+			return
+		}
+		if tc.valid && defaults.KeyConfigs[0].KeySecretNamespace != tc.expected {
+			t.Errorf("%s: unexpected key secret namespace: got: %s\nwant: %s", tc.name, defaults.KeyConfigs[0].KeySecretNamespace, tc.expected)
+			return
+		}
+	}
+}
+
+func TestLoadNamespaceDefaultsWithoutKeys(t *testing.T) {
+	verified := func(unstructured.Unstructured) (bool, error) { return true, nil }
+	_, err := LoadNamespaceDefaults("team-a", NamespaceDefaultsConfig{Enabled: true}, verified)
+	if err == nil {
+		t.Errorf("namespace defaults must not be loaded without keys to verify them")
+		return
+	}
+	defaults, err := LoadNamespaceDefaults("team-a", NamespaceDefaultsConfig{}, verified)
+	if defaults != nil || err != nil {
+		t.Errorf("disabled namespace defaults must not be loaded: got: %v, %v", defaults, err)
+		return
+	}
+}
+
+func TestWithNamespaceDefaults(t *testing.T) {
+	cm := &v1.ConfigMap{Data: map[string]string{defaultNamespaceDefaultsKey: testNamespaceDefaults}}
+	cm.SetNamespace("team-a")
+	defaults, _ := parseNamespaceDefaults(cm, "")
+
+	// the constraint without signing setup uses the defaults
+	param := &ParameterObject{}
+	param.IgnoreFields = k8smanifest.ObjectFieldBindingList{{Fields: []string{"metadata.labels.version"}, Objects: k8smanifest.ObjectReferenceList{{Kind: "*"}}}}
+	merged := param.WithNamespaceDefaults(defaults)
+	if merged.SignatureRef.SignatureResourceRef.Name != "team-a-signatures" || len(merged.KeyConfigs) != 1 {
+		t.Errorf("defaults should be used: got: %v", merged)
+		return
+	}
+	if len(merged.IgnoreFields) != 2 || merged.IgnoreFields[0].Fields[0] != "metadata.labels.version" || merged.IgnoreFields[1].Fields[0] != "data.timestamp" {
+		t.Errorf("ignore fields in the defaults should be added: got: %v", merged.IgnoreFields)
+		return
+	}
+	if len(param.IgnoreFields) != 1 {
+		t.Errorf("ignore fields of the constraint should not be changed: got: %v", param.IgnoreFields)
+		return
+	}
+
+	// signing setup of the constraint takes precedence
+	param = &ParameterObject{
+		SignatureRef: SignatureRef{ImageRef: "sample-registry/sample-manifest:0.1.0"},
+		KeyConfigs:   []KeyConfig{{KeySecretName: "constraint-pubkey", KeySecretNamespace: "integrity-shield-operator-system"}},
+	}
+	merged = param.WithNamespaceDefaults(defaults)
+	if merged.SignatureRef.SignatureResourceRef.Name != "" || merged.KeyConfigs[0].KeySecretName != "constraint-pubkey" {
+		t.Errorf("constraint should take precedence: got: %v", merged)
+		return
+	}
+
+	merged = param.WithNamespaceDefaults(nil)
+	if merged.SignatureRef.ImageRef != param.SignatureRef.ImageRef {
+		t.Errorf("parameters should not be changed without defaults: got: %v", merged)
+		return
+	}
+}
@@ -31,8 +31,10 @@ type ParameterObject struct {
 	SkipUsers                        ObjectUserBindingList           `json:"skipUsers,omitempty"`
 	InScopeUsers                     ObjectUserBindingList           `json:"inScopeUsers,omitempty"`
 	ImageProfile                     ImageProfile                    `json:"imageProfile,omitempty"`
+	ProvenancePolicy                 ProvenancePolicy                `json:"provenancePolicy,omitempty"`
 	k8smanifest.VerifyResourceOption `json:""`
-	Action                           *Action `json:"action,omitempty"`
+	Action                           *Action            `json:"action,omitempty"`
+	Remediation                      *RemediationConfig `json:"remediation,omitempty"`
 }
 
 type Action struct {
@@ -44,10 +46,40 @@ type Action struct {
 	} `json:"admissionControl,omitempty"`
 }
 
+// RemediationConfig lets the observer restore resources which fail verification
+type RemediationConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// report the resources to be restored without applying
+	DryRun bool `json:"dryRun,omitempty"`
+	// restore the last verified state if no signed manifest is available.
+	// the states are kept in the memory of the observer, and lost when it restarts.
+	UseSnapshot bool `json:"useSnapshot,omitempty"`
+}
+
+func (c *RemediationConfig) IsEnabled() bool {
+	return c != nil && c.Enabled
+}
+
 type SignatureRef struct {
 	ImageRef              string      `json:"imageRef,omitempty"`
 	SignatureResourceRef  ResourceRef `json:"signatureResourceRef,omitempty"`
 	ProvenanceResourceRef ResourceRef `json:"provenanceResourceRef,omitempty"`
+	BundleRef             BundleRef   `json:"bundleRef,omitempty"`
+}
+
+// BundleRef refers to a signed manifest bundle which is available without a registry,
+// so that air-gapped clusters can pre-load signed manifests.
+type BundleRef struct {
+	// path to a directory or a tarball mounted into the pod
+	Path string `json:"path,omitempty"`
+	// ConfigMap which embeds the bundle tarball
+	ConfigMap ResourceRef `json:"configMap,omitempty"`
+	// key of the bundle tarball in the ConfigMap. If empty, "bundle.tar.gz" is used.
+	Key string `json:"key,omitempty"`
+}
+
+func (r BundleRef) Enabled() bool {
+	return r.Path != "" || (r.ConfigMap.Name != "" && r.ConfigMap.Namespace != "")
 }
 
 type ResourceRef struct {
@@ -55,6 +87,21 @@ type ResourceRef struct {
 	Namespace string `json:"namespace,omitempty"`
 }
 
+// ProvenancePolicy is the condition which in-toto provenance of the resource must satisfy.
+// Each list is a list of patterns; an empty list allows anything.
+type ProvenancePolicy struct {
+	AllowedBuilders    []string `json:"allowedBuilders,omitempty"`
+	AllowedSourceRepos []string `json:"allowedSourceRepos,omitempty"`
+	AllowedBranches    []string `json:"allowedBranches,omitempty"`
+	// every pattern must match the URI of at least one material
+	RequiredMaterials []string `json:"requiredMaterials,omitempty"`
+}
+
+// if any condition is defined, provenance policy returns enabled = true
+func (p ProvenancePolicy) Enabled() bool {
+	return len(p.AllowedBuilders) > 0 || len(p.AllowedSourceRepos) > 0 || len(p.AllowedBranches) > 0 || len(p.RequiredMaterials) > 0
+}
+
 type KeyConfig struct {
 	KeySecretName      string `json:"keySecretName,omitempty"`
 	KeySecretNamespace string `json:"keySecretNamespace,omitempty"`
@@ -60,6 +60,7 @@ type RequestHandlerConfig struct {
 	Log                     LogConfig               `json:"log,omitempty"`
 	SideEffectConfig        SideEffectConfig        `json:"sideEffect,omitempty"`
 	DefaultConstraintAction Action                  `json:"defaultConstraintAction,omitempty"`
+	NamespaceDefaults       NamespaceDefaultsConfig `json:"namespaceDefaults,omitempty"`
 	Options                 []string
 }
 
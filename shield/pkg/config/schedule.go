@@ -0,0 +1,56 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// ParseSchedule parses a schedule of observer scans in one of the following formats.
+// It is shared by the observer and the operator, so that both accept the same syntax.
+//   - number of minutes (e.g. "5"), for compatibility with INTERVAL
+//   - duration (e.g. "90s", "1h30m")
+//   - cron expression (e.g. "*/10 * * * *", "@daily", "@every 2h")
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, errors.New("schedule is empty")
+	}
+	if minutes, err := strconv.Atoi(spec); err == nil {
+		if minutes <= 0 {
+			return nil, errors.New(fmt.Sprintf("interval must be a positive number of minutes: %s", spec))
+		}
+		return cron.Every(time.Duration(minutes) * time.Minute), nil
+	}
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d < time.Second {
+			return nil, errors.New(fmt.Sprintf("interval must be 1s or longer: %s", spec))
+		}
+		return cron.Every(d), nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("`%s` is neither minutes, a duration nor a cron expression", spec))
+	}
+	return schedule, nil
+}
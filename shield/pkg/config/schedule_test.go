@@ -0,0 +1,54 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	testcases := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{spec: "5", expected: base.Add(5 * time.Minute)},
+		{spec: "90s", expected: base.Add(90 * time.Second)},
+		{spec: "*/10 * * * *", expected: base.Add(10 * time.Minute)},
+		{spec: "@daily", expected: base.Add(24 * time.Hour)},
+		{spec: "@every 2h", expected: base.Add(2 * time.Hour)},
+	}
+	for _, tc := range testcases {
+		schedule, err := ParseSchedule(tc.spec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		next := schedule.Next(base)
+		if !next.Equal(tc.expected) {
+			t.Errorf("unexpected next time for `%s`: got: %v\nwant: %v", tc.spec, next, tc.expected)
+			return
+		}
+	}
+	for _, spec := range []string{"", "0", "-1", "0s", "every minute", "* * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("`%s` should be invalid", spec)
+			return
+		}
+	}
+}
@@ -0,0 +1,212 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/cmd/cosign/cli/fulcio"
+	"github.com/sigstore/cosign/pkg/cosign"
+	cremote "github.com/sigstore/cosign/pkg/cosign/remote"
+	k8scosign "github.com/sigstore/k8s-manifest-sigstore/pkg/cosign"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	"github.com/sigstore/rekor/pkg/client"
+	"github.com/sigstore/rekor/pkg/generated/client/entries"
+	rekorutil "github.com/sigstore/rekor/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// attestationLogEntry is an attestation in the transparency log and the public key or the certificate which signed it.
+type attestationLogEntry struct {
+	Attestation []byte
+	PublicKey   []byte
+}
+
+// getAttestationLogEntry gets the entry at the index from the Rekor server and verifies that it is included in the log.
+// It is replaced in tests.
+var getAttestationLogEntry = fetchAttestationLogEntry
+
+// fulcioRoots returns the root certificates of keyless signing. It is replaced in tests.
+var fulcioRoots = fulcio.GetRoots
+
+// VerifyProvenanceAttestation verifies that the attestation of the image provenance is signed with the keys
+// or by the signers of the constraint. The attestation is found in the public transparency log by the image digest,
+// so anyone can upload an attestation for the image; only the signer of the log entry makes it trustworthy.
+func (self *Verifier) VerifyProvenanceAttestation(resource unstructured.Unstructured, prov *k8smanifest.Provenance) (bool, error) {
+	vo := self.VerifyOption(resource)
+	keyPaths := []string{}
+	if vo.KeyPath != "" {
+		keyPaths = k8smnfutil.SplitCommaSeparatedString(vo.KeyPath)
+	}
+	err := verifyAttestation(prov, keyPaths, vo.Signers)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyAttestation checks that the log entry of the provenance contains the attestation and is signed by a valid signer.
+func verifyAttestation(prov *k8smanifest.Provenance, keyPaths []string, signers []string) error {
+	if prov.RawAttestation == "" || prov.AttestationLogIndex == nil {
+		return errors.New(fmt.Sprintf("no attestation log entry is found for %s", prov.Artifact))
+	}
+	entry, err := getAttestationLogEntry(*prov.AttestationLogIndex)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to get the attestation log entry `%d`", *prov.AttestationLogIndex))
+	}
+	if !bytes.Equal(bytes.TrimSpace(entry.Attestation), bytes.TrimSpace([]byte(prov.RawAttestation))) {
+		return errors.New(fmt.Sprintf("the attestation log entry `%d` does not contain the attestation of %s", *prov.AttestationLogIndex, prov.Artifact))
+	}
+	return verifyAttestationSigner(entry.PublicKey, keyPaths, signers)
+}
+
+// verifyAttestationSigner checks the public key in the attestation log entry.
+// A public key must be one of the keys in keyPaths, and a certificate must be issued by Fulcio to one of the signers.
+// Without keys and signers, no attestation is trusted because anyone can get a certificate from Fulcio.
+func verifyAttestationSigner(publicKeyPEM []byte, keyPaths []string, signers []string) error {
+	if len(keyPaths) == 0 && len(signers) == 0 {
+		return errors.New("no key or signer is configured to verify the attestation")
+	}
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return errors.New("failed to decode the public key of the attestation")
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse the certificate of the attestation")
+		}
+		if len(signers) == 0 {
+			return errors.New("the attestation is signed with a certificate, but no signer is configured")
+		}
+		err = cosign.TrustedCert(cert, fulcioRoots())
+		if err != nil {
+			return errors.Wrap(err, "the certificate of the attestation is not trusted")
+		}
+		for _, email := range cert.EmailAddresses {
+			if k8smnfutil.MatchWithPatternArray(email, signers) {
+				return nil
+			}
+		}
+		return errors.New(fmt.Sprintf("signer `%s` of the attestation is not allowed", strings.Join(cert.EmailAddresses, ",")))
+	}
+	for _, keyPath := range keyPaths {
+		keyPEM, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to read the key `%s`", keyPath))
+		}
+		keyBlock, _ := pem.Decode(keyPEM)
+		if keyBlock != nil && bytes.Equal(keyBlock.Bytes, block.Bytes) {
+			return nil
+		}
+	}
+	return errors.New("the attestation is not signed with any key of the constraint")
+}
+
+// fetchAttestationLogEntry gets the attestation and the public key in the Rekor entry at the index.
+// The signed entry timestamp is verified with the public key of the Rekor server.
+func fetchAttestationLogEntry(logIndex int) (*attestationLogEntry, error) {
+	rekorClient, err := client.GetRekorClient(k8scosign.GetRekorServerURL())
+	if err != nil {
+		return nil, err
+	}
+	params := entries.NewGetLogEntryByIndexParams()
+	params.LogIndex = int64(logIndex)
+	resp, err := rekorClient.Entries.GetLogEntryByIndex(params)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range resp.Payload {
+		if e.Verification == nil || e.Verification.SignedEntryTimestamp == nil || e.IntegratedTime == nil || e.LogIndex == nil || e.LogID == nil {
+			return nil, errors.New("the log entry has no signed entry timestamp")
+		}
+		rekorPubKey, err := rekorutil.PublicKey(context.Background(), rekorClient)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get the public key of the rekor server")
+		}
+		payload := cremote.BundlePayload{
+			Body:           e.Body,
+			IntegratedTime: *e.IntegratedTime,
+			LogIndex:       *e.LogIndex,
+			LogID:          *e.LogID,
+		}
+		err = cosign.VerifySET(payload, []byte(e.Verification.SignedEntryTimestamp), rekorPubKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to verify the signed entry timestamp")
+		}
+		bodyStr, ok := e.Body.(string)
+		if !ok {
+			return nil, errors.New("the body of the log entry is not a string")
+		}
+		body, err := base64.StdEncoding.DecodeString(bodyStr)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode the body of the log entry")
+		}
+		if e.Attestation == nil {
+			return nil, errors.New("no attestation is stored in the log entry")
+		}
+		return parseAttestationLogEntry(body, e.Attestation.Data)
+	}
+	return nil, errors.New(fmt.Sprintf("log entry `%d` is not found", logIndex))
+}
+
+// parseAttestationLogEntry returns the attestation and the public key of the intoto log entry.
+// The signed entry timestamp covers only the body, so the attestation stored next to it is accepted
+// only if its hash matches the payload hash in the body.
+func parseAttestationLogEntry(body []byte, attestationData []byte) (*attestationLogEntry, error) {
+	var intoto struct {
+		Spec struct {
+			Content struct {
+				PayloadHash *struct {
+					Algorithm string `json:"algorithm"`
+					Value     string `json:"value"`
+				} `json:"payloadHash"`
+			} `json:"content"`
+			PublicKey []byte `json:"publicKey"`
+		} `json:"spec"`
+	}
+	err := json.Unmarshal(body, &intoto)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the body of the log entry")
+	}
+	// the attestation is stored as a base64 encoded string
+	attestation, err := base64.StdEncoding.DecodeString(string(attestationData))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the attestation")
+	}
+	payloadHash := intoto.Spec.Content.PayloadHash
+	if payloadHash == nil || payloadHash.Algorithm != "sha256" || payloadHash.Value == "" {
+		return nil, errors.New("the log entry has no sha256 payload hash")
+	}
+	digest := sha256.Sum256(attestation)
+	if hex.EncodeToString(digest[:]) != strings.ToLower(payloadHash.Value) {
+		return nil, errors.New("the attestation does not match the payload hash in the log entry")
+	}
+	return &attestationLogEntry{Attestation: attestation, PublicKey: intoto.Spec.PublicKey}, nil
+}
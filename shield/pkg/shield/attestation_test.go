@@ -0,0 +1,192 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+)
+
+func generateTestKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func generateTestCert(t *testing.T, template, parent *x509.Certificate, pub *ecdsa.PublicKey, signer *ecdsa.PrivateKey) (*x509.Certificate, []byte) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestVerifyAttestation(t *testing.T) {
+	attestation, err := ioutil.ReadFile(provenanceV01Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, constraintKeyPEM := generateTestKey(t)
+	_, otherKeyPEM := generateTestKey(t)
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	err = ioutil.WriteFile(keyPath, constraintKeyPEM, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keyless signing: a certificate issued by the test root is trusted, a self-signed one is not
+	caKey, _ := generateTestKey(t)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sample-fulcio"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caCert, _ := generateTestCert(t, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	signerKey, _ := generateTestKey(t)
+	signerTemplate := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		EmailAddresses: []string{"builder@sample.com"},
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	_, signerCertPEM := generateTestCert(t, signerTemplate, caCert, &signerKey.PublicKey, caKey)
+	selfSignedTemplate := *signerTemplate
+	selfSignedTemplate.SerialNumber = big.NewInt(3)
+	_, selfSignedCertPEM := generateTestCert(t, &selfSignedTemplate, &selfSignedTemplate, &signerKey.PublicKey, signerKey)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	origRoots, origGetter := fulcioRoots, getAttestationLogEntry
+	defer func() { fulcioRoots, getAttestationLogEntry = origRoots, origGetter }()
+	fulcioRoots = func() *x509.CertPool { return roots }
+
+	logIndex := 100
+	prov := &k8smanifest.Provenance{Artifact: "sample-registry/sample-app", RawAttestation: string(attestation), AttestationLogIndex: &logIndex}
+	testcases := []struct {
+		name        string
+		attestation []byte
+		publicKey   []byte
+		keyPaths    []string
+		signers     []string
+		verified    bool
+	}{
+		{name: "signed with the constraint key", attestation: attestation, publicKey: constraintKeyPEM, keyPaths: []string{keyPath}, verified: true},
+		{name: "signed with another key", attestation: attestation, publicKey: otherKeyPEM, keyPaths: []string{keyPath}, verified: false},
+		{name: "no key and signer", attestation: attestation, publicKey: constraintKeyPEM, verified: false},
+		{name: "other attestation in the entry", attestation: []byte(`{"subject":[]}`), publicKey: constraintKeyPEM, keyPaths: []string{keyPath}, verified: false},
+		{name: "certificate of allowed signer", attestation: attestation, publicKey: signerCertPEM, signers: []string{"builder@sample.com"}, verified: true},
+		{name: "certificate of other signer", attestation: attestation, publicKey: signerCertPEM, signers: []string{"release@*"}, verified: false},
+		{name: "certificate without signers", attestation: attestation, publicKey: signerCertPEM, keyPaths: []string{keyPath}, verified: false},
+		{name: "self-signed certificate", attestation: attestation, publicKey: selfSignedCertPEM, signers: []string{"builder@sample.com"}, verified: false},
+	}
+	for _, tc := range testcases {
+		entry := &attestationLogEntry{Attestation: tc.attestation, PublicKey: tc.publicKey}
+		getAttestationLogEntry = func(int) (*attestationLogEntry, error) { return entry, nil }
+		err := verifyAttestation(prov, tc.keyPaths, tc.signers)
+		if (err == nil) != tc.verified {
+			t.Errorf("%s: unexpected result: got: %v\nwant verified: %v", tc.name, err, tc.verified)
+			return
+		}
+	}
+
+	err = verifyAttestation(&k8smanifest.Provenance{Artifact: "sample-registry/sample-app"}, []string{keyPath}, nil)
+	if err == nil {
+		t.Errorf("provenance without attestation log entry must not be verified")
+		return
+	}
+}
+
+func TestParseAttestationLogEntry(t *testing.T) {
+	attestation, err := ioutil.ReadFile(provenanceV01Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, keyPEM := generateTestKey(t)
+	makeBody := func(payloadHash map[string]string) []byte {
+		content := map[string]interface{}{}
+		if payloadHash != nil {
+			content["payloadHash"] = payloadHash
+		}
+		body, _ := json.Marshal(map[string]interface{}{
+			"apiVersion": "0.0.1",
+			"kind":       "intoto",
+			"spec":       map[string]interface{}{"content": content, "publicKey": keyPEM},
+		})
+		return body
+	}
+	digest := sha256.Sum256(attestation)
+	validHash := map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(digest[:])}
+	data := []byte(base64.StdEncoding.EncodeToString(attestation))
+	otherData := []byte(base64.StdEncoding.EncodeToString([]byte(`{"subject":[]}`)))
+
+	entry, err := parseAttestationLogEntry(makeBody(validHash), data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(entry.Attestation) != string(attestation) || string(entry.PublicKey) != string(keyPEM) {
+		t.Errorf("unexpected log entry: got: %v", entry)
+		return
+	}
+	// the attestation is not covered by the signed entry timestamp, so it must match the payload hash in the body
+	testcases := []struct {
+		name string
+		body []byte
+		data []byte
+	}{
+		{name: "attestation swapped", body: makeBody(validHash), data: otherData},
+		{name: "no payload hash", body: makeBody(nil), data: data},
+		{name: "other hash algorithm", body: makeBody(map[string]string{"algorithm": "sha1", "value": validHash["value"]}), data: data},
+	}
+	for _, tc := range testcases {
+		if _, err := parseAttestationLogEntry(tc.body, tc.data); err == nil {
+			t.Errorf("%s: the log entry must be rejected", tc.name)
+			return
+		}
+	}
+}
@@ -0,0 +1,328 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const gitRefBranchPrefix = "refs/heads/"
+
+const defaultDigestAlgorithm = "sha256"
+
+// ProvenanceInfo is the content of a provenance attestation which is checked against the provenance policy.
+type ProvenanceInfo struct {
+	Artifact     string                           `json:"artifact,omitempty"`
+	ArtifactType string                           `json:"artifactType,omitempty"`
+	Hash         string                           `json:"hash,omitempty"`
+	BuilderID    string                           `json:"builderId,omitempty"`
+	SourceRepo   string                           `json:"sourceRepo,omitempty"`
+	Branch       string                           `json:"branch,omitempty"`
+	Materials    []k8smanifest.ProvenanceMaterial `json:"materials,omitempty"`
+	ConfigMapRef string                           `json:"configMapRef,omitempty"`
+	// digests of the attestation subjects like "sha256:<hex>"; one of them must be the hash of the artifact
+	SubjectDigests []string `json:"subjectDigests,omitempty"`
+	// true if the signature of the ConfigMap in ConfigMapRef is verified
+	ConfigMapVerified bool `json:"configMapVerified,omitempty"`
+	// true if the attestation in the transparency log is signed with the keys or by the signers of the constraint
+	AttestationVerified bool `json:"attestationVerified,omitempty"`
+}
+
+type slsaMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// ParseProvenance extracts builder ID, source repository, branch and materials from in-toto provenance.
+// Both SLSA v0.1 and v0.2 predicates are supported.
+func ParseProvenance(prov *k8smanifest.Provenance) ProvenanceInfo {
+	info := ProvenanceInfo{
+		Artifact:     prov.Artifact,
+		ArtifactType: string(prov.ArtifactType),
+		Hash:         prov.Hash,
+		Materials:    prov.AttestationMaterials,
+		ConfigMapRef: prov.ConfigMapRef,
+	}
+	if prov.RawAttestation == "" {
+		return info
+	}
+	var statement struct {
+		Subject []struct {
+			Digest map[string]string `json:"digest"`
+		} `json:"subject"`
+		Predicate struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+			Recipe struct {
+				DefinedInMaterial *int `json:"definedInMaterial"`
+			} `json:"recipe"`
+			Invocation struct {
+				ConfigSource struct {
+					URI string `json:"uri"`
+				} `json:"configSource"`
+			} `json:"invocation"`
+			Materials []slsaMaterial `json:"materials"`
+		} `json:"predicate"`
+	}
+	err := json.Unmarshal([]byte(prov.RawAttestation), &statement)
+	if err != nil {
+		log.Debugf("failed to parse the attestation of %s; %s", prov.Artifact, err.Error())
+		return info
+	}
+	for _, subject := range statement.Subject {
+		algs := []string{}
+		for alg := range subject.Digest {
+			algs = append(algs, alg)
+		}
+		sort.Strings(algs)
+		for _, alg := range algs {
+			info.SubjectDigests = append(info.SubjectDigests, alg+":"+subject.Digest[alg])
+		}
+	}
+	pred := statement.Predicate
+	info.BuilderID = pred.Builder.ID
+	if len(info.Materials) == 0 {
+		for _, m := range pred.Materials {
+			info.Materials = append(info.Materials, k8smanifest.ProvenanceMaterial{URI: m.URI, Digest: k8smanifest.DigestSet(m.Digest)})
+		}
+	}
+	// SLSA v0.2 has the source in the invocation, v0.1 refers to one of the materials
+	source := ""
+	if pred.Invocation.ConfigSource.URI != "" {
+		source = pred.Invocation.ConfigSource.URI
+	} else if idx := pred.Recipe.DefinedInMaterial; idx != nil && *idx >= 0 && *idx < len(pred.Materials) {
+		source = pred.Materials[*idx].URI
+	} else {
+		for _, m := range pred.Materials {
+			if strings.HasPrefix(m.URI, "git+") {
+				source = m.URI
+				break
+			}
+		}
+	}
+	info.SourceRepo, info.Branch = splitSourceRef(source)
+	return info
+}
+
+// splitSourceRef splits a source URI like "git+https://github.com/org/repo@refs/heads/main" into the repository and the branch.
+func splitSourceRef(source string) (string, string) {
+	idx := strings.LastIndex(source, "@refs/")
+	if idx < 0 {
+		return source, ""
+	}
+	return source[:idx], strings.TrimPrefix(source[idx+1:], gitRefBranchPrefix)
+}
+
+// normalizeDigest adds the default algorithm to a digest without it, e.g. "<hex>" -> "sha256:<hex>".
+func normalizeDigest(digest string) string {
+	if digest == "" || strings.Contains(digest, ":") {
+		return digest
+	}
+	return defaultDigestAlgorithm + ":" + digest
+}
+
+// subjectMatched returns true if one of the attestation subjects is the artifact of the provenance.
+func (self ProvenanceInfo) subjectMatched() bool {
+	hash := normalizeDigest(self.Hash)
+	if hash == "" {
+		return false
+	}
+	for _, digest := range self.SubjectDigests {
+		if normalizeDigest(digest) == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckProvenancePolicy returns false with the reason if the provenances do not satisfy the policy.
+// At least one provenance is required when the policy is enabled.
+func CheckProvenancePolicy(policy k8smnfconfig.ProvenancePolicy, infos []ProvenanceInfo) (bool, string) {
+	if !policy.Enabled() {
+		return true, ""
+	}
+	if len(infos) == 0 {
+		return false, "no provenance is found"
+	}
+	for _, info := range infos {
+		if info.ConfigMapRef != "" && !info.ConfigMapVerified {
+			return false, fmt.Sprintf("provenance configmap `%s` is not signed by a valid key", info.ConfigMapRef)
+		}
+		if info.ConfigMapRef == "" && !info.AttestationVerified {
+			return false, fmt.Sprintf("the attestation of %s is not signed by a valid key", info.Artifact)
+		}
+		if !info.subjectMatched() {
+			return false, fmt.Sprintf("the subject of the provenance of %s does not match the digest `%s`", info.Artifact, info.Hash)
+		}
+		if len(policy.AllowedBuilders) > 0 && !k8smnfutil.MatchWithPatternArray(info.BuilderID, policy.AllowedBuilders) {
+			return false, fmt.Sprintf("builder `%s` of %s is not allowed", info.BuilderID, info.Artifact)
+		}
+		if len(policy.AllowedSourceRepos) > 0 && !k8smnfutil.MatchWithPatternArray(info.SourceRepo, policy.AllowedSourceRepos) {
+			return false, fmt.Sprintf("source repository `%s` of %s is not allowed", info.SourceRepo, info.Artifact)
+		}
+		if len(policy.AllowedBranches) > 0 && !k8smnfutil.MatchWithPatternArray(info.Branch, policy.AllowedBranches) {
+			return false, fmt.Sprintf("branch `%s` of %s is not allowed", info.Branch, info.Artifact)
+		}
+		for _, required := range policy.RequiredMaterials {
+			found := false
+			for _, m := range info.Materials {
+				if k8smnfutil.MatchPattern(required, m.URI) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false, fmt.Sprintf("required material `%s` is not found in %s", required, info.Artifact)
+			}
+		}
+	}
+	return true, ""
+}
+
+// VerifyProvenance gets the provenances of the verified resource and checks them against the provenance policy.
+// The provenances in ConfigMaps and the attestations in the transparency log must be signed with the keys
+// (or by the signers) of the constraint, and the attestation subject
+// must be the artifact which is actually verified: the image digest for images, and the signed manifest for a manifest resource.
+func (self *Verifier) VerifyProvenance(resource unstructured.Unstructured, sigRef string) (bool, string, []ProvenanceInfo) {
+	if !self.param.ProvenancePolicy.Enabled() {
+		return true, "", nil
+	}
+	vo := self.VerifyOption(resource)
+	provs, err := k8smanifest.NewProvenanceGetter(&resource, sigRef, "", vo.ProvenanceResourceRef).Get()
+	if err != nil {
+		log.Errorf("failed to get provenance: %s", err.Error())
+		return false, "Provenance verification is required, but failed to get provenance: " + err.Error(), nil
+	}
+	infos := []ProvenanceInfo{}
+	manifestDigest := ""
+	for _, prov := range provs {
+		if prov == nil {
+			continue
+		}
+		info := ParseProvenance(prov)
+		// the hash of a manifest resource provenance is read from its own subject, so it is replaced with the signed manifest
+		if prov.ArtifactType == k8smanifest.ArtifactManifestResource {
+			if manifestDigest == "" {
+				manifestDigest, err = signedManifestDigest(resource, sigRef, vo.AnnotationConfig)
+				if err != nil {
+					log.Errorf("failed to get the digest of the signed manifest: %s", err.Error())
+					return false, "Provenance verification is required, but failed to get the signed manifest: " + err.Error(), infos
+				}
+			}
+			info.Hash = manifestDigest
+		}
+		if info.ConfigMapRef != "" {
+			info.ConfigMapVerified, err = self.VerifyProvenanceConfigMap(info.ConfigMapRef)
+			if err != nil {
+				log.Warningf("failed to verify the provenance configmap %s; %s", info.ConfigMapRef, err.Error())
+			}
+		} else {
+			info.AttestationVerified, err = self.VerifyProvenanceAttestation(resource, prov)
+			if err != nil {
+				log.Warningf("failed to verify the attestation of %s; %s", info.Artifact, err.Error())
+			}
+		}
+		infos = append(infos, info)
+	}
+	if ok, reason := CheckProvenancePolicy(self.param.ProvenancePolicy, infos); !ok {
+		return false, "Provenance verification is required, but " + reason, infos
+	}
+	return true, "", infos
+}
+
+// VerifyProvenanceConfigMap verifies the signature of the ConfigMap which contains the provenance
+// with the keys of the constraint, so that the provenance itself is not tampered.
+// The signature of the ConfigMap is embedded in its annotations.
+func (self *Verifier) VerifyProvenanceConfigMap(ref string) (bool, error) {
+	namespace, name, err := parseConfigMapRef(ref)
+	if err != nil {
+		return false, err
+	}
+	cm, err := kubeutil.GetResource("v1", "ConfigMap", namespace, name)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to get the configmap `%s`", ref))
+	}
+	verified, err := self.verifySignedConfigMap(*cm)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("failed to verify the configmap `%s`", ref))
+	}
+	return verified, nil
+}
+
+// verifySignedConfigMap verifies the signature embedded in the annotations of the ConfigMap with the keys of the verifier.
+// A ConfigMap is never verified without keys, because anyone can sign it keylessly.
+func (self *Verifier) verifySignedConfigMap(cm unstructured.Unstructured) (bool, error) {
+	vo := self.VerifyOption(cm)
+	if vo.KeyPath == "" {
+		return false, errors.New("no key is loaded to verify the configmap")
+	}
+	vo.ImageRef = ""
+	vo.SignatureResourceRef = ""
+	vo.ProvenanceResourceRef = ""
+	vo.Provenance = false
+	vres, err := k8smanifest.VerifyResource(cm, vo)
+	if err != nil {
+		return false, err
+	}
+	return vres.Verified, nil
+}
+
+// parseConfigMapRef returns the namespace and the name in "k8s://ConfigMap/[NAMESPACE]/[NAME]".
+func parseConfigMapRef(ref string) (string, string, error) {
+	parts := strings.Split(strings.TrimPrefix(ref, k8smanifest.InClusterObjectPrefix), "/")
+	if !strings.HasPrefix(ref, k8smanifest.InClusterObjectPrefix) || len(parts) != 3 || parts[0] != "ConfigMap" || parts[1] == "" || parts[2] == "" {
+		return "", "", errors.New(fmt.Sprintf("configmap reference must be \"k8s://ConfigMap/[NAMESPACE]/[NAME]\", but got %s", ref))
+	}
+	return parts[1], parts[2], nil
+}
+
+// signedManifestDigest returns the digest of the signed message of the resource, i.e. the gzipped manifest
+// in the signature ConfigMap of sigRef, or in the annotation if the signature is embedded in the resource.
+func signedManifestDigest(resource unstructured.Unstructured, sigRef string, annotationConfig k8smanifest.AnnotationConfig) (string, error) {
+	var base64Msg string
+	if strings.HasPrefix(sigRef, k8smanifest.InClusterObjectPrefix) {
+		cm, err := k8smanifest.GetConfigMapFromK8sObjectRef(sigRef)
+		if err != nil {
+			return "", err
+		}
+		base64Msg = cm.Data[k8smanifest.MessageAnnotationBaseName]
+	} else {
+		base64Msg = resource.GetAnnotations()[annotationConfig.MessageAnnotationKey()]
+	}
+	if base64Msg == "" {
+		return "", errors.New("no signed message is found for the resource")
+	}
+	msg, err := base64.StdEncoding.DecodeString(base64Msg)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode the signed message")
+	}
+	return fmt.Sprintf("%s:%x", defaultDigestAlgorithm, sha256.Sum256(msg)), nil
+}
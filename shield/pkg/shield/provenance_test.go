@@ -0,0 +1,179 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	provenanceV01Path = "./testdata/provenance_slsa_v01.json"
+	provenanceV02Path = "./testdata/provenance_slsa_v02.json"
+)
+
+func loadTestProvenance(fpath string) (ProvenanceInfo, error) {
+	data, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return ProvenanceInfo{}, err
+	}
+	statement, _, materials, err := k8smanifest.ParseAttestation(string(data))
+	if err != nil {
+		return ProvenanceInfo{}, err
+	}
+	prov := &k8smanifest.Provenance{
+		RawAttestation:       string(data),
+		Artifact:             statement.Subject[0].Name,
+		Hash:                 statement.Subject[0].Digest["sha256"],
+		AttestationMaterials: materials,
+	}
+	return ParseProvenance(prov), nil
+}
+
+func TestParseProvenance(t *testing.T) {
+	testcases := []struct {
+		fpath     string
+		builderID string
+		repo      string
+		branch    string
+		materials int
+	}{
+		{fpath: provenanceV01Path, builderID: "https://github.com/Attestations/GitHubHostedActions@v1", repo: "git+https://github.com/sample-org/sample-app", branch: "main", materials: 2},
+		{fpath: provenanceV02Path, builderID: "https://tekton.dev/chains/v2", repo: "git+https://github.com/sample-org/sample-app", branch: "release-1.0", materials: 2},
+	}
+	for _, tc := range testcases {
+		info, err := loadTestProvenance(tc.fpath)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if info.BuilderID != tc.builderID || info.SourceRepo != tc.repo || info.Branch != tc.branch || len(info.Materials) != tc.materials {
+			t.Errorf("%s: unexpected provenance: got: %v\nwant: %v, %v, %v, %v materials", tc.fpath, info, tc.builderID, tc.repo, tc.branch, tc.materials)
+			return
+		}
+	}
+}
+
+func TestCheckProvenancePolicy(t *testing.T) {
+	info, err := loadTestProvenance(provenanceV01Path)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	info.AttestationVerified = true
+	unsignedAttestation := info
+	unsignedAttestation.AttestationVerified = false
+	otherArtifact := info
+	otherArtifact.Hash = "sha256:0000000000000000000000000000000000000000000000000000000000000000"
+	unsignedConfigMap := info
+	unsignedConfigMap.AttestationVerified = false
+	unsignedConfigMap.ConfigMapRef = "k8s://ConfigMap/sample-ns/sample-provenance"
+	signedConfigMap := unsignedConfigMap
+	signedConfigMap.ConfigMapVerified = true
+	testcases := []struct {
+		name   string
+		infos  []ProvenanceInfo
+		policy k8smnfconfig.ProvenancePolicy
+		allow  bool
+	}{
+		{name: "no policy", infos: nil, policy: k8smnfconfig.ProvenancePolicy{}, allow: true},
+		{name: "no provenance", infos: nil, policy: k8smnfconfig.ProvenancePolicy{AllowedBuilders: []string{"*"}}, allow: false},
+		{
+			name:  "all satisfied",
+			infos: []ProvenanceInfo{info},
+			policy: k8smnfconfig.ProvenancePolicy{
+				AllowedBuilders:    []string{"https://github.com/Attestations/*"},
+				AllowedSourceRepos: []string{"git+https://github.com/sample-org/*"},
+				AllowedBranches:    []string{"main", "release-*"},
+				RequiredMaterials:  []string{"https://github.com/actions/checkout*"},
+			},
+			allow: true,
+		},
+		{name: "builder not allowed", infos: []ProvenanceInfo{info}, policy: k8smnfconfig.ProvenancePolicy{AllowedBuilders: []string{"https://tekton.dev/*"}}, allow: false},
+		{name: "repository not allowed", infos: []ProvenanceInfo{info}, policy: k8smnfconfig.ProvenancePolicy{AllowedSourceRepos: []string{"git+https://github.com/other-org/*"}}, allow: false},
+		{name: "branch not allowed", infos: []ProvenanceInfo{info}, policy: k8smnfconfig.ProvenancePolicy{AllowedBranches: []string{"release-*"}}, allow: false},
+		{name: "material not found", infos: []ProvenanceInfo{info}, policy: k8smnfconfig.ProvenancePolicy{RequiredMaterials: []string{"gcr.io/tekton-releases/*"}}, allow: false},
+		{name: "subject of other artifact", infos: []ProvenanceInfo{otherArtifact}, policy: k8smnfconfig.ProvenancePolicy{AllowedBuilders: []string{"*"}}, allow: false},
+		{name: "attestation not signed", infos: []ProvenanceInfo{unsignedAttestation}, policy: k8smnfconfig.ProvenancePolicy{AllowedBuilders: []string{"*"}}, allow: false},
+		{name: "configmap not signed", infos: []ProvenanceInfo{unsignedConfigMap}, policy: k8smnfconfig.ProvenancePolicy{AllowedBuilders: []string{"*"}}, allow: false},
+		{name: "configmap signed", infos: []ProvenanceInfo{signedConfigMap}, policy: k8smnfconfig.ProvenancePolicy{AllowedBuilders: []string{"*"}}, allow: true},
+	}
+	for _, tc := range testcases {
+		allow, reason := CheckProvenancePolicy(tc.policy, tc.infos)
+		if allow != tc.allow {
+			t.Errorf("%s: unexpected result: got: %v\nwant: %v (%s)", tc.name, allow, tc.allow, reason)
+			return
+		}
+	}
+}
+
+func TestParseConfigMapRef(t *testing.T) {
+	testcases := []struct {
+		ref       string
+		namespace string
+		name      string
+		valid     bool
+	}{
+		{ref: "k8s://ConfigMap/sample-ns/sample-provenance", namespace: "sample-ns", name: "sample-provenance", valid: true},
+		{ref: "k8s://Secret/sample-ns/sample-provenance", valid: false},
+		{ref: "k8s://ConfigMap/sample-provenance", valid: false},
+		{ref: "ConfigMap/sample-ns/sample-provenance", valid: false},
+	}
+	for _, tc := range testcases {
+		namespace, name, err := parseConfigMapRef(tc.ref)
+		if (err == nil) != tc.valid || namespace != tc.namespace || name != tc.name {
+			t.Errorf("%s: unexpected result: got: %s, %s, %v\nwant: %s, %s, valid: %v", tc.ref, namespace, name, err, tc.namespace, tc.name, tc.valid)
+			return
+		}
+	}
+}
+
+func TestSignedManifestDigest(t *testing.T) {
+	msg := []byte("sample gzipped manifest")
+	annotationConfig := k8smanifest.AnnotationConfig{}
+	obj := unstructured.Unstructured{}
+	obj.SetAnnotations(map[string]string{annotationConfig.MessageAnnotationKey(): base64.StdEncoding.EncodeToString(msg)})
+
+	digest, err := signedManifestDigest(obj, k8smanifest.SigRefEmbeddedInAnnotation, annotationConfig)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expected := fmt.Sprintf("sha256:%x", sha256.Sum256(msg))
+	if digest != expected {
+		t.Errorf("unexpected digest: got: %s\nwant: %s", digest, expected)
+		return
+	}
+	info := ProvenanceInfo{Hash: digest, SubjectDigests: []string{expected}}
+	if !info.subjectMatched() {
+		t.Errorf("subject %s does not match the signed manifest", expected)
+		return
+	}
+
+	_, err = signedManifestDigest(unstructured.Unstructured{}, "", annotationConfig)
+	if err == nil {
+		t.Errorf("digest of a resource without signed message must fail")
+		return
+	}
+}
@@ -27,7 +27,6 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
-	ishieldimage "github.com/IBM/integrity-shield/shield/pkg/image"
 	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
 	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
 	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/mapnode"
@@ -46,6 +45,24 @@ const defaultPodNamespace = "integrity-shield-operator-system"
 const ImageRefAnnotationKeyShield = "integrityshield.io/signature"
 const AnnotationKeyDomain = "integrityshield.io"
 const SignatureAnnotationTypeShield = "IntegrityShield"
+
+// SignatureResourceRefAnnotationKey refers to a ConfigMap `<namespace>/<name>` which holds the signature of the resource.
+// It is attached by the mutating webhook of the admission controller.
+const SignatureResourceRefAnnotationKey = "integrityshield.io/signatureResourceRef"
+
+// ViolationMarkerKey is an annotation or a label key which the observer sets on resources violating constraints.
+const ViolationMarkerKey = "integrityshield.io/violation"
+
+// ObserverServiceAccountEnvKey is the env which has the user name of the observer service account.
+// It is set by the operator, so that only the observer can change the violation marker without a signature.
+const ObserverServiceAccountEnvKey = "OBSERVER_SERVICE_ACCOUNT"
+
+// fields set by the observer, which are not a part of signed manifests
+var builtinIgnoreFields = []string{
+	"metadata.annotations." + ViolationMarkerKey,
+	"metadata.labels." + ViolationMarkerKey,
+}
+
 const (
 	EventTypeAnnotationKey       = "integrityshield.io/eventType"
 	EventResultAnnotationKey     = "integrityshield.io/eventResult"
@@ -63,7 +80,9 @@ func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObjec
 	if err != nil {
 		log.Errorf("failed to Unmarshal a requested object into %T; %s", resource, err.Error())
 		errMsg := "IntegrityShield failed to decide the response. Failed to Unmarshal a requested object: " + err.Error()
-		return makeResultFromRequestHandler(false, errMsg, false, req)
+		r := makeResultFromRequestHandler(false, errMsg, false, req)
+		r.Reason = ReasonInternalError
+		return r
 	}
 
 	// load request handler config
@@ -71,7 +90,9 @@ func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObjec
 	if err != nil {
 		log.Errorf("failed to load request handler config: %s", err.Error())
 		errMsg := "IntegrityShield failed to decide the response. Failed to load request handler config: " + err.Error()
-		return makeResultFromRequestHandler(false, errMsg, false, req)
+		r := makeResultFromRequestHandler(false, errMsg, false, req)
+		r.Reason = ReasonInternalError
+		return r
 	}
 	if rhconfig == nil {
 		log.Warning("request handler config is empty")
@@ -113,137 +134,40 @@ func RequestHandler(req admission.Request, paramObj *k8smnfconfig.ParameterObjec
 		log.Debug("REKOR_SERVER is set as ", debug)
 	}
 
-	commonSkipUserMatched := false
-	skipObjectMatched := false
-
-	//filter by user listed in common profile
-	commonSkipUserMatched = rhconfig.RequestFilterProfile.SkipUsers.Match(resource, req.AdmissionRequest.UserInfo.Username)
-
-	// skip object
-	skipObjectMatched = skipObjectsMatch(rhconfig.RequestFilterProfile.SkipObjects, resource)
-
-	// Proccess with parameter
-	//filter by user
-	skipUserMatched := paramObj.SkipUsers.Match(resource, req.AdmissionRequest.UserInfo.Username)
-
-	//force check user
-	inScopeUserMatched := paramObj.InScopeUsers.Match(resource, req.AdmissionRequest.UserInfo.Username)
-
-	//check scope
-	inScopeObjMatched := paramObj.InScopeObjects.Match(resource)
+	verifier := NewVerifier(paramObj, rhconfig)
 
 	// mutation check
 	if isUpdateRequest(req.AdmissionRequest.Operation) {
-		ignoreFields := getMatchedIgnoreFields(paramObj.IgnoreFields, rhconfig.RequestFilterProfile.IgnoreFields, resource)
+		param := verifier.Parameters(resource)
+		ignoreFields := getMatchedIgnoreFields(param.IgnoreFields, rhconfig.RequestFilterProfile.IgnoreFields, resource, req.AdmissionRequest.UserInfo.Username)
 		mutated, err := mutationCheck(req.AdmissionRequest.OldObject.Raw, req.AdmissionRequest.Object.Raw, ignoreFields)
 		if err != nil {
 			log.Errorf("failed to check mutation: %s", err.Error())
 			errMsg := "IntegrityShield failed to decide the response. Failed to check mutation: " + err.Error()
-			return makeResultFromRequestHandler(false, errMsg, enforce, req)
+			r := makeResultFromRequestHandler(false, errMsg, enforce, req)
+			r.Reason = ReasonInternalError
+			return r
 		}
 		if !mutated {
 			return makeResultFromRequestHandler(true, "no mutation found", enforce, req)
 		}
 	}
 
-	allow := false
-	message := ""
-	if (skipUserMatched || commonSkipUserMatched) && !inScopeUserMatched {
-		allow = true
-		message = "SkipUsers rule matched."
-	} else if !inScopeObjMatched {
-		allow = true
-		message = "ObjectSelector rule did not match. Out of scope of verification."
-	} else if skipObjectMatched {
-		allow = true
-		message = "SkipObjects rule matched."
-	} else {
-		var signatureAnnotationType string
-		annotations := resource.GetAnnotations()
-		_, found := annotations[ImageRefAnnotationKeyShield]
-		if found {
-			signatureAnnotationType = SignatureAnnotationTypeShield
-		}
-		vo := setVerifyOption(paramObj, rhconfig, signatureAnnotationType)
-		log.WithFields(log.Fields{
-			"namespace": req.Namespace,
-			"name":      req.Name,
-			"kind":      req.Kind.Kind,
-			"operation": req.Operation,
-			"userName":  req.UserInfo.Username,
-		}).Debug("VerifyOption: ", vo)
-		// call VerifyResource with resource, verifyOption, keypath, imageRef
-		result, err := k8smanifest.VerifyResource(resource, vo)
-		log.WithFields(log.Fields{
-			"namespace": req.Namespace,
-			"name":      req.Name,
-			"kind":      req.Kind.Kind,
-			"operation": req.Operation,
-			"userName":  req.UserInfo.Username,
-		}).Debug("VerifyResource result: ", result)
-		if err != nil {
-			log.WithFields(log.Fields{
-				"namespace": req.Namespace,
-				"name":      req.Name,
-				"kind":      req.Kind.Kind,
-				"operation": req.Operation,
-				"userName":  req.UserInfo.Username,
-			}).Warningf("Signature verification is required for this request, but verifyResource return error ; %s", err.Error())
-			r := makeResultFromRequestHandler(false, err.Error(), enforce, req)
-			// generate events
-			if rhconfig.SideEffectConfig.CreateDenyEvent {
-				_ = createOrUpdateEvent(req, r, paramObj.ConstraintName)
-			}
-			return r
-		}
-
-		if result.InScope {
-			if result.Verified {
-				allow = true
-				message = fmt.Sprintf("singed by a valid signer: %s", result.Signer)
-			} else {
-				allow = false
-				message = "Signature verification is required for this request, but no signature is found."
-				if result.Diff != nil && result.Diff.Size() > 0 {
-					message = fmt.Sprintf("Signature verification is required for this request, but failed to verify signature. diff found: %s", result.Diff.String())
-				} else if result.Signer != "" {
-					message = fmt.Sprintf("Signature verification is required for this request, but no signer config matches with this resource. This is signed by %s", result.Signer)
-				}
-			}
-		} else {
-			allow = true
-			message = "not protected"
-		}
-
-		// image verify
-		imageAllow := true
-		imageMessage := ""
-		var imageVerifyResults []ishieldimage.ImageVerifyResult
-		if paramObj.ImageProfile.Enabled() {
-			_, err = ishieldimage.VerifyImageInManifest(resource, paramObj.ImageProfile)
-			if err != nil {
-				log.Errorf("failed to verify images: %s", err.Error())
-				imageAllow = false
-				imageMessage = "Image signature verification is required, but failed to verify signature: " + err.Error()
-
-			} else {
-				for _, res := range imageVerifyResults {
-					if res.InScope && !res.Verified {
-						imageAllow = false
-						imageMessage = "Image signature verification is required, but failed to verify signature: " + res.FailReason
-						break
-					}
-				}
-			}
-		}
-
-		if allow && !imageAllow {
-			message = imageMessage
-			allow = false
-		}
-	}
+	// filters, signature verification and image verification
+	result := verifier.Verify(resource, req.AdmissionRequest.UserInfo.Username)
+	log.WithFields(log.Fields{
+		"namespace": req.Namespace,
+		"name":      req.Name,
+		"kind":      req.Kind.Kind,
+		"operation": req.Operation,
+		"userName":  req.UserInfo.Username,
+	}).Debug("VerifyResource result: ", result.VerifyResourceResult)
+	allow := result.Allow
+	message := result.Message
 
 	r := makeResultFromRequestHandler(allow, message, enforce, req)
+	r.Reason = result.Reason
+	r.Verified = result.Verified
 
 	// generate events
 	if rhconfig.SideEffectConfig.CreateDenyEvent {
@@ -256,6 +180,10 @@ type ResultFromRequestHandler struct {
 	Allow   bool   `json:"allow"`
 	Message string `json:"message"`
 	Profile string `json:"profile,omitempty"`
+	// the reason of the denial; see Reason* consts
+	Reason string `json:"reason,omitempty"`
+	// true if the request is allowed with a verified signature
+	Verified bool `json:"verified,omitempty"`
 }
 
 func makeResultFromRequestHandler(allow bool, msg string, enforce bool, req admission.Request) *ResultFromRequestHandler {
@@ -282,15 +210,28 @@ func isUpdateRequest(operation v1.Operation) bool {
 	return (operation == v1.Update)
 }
 
-func getMatchedIgnoreFields(pi, ci k8smanifest.ObjectFieldBindingList, resource unstructured.Unstructured) []string {
+func getMatchedIgnoreFields(pi, ci k8smanifest.ObjectFieldBindingList, resource unstructured.Unstructured, userName string) []string {
 	var allIgnoreFields []string
 	_, fields := pi.Match(resource)
 	_, commonfields := ci.Match(resource)
 	allIgnoreFields = append(allIgnoreFields, fields...)
 	allIgnoreFields = append(allIgnoreFields, commonfields...)
+	if isObserver(userName) {
+		allIgnoreFields = append(allIgnoreFields, builtinIgnoreFields...)
+	}
 	return allIgnoreFields
 }
 
+// isObserver returns true if the requester is the observer.
+// userName is empty when the observer verifies existing resources.
+func isObserver(userName string) bool {
+	if userName == "" {
+		return true
+	}
+	observer := os.Getenv(ObserverServiceAccountEnvKey)
+	return observer != "" && userName == observer
+}
+
 func mutationCheck(rawOldObject, rawObject []byte, IgnoreFields []string) (bool, error) {
 	var oldObject *mapnode.Node
 	var newObject *mapnode.Node
@@ -336,62 +277,6 @@ func mutationCheck(rawOldObject, rawObject []byte, IgnoreFields []string) (bool,
 	return true, nil
 }
 
-func setVerifyOption(paramObj *k8smnfconfig.ParameterObject, config *k8smnfconfig.RequestHandlerConfig, signatureAnnotationType string) *k8smanifest.VerifyResourceOption {
-	// get verifyOption and imageRef from Parameter
-	vo := &paramObj.VerifyResourceOption
-
-	// set Signature ref
-	if paramObj.SignatureRef.ImageRef != "" {
-		vo.ImageRef = paramObj.SignatureRef.ImageRef
-	}
-	if paramObj.SignatureRef.SignatureResourceRef.Name != "" && paramObj.SignatureRef.SignatureResourceRef.Namespace != "" {
-		ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", paramObj.SignatureRef.SignatureResourceRef.Namespace, paramObj.SignatureRef.SignatureResourceRef.Name)
-		vo.SignatureResourceRef = ref
-	}
-	if paramObj.SignatureRef.ProvenanceResourceRef.Name != "" && paramObj.SignatureRef.ProvenanceResourceRef.Namespace != "" {
-		ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", paramObj.SignatureRef.ProvenanceResourceRef.Namespace, paramObj.SignatureRef.ProvenanceResourceRef.Name)
-		vo.ProvenanceResourceRef = ref
-	}
-
-	// set DryRun namespace
-	namespace := os.Getenv("POD_NAMESPACE")
-	if namespace == "" {
-		namespace = defaultPodNamespace
-	}
-	vo.DryRunNamespace = namespace
-
-	// set Signature type
-	if signatureAnnotationType == SignatureAnnotationTypeShield {
-		vo.AnnotationConfig.AnnotationKeyDomain = AnnotationKeyDomain
-	}
-	// prepare local key for verifyResource
-	if len(paramObj.KeyConfigs) != 0 {
-		keyPathList := []string{}
-		for _, keyconfig := range paramObj.KeyConfigs {
-			if keyconfig.KeySecretName != "" {
-				keyPath, err := k8smnfconfig.LoadKeySecret(keyconfig.KeySecretNamespace, keyconfig.KeySecretName)
-				if err != nil {
-					log.Errorf("failed to load key secret: %s", err.Error())
-				}
-				keyPathList = append(keyPathList, keyPath)
-			}
-		}
-		keyPathString := strings.Join(keyPathList, ",")
-		if keyPathString != "" {
-			vo.KeyPath = keyPathString
-		}
-	}
-	// merge params in request handler config
-	if len(config.RequestFilterProfile.IgnoreFields) == 0 {
-		return vo
-	}
-	fields := k8smanifest.ObjectFieldBindingList{}
-	fields = append(fields, vo.IgnoreFields...)
-	fields = append(fields, config.RequestFilterProfile.IgnoreFields...)
-	vo.IgnoreFields = fields
-	return vo
-}
-
 func skipObjectsMatch(l k8smanifest.ObjectReferenceList, obj unstructured.Unstructured) bool {
 	if len(l) == 0 {
 		return false
@@ -19,6 +19,7 @@ package shield
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
 	"testing"
 
 	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
@@ -80,7 +81,7 @@ func TestMutationCheck(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	ignoreFields := getMatchedIgnoreFields(testIgnoredFields, rhc.RequestFilterProfile.IgnoreFields, resource)
+	ignoreFields := getMatchedIgnoreFields(testIgnoredFields, rhc.RequestFilterProfile.IgnoreFields, resource, adreq1.UserInfo.Username)
 	res, err := mutationCheck(adreq1.OldObject.Raw, adreq1.Object.Raw, ignoreFields)
 	if err != nil {
 		t.Error(err)
@@ -109,7 +110,7 @@ func TestMutationCheck(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	ignoreFields2 := getMatchedIgnoreFields(testIgnoredFields, rhc.RequestFilterProfile.IgnoreFields, resource2)
+	ignoreFields2 := getMatchedIgnoreFields(testIgnoredFields, rhc.RequestFilterProfile.IgnoreFields, resource2, adreq2.UserInfo.Username)
 	res2, err := mutationCheck(adreq2.OldObject.Raw, adreq2.Object.Raw, ignoreFields2)
 	if err != nil {
 		t.Error(err)
@@ -120,3 +121,60 @@ func TestMutationCheck(t *testing.T) {
 		return
 	}
 }
+
+func TestMutationCheckViolationMarker(t *testing.T) {
+	observer := "system:serviceaccount:integrity-shield-operator-system:integrity-shield-observer-sa"
+	_ = os.Setenv(ObserverServiceAccountEnvKey, observer)
+	defer os.Unsetenv(ObserverServiceAccountEnvKey)
+
+	oldObject := []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"sample-cm","namespace":"sample-ns"},"data":{"key1":"val1"}}`)
+	testcases := []struct {
+		name     string
+		object   []byte
+		userName string
+		expected bool
+	}{
+		{
+			name:     "marker annotation by observer",
+			object:   []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"sample-cm","namespace":"sample-ns","annotations":{"integrityshield.io/violation":"constraint-a"}},"data":{"key1":"val1"}}`),
+			userName: observer,
+			expected: false,
+		},
+		{
+			name:     "marker label by observer",
+			object:   []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"sample-cm","namespace":"sample-ns","labels":{"integrityshield.io/violation":"true"}},"data":{"key1":"val1"}}`),
+			userName: observer,
+			expected: false,
+		},
+		{
+			name:     "marker and data by observer",
+			object:   []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"sample-cm","namespace":"sample-ns","labels":{"integrityshield.io/violation":"true"}},"data":{"key1":"val2"}}`),
+			userName: observer,
+			expected: true,
+		},
+		{
+			name:     "marker label by other user",
+			object:   []byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"sample-cm","namespace":"sample-ns","labels":{"integrityshield.io/violation":"true"}},"data":{"key1":"val1"}}`),
+			userName: "sample-user",
+			expected: true,
+		},
+	}
+	for _, tc := range testcases {
+		var resource unstructured.Unstructured
+		err := json.Unmarshal(tc.object, &resource)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ignoreFields := getMatchedIgnoreFields(nil, nil, resource, tc.userName)
+		res, err := mutationCheck(oldObject, tc.object, ignoreFields)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if res != tc.expected {
+			t.Errorf("%s: unexpected mutation: got: %v\nwant: %v", tc.name, res, tc.expected)
+			return
+		}
+	}
+}
@@ -0,0 +1,325 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/IBM/integrity-shield/shield/pkg/bundle"
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	ishieldimage "github.com/IBM/integrity-shield/shield/pkg/image"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Verifier verifies resources with the parameters of a constraint and the request handler config.
+// Both the admission request handler and the observer use it, so that they always decide in the same way.
+type Verifier struct {
+	param  k8smnfconfig.ParameterObject
+	config k8smnfconfig.RequestHandlerConfig
+	// loads the defaults of namespaces; nil if the parameters are already merged with them
+	loadNamespaceDefaults func(namespace string) (*k8smnfconfig.NamespaceDefaults, error)
+	namespaceDefaults     map[string]*k8smnfconfig.NamespaceDefaults
+	mu                    sync.Mutex
+}
+
+// Reasons why a resource is not allowed
+const (
+	ReasonNoSignature           = "NoSignature"
+	ReasonSignatureMismatch     = "SignatureMismatch"
+	ReasonSignerNotAllowed      = "SignerNotAllowed"
+	ReasonVerificationError     = "VerificationError"
+	ReasonImageNotVerified      = "ImageNotVerified"
+	ReasonProvenanceNotVerified = "ProvenanceNotVerified"
+	ReasonInternalError         = "InternalError"
+)
+
+type VerifyResult struct {
+	Allow bool `json:"allow"`
+	// false if the resource is filtered out or out of scope of verification
+	InScope bool   `json:"inScope"`
+	Message string `json:"message"`
+	// one of Reason* consts if the resource is not allowed
+	Reason string `json:"reason,omitempty"`
+	// true if the resource is allowed with a verified signature
+	Verified bool `json:"verified"`
+	// error in verification; the resource is not allowed
+	Error                error                             `json:"-"`
+	VerifyResourceResult *k8smanifest.VerifyResourceResult `json:"verifyResourceResult,omitempty"`
+	ImageAllow           bool                              `json:"imageAllow"`
+	ImageMessage         string                            `json:"imageMessage,omitempty"`
+	ImageError           error                             `json:"-"` // error in image verification itself, e.g. the registry is not reachable
+	Provenances          []ProvenanceInfo                  `json:"provenances,omitempty"`
+}
+
+func NewVerifier(paramObj *k8smnfconfig.ParameterObject, config *k8smnfconfig.RequestHandlerConfig) *Verifier {
+	v := &Verifier{}
+	if paramObj != nil {
+		v.param = *paramObj
+	}
+	if config != nil {
+		v.config = *config
+	}
+	if v.config.NamespaceDefaults.Enabled {
+		nsConfig := v.config.NamespaceDefaults
+		// the defaults ConfigMaps are verified with the keys in the config, not with the keys of the constraint
+		cmVerifier := &Verifier{param: k8smnfconfig.ParameterObject{KeyConfigs: nsConfig.KeyConfigs}, config: v.config}
+		v.loadNamespaceDefaults = func(namespace string) (*k8smnfconfig.NamespaceDefaults, error) {
+			return k8smnfconfig.LoadNamespaceDefaults(namespace, nsConfig, cmVerifier.verifySignedConfigMap)
+		}
+	}
+	return v
+}
+
+// Parameters returns the parameters for the resource, which are merged with the defaults of its namespace.
+func (self *Verifier) Parameters(resource unstructured.Unstructured) k8smnfconfig.ParameterObject {
+	if self.loadNamespaceDefaults == nil {
+		return self.param
+	}
+	namespace := resource.GetNamespace()
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.namespaceDefaults == nil {
+		self.namespaceDefaults = map[string]*k8smnfconfig.NamespaceDefaults{}
+	}
+	defaults, found := self.namespaceDefaults[namespace]
+	if !found {
+		var err error
+		defaults, err = self.loadNamespaceDefaults(namespace)
+		if err != nil {
+			log.Warningf("failed to load the defaults of namespace `%s`; %s", namespace, err.Error())
+		}
+		self.namespaceDefaults[namespace] = defaults
+	}
+	return *self.param.WithNamespaceDefaults(defaults)
+}
+
+// forResource returns the verifier with the parameters for the resource.
+func (self *Verifier) forResource(resource unstructured.Unstructured) *Verifier {
+	param := self.Parameters(resource)
+	return &Verifier{param: param, config: self.config}
+}
+
+// CheckFilters checks skipUsers, objectSelector and skipObjects of the constraint and the request filter profile.
+// It returns false with the reason if the resource is not verified.
+// userName is the requester of admission requests; it is empty for existing resources,
+// then only skipUsers rules which match any user (e.g. "*") are applied.
+func (self *Verifier) CheckFilters(resource unstructured.Unstructured, userName string) (bool, string) {
+	commonSkipUserMatched := self.config.RequestFilterProfile.SkipUsers.Match(resource, userName)
+	skipUserMatched := self.param.SkipUsers.Match(resource, userName)
+	inScopeUserMatched := self.param.InScopeUsers.Match(resource, userName)
+	if (skipUserMatched || commonSkipUserMatched) && !inScopeUserMatched {
+		return false, "SkipUsers rule matched."
+	}
+	if !self.param.InScopeObjects.Match(resource) {
+		return false, "ObjectSelector rule did not match. Out of scope of verification."
+	}
+	if skipObjectsMatch(self.config.RequestFilterProfile.SkipObjects, resource) || skipObjectsMatch(self.param.SkipObjects, resource) {
+		return false, "SkipObjects rule matched."
+	}
+	return true, ""
+}
+
+// VerifyOption returns the option of k8smanifest.VerifyResource for the existing resource.
+func (self *Verifier) VerifyOption(resource unstructured.Unstructured) *k8smanifest.VerifyResourceOption {
+	return self.verifyOption(resource, "")
+}
+
+// verifyOption returns the option of k8smanifest.VerifyResource for the resource requested by the user.
+// The violation marker is ignored only if the requester is the observer.
+func (self *Verifier) verifyOption(resource unstructured.Unstructured, userName string) *k8smanifest.VerifyResourceOption {
+	if self.loadNamespaceDefaults != nil {
+		return self.forResource(resource).verifyOption(resource, userName)
+	}
+	// copy not to change the parameter
+	vo := self.param.VerifyResourceOption
+
+	// set Signature ref
+	if self.param.SignatureRef.ImageRef != "" {
+		vo.ImageRef = self.param.SignatureRef.ImageRef
+	}
+	if self.param.SignatureRef.SignatureResourceRef.Name != "" && self.param.SignatureRef.SignatureResourceRef.Namespace != "" {
+		ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", self.param.SignatureRef.SignatureResourceRef.Namespace, self.param.SignatureRef.SignatureResourceRef.Name)
+		vo.SignatureResourceRef = ref
+	}
+	if self.param.SignatureRef.ProvenanceResourceRef.Name != "" && self.param.SignatureRef.ProvenanceResourceRef.Namespace != "" {
+		ref := fmt.Sprintf("k8s://ConfigMap/%s/%s", self.param.SignatureRef.ProvenanceResourceRef.Namespace, self.param.SignatureRef.ProvenanceResourceRef.Name)
+		vo.ProvenanceResourceRef = ref
+	}
+
+	// set DryRun namespace
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultPodNamespace
+	}
+	vo.DryRunNamespace = namespace
+
+	// set Signature type
+	annotations := resource.GetAnnotations()
+	if _, found := annotations[ImageRefAnnotationKeyShield]; found {
+		vo.AnnotationConfig.AnnotationKeyDomain = AnnotationKeyDomain
+	}
+	// signature ConfigMap attached by the mutating webhook is used if the constraint does not specify the signature
+	if ref, found := annotations[SignatureResourceRefAnnotationKey]; found && vo.ImageRef == "" && vo.SignatureResourceRef == "" {
+		vo.SignatureResourceRef = fmt.Sprintf("k8s://ConfigMap/%s", ref)
+	}
+	// prepare local key for verifyResource
+	keyPathList := []string{}
+	for _, keyconfig := range self.param.KeyConfigs {
+		if keyconfig.KeySecretName != "" {
+			keyPath, err := k8smnfconfig.LoadKeySecret(keyconfig.KeySecretNamespace, keyconfig.KeySecretName)
+			if err != nil {
+				log.Errorf("failed to load key secret: %s", err.Error())
+				continue
+			}
+			keyPathList = append(keyPathList, keyPath)
+		}
+	}
+	if len(keyPathList) > 0 {
+		vo.KeyPath = strings.Join(keyPathList, ",")
+	}
+	// merge params in request handler config
+	fields := k8smanifest.ObjectFieldBindingList{}
+	fields = append(fields, self.param.IgnoreFields...)
+	fields = append(fields, self.config.RequestFilterProfile.IgnoreFields...)
+	if isObserver(userName) {
+		fields = append(fields, k8smanifest.ObjectFieldBinding{
+			Fields:  builtinIgnoreFields,
+			Objects: k8smanifest.ObjectReferenceList{{Kind: "*"}},
+		})
+	}
+	// the injected reference is not a part of the signed manifest
+	if _, found := annotations[SignatureResourceRefAnnotationKey]; found {
+		fields = append(fields, k8smanifest.ObjectFieldBinding{
+			Fields:  []string{"metadata.annotations." + SignatureResourceRefAnnotationKey},
+			Objects: k8smanifest.ObjectReferenceList{{Kind: "*"}},
+		})
+	}
+	vo.IgnoreFields = fields
+	return &vo
+}
+
+// VerifyImages verifies signatures of the images in the resource if the image profile is enabled.
+func (self *Verifier) VerifyImages(resource unstructured.Unstructured) (bool, string, error) {
+	if !self.param.ImageProfile.Enabled() {
+		return true, "", nil
+	}
+	verified, err := ishieldimage.VerifyImageInManifest(resource, self.param.ImageProfile)
+	if err != nil {
+		log.Errorf("failed to verify images: %s", err.Error())
+		return false, "Image signature verification is required, but failed to verify signature: " + err.Error(), err
+	}
+	if !verified {
+		return false, "Image signature verification is required, but failed to verify signature", nil
+	}
+	return true, "", nil
+}
+
+// Verify checks the filters, and verifies the signature of the resource and its images.
+func (self *Verifier) Verify(resource unstructured.Unstructured, userName string) *VerifyResult {
+	if self.loadNamespaceDefaults != nil {
+		return self.forResource(resource).Verify(resource, userName)
+	}
+	if ok, msg := self.CheckFilters(resource, userName); !ok {
+		return &VerifyResult{Allow: true, InScope: false, Message: msg, ImageAllow: true}
+	}
+	vo := self.verifyOption(resource, userName)
+	log.WithFields(log.Fields{
+		"namespace": resource.GetNamespace(),
+		"name":      resource.GetName(),
+		"kind":      resource.GetKind(),
+	}).Debug("VerifyOption: ", vo)
+	// the message of the bundle is embedded in the resource, so that provenance refers to the signed manifest
+	target := resource
+	var signedBundle *bundle.Bundle
+	var result *k8smanifest.VerifyResourceResult
+	var err error
+	if self.param.SignatureRef.BundleRef.Enabled() {
+		signedBundle, err = bundle.Load(self.param.SignatureRef.BundleRef)
+		if err != nil {
+			log.Warningf("failed to load the signed manifest bundle; %s", err.Error())
+			return &VerifyResult{Allow: false, InScope: true, Message: err.Error(), Reason: ReasonVerificationError, Error: err, ImageAllow: true}
+		}
+		target = signedBundle.Embed(resource, vo.AnnotationConfig)
+		vo.ImageRef = ""
+		vo.SignatureResourceRef = ""
+		result, err = signedBundle.Verify(resource, vo)
+	} else {
+		result, err = k8smanifest.VerifyResource(resource, vo)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"namespace": resource.GetNamespace(),
+			"name":      resource.GetName(),
+			"kind":      resource.GetKind(),
+		}).Warningf("Signature verification is required for this request, but verifyResource return error ; %s", err.Error())
+		return &VerifyResult{Allow: false, InScope: true, Message: err.Error(), Reason: ReasonVerificationError, Error: err, ImageAllow: true}
+	}
+
+	provSigRef := result.SigRef
+	if signedBundle != nil {
+		result.SigRef = signedBundle.Ref
+		provSigRef = ""
+	}
+	res := &VerifyResult{InScope: result.InScope, VerifyResourceResult: result}
+	if result.InScope {
+		if result.Verified {
+			res.Allow = true
+			res.Message = fmt.Sprintf("singed by a valid signer: %s", result.Signer)
+		} else {
+			res.Allow = false
+			res.Message = "Signature verification is required for this request, but no signature is found."
+			res.Reason = ReasonNoSignature
+			if result.Diff != nil && result.Diff.Size() > 0 {
+				res.Message = fmt.Sprintf("Signature verification is required for this request, but failed to verify signature. diff found: %s", result.Diff.String())
+				res.Reason = ReasonSignatureMismatch
+			} else if result.Signer != "" {
+				res.Message = fmt.Sprintf("Signature verification is required for this request, but no signer config matches with this resource. This is signed by %s", result.Signer)
+				res.Reason = ReasonSignerNotAllowed
+			}
+		}
+	} else {
+		res.Allow = true
+		res.Message = "not protected"
+	}
+
+	// image verify
+	res.ImageAllow, res.ImageMessage, res.ImageError = self.VerifyImages(resource)
+	if res.Allow && !res.ImageAllow {
+		res.Allow = false
+		res.Message = res.ImageMessage
+		res.Reason = ReasonImageNotVerified
+	}
+
+	// provenance verify
+	if res.Allow && res.InScope {
+		var provAllow bool
+		var provMsg string
+		provAllow, provMsg, res.Provenances = self.VerifyProvenance(target, provSigRef)
+		if !provAllow {
+			res.Allow = false
+			res.Message = provMsg
+			res.Reason = ReasonProvenanceNotVerified
+		}
+	}
+	res.Verified = res.Allow && result.InScope && result.Verified
+	return res
+}
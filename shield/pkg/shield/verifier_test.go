@@ -0,0 +1,297 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package shield
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	"github.com/ghodss/yaml"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	"golang.org/x/crypto/openpgp"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func makeTestResource(name string, annotations map[string]string) unstructured.Unstructured {
+	obj := unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("sample-ns")
+	obj.SetName(name)
+	obj.SetAnnotations(annotations)
+	return obj
+}
+
+func TestVerifierCheckFilters(t *testing.T) {
+	param := &k8smnfconfig.ParameterObject{
+		InScopeObjects: k8smanifest.ObjectReferenceList{{Kind: "ConfigMap"}},
+		SkipUsers:      k8smnfconfig.ObjectUserBindingList{{Users: []string{"system:admin"}}},
+		InScopeUsers:   k8smnfconfig.ObjectUserBindingList{{Objects: k8smanifest.ObjectReferenceList{{Name: "forced-cm"}}, Users: []string{"system:admin"}}},
+	}
+	rhconfig := &k8smnfconfig.RequestHandlerConfig{
+		RequestFilterProfile: k8smnfconfig.RequestFilterProfile{
+			SkipObjects: k8smanifest.ObjectReferenceList{{Kind: "ConfigMap", Name: "skipped-cm"}},
+			SkipUsers:   k8smnfconfig.ObjectUserBindingList{{Objects: k8smanifest.ObjectReferenceList{{Name: "any-user-cm"}}, Users: []string{"*"}}},
+		},
+	}
+	v := NewVerifier(param, rhconfig)
+	testcases := []struct {
+		name     string
+		userName string
+		expected bool
+	}{
+		{name: "sample-cm", userName: "developer", expected: true},
+		{name: "sample-cm", userName: "system:admin", expected: false},
+		{name: "forced-cm", userName: "system:admin", expected: true},
+		{name: "skipped-cm", userName: "developer", expected: false},
+		// existing resources have no requester; only skip user rules for any user are applied
+		{name: "sample-cm", userName: "", expected: true},
+		{name: "any-user-cm", userName: "", expected: false},
+	}
+	for _, tc := range testcases {
+		ok, msg := v.CheckFilters(makeTestResource(tc.name, nil), tc.userName)
+		if ok != tc.expected {
+			t.Errorf("unexpected filter result for `%s` by `%s`: got: %v (%s)\nwant: %v", tc.name, tc.userName, ok, msg, tc.expected)
+			return
+		}
+	}
+	secret := makeTestResource("sample-secret", nil)
+	secret.SetKind("Secret")
+	if ok, _ := v.CheckFilters(secret, "developer"); ok {
+		t.Errorf("resource out of objectSelector should be filtered out")
+		return
+	}
+}
+
+func TestVerifierVerifyOption(t *testing.T) {
+	param := &k8smnfconfig.ParameterObject{
+		SignatureRef: k8smnfconfig.SignatureRef{ImageRef: "sample-registry/sample-signature:0.1.0"},
+	}
+	param.IgnoreFields = k8smanifest.ObjectFieldBindingList{{Fields: []string{"data.key1"}}}
+	rhconfig := &k8smnfconfig.RequestHandlerConfig{
+		RequestFilterProfile: k8smnfconfig.RequestFilterProfile{
+			IgnoreFields: k8smanifest.ObjectFieldBindingList{{Fields: []string{"metadata.labels"}}},
+		},
+	}
+	v := NewVerifier(param, rhconfig)
+	resource := makeTestResource("sample-cm", map[string]string{ImageRefAnnotationKeyShield: "sample-registry/sample-signature:0.1.0"})
+	// options are built twice to check the parameter is not changed
+	for i := 0; i < 2; i++ {
+		vo := v.VerifyOption(resource)
+		// fields of the parameter, the request handler config and the violation marker
+		if len(vo.IgnoreFields) != 3 {
+			t.Errorf("unexpected ignore fields: got: %v\nwant: 3 fields", vo.IgnoreFields)
+			return
+		}
+		if vo.ImageRef != param.SignatureRef.ImageRef {
+			t.Errorf("unexpected imageRef: got: %v\nwant: %v", vo.ImageRef, param.SignatureRef.ImageRef)
+			return
+		}
+		if vo.AnnotationConfig.AnnotationKeyDomain != AnnotationKeyDomain {
+			t.Errorf("unexpected annotation key domain: got: %v\nwant: %v", vo.AnnotationConfig.AnnotationKeyDomain, AnnotationKeyDomain)
+			return
+		}
+	}
+	if len(param.IgnoreFields) != 1 {
+		t.Errorf("parameter should not be changed: got: %v", param.IgnoreFields)
+		return
+	}
+}
+
+func TestVerifierVerifyOptionViolationMarker(t *testing.T) {
+	observer := "system:serviceaccount:integrity-shield-operator-system:integrity-shield-observer-sa"
+	_ = os.Setenv(ObserverServiceAccountEnvKey, observer)
+	defer os.Unsetenv(ObserverServiceAccountEnvKey)
+
+	resource := makeTestResource("sample-cm", map[string]string{ViolationMarkerKey: "constraint-a"})
+	v := NewVerifier(&k8smnfconfig.ParameterObject{}, &k8smnfconfig.RequestHandlerConfig{})
+	// the marker is ignored for existing resources and requests from the observer only
+	testcases := map[string]bool{"": true, observer: true, "sample-user": false}
+	for userName, ignored := range testcases {
+		vo := v.verifyOption(resource, userName)
+		if ok, _ := vo.IgnoreFields.Match(resource); ok != ignored {
+			t.Errorf("unexpected ignore fields for user `%s`: got: %v\nwant ignored: %v", userName, vo.IgnoreFields, ignored)
+			return
+		}
+	}
+}
+
+func TestVerifierVerifyOptionInjectedSignatureRef(t *testing.T) {
+	resource := makeTestResource("sample-cm", map[string]string{SignatureResourceRefAnnotationKey: "sample-ns/sample-cm-signature"})
+	v := NewVerifier(&k8smnfconfig.ParameterObject{}, &k8smnfconfig.RequestHandlerConfig{})
+	vo := v.VerifyOption(resource)
+	want := "k8s://ConfigMap/sample-ns/sample-cm-signature"
+	if vo.SignatureResourceRef != want {
+		t.Errorf("unexpected signatureResourceRef: got: %v\nwant: %v", vo.SignatureResourceRef, want)
+		return
+	}
+	if ok, fields := vo.IgnoreFields.Match(resource); !ok || len(fields) != 1+len(builtinIgnoreFields) {
+		t.Errorf("injected annotation should be ignored: got: %v", fields)
+		return
+	}
+	// signature ref of the constraint takes precedence
+	param := &k8smnfconfig.ParameterObject{
+		SignatureRef: k8smnfconfig.SignatureRef{ImageRef: "sample-registry/sample-signature:0.1.0"},
+	}
+	vo = NewVerifier(param, &k8smnfconfig.RequestHandlerConfig{}).VerifyOption(resource)
+	if vo.SignatureResourceRef != "" {
+		t.Errorf("unexpected signatureResourceRef: got: %v\nwant: empty", vo.SignatureResourceRef)
+		return
+	}
+}
+
+func TestVerifierVerify(t *testing.T) {
+	v := NewVerifier(&k8smnfconfig.ParameterObject{}, nil)
+	res := v.Verify(makeTestResource("sample-cm", nil), "developer")
+	if res.Allow || res.Error == nil {
+		t.Errorf("unsigned resource should not be allowed: got: %v, %v", res.Allow, res.Message)
+		return
+	}
+	v = NewVerifier(&k8smnfconfig.ParameterObject{
+		SkipUsers: k8smnfconfig.ObjectUserBindingList{{Users: []string{"developer"}}},
+	}, nil)
+	res = v.Verify(makeTestResource("sample-cm", nil), "developer")
+	if !res.Allow || res.InScope {
+		t.Errorf("resource by skip user should be allowed: got: %v, %v", res.Allow, res.Message)
+		return
+	}
+}
+
+// makeTestBundle signs the resource with a new PGP key and writes the bundle and the public key into the directory.
+func makeTestBundle(dir string, resource unstructured.Unstructured) (string, string, error) {
+	entity, err := openpgp.NewEntity("sample-signer", "", "sample-signer@example.com", nil)
+	if err != nil {
+		return "", "", err
+	}
+	var pubkey bytes.Buffer
+	if err = entity.Serialize(&pubkey); err != nil {
+		return "", "", err
+	}
+	keyPath := filepath.Join(dir, "pubring.gpg")
+	if err = ioutil.WriteFile(keyPath, pubkey.Bytes(), 0644); err != nil {
+		return "", "", err
+	}
+
+	manifest, _ := yaml.Marshal(resource.Object)
+	var tarGz bytes.Buffer
+	gw := gzip.NewWriter(&tarGz)
+	tw := tar.NewWriter(gw)
+	if err = tw.WriteHeader(&tar.Header{Name: "configmap.yaml", Mode: 0644, Size: int64(len(manifest))}); err != nil {
+		return "", "", err
+	}
+	_, _ = tw.Write(manifest)
+	tw.Close()
+	gw.Close()
+	var sig bytes.Buffer
+	if err = openpgp.ArmoredDetachSign(&sig, entity, bytes.NewReader(tarGz.Bytes()), nil); err != nil {
+		return "", "", err
+	}
+
+	bundleDir := filepath.Join(dir, "bundle")
+	_ = os.MkdirAll(bundleDir, os.ModePerm)
+	message := base64.StdEncoding.EncodeToString(k8smnfutil.GzipCompress(tarGz.Bytes()))
+	if err = ioutil.WriteFile(filepath.Join(bundleDir, k8smanifest.MessageAnnotationBaseName), []byte(message), 0644); err != nil {
+		return "", "", err
+	}
+	signature := base64.StdEncoding.EncodeToString(sig.Bytes())
+	if err = ioutil.WriteFile(filepath.Join(bundleDir, k8smanifest.SignatureAnnotationBaseName), []byte(signature), 0644); err != nil {
+		return "", "", err
+	}
+	return bundleDir, keyPath, nil
+}
+
+func TestVerifierVerifyBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify-bundle")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	resource := makeTestResource("sample-cm", nil)
+	_ = unstructured.SetNestedStringMap(resource.Object, map[string]string{"key": "value"}, "data")
+	bundleDir, keyPath, err := makeTestBundle(dir, resource)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	param := &k8smnfconfig.ParameterObject{}
+	param.SignatureRef.BundleRef.Path = bundleDir
+	param.KeyPath = keyPath
+	v := NewVerifier(param, nil)
+	res := v.Verify(resource, "developer")
+	if !res.Allow {
+		t.Errorf("resource in the bundle should be allowed: got: %v", res.Message)
+		return
+	}
+	if res.VerifyResourceResult.Signer != "sample-signer@example.com" || !strings.HasPrefix(res.VerifyResourceResult.SigRef, "file://") {
+		t.Errorf("unexpected verify result: got: %v", res.VerifyResourceResult)
+		return
+	}
+
+	tampered := resource.DeepCopy()
+	_ = unstructured.SetNestedStringMap(tampered.Object, map[string]string{"key": "tampered"}, "data")
+	res = v.Verify(*tampered, "developer")
+	if res.Allow {
+		t.Errorf("tampered resource should not be allowed: got: %v", res.Message)
+		return
+	}
+}
+
+func TestVerifierNamespaceDefaults(t *testing.T) {
+	loaded := 0
+	v := NewVerifier(&k8smnfconfig.ParameterObject{}, nil)
+	v.loadNamespaceDefaults = func(namespace string) (*k8smnfconfig.NamespaceDefaults, error) {
+		loaded++
+		if namespace != "sample-ns" {
+			return nil, nil
+		}
+		return &k8smnfconfig.NamespaceDefaults{SignatureRef: k8smnfconfig.SignatureRef{ImageRef: "sample-registry/sample-manifest:0.1.0"}}, nil
+	}
+	vo := v.VerifyOption(makeTestResource("sample-cm", nil))
+	if vo.ImageRef != "sample-registry/sample-manifest:0.1.0" {
+		t.Errorf("unexpected imageRef: got: %v", vo.ImageRef)
+		return
+	}
+	// defaults are loaded once per namespace
+	_ = v.Parameters(makeTestResource("other-cm", nil))
+	other := makeTestResource("other-cm", nil)
+	other.SetNamespace("other-ns")
+	param := v.Parameters(other)
+	if param.SignatureRef.ImageRef != "" || loaded != 2 {
+		t.Errorf("unexpected parameters: got: %v, loaded %v times", param.SignatureRef, loaded)
+		return
+	}
+}
+
+func TestVerifySignedConfigMapWithoutKeys(t *testing.T) {
+	v := NewVerifier(&k8smnfconfig.ParameterObject{}, nil)
+	verified, err := v.verifySignedConfigMap(makeTestResource("sample-cm", nil))
+	if verified || err == nil {
+		t.Errorf("configmap must not be verified without keys: got: %v, %v", verified, err)
+		return
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/ghodss/yaml v1.0.0
 	github.com/jinzhu/copier v0.3.2
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.11.0
 	github.com/sigstore/cosign v1.1.0
 	github.com/sigstore/k8s-manifest-sigstore v0.0.0-20210909071548-2120192e4ff7
 	github.com/sirupsen/logrus v1.8.1
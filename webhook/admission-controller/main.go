@@ -18,8 +18,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"net/http"
 	"os"
+	"time"
 
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -30,6 +33,7 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -42,14 +46,20 @@ var (
 
 const tlsDir = `/run/secrets/tls`
 
-// +kubebuilder:webhook:path=/validate-resource,mutating=false,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups=*,resources=*,verbs=create;update,versions=*,name=k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
+// Resources are served on separate paths for namespaced and cluster scope resources.
+// The markers cannot express the scope and the namespace selector, so they are set in config/webhook.
+// +kubebuilder:webhook:path=/validate-namespaced-resource,mutating=false,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups=*,resources=*,verbs=create;update,versions=*,name=k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
+// +kubebuilder:webhook:path=/validate-cluster-resource,mutating=false,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups=*,resources=*,verbs=create;update,versions=*,name=cluster.k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
+// +kubebuilder:webhook:path=/validate-profile,mutating=false,failurePolicy=ignore,sideEffects=None,groups=apis.integrityshield.io,resources=manifestintegrityprofiles,verbs=create;update,versions=*,name=profile.k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
+// +kubebuilder:webhook:path=/mutate-namespaced-resource,mutating=true,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups=*,resources=*,verbs=create;update,versions=*,name=signature.k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
+// +kubebuilder:webhook:path=/mutate-cluster-resource,mutating=true,failurePolicy=ignore,sideEffects=NoneOnDryRun,groups=*,resources=*,verbs=create;update,versions=*,name=cluster.signature.k8smanifest.sigstore.dev,admissionReviewVersions={v1,v1beta1}
 
 type k8sManifestHandler struct {
 	Client client.Client
 }
 
 func (h *k8sManifestHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
-	res := ac.ProcessRequest(req)
+	res := ac.ProcessRequest(ctx, req)
 	return res
 }
 
@@ -62,30 +72,97 @@ func init() {
 
 func main() {
 	var metricsAddr string
+	var probeAddr string
 	var enableLeaderElection bool
+	var statusFlushInterval time.Duration
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-addr", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&statusFlushInterval, "status-flush-interval", 10*time.Second,
+		"The interval to write denials to the status of ManifestIntegrityProfiles.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "22a603b9.sigstore.dev",
-		CertDir:            tlsDir,
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		HealthProbeBindAddress: probeAddr,
+		Port:                   9443,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "22a603b9.sigstore.dev",
+		CertDir:                tlsDir,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// serve ManifestIntegrityProfiles and config from informer caches
+	cache, err := ac.NewCache(mgr.GetConfig(), 0)
+	if err != nil {
+		setupLog.Error(err, "unable to create informer cache")
+		os.Exit(1)
+	}
+	ac.SetCache(cache)
+	if err := mgr.Add(cache); err != nil {
+		setupLog.Error(err, "unable to add informer cache to manager")
+		os.Exit(1)
+	}
+	// write denials to ManifestIntegrityProfile status in batches
+	statusRecorder, err := ac.NewStatusRecorder(mgr.GetConfig(), statusFlushInterval)
+	if err != nil {
+		setupLog.Error(err, "unable to create status recorder")
+		os.Exit(1)
+	}
+	ac.SetStatusRecorder(statusRecorder)
+	if err := mgr.Add(statusRecorder); err != nil {
+		setupLog.Error(err, "unable to add status recorder to manager")
+		os.Exit(1)
+	}
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", func(_ *http.Request) error {
+		if !cache.HasSynced() {
+			return errors.New("informer caches are not synced")
+		}
+		return nil
+	}); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
 	hookServer := mgr.GetWebhookServer()
-	hookServer.Register("/validate-resource", &webhook.Admission{Handler: &k8sManifestHandler{Client: mgr.GetClient()}})
+	// both AdmissionReview v1 and v1beta1 are served at every path
+	handler := &k8sManifestHandler{Client: mgr.GetClient()}
+	for _, path := range []string{ac.ValidateResourcePath, ac.ValidateNamespacedResourcePath, ac.ValidateClusterResourcePath} {
+		hookServer.Register(path, &webhook.Admission{
+			Handler:         handler,
+			WithContextFunc: ac.WithRequestTimeout,
+		})
+	}
+
+	// validate ManifestIntegrityProfiles themselves
+	profileValidator, err := ac.NewProfileValidator(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create profile validator")
+		os.Exit(1)
+	}
+	hookServer.Register(ac.ValidateProfilePath, &webhook.Admission{Handler: profileValidator})
+
+	// inject signature references for resources which have no signature annotations
+	injector, err := ac.NewSignatureInjector(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create signature injector")
+		os.Exit(1)
+	}
+	for _, path := range []string{ac.MutateResourcePath, ac.MutateNamespacedResourcePath, ac.MutateClusterResourcePath} {
+		hookServer.Register(path, &webhook.Admission{Handler: injector})
+	}
 
 	// +kubebuilder:scaffold:builder
 
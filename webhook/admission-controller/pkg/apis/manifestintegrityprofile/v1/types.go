@@ -17,6 +17,7 @@
 package v1
 
 import (
+	"sort"
 	"time"
 
 	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
@@ -29,10 +30,40 @@ var layout = "2006-01-02 15:04:05"
 
 const maxHistoryLength = 10
 
+// maxStatisticsEntries is the max number of keys in each deny statistics; others are counted as statisticsOthersKey
+const maxStatisticsEntries = 50
+const statisticsOthersKey = "(others)"
+
+// clusterScopeKey is a namespace key for cluster scope resources in deny statistics
+const clusterScopeKey = "-"
+
 // ManifestIntegrityProfileSpec defines the desired state of AppEnforcePolicy
 type ManifestIntegrityProfileSpec struct {
 	Match      MatchCondition               `json:"match,omitempty"`
 	Parameters k8smnfconfig.ParameterObject `json:"parameters,omitempty"`
+	// Mode is one of `enforce`, `detect` or `disabled`. The mode of admission controller config is used if empty.
+	Mode string `json:"mode,omitempty"`
+	// Priority decides which profiles an allow-override profile takes precedence over.
+	Priority int `json:"priority,omitempty"`
+	// AllowOverride makes requests matched with this profile allowed without verification,
+	// even if they are denied by profiles with the same or lower priority (e.g. break-glass for a namespace).
+	AllowOverride bool `json:"allowOverride,omitempty"`
+}
+
+// modes of ManifestIntegrityProfile
+const (
+	ProfileModeEnforce  = "enforce"
+	ProfileModeDetect   = "detect"
+	ProfileModeDisabled = "disabled"
+)
+
+// GetMode returns the mode of the profile, or defaultMode if it is empty or unknown
+func (spec ManifestIntegrityProfileSpec) GetMode(defaultMode string) string {
+	switch spec.Mode {
+	case ProfileModeEnforce, ProfileModeDetect, ProfileModeDisabled:
+		return spec.Mode
+	}
+	return defaultMode
 }
 
 type MatchCondition struct {
@@ -50,14 +81,33 @@ type Kinds struct {
 
 // ManifestIntegrityProfileStatus defines the observed state of ManifestIntegrityProfile
 type ManifestIntegrityProfileStatus struct {
-	DenyCount  int                `json:"denyCount,omitempty"`
+	DenyCount int `json:"denyCount,omitempty"`
+	// AllowCount is the number of allowed requests which match the profile
+	AllowCount int `json:"allowCount,omitempty"`
+	// VerifiedCount is the number of requests allowed with a verified signature
+	VerifiedCount    int            `json:"verifiedCount,omitempty"`
+	LastVerifiedTime string         `json:"lastVerifiedTime,omitempty"`
+	DenyStatistics   DenyStatistics `json:"denyStatistics,omitempty"`
+	// the latest denials, newest first
 	Violations []*ViolationDetail `json:"violations,omitempty"`
 }
 
+// DenyStatistics is the number of denied requests aggregated by user, kind, namespace and reason.
+// Cluster scope resources are counted in "-" namespace.
+type DenyStatistics struct {
+	ByUser      map[string]int `json:"byUser,omitempty"`
+	ByKind      map[string]int `json:"byKind,omitempty"`
+	ByNamespace map[string]int `json:"byNamespace,omitempty"`
+	ByReason    map[string]int `json:"byReason,omitempty"`
+}
+
 type ViolationDetail struct {
 	Namespace string `json:"namespace,omitempty"`
 	Kind      string `json:"kind,omitempty"`
 	Name      string `json:"name,omitempty"`
+	User      string `json:"user,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	Reason    string `json:"reason,omitempty"`
 	Message   string `json:"message,omitempty"`
 	Timestamp string `json:"timestamp,omitempty"`
 }
@@ -94,24 +144,118 @@ func (p *MatchCondition) DeepCopyInto(p2 *MatchCondition) {
 }
 
 func (self *ManifestIntegrityProfile) UpdateStatus(request admission.Request, errMsg string) *ManifestIntegrityProfile {
+	self.Status.RecordDenial(request, errMsg, "")
+	return self
+}
 
-	// Increment DenyCount
-	self.Status.DenyCount = self.Status.DenyCount + 1
-
-	// Update Latest events
+// RecordDenial counts a request which is denied by the profile
+func (self *ManifestIntegrityProfileStatus) RecordDenial(request admission.Request, errMsg, reason string) {
 	violation := &ViolationDetail{
 		Kind:      request.Kind.Kind,
 		Namespace: request.Namespace,
 		Name:      request.Name,
+		User:      request.UserInfo.Username,
+		Operation: string(request.Operation),
+		Reason:    reason,
 		Message:   errMsg,
 		Timestamp: time.Now().UTC().Format(layout),
 	}
+	self.Merge(&ManifestIntegrityProfileStatus{
+		DenyCount:      1,
+		DenyStatistics: newDenyStatistics(violation),
+		Violations:     []*ViolationDetail{violation},
+	})
+}
+
+// RecordAllowed counts a request which matches the profile and is allowed
+func (self *ManifestIntegrityProfileStatus) RecordAllowed(verified bool) {
+	delta := &ManifestIntegrityProfileStatus{AllowCount: 1}
+	if verified {
+		delta.VerifiedCount = 1
+		delta.LastVerifiedTime = time.Now().UTC().Format(layout)
+	}
+	self.Merge(delta)
+}
+
+// Merge adds counts and statistics of delta, and merges its violations into the latest events newest first.
+// Violations with the same timestamp keep delta first, so delta is expected to be the newer one.
+func (self *ManifestIntegrityProfileStatus) Merge(delta *ManifestIntegrityProfileStatus) {
+	self.DenyCount = self.DenyCount + delta.DenyCount
+	self.AllowCount = self.AllowCount + delta.AllowCount
+	self.VerifiedCount = self.VerifiedCount + delta.VerifiedCount
+	// timestamps in the layout can be compared as strings
+	if delta.LastVerifiedTime > self.LastVerifiedTime {
+		self.LastVerifiedTime = delta.LastVerifiedTime
+	}
+	self.DenyStatistics.merge(delta.DenyStatistics)
+
+	// Update Latest events
 	newLatestEvents := []*ViolationDetail{}
-	newLatestEvents = append(newLatestEvents, violation)
-	newLatestEvents = append(newLatestEvents, self.Status.Violations...)
+	newLatestEvents = append(newLatestEvents, delta.Violations...)
+	newLatestEvents = append(newLatestEvents, self.Violations...)
+	// timestamps in the layout can be compared as strings
+	sort.SliceStable(newLatestEvents, func(i, j int) bool {
+		return newLatestEvents[i].Timestamp > newLatestEvents[j].Timestamp
+	})
 	if len(newLatestEvents) > maxHistoryLength {
 		newLatestEvents = newLatestEvents[:maxHistoryLength]
 	}
-	self.Status.Violations = newLatestEvents
-	return self
+	self.Violations = newLatestEvents
+}
+
+func newDenyStatistics(violation *ViolationDetail) DenyStatistics {
+	namespace := violation.Namespace
+	if namespace == "" {
+		namespace = clusterScopeKey
+	}
+	return DenyStatistics{
+		ByUser:      map[string]int{violation.User: 1},
+		ByKind:      map[string]int{violation.Kind: 1},
+		ByNamespace: map[string]int{namespace: 1},
+		ByReason:    map[string]int{violation.Reason: 1},
+	}
+}
+
+func (self *DenyStatistics) merge(delta DenyStatistics) {
+	self.ByUser = mergeCounts(self.ByUser, delta.ByUser)
+	self.ByKind = mergeCounts(self.ByKind, delta.ByKind)
+	self.ByNamespace = mergeCounts(self.ByNamespace, delta.ByNamespace)
+	self.ByReason = mergeCounts(self.ByReason, delta.ByReason)
+}
+
+// mergeCounts adds delta to counts. Keys beyond maxStatisticsEntries are counted as statisticsOthersKey
+// so that status does not grow with the number of users or namespaces.
+// New keys of delta take the free entries in descending order of counts, and ties in order of keys, so that the result is stable.
+func mergeCounts(counts, delta map[string]int) map[string]int {
+	if len(delta) == 0 {
+		return counts
+	}
+	if counts == nil {
+		counts = map[string]int{}
+	}
+	normalized := map[string]int{}
+	for key, n := range delta {
+		if key == "" {
+			key = "unknown"
+		}
+		normalized[key] = normalized[key] + n
+	}
+	keys := []string{}
+	for key := range normalized {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if normalized[keys[i]] != normalized[keys[j]] {
+			return normalized[keys[i]] > normalized[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		n := normalized[key]
+		if _, ok := counts[key]; !ok && len(counts) >= maxStatisticsEntries {
+			key = statisticsOthersKey
+		}
+		counts[key] = counts[key] + n
+	}
+	return counts
 }
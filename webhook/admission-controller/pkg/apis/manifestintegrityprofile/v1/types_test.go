@@ -0,0 +1,60 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package v1
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestMergeCountsOverMaxEntries(t *testing.T) {
+	// user-00 has the largest count, and the others have the same count
+	delta := map[string]int{}
+	for i := 0; i < maxStatisticsEntries+10; i++ {
+		delta[fmt.Sprintf("user-%02d", i)] = 1
+	}
+	delta["user-00"] = 5
+	delta["user-59"] = 3
+
+	var first map[string]int
+	// map iteration order is random, so merge several times to check the result is stable
+	for i := 0; i < 20; i++ {
+		counts := mergeCounts(map[string]int{"existing": 1}, delta)
+		if len(counts) != maxStatisticsEntries+1 {
+			t.Errorf("unexpected number of keys: got: %d\nwant: %d", len(counts), maxStatisticsEntries+1)
+			return
+		}
+		if first == nil {
+			first = counts
+			continue
+		}
+		if !reflect.DeepEqual(counts, first) {
+			t.Errorf("merged counts differ for the same input: got: %v\nwant: %v", counts, first)
+			return
+		}
+	}
+	// keys with larger counts take the free entries first, and ties are taken in order of keys
+	want := map[string]int{"existing": 1, "user-00": 5, "user-59": 3}
+	for i := 1; i <= maxStatisticsEntries-3; i++ {
+		want[fmt.Sprintf("user-%02d", i)] = 1
+	}
+	want[statisticsOthersKey] = maxStatisticsEntries + 10 - 2 - (maxStatisticsEntries - 3)
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("unexpected counts: got: %v\nwant: %v", first, want)
+	}
+}
@@ -24,6 +24,50 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DenyStatistics) DeepCopyInto(out *DenyStatistics) {
+	*out = *in
+	if in.ByUser != nil {
+		in, out := &in.ByUser, &out.ByUser
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ByKind != nil {
+		in, out := &in.ByKind, &out.ByKind
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ByNamespace != nil {
+		in, out := &in.ByNamespace, &out.ByNamespace
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ByReason != nil {
+		in, out := &in.ByReason, &out.ByReason
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DenyStatistics.
+func (in *DenyStatistics) DeepCopy() *DenyStatistics {
+	if in == nil {
+		return nil
+	}
+	out := new(DenyStatistics)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Kinds) DeepCopyInto(out *Kinds) {
 	*out = *in
@@ -132,6 +176,7 @@ func (in *ManifestIntegrityProfileSpec) DeepCopy() *ManifestIntegrityProfileSpec
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ManifestIntegrityProfileStatus) DeepCopyInto(out *ManifestIntegrityProfileStatus) {
 	*out = *in
+	in.DenyStatistics.DeepCopyInto(&out.DenyStatistics)
 	if in.Violations != nil {
 		in, out := &in.Violations, &out.Violations
 		*out = make([]*ViolationDetail, len(*in))
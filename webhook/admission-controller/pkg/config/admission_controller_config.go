@@ -17,21 +17,67 @@
 package config
 
 import (
+	"os"
+
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
 	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	log "github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 type AdmissionControllerConfig struct {
-	InScopeNamespaceSelector NamespaceSelector `json:"inScopeNamespaceSelector,omitempty"`
-	Allow                    Allow             `json:"allow,omitempty"`
-	SideEffect               SideEffectConfig  `json:"sideEffect,omitempty"`
-	Mode                     string            `json:"mode,omitempty"`
-	Options                  []string          `json:"option,omitempty"`
+	InScopeNamespaceSelector NamespaceSelector        `json:"inScopeNamespaceSelector,omitempty"`
+	Allow                    Allow                    `json:"allow,omitempty"`
+	SideEffect               SideEffectConfig         `json:"sideEffect,omitempty"`
+	Mode                     string                   `json:"mode,omitempty"`
+	FailurePolicy            string                   `json:"failurePolicy,omitempty"`
+	SignatureInjection       SignatureInjectionConfig `json:"signatureInjection,omitempty"`
+	Options                  []string                 `json:"option,omitempty"`
+}
+
+// SignatureInjectionConfig lets the mutating webhook attach signature references to resources
+// which are applied without signature annotations (e.g. by GitOps tools which strip annotations)
+type SignatureInjectionConfig struct {
+	Enabled bool             `json:"enabled,omitempty"`
+	Stores  []SignatureStore `json:"stores,omitempty"`
+	// annotation key domain of the imageRef annotation. If empty, "cosign.sigstore.dev" is used.
+	AnnotationKeyDomain string `json:"annotationKeyDomain,omitempty"`
+}
+
+// SignatureStore is a place where signatures of the matched resources are stored.
+// `{namespace}`, `{kind}` and `{name}` in the references are replaced with those of the resource.
+type SignatureStore struct {
+	Match k8smanifest.ObjectReferenceList `json:"match,omitempty"`
+	// OCI image which contains the signed manifests
+	ImageRef string `json:"imageRef,omitempty"`
+	// ConfigMap which holds the signature and the signed manifests
+	ConfigMap SignatureConfigMapRef `json:"configMap,omitempty"`
 }
 
+type SignatureConfigMapRef struct {
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// failure policies which decide the response when config or constraints cannot be loaded
+const (
+	FailurePolicyOpen                         = "open"
+	FailurePolicyClosed                       = "closed"
+	FailurePolicyClosedForProtectedNamespaces = "closedForProtectedNamespaces"
+)
+
+const defaultFailurePolicy = FailurePolicyOpen
+
+// FailurePolicyEnvKey is the env which the operator sets to the failure policy of the admission controller config.
+// It is used when the config itself cannot be loaded.
+const FailurePolicyEnvKey = "FAILURE_POLICY"
+
 type NamespaceSelector struct {
 	Include []string `json:"include,omitempty"`
 	Exclude []string `json:"exclude,omitempty"`
+	// LabelSelector selects namespaces by their labels in addition to include/exclude name patterns
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
 }
 
 type Allow struct {
@@ -41,8 +87,11 @@ type Allow struct {
 type SideEffectConfig struct {
 	// ManifestIntegrityProfile
 	UpdateMIPStatusForDeniedRequest bool `json:"updateMIPStatusForDeniedRequest"`
+	// counts allowed and verified requests in the status as well
+	UpdateMIPStatusForAllowedRequest bool `json:"updateMIPStatusForAllowedRequest"`
 }
 
+// Match checks the namespace name with include/exclude patterns. LabelSelector is checked by MatchLabels.
 func (ns NamespaceSelector) Match(rns string) bool {
 	excluded := false
 	included := false
@@ -62,6 +111,20 @@ func (ns NamespaceSelector) Match(rns string) bool {
 	return true
 }
 
+// MatchLabels returns true if LabelSelector is empty or matches the labels of a namespace.
+// An invalid LabelSelector matches any namespace so that a typo does not leave namespaces unprotected.
+func (ns NamespaceSelector) MatchLabels(nsLabels map[string]string) bool {
+	if ns.LabelSelector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ns.LabelSelector)
+	if err != nil {
+		log.Errorf("failed to convert the LabelSelector api type into a struct that implements labels.Selector; the namespace is treated as in scope; %s", err.Error())
+		return true
+	}
+	return selector.Matches(labels.Set(nsLabels))
+}
+
 func (allow Allow) Match(kind metav1.GroupVersionKind) bool {
 	for _, k := range allow.Kinds {
 		var groupMatch bool
@@ -92,3 +155,23 @@ func (allow Allow) Match(kind metav1.GroupVersionKind) bool {
 func CheckIfDetectOnly(mode string) bool {
 	return mode == "detect"
 }
+
+// GetFailurePolicy returns the failure policy in the config, or the one in the env if the config is nil or does not set it.
+// The default one is returned only if neither sets it, and unknown values are treated as `closed`.
+func (ac *AdmissionControllerConfig) GetFailurePolicy() string {
+	policy := ""
+	if ac != nil {
+		policy = ac.FailurePolicy
+	}
+	if policy == "" {
+		policy = os.Getenv(FailurePolicyEnvKey)
+	}
+	switch policy {
+	case "":
+		return defaultFailurePolicy
+	case FailurePolicyOpen, FailurePolicyClosed, FailurePolicyClosedForProtectedNamespaces:
+		return policy
+	}
+	log.Warningf("unknown failure policy `%s`; `%s` is used instead", policy, FailurePolicyClosed)
+	return FailurePolicyClosed
+}
@@ -0,0 +1,65 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package config
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNamespaceSelectorMatchLabels(t *testing.T) {
+	testcases := []struct {
+		name     string
+		selector *metav1.LabelSelector
+		labels   map[string]string
+		want     bool
+	}{
+		{
+			name:     "no selector",
+			selector: nil,
+			labels:   map[string]string{"env": "dev"},
+			want:     true,
+		},
+		{
+			name:     "matched selector",
+			selector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			labels:   map[string]string{"env": "prod"},
+			want:     true,
+		},
+		{
+			name:     "unmatched selector",
+			selector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			labels:   map[string]string{"env": "dev"},
+			want:     false,
+		},
+		{
+			name: "invalid selector is treated as in scope",
+			selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "env", Operator: "in", Values: []string{"prod"}},
+			}},
+			labels: map[string]string{"env": "dev"},
+			want:   true,
+		},
+	}
+	for _, tc := range testcases {
+		ns := NamespaceSelector{LabelSelector: tc.selector}
+		if got := ns.MatchLabels(tc.labels); got != tc.want {
+			t.Errorf("%s: got: %v\nwant: %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	miprofile "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/apis/manifestintegrityprofile/v1"
+	mipversioned "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/client/manifestintegrityprofile/clientset/versioned"
+	mipinformers "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/client/manifestintegrityprofile/informers/externalversions"
+	miplisters "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/client/manifestintegrityprofile/listers/manifestintegrityprofile/v1"
+	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	kubeclient "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+const defaultCacheResyncPeriod = 10 * time.Minute
+
+// sharedCache is used by ProcessRequest once it is set by SetCache.
+// If it is nil or not synced yet, profiles and config are loaded from the API server directly.
+var sharedCache *Cache
+
+// Cache serves ManifestIntegrityProfiles, the admission controller config and namespaces
+// from shared informers instead of the API server
+type Cache struct {
+	profileFactory   mipinformers.SharedInformerFactory
+	kubeFactory      kubeinformers.SharedInformerFactory
+	namespaceFactory kubeinformers.SharedInformerFactory
+	profileLister    miplisters.ManifestIntegrityProfileLister
+	configLister     corelisters.ConfigMapLister
+	namespaceLister  corelisters.NamespaceLister
+	informerSynced   []cache.InformerSynced
+
+	namespace  string
+	configName string
+	configKey  string
+
+	synced int32
+}
+
+// NewCache creates informers for ManifestIntegrityProfiles, the admission controller ConfigMap and namespaces
+func NewCache(config *rest.Config, resync time.Duration) (*Cache, error) {
+	mipClient, err := mipversioned.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a clientset for ManifestIntegrityProfile")
+	}
+	kubeClient, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a kubernetes clientset")
+	}
+	return newCache(mipClient, kubeClient, resync), nil
+}
+
+func newCache(mipClient mipversioned.Interface, kubeClient kubeclient.Interface, resync time.Duration) *Cache {
+	if resync == 0 {
+		resync = defaultCacheResyncPeriod
+	}
+	namespace, configName, configKey := getAdmissionControllerConfigRef()
+
+	profileFactory := mipinformers.NewSharedInformerFactory(mipClient, resync)
+	profileInformer := profileFactory.Apis().V1().ManifestIntegrityProfiles()
+
+	// only the ConfigMap of the admission controller config is watched
+	kubeFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resync,
+		kubeinformers.WithNamespace(namespace),
+		kubeinformers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", configName).String()
+		}),
+	)
+	configInformer := kubeFactory.Core().V1().ConfigMaps()
+
+	// labels of namespaces are used by namespace selectors
+	namespaceFactory := kubeinformers.NewSharedInformerFactory(kubeClient, resync)
+	namespaceInformer := namespaceFactory.Core().V1().Namespaces()
+
+	return &Cache{
+		profileFactory:   profileFactory,
+		kubeFactory:      kubeFactory,
+		namespaceFactory: namespaceFactory,
+		profileLister:    profileInformer.Lister(),
+		configLister:     configInformer.Lister(),
+		namespaceLister:  namespaceInformer.Lister(),
+		informerSynced: []cache.InformerSynced{
+			profileInformer.Informer().HasSynced,
+			configInformer.Informer().HasSynced,
+			namespaceInformer.Informer().HasSynced,
+		},
+		namespace:  namespace,
+		configName: configName,
+		configKey:  configKey,
+	}
+}
+
+// SetCache makes ProcessRequest use the given cache
+func SetCache(c *Cache) {
+	sharedCache = c
+}
+
+// Start runs the informers and blocks until the caches are synced or ctx is done
+func (c *Cache) Start(ctx context.Context) error {
+	stopCh := ctx.Done()
+	c.profileFactory.Start(stopCh)
+	c.kubeFactory.Start(stopCh)
+	c.namespaceFactory.Start(stopCh)
+	log.Info("waiting for informer caches to sync")
+	if !cache.WaitForCacheSync(stopCh, c.informerSynced...) {
+		return errors.New("failed to wait for informer caches to sync")
+	}
+	atomic.StoreInt32(&c.synced, 1)
+	log.Info("informer caches are synced")
+	return nil
+}
+
+// NeedLeaderElection returns false so that every replica serves from its own cache
+func (c *Cache) NeedLeaderElection() bool {
+	return false
+}
+
+// HasSynced returns true once all informer caches are synced
+func (c *Cache) HasSynced() bool {
+	return atomic.LoadInt32(&c.synced) == 1
+}
+
+// Constraints returns ManifestIntegrityProfiles from the cache
+func (c *Cache) Constraints() ([]miprofile.ManifestIntegrityProfile, error) {
+	mips, err := c.profileLister.List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list ManifestIntegrityProfiles from cache")
+	}
+	// listers return objects in random order; keep the order of a LIST request
+	sort.Slice(mips, func(i, j int) bool {
+		return mips[i].Name < mips[j].Name
+	})
+	items := []miprofile.ManifestIntegrityProfile{}
+	for _, mip := range mips {
+		items = append(items, *(mip.DeepCopy()))
+	}
+	return items, nil
+}
+
+// AdmissionControllerConfig returns the admission controller config from the cached ConfigMap
+func (c *Cache) AdmissionControllerConfig() (*acconfig.AdmissionControllerConfig, error) {
+	cm, err := c.configLister.ConfigMaps(c.namespace).Get(c.configName)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace from cache", c.configName, c.namespace))
+	}
+	return parseAdmissionControllerConfig(cm.Data, c.configKey)
+}
+
+// NamespaceLabels returns labels of the namespace from the cache
+func (c *Cache) NamespaceLabels(name string) (map[string]string, error) {
+	ns, err := c.namespaceLister.Get(name)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a namespace `%s` from cache", name))
+	}
+	return ns.GetLabels(), nil
+}
@@ -0,0 +1,71 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	mipfake "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/client/manifestintegrityprofile/clientset/versioned/fake"
+	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIsInScopeNamespace(t *testing.T) {
+	protected := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   "protected-ns",
+		Labels: map[string]string{"integrityshield.io/protected": "true"},
+	}}
+	unlabeled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "sample-ns"}}
+	c := newCache(mipfake.NewSimpleClientset(), kubefake.NewSimpleClientset(protected, unlabeled), 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.Start(ctx); err != nil {
+		t.Error(err)
+		return
+	}
+	SetCache(c)
+	defer SetCache(nil)
+
+	config := &acconfig.AdmissionControllerConfig{
+		InScopeNamespaceSelector: acconfig.NamespaceSelector{
+			Include: []string{"*"},
+			Exclude: []string{"kube-*"},
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"integrityshield.io/protected": "true"},
+			},
+		},
+	}
+	testcases := []struct {
+		namespace string
+		expected  bool
+	}{
+		{namespace: "protected-ns", expected: true},
+		{namespace: "sample-ns", expected: false},
+		{namespace: "kube-system", expected: false},
+		// label selector is not applied to cluster scope resources
+		{namespace: "", expected: true},
+	}
+	for _, tc := range testcases {
+		inScope := isInScopeNamespace(config, tc.namespace)
+		if inScope != tc.expected {
+			t.Errorf("unexpected scope of namespace `%s`: got: %v\nwant: %v", tc.namespace, inScope, tc.expected)
+		}
+	}
+}
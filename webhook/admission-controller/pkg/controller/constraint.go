@@ -19,14 +19,17 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
-	"github.com/IBM/integrity-shield/shield/pkg/shield"
 	miprofile "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/apis/manifestintegrityprofile/v1"
 	mipclient "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/client/manifestintegrityprofile/clientset/versioned/typed/manifestintegrityprofile/v1"
+	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
+	"github.com/pkg/errors"
 	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
 	"github.com/sigstore/k8s-manifest-sigstore/pkg/util/kubeutil"
 	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
@@ -39,19 +42,20 @@ func GetParametersFromConstraint(constraint miprofile.ManifestIntegrityProfileSp
 }
 
 func LoadConstraints() ([]miprofile.ManifestIntegrityProfile, error) {
+	if sharedCache != nil && sharedCache.HasSynced() {
+		return sharedCache.Constraints()
+	}
 	config, err := kubeutil.GetKubeConfig()
 	if err != nil {
-		return nil, nil
+		return nil, errors.Wrap(err, "failed to get kube config")
 	}
 	clientset, err := mipclient.NewForConfig(config)
 	if err != nil {
-		log.Error(err)
-		return nil, nil
+		return nil, errors.Wrap(err, "failed to create a clientset for ManifestIntegrityProfile")
 	}
 	miplist, err := clientset.ManifestIntegrityProfiles().List(context.Background(), metav1.ListOptions{})
 	if err != nil {
-		log.Error("failed to get ManifestIntegrityProfiles:", err.Error())
-		return nil, nil
+		return nil, errors.Wrap(err, "failed to get ManifestIntegrityProfiles")
 	}
 	return miplist.Items, nil
 }
@@ -86,29 +90,68 @@ func checkNamespaceLabelMatch(namespace string, labelSelector *metav1.LabelSelec
 	if labelSelector == nil {
 		return true
 	}
-	config, err := kubeutil.GetKubeConfig()
+	nsLabels, err := getNamespaceLabels(namespace)
 	if err != nil {
+		log.Error(err)
 		return false
 	}
-	clientset, err := kubeclient.NewForConfig(config)
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
 	if err != nil {
-		log.Error(err)
+		log.Errorf("failed to convert the LabelSelector api type into a struct that implements labels.Selector; %s", err.Error())
 		return false
 	}
+	labelsSet := labels.Set(nsLabels)
+	matched := selector.Matches(labelsSet)
+	return matched
+}
+
+// getNamespaceLabels returns labels of the namespace from the cache.
+// Namespaces which are not in the cache yet (e.g. just created) are loaded from the API server.
+func getNamespaceLabels(namespace string) (map[string]string, error) {
+	if sharedCache != nil && sharedCache.HasSynced() {
+		nsLabels, err := sharedCache.NamespaceLabels(namespace)
+		if err == nil {
+			return nsLabels, nil
+		}
+		if !k8serrors.IsNotFound(errors.Cause(err)) {
+			return nil, err
+		}
+	}
+	config, err := kubeutil.GetKubeConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get kube config")
+	}
+	clientset, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a kubernetes clientset")
+	}
 	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), namespace, metav1.GetOptions{})
 	if err != nil {
-		log.Errorf("failed to get a namespace `%s`:`%s`", namespace, err.Error())
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a namespace `%s`", namespace))
+	}
+	return ns.GetLabels(), nil
+}
+
+// isInScopeNamespace checks the namespace with name patterns and the label selector of InScopeNamespaceSelector.
+// If labels of the namespace cannot be loaded, it is regarded as in scope so that requests are not left unverified.
+func isInScopeNamespace(config *acconfig.AdmissionControllerConfig, namespace string) bool {
+	if config == nil {
+		return true
+	}
+	selector := config.InScopeNamespaceSelector
+	if !selector.Match(namespace) {
 		return false
 	}
-	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	// label selector is not applied to cluster scope resources
+	if selector.LabelSelector == nil || namespace == "" {
+		return true
+	}
+	nsLabels, err := getNamespaceLabels(namespace)
 	if err != nil {
-		log.Errorf("failed to convert the LabelSelector api type into a struct that implements labels.Selector; %s", err.Error())
-		return false
+		log.Error(err)
+		return true
 	}
-	labelsMap := ns.GetLabels()
-	labelsSet := labels.Set(labelsMap)
-	matched := selector.Matches(labelsSet)
-	return matched
+	return selector.MatchLabels(nsLabels)
 }
 
 func checkLabelMatch(req admission.Request, labelSelector *metav1.LabelSelector) bool {
@@ -186,7 +229,7 @@ func checkKindMatch(req admission.Request, match []miprofile.Kinds) bool {
 }
 
 // Status
-func updateConstraintStatus(constraint string, req admission.Request, errMsg string) error {
+func updateConstraintStatus(constraint string, delta *miprofile.ManifestIntegrityProfileStatus) error {
 	config, err := kubeutil.GetKubeConfig()
 	if err != nil {
 		log.Error(err)
@@ -197,36 +240,56 @@ func updateConstraintStatus(constraint string, req admission.Request, errMsg str
 		log.Error(err)
 		return err
 	}
-	mip, err := clientset.ManifestIntegrityProfiles().Get(context.Background(), constraint, metav1.GetOptions{})
+	err = updateProfileStatus(context.Background(), clientset.ManifestIntegrityProfiles(), constraint, delta)
 	if err != nil {
-		log.Error("failed to get ManifestIntegrityProfiles:", err.Error())
-		return err
-	}
-	newMIP := mip.UpdateStatus(req, errMsg)
-	_, err = clientset.ManifestIntegrityProfiles().Update(context.Background(), newMIP, metav1.UpdateOptions{})
-	if err != nil {
-		log.Error("failed to update ManifestIntegrityProfileStatus:", err.Error())
+		log.Error(err)
 		return err
 	}
 	return nil
 }
 
-func updateConstraints(isDetectMode bool, req admission.Request, results []shield.ResultFromRequestHandler) {
+// updateConstraints records denials and allowed requests of matched profiles in their status
+func updateConstraints(req admission.Request, results []ProfileResult, ar *AccumulatedResult, sideEffect acconfig.SideEffectConfig) {
 	for _, res := range results {
-		if !res.Allow {
-			errMsg := res.Message
-			if isDetectMode {
-				errMsg = "[Detection] " + res.Message
+		if !res.Matched {
+			continue
+		}
+		if res.Allow {
+			if !sideEffect.UpdateMIPStatusForAllowedRequest {
+				continue
 			}
-			// update status
-			_ = updateConstraintStatus(res.Profile, req, errMsg)
-
-			log.WithFields(log.Fields{
-				"namespace": req.Namespace,
-				"name":      req.Name,
-				"kind":      req.Kind.Kind,
-				"operation": req.Operation,
-			}).Debug("updated constraint status:", res.Profile)
+			if sharedStatusRecorder != nil {
+				sharedStatusRecorder.RecordAllowed(res.Profile, res.Verified)
+			} else {
+				delta := &miprofile.ManifestIntegrityProfileStatus{}
+				delta.RecordAllowed(res.Verified)
+				_ = updateConstraintStatus(res.Profile, delta)
+			}
+			continue
+		}
+		if !sideEffect.UpdateMIPStatusForDeniedRequest {
+			continue
+		}
+		errMsg := res.Message
+		if res.Mode == miprofile.ProfileModeDetect {
+			errMsg = "[Detection] " + res.Message
+		} else if k8smnfutil.ExactMatchWithPatternArray(res.Profile, ar.Overridden) {
+			errMsg = "[Overridden] " + res.Message
 		}
+		// update status
+		if sharedStatusRecorder != nil {
+			sharedStatusRecorder.RecordDenial(res.Profile, req, errMsg, res.Reason)
+		} else {
+			delta := &miprofile.ManifestIntegrityProfileStatus{}
+			delta.RecordDenial(req, errMsg, res.Reason)
+			_ = updateConstraintStatus(res.Profile, delta)
+		}
+
+		log.WithFields(log.Fields{
+			"namespace": req.Namespace,
+			"name":      req.Name,
+			"kind":      req.Kind.Kind,
+			"operation": req.Operation,
+		}).Debug("updated constraint status:", res.Profile)
 	}
 }
@@ -0,0 +1,216 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	miprofile "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/apis/manifestintegrityprofile/v1"
+	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// API server timeout of the webhook if the request does not have it
+const defaultRequestTimeout = 10 * time.Second
+
+// time left for accumulating results and responding to API server
+const requestTimeoutMargin = 1 * time.Second
+
+// ReasonVerificationTimeout is a reason code of responses for requests which are not verified before the deadline
+const ReasonVerificationTimeout = "VerificationTimeout"
+
+// maxConcurrentHandlers is the max number of request handlers which run at the same time for requests before their deadline.
+// Request handler does not stop at the deadline, so handlers of timed out requests release their slots then
+// and finish in background; otherwise a slow verification would make later requests time out without being evaluated.
+const maxConcurrentHandlers = 64
+
+// handlerSemaphore holds a slot for each request handler running before the deadline
+var handlerSemaphore = make(chan struct{}, maxConcurrentHandlers)
+
+// requestHandler verifies the request with the parameters of a constraint
+var requestHandler = shield.RequestHandler
+
+type requestTimeoutKey struct{}
+
+// WithRequestTimeout stores the timeout of an admission request, which API server sets as `timeout` query parameter.
+// It is used as WithContextFunc of the admission webhook.
+func WithRequestTimeout(ctx context.Context, r *http.Request) context.Context {
+	timeout := defaultRequestTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Warningf("failed to parse the request timeout `%s`; use default timeout %s", v, defaultRequestTimeout)
+		} else {
+			timeout = d
+		}
+	}
+	return context.WithValue(ctx, requestTimeoutKey{}, timeout)
+}
+
+func withRequestDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout, ok := ctx.Value(requestTimeoutKey{}).(time.Duration)
+	if !ok {
+		timeout = defaultRequestTimeout
+	}
+	if timeout > 2*requestTimeoutMargin {
+		timeout -= requestTimeoutMargin
+	} else {
+		timeout = timeout / 2
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ProfileResult is a result of a ManifestIntegrityProfile with its mode and priority
+type ProfileResult struct {
+	shield.ResultFromRequestHandler
+	Mode          string
+	Priority      int
+	AllowOverride bool
+	// Matched is true if the request matches the profile
+	Matched bool
+}
+
+type constraintResult struct {
+	index  int
+	result *shield.ResultFromRequestHandler
+}
+
+// evaluateConstraints runs request handler for each matched constraint concurrently.
+// Results are in the order of constraints, and constraints which are not evaluated before the deadline
+// are decided by the failure policy. The second return value is true if any constraint timed out.
+func evaluateConstraints(ctx context.Context, req admission.Request, constraints []miprofile.ManifestIntegrityProfile, config *acconfig.AdmissionControllerConfig) ([]ProfileResult, bool) {
+	// the mode of admission controller config is used for profiles without mode,
+	// and `detect` mode of the config also caps profiles in `enforce` mode, so that no request is denied
+	detectOnly := acconfig.CheckIfDetectOnly(config.Mode)
+	defaultMode := miprofile.ProfileModeEnforce
+	if detectOnly {
+		defaultMode = miprofile.ProfileModeDetect
+	}
+
+	handler := requestHandler
+	results := []ProfileResult{}
+	// buffered so that handlers which finish after the deadline do not block
+	resultCh := make(chan constraintResult, len(constraints))
+	running := map[int]bool{}
+	for _, constraint := range constraints {
+		mode := constraint.Spec.GetMode(defaultMode)
+		if detectOnly && mode == miprofile.ProfileModeEnforce {
+			mode = miprofile.ProfileModeDetect
+		}
+		if mode == miprofile.ProfileModeDisabled {
+			continue
+		}
+		pr := ProfileResult{
+			Mode:          mode,
+			Priority:      constraint.Spec.Priority,
+			AllowOverride: constraint.Spec.AllowOverride,
+		}
+		pr.Profile = constraint.Name
+
+		//match check: kind, namespace, label
+		isMatched := matchCheck(req, constraint.Spec.Match)
+		if !isMatched {
+			pr.Allow = true
+			pr.Message = "not protected"
+			results = append(results, pr)
+			continue
+		}
+		pr.Matched = true
+
+		// allow-override profiles allow matched requests without verification
+		if constraint.Spec.AllowOverride {
+			pr.Allow = true
+			pr.Message = "allowed by override profile"
+			results = append(results, pr)
+			continue
+		}
+
+		// pick parameters from constaint
+		paramObj := GetParametersFromConstraint(constraint.Spec)
+
+		index := len(results)
+		results = append(results, pr)
+		running[index] = true
+		go func(i int, name string) {
+			// wait for a free slot; the constraint is decided by the failure policy if the deadline comes first
+			select {
+			case handlerSemaphore <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			// the slot is released when the handler finishes or the deadline comes
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-done:
+				case <-ctx.Done():
+				}
+				<-handlerSemaphore
+			}()
+			if ctx.Err() != nil {
+				return
+			}
+			// call request handler & receive result from request handler (allow, message)
+			r := handler(req, paramObj)
+			r.Profile = name
+			resultCh <- constraintResult{index: i, result: r}
+		}(index, constraint.Name)
+	}
+
+	timedOut := false
+	for len(running) > 0 && !timedOut {
+		select {
+		case cr := <-resultCh:
+			results[cr.index].ResultFromRequestHandler = *cr.result
+			delete(running, cr.index)
+		case <-ctx.Done():
+			timedOut = true
+		}
+	}
+	for i := range running {
+		results[i].ResultFromRequestHandler = *timeoutResult(req, results[i].Profile, config)
+	}
+	return results, timedOut
+}
+
+// timeoutResult returns the result of a constraint which is not evaluated before the deadline.
+// The request is allowed or denied by the failure policy, and the timeout is recorded as a failure.
+func timeoutResult(req admission.Request, profile string, config *acconfig.AdmissionControllerConfig) *shield.ResultFromRequestHandler {
+	allow, reason := decideOnFailure(config, req.Namespace)
+	recordFailure(errorReasonTimeout, reason, allow)
+	msg := fmt.Sprintf("%s: verification did not finish before the deadline (%s)", ReasonVerificationTimeout, reason)
+	log.WithFields(log.Fields{
+		"namespace": req.Namespace,
+		"name":      req.Name,
+		"kind":      req.Kind.Kind,
+		"operation": req.Operation,
+		"profile":   profile,
+		"allow":     allow,
+	}).Warning(msg)
+	return &shield.ResultFromRequestHandler{
+		Allow:   allow,
+		Message: msg,
+		Profile: profile,
+		Reason:  ReasonVerificationTimeout,
+	}
+}
@@ -0,0 +1,96 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// reason codes of responses decided by the failure policy
+const (
+	ReasonFailOpen                     = "FailOpen"
+	ReasonFailClosed                   = "FailClosed"
+	ReasonFailClosedProtectedNamespace = "FailClosedProtectedNamespace"
+	ReasonFailOpenUnprotectedNamespace = "FailOpenUnprotectedNamespace"
+)
+
+// lastConfig holds the latest config which is loaded successfully.
+// It is used to decide the failure policy when the config cannot be loaded.
+var lastConfig atomic.Value
+
+func storeLastConfig(config *acconfig.AdmissionControllerConfig) {
+	if config != nil {
+		lastConfig.Store(config)
+	}
+}
+
+func loadLastConfig() *acconfig.AdmissionControllerConfig {
+	config, ok := lastConfig.Load().(*acconfig.AdmissionControllerConfig)
+	if !ok {
+		return nil
+	}
+	return config
+}
+
+// decideOnFailure returns whether the request is allowed and the reason code according to the failure policy
+func decideOnFailure(config *acconfig.AdmissionControllerConfig, namespace string) (bool, string) {
+	switch config.GetFailurePolicy() {
+	case acconfig.FailurePolicyClosed:
+		return false, ReasonFailClosed
+	case acconfig.FailurePolicyClosedForProtectedNamespaces:
+		if isInScopeNamespace(config, namespace) {
+			return false, ReasonFailClosedProtectedNamespace
+		}
+		return true, ReasonFailOpenUnprotectedNamespace
+	}
+	return true, ReasonFailOpen
+}
+
+// failureResponse makes an admission response for a request which could not be evaluated
+func failureResponse(req admission.Request, config *acconfig.AdmissionControllerConfig, errReason string, err error) admission.Response {
+	allow, reason := decideOnFailure(config, req.Namespace)
+	msg := fmt.Sprintf("failed to evaluate the request (%s); %s", errReason, err.Error())
+	if !allow && config != nil && acconfig.CheckIfDetectOnly(config.Mode) {
+		allow = true
+		msg = "allowed by detection mode: " + msg
+	}
+	recordFailure(errReason, reason, allow)
+
+	log.WithFields(log.Fields{
+		"namespace": req.Namespace,
+		"name":      req.Name,
+		"kind":      req.Kind.Kind,
+		"operation": req.Operation,
+		"allow":     allow,
+		"reason":    reason,
+	}).Error(msg)
+
+	var res admission.Response
+	if allow {
+		res = admission.Allowed(msg)
+	} else {
+		res = admission.Denied(msg)
+	}
+	res.Result.Reason = metav1.StatusReason(reason)
+	return res
+}
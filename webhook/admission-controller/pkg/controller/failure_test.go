@@ -0,0 +1,118 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
+	admv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func setFailurePolicyEnv(t *testing.T, value string) {
+	orig, found := os.LookupEnv(acconfig.FailurePolicyEnvKey)
+	os.Setenv(acconfig.FailurePolicyEnvKey, value)
+	t.Cleanup(func() {
+		if found {
+			os.Setenv(acconfig.FailurePolicyEnvKey, orig)
+		} else {
+			os.Unsetenv(acconfig.FailurePolicyEnvKey)
+		}
+	})
+}
+
+func TestDecideOnFailure(t *testing.T) {
+	testcases := []struct {
+		name       string
+		config     *acconfig.AdmissionControllerConfig
+		env        string
+		wantAllow  bool
+		wantReason string
+	}{
+		{
+			name:       "nil config and no env",
+			config:     nil,
+			wantAllow:  true,
+			wantReason: ReasonFailOpen,
+		},
+		{
+			name:       "nil config falls back to env",
+			config:     nil,
+			env:        acconfig.FailurePolicyClosed,
+			wantAllow:  false,
+			wantReason: ReasonFailClosed,
+		},
+		{
+			name:       "nil config with protected namespaces env",
+			config:     nil,
+			env:        acconfig.FailurePolicyClosedForProtectedNamespaces,
+			wantAllow:  false,
+			wantReason: ReasonFailClosedProtectedNamespace,
+		},
+		{
+			name:       "config takes precedence over env",
+			config:     &acconfig.AdmissionControllerConfig{FailurePolicy: acconfig.FailurePolicyOpen},
+			env:        acconfig.FailurePolicyClosed,
+			wantAllow:  true,
+			wantReason: ReasonFailOpen,
+		},
+		{
+			name:       "config without failure policy falls back to env",
+			config:     &acconfig.AdmissionControllerConfig{},
+			env:        acconfig.FailurePolicyClosed,
+			wantAllow:  false,
+			wantReason: ReasonFailClosed,
+		},
+		{
+			name:       "mis-cased value in config is treated as closed",
+			config:     &acconfig.AdmissionControllerConfig{FailurePolicy: "Closed"},
+			wantAllow:  false,
+			wantReason: ReasonFailClosed,
+		},
+		{
+			name:       "unknown value in env is treated as closed",
+			config:     nil,
+			env:        "Open",
+			wantAllow:  false,
+			wantReason: ReasonFailClosed,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			setFailurePolicyEnv(t, tc.env)
+			allow, reason := decideOnFailure(tc.config, "sample-ns")
+			if allow != tc.wantAllow || reason != tc.wantReason {
+				t.Errorf("got: %v, %s\nwant: %v, %s", allow, reason, tc.wantAllow, tc.wantReason)
+			}
+		})
+	}
+}
+
+func TestFailureResponseWithoutConfig(t *testing.T) {
+	setFailurePolicyEnv(t, acconfig.FailurePolicyClosed)
+	req := admission.Request{AdmissionRequest: admv1.AdmissionRequest{Namespace: "sample-ns"}}
+	res := failureResponse(req, nil, errorReasonConfig, errors.New("config is not found"))
+	if res.Allowed {
+		t.Errorf("request should be denied when the config cannot be loaded and the env sets `closed`")
+	}
+	if string(res.Result.Reason) != ReasonFailClosed {
+		t.Errorf("got: %s\nwant: %s", res.Result.Reason, ReasonFailClosed)
+	}
+}
@@ -0,0 +1,54 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "integrity_shield_admission_controller"
+
+// reasons of admission controller errors
+const (
+	errorReasonConfig     = "config_error"
+	errorReasonConstraint = "constraint_error"
+	errorReasonTimeout    = "timeout_error"
+)
+
+var (
+	failuresCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "failures_total",
+		Help:      "Number of requests which could not be evaluated, by error reason, failure policy reason code and decision.",
+	}, []string{"error", "reason", "decision"})
+)
+
+func init() {
+	// served on the metrics endpoint of the controller manager
+	metrics.Registry.MustRegister(
+		failuresCounter,
+	)
+}
+
+func recordFailure(errReason, reason string, allow bool) {
+	decision := "deny"
+	if allow {
+		decision = "allow"
+	}
+	failuresCounter.WithLabelValues(errReason, reason, decision).Inc()
+}
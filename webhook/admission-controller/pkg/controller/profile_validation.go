@@ -0,0 +1,191 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	miprofile "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/apis/manifestintegrityprofile/v1"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	k8smnfutil "github.com/sigstore/k8s-manifest-sigstore/pkg/util"
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ProfileValidator is an admission handler which rejects invalid ManifestIntegrityProfiles
+type ProfileValidator struct {
+	// checkSecret returns an error if the key secret is not available
+	checkSecret func(namespace, name string) error
+}
+
+func NewProfileValidator(config *rest.Config) (*ProfileValidator, error) {
+	clientset, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a kubernetes clientset")
+	}
+	checkSecret := func(namespace, name string) error {
+		_, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil && k8serrors.IsNotFound(err) {
+			return errors.New(fmt.Sprintf("a secret `%s` is not found in `%s` namespace", name, namespace))
+		} else if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to get a secret `%s` in `%s` namespace", name, namespace))
+		}
+		return nil
+	}
+	return &ProfileValidator{checkSecret: checkSecret}, nil
+}
+
+func (v *ProfileValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	err := v.Validate(req.Object.Raw)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"name":      req.Name,
+			"operation": req.Operation,
+		}).Info("denied an invalid ManifestIntegrityProfile: ", err.Error())
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	return admission.Allowed("ManifestIntegrityProfile is valid")
+}
+
+// Validate checks unknown fields, patterns, label selectors and key secrets of a ManifestIntegrityProfile
+func (v *ProfileValidator) Validate(raw []byte) error {
+	var profile miprofile.ManifestIntegrityProfile
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&profile); err != nil {
+		return errors.Wrap(err, "failed to decode ManifestIntegrityProfile")
+	}
+
+	sumErr := []string{}
+	spec := profile.Spec
+	if spec.Mode != "" && spec.GetMode("") == "" {
+		sumErr = append(sumErr, fmt.Sprintf("spec.mode: `%s` is not one of `%s`, `%s` or `%s`", spec.Mode, miprofile.ProfileModeEnforce, miprofile.ProfileModeDetect, miprofile.ProfileModeDisabled))
+	}
+
+	// match
+	sumErr = append(sumErr, validatePatterns("spec.match.namespaces", spec.Match.Namespaces)...)
+	sumErr = append(sumErr, validatePatterns("spec.match.excludedNamespaces", spec.Match.ExcludedNamespaces)...)
+	for i, k := range spec.Match.Kinds {
+		sumErr = append(sumErr, validatePatterns(fmt.Sprintf("spec.match.kinds[%d].kinds", i), k.Kinds)...)
+		sumErr = append(sumErr, validatePatterns(fmt.Sprintf("spec.match.kinds[%d].apiGroups", i), k.ApiGroups)...)
+	}
+	sumErr = append(sumErr, validateLabelSelector("spec.match.labelSelector", spec.Match.LabelSelector)...)
+	sumErr = append(sumErr, validateLabelSelector("spec.match.namespaceSelector", spec.Match.NamespaceSelector)...)
+
+	// parameters
+	param := spec.Parameters
+	sumErr = append(sumErr, validateObjectReferences("spec.parameters.objectSelector", param.InScopeObjects)...)
+	sumErr = append(sumErr, validateObjectReferences("spec.parameters.skipObjects", param.SkipObjects)...)
+	for i, f := range param.IgnoreFields {
+		sumErr = append(sumErr, validateObjectReferences(fmt.Sprintf("spec.parameters.ignoreFields[%d].objects", i), f.Objects)...)
+	}
+	for i, u := range param.SkipUsers {
+		sumErr = append(sumErr, validateObjectReferences(fmt.Sprintf("spec.parameters.skipUsers[%d].objects", i), u.Objects)...)
+		sumErr = append(sumErr, validatePatterns(fmt.Sprintf("spec.parameters.skipUsers[%d].users", i), u.Users)...)
+	}
+	for i, u := range param.InScopeUsers {
+		sumErr = append(sumErr, validateObjectReferences(fmt.Sprintf("spec.parameters.inScopeUsers[%d].objects", i), u.Objects)...)
+		sumErr = append(sumErr, validatePatterns(fmt.Sprintf("spec.parameters.inScopeUsers[%d].users", i), u.Users)...)
+	}
+	for i, r := range param.ImageProfile.Match {
+		sumErr = append(sumErr, validatePatterns(fmt.Sprintf("spec.parameters.imageProfile.match[%d]", i), []string{string(r)})...)
+	}
+	for i, r := range param.ImageProfile.Exclude {
+		sumErr = append(sumErr, validatePatterns(fmt.Sprintf("spec.parameters.imageProfile.exclude[%d]", i), []string{string(r)})...)
+	}
+	policy := param.ProvenancePolicy
+	sumErr = append(sumErr, validatePatterns("spec.parameters.provenancePolicy.allowedBuilders", policy.AllowedBuilders)...)
+	sumErr = append(sumErr, validatePatterns("spec.parameters.provenancePolicy.allowedSourceRepos", policy.AllowedSourceRepos)...)
+	sumErr = append(sumErr, validatePatterns("spec.parameters.provenancePolicy.allowedBranches", policy.AllowedBranches)...)
+	sumErr = append(sumErr, validatePatterns("spec.parameters.provenancePolicy.requiredMaterials", policy.RequiredMaterials)...)
+
+	// key secrets
+	sumErr = append(sumErr, v.validateKeyConfigs("spec.parameters.keyConfigs", param.KeyConfigs)...)
+	sumErr = append(sumErr, v.validateKeyConfigs("spec.parameters.imageProfile.keyConfigs", param.ImageProfile.KeyConfigs)...)
+
+	if len(sumErr) > 0 {
+		return errors.New(fmt.Sprintf("invalid ManifestIntegrityProfile `%s`; %s", profile.Name, strings.Join(sumErr, "; ")))
+	}
+	return nil
+}
+
+// validatePatterns checks patterns of k8smnfutil.MatchPattern, which supports `*` only as a suffix
+func validatePatterns(path string, patterns []string) []string {
+	sumErr := []string{}
+	for _, pattern := range patterns {
+		for _, p := range k8smnfutil.SplitRule(pattern) {
+			p = strings.TrimSpace(p)
+			if strings.Contains(strings.TrimRight(p, "*"), "*") {
+				sumErr = append(sumErr, fmt.Sprintf("%s: `%s` is an invalid pattern; `*` is allowed only at the end", path, pattern))
+				break
+			}
+		}
+	}
+	return sumErr
+}
+
+func validateObjectReferences(path string, refs k8smanifest.ObjectReferenceList) []string {
+	sumErr := []string{}
+	for i, ref := range refs {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		sumErr = append(sumErr, validatePatterns(p+".group", []string{ref.Group})...)
+		sumErr = append(sumErr, validatePatterns(p+".version", []string{ref.Version})...)
+		sumErr = append(sumErr, validatePatterns(p+".kind", []string{ref.Kind})...)
+		sumErr = append(sumErr, validatePatterns(p+".name", []string{ref.Name})...)
+		sumErr = append(sumErr, validatePatterns(p+".namespace", []string{ref.Namespace})...)
+	}
+	return sumErr
+}
+
+func validateLabelSelector(path string, selector *metav1.LabelSelector) []string {
+	if selector == nil {
+		return nil
+	}
+	if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+		return []string{fmt.Sprintf("%s: %s", path, err.Error())}
+	}
+	return nil
+}
+
+func (v *ProfileValidator) validateKeyConfigs(path string, keyConfigs []k8smnfconfig.KeyConfig) []string {
+	sumErr := []string{}
+	for i, kc := range keyConfigs {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		if kc.KeySecretName == "" || kc.KeySecretNamespace == "" {
+			sumErr = append(sumErr, fmt.Sprintf("%s: both keySecretName and keySecretNamespace are required", p))
+			continue
+		}
+		if v.checkSecret == nil {
+			continue
+		}
+		if err := v.checkSecret(kc.KeySecretNamespace, kc.KeySecretName); err != nil {
+			sumErr = append(sumErr, fmt.Sprintf("%s: %s", p, err.Error()))
+		}
+	}
+	return sumErr
+}
@@ -0,0 +1,113 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const testValidProfile = `{
+  "apiVersion": "apis.integrityshield.io/v1",
+  "kind": "ManifestIntegrityProfile",
+  "metadata": {"name": "profile-configmap"},
+  "spec": {
+    "mode": "detect",
+    "match": {
+      "kinds": [{"kinds": ["ConfigMap"]}],
+      "namespaces": ["sample-*"],
+      "labelSelector": {"matchExpressions": [{"key": "app", "operator": "In", "values": ["sample"]}]}
+    },
+    "parameters": {
+      "objectSelector": [{"name": "sample-cm*"}],
+      "ignoreFields": [{"fields": ["data.comment"], "objects": [{"kind": "ConfigMap"}]}],
+      "keyConfigs": [{"keySecretName": "keyring-secret", "keySecretNamespace": "integrity-shield-operator-system"}],
+      "signers": ["signer@signer.com"]
+    }
+  }
+}`
+
+func TestProfileValidatorValidate(t *testing.T) {
+	v := &ProfileValidator{
+		checkSecret: func(namespace, name string) error {
+			if name != "keyring-secret" {
+				return fmt.Errorf("a secret `%s` is not found in `%s` namespace", name, namespace)
+			}
+			return nil
+		},
+	}
+	testcases := []struct {
+		name    string
+		old     string
+		new     string
+		wantErr string
+	}{
+		{
+			name: "valid profile",
+		},
+		{
+			name:    "unknown field",
+			old:     `"objectSelector"`,
+			new:     `"objectSelecter"`,
+			wantErr: "unknown field",
+		},
+		{
+			name:    "invalid pattern",
+			old:     `"sample-*"`,
+			new:     `"*-sample"`,
+			wantErr: "spec.match.namespaces",
+		},
+		{
+			name:    "invalid object reference pattern",
+			old:     `"sample-cm*"`,
+			new:     `"sample-*-cm"`,
+			wantErr: "spec.parameters.objectSelector[0].name",
+		},
+		{
+			name:    "malformed label selector",
+			old:     `"operator": "In"`,
+			new:     `"operator": "in"`,
+			wantErr: "spec.match.labelSelector",
+		},
+		{
+			name:    "missing key secret",
+			old:     `"keyring-secret"`,
+			new:     `"missing-secret"`,
+			wantErr: "spec.parameters.keyConfigs[0]",
+		},
+		{
+			name:    "invalid mode",
+			old:     `"mode": "detect"`,
+			new:     `"mode": "audit"`,
+			wantErr: "spec.mode",
+		},
+	}
+	for _, tc := range testcases {
+		raw := strings.Replace(testValidProfile, tc.old, tc.new, 1)
+		err := v.Validate([]byte(raw))
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", tc.name, err.Error())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: got: %v\nwant: error which contains `%s`", tc.name, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	log "github.com/sirupsen/logrus"
+	admv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kubeclient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SignatureInjector is a mutating admission handler which attaches a reference to the signature
+// found in the configured signature stores, so that the validating webhook can verify the resource as usual
+type SignatureInjector struct {
+	loadConfig func() (*acconfig.AdmissionControllerConfig, error)
+	// getConfigMap returns nil if the ConfigMap is not found
+	getConfigMap func(namespace, name string) (*corev1.ConfigMap, error)
+}
+
+func NewSignatureInjector(config *rest.Config) (*SignatureInjector, error) {
+	clientset, err := kubeclient.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a kubernetes clientset")
+	}
+	getConfigMap := func(namespace, name string) (*corev1.ConfigMap, error) {
+		cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil && k8serrors.IsNotFound(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", name, namespace))
+		}
+		return cm, nil
+	}
+	return &SignatureInjector{loadConfig: loadAdmissionControllerConfig, getConfigMap: getConfigMap}, nil
+}
+
+func (i *SignatureInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admv1.Create && req.Operation != admv1.Update {
+		return admission.Allowed("signature injection is not required for this operation")
+	}
+	config, err := i.loadConfig()
+	if err != nil || config == nil {
+		if err != nil {
+			log.Errorf("failed to load admission controller config; %s", err.Error())
+		}
+		// the validating webhook decides the response by the failure policy
+		return admission.Allowed("signature is not injected because admission controller config is not available")
+	}
+	if !config.SignatureInjection.Enabled {
+		return admission.Allowed("signature injection is disabled")
+	}
+	if !isInScopeNamespace(config, req.Namespace) || config.Allow.Match(req.Kind) {
+		return admission.Allowed("this request is out of scope")
+	}
+
+	var resource unstructured.Unstructured
+	if err := json.Unmarshal(req.Object.Raw, &resource); err != nil {
+		log.Errorf("failed to Unmarshal a requested object into %T; %s", resource, err.Error())
+		return admission.Allowed("signature is not injected because the object cannot be decoded")
+	}
+	key, value, err := i.resolve(resource, config.SignatureInjection)
+	if err != nil {
+		log.Errorf("failed to resolve the signature of %s `%s`; %s", req.Kind.Kind, req.Name, err.Error())
+		return admission.Allowed("signature is not injected because the signature store is not available")
+	}
+	if key == "" {
+		return admission.Allowed("no signature is found in signature stores")
+	}
+
+	annotations := resource.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[key] = value
+	resource.SetAnnotations(annotations)
+	mutated, err := json.Marshal(resource.Object)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	log.WithFields(log.Fields{
+		"namespace": req.Namespace,
+		"name":      req.Name,
+		"kind":      req.Kind.Kind,
+		"operation": req.Operation,
+	}).Infof("injected a signature reference %s: %s", key, value)
+	return admission.PatchResponseFromRaw(req.Object.Raw, mutated)
+}
+
+// resolve returns the annotation which refers to the signature of the resource in the first matched signature store.
+// It returns an empty key if the resource already has a signature or no signature is found.
+func (i *SignatureInjector) resolve(resource unstructured.Unstructured, injection acconfig.SignatureInjectionConfig) (string, string, error) {
+	domain := injection.AnnotationKeyDomain
+	if domain == "" {
+		domain = k8smanifest.DefaultAnnotationKeyDomain
+	}
+	annotationConfig := k8smanifest.AnnotationConfig{AnnotationKeyDomain: domain}
+
+	// resources which already have a signature or a reference are not changed
+	annotations := resource.GetAnnotations()
+	for _, key := range []string{
+		annotationConfig.ImageRefAnnotationKey(),
+		annotationConfig.SignatureAnnotationKey(),
+		shield.ImageRefAnnotationKeyShield,
+		shield.SignatureResourceRefAnnotationKey,
+	} {
+		if _, found := annotations[key]; found {
+			return "", "", nil
+		}
+	}
+
+	replacer := strings.NewReplacer(
+		"{namespace}", resource.GetNamespace(),
+		"{kind}", strings.ToLower(resource.GetKind()),
+		"{name}", resource.GetName(),
+	)
+	for _, store := range injection.Stores {
+		if !store.Match.Match(resource) {
+			continue
+		}
+		if store.ImageRef != "" {
+			return annotationConfig.ImageRefAnnotationKey(), replacer.Replace(store.ImageRef), nil
+		}
+		if store.ConfigMap.Name == "" {
+			continue
+		}
+		name := replacer.Replace(store.ConfigMap.Name)
+		namespace := replacer.Replace(store.ConfigMap.Namespace)
+		if namespace == "" {
+			namespace = resource.GetNamespace()
+		}
+		cm, err := i.getConfigMap(namespace, name)
+		if err != nil {
+			return "", "", err
+		}
+		// only ConfigMaps which hold a signature are referred
+		if cm == nil {
+			continue
+		}
+		if _, found := cm.GetAnnotations()[annotationConfig.SignatureAnnotationKey()]; !found {
+			log.Debugf("a configmap `%s` in `%s` namespace does not have a signature", name, namespace)
+			continue
+		}
+		return shield.SignatureResourceRefAnnotationKey, fmt.Sprintf("%s/%s", namespace, name), nil
+	}
+	return "", "", nil
+}
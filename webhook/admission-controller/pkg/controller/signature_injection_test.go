@@ -0,0 +1,138 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
+	"github.com/sigstore/k8s-manifest-sigstore/pkg/k8smanifest"
+	admv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func testSignatureInjector(config *acconfig.AdmissionControllerConfig) *SignatureInjector {
+	return &SignatureInjector{
+		loadConfig: func() (*acconfig.AdmissionControllerConfig, error) {
+			return config, nil
+		},
+		getConfigMap: func(namespace, name string) (*corev1.ConfigMap, error) {
+			if namespace != "sample-ns" || name != "sample-cm-signature" {
+				return nil, nil
+			}
+			cm := &corev1.ConfigMap{}
+			cm.SetAnnotations(map[string]string{"cosign.sigstore.dev/signature": "c2lnbmF0dXJl"})
+			return cm, nil
+		},
+	}
+}
+
+func testInjectionRequest(t *testing.T, name string, annotations map[string]string) admission.Request {
+	resource := unstructured.Unstructured{}
+	resource.SetAPIVersion("v1")
+	resource.SetKind("ConfigMap")
+	resource.SetNamespace("sample-ns")
+	resource.SetName(name)
+	resource.SetAnnotations(annotations)
+	raw, err := json.Marshal(resource.Object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return admission.Request{AdmissionRequest: admv1.AdmissionRequest{
+		Namespace: "sample-ns",
+		Name:      name,
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+		Operation: admv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}}
+}
+
+func TestSignatureInjectorHandle(t *testing.T) {
+	config := &acconfig.AdmissionControllerConfig{
+		SignatureInjection: acconfig.SignatureInjectionConfig{
+			Enabled: true,
+			Stores: []acconfig.SignatureStore{
+				{
+					Match:     k8smanifest.ObjectReferenceList{{Kind: "ConfigMap", Name: "sample-cm"}},
+					ConfigMap: acconfig.SignatureConfigMapRef{Name: "{name}-signature"},
+				},
+				{
+					Match:    k8smanifest.ObjectReferenceList{{Kind: "ConfigMap"}},
+					ImageRef: "sample-registry/{namespace}-{kind}:0.1.0",
+				},
+			},
+		},
+	}
+	injector := testSignatureInjector(config)
+	testcases := []struct {
+		name        string
+		annotations map[string]string
+		key         string
+		value       string
+	}{
+		{
+			name:  "sample-cm",
+			key:   shield.SignatureResourceRefAnnotationKey,
+			value: "sample-ns/sample-cm-signature",
+		},
+		{
+			name:  "other-cm",
+			key:   "cosign.sigstore.dev/imageRef",
+			value: "sample-registry/sample-ns-configmap:0.1.0",
+		},
+		{
+			// resources which have a signature are not changed
+			name:        "signed-cm",
+			annotations: map[string]string{"cosign.sigstore.dev/signature": "c2lnbmF0dXJl"},
+		},
+	}
+	for _, tc := range testcases {
+		res := injector.Handle(context.Background(), testInjectionRequest(t, tc.name, tc.annotations))
+		if !res.Allowed {
+			t.Errorf("%s: mutation should not deny the request: %v", tc.name, res.Result)
+			continue
+		}
+		if tc.key == "" {
+			if len(res.Patches) != 0 {
+				t.Errorf("%s: unexpected patches: got: %v", tc.name, res.Patches)
+			}
+			continue
+		}
+		if len(res.Patches) != 1 {
+			t.Errorf("%s: unexpected patches: got: %v\nwant: an annotation %s", tc.name, res.Patches, tc.key)
+			continue
+		}
+		annotations, ok := res.Patches[0].Value.(map[string]interface{})
+		if !ok || annotations[tc.key] != tc.value {
+			t.Errorf("%s: unexpected patch: got: %v\nwant: %s: %s", tc.name, res.Patches[0].Value, tc.key, tc.value)
+		}
+	}
+
+	// nothing is injected if it is disabled
+	config.SignatureInjection.Enabled = false
+	res := injector.Handle(context.Background(), testInjectionRequest(t, "sample-cm", nil))
+	if !res.Allowed || len(res.Patches) != 0 {
+		t.Errorf("unexpected response for disabled injection: got: %v", res.Patches)
+	}
+}
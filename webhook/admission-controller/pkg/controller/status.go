@@ -0,0 +1,179 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	miprofile "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/apis/manifestintegrityprofile/v1"
+	mipversioned "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/client/manifestintegrityprofile/clientset/versioned"
+	mipclient "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/client/manifestintegrityprofile/clientset/versioned/typed/manifestintegrityprofile/v1"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const defaultStatusFlushInterval = 10 * time.Second
+
+// sharedStatusRecorder is used by updateConstraints once it is set by SetStatusRecorder.
+// If it is nil, status is updated for every request.
+var sharedStatusRecorder *StatusRecorder
+
+// StatusRecorder batches denials and allowed requests per ManifestIntegrityProfile and writes them
+// to the status subresource once per interval
+type StatusRecorder struct {
+	client   mipclient.ManifestIntegrityProfileInterface
+	interval time.Duration
+
+	mu sync.Mutex
+	// the changes of status which are not written yet
+	pending map[string]*miprofile.ManifestIntegrityProfileStatus
+}
+
+// NewStatusRecorder creates a recorder which flushes status of ManifestIntegrityProfiles every interval
+func NewStatusRecorder(config *rest.Config, interval time.Duration) (*StatusRecorder, error) {
+	mipClient, err := mipversioned.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a clientset for ManifestIntegrityProfile")
+	}
+	return newStatusRecorder(mipClient.ApisV1().ManifestIntegrityProfiles(), interval), nil
+}
+
+func newStatusRecorder(client mipclient.ManifestIntegrityProfileInterface, interval time.Duration) *StatusRecorder {
+	if interval == 0 {
+		interval = defaultStatusFlushInterval
+	}
+	return &StatusRecorder{
+		client:   client,
+		interval: interval,
+		pending:  map[string]*miprofile.ManifestIntegrityProfileStatus{},
+	}
+}
+
+// SetStatusRecorder makes updateConstraints use the given recorder
+func SetStatusRecorder(r *StatusRecorder) {
+	sharedStatusRecorder = r
+}
+
+// RecordDenial adds a denial of the request to the pending status of the profile
+func (r *StatusRecorder) RecordDenial(profile string, req admission.Request, errMsg, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pendingStatus(profile).RecordDenial(req, errMsg, reason)
+}
+
+// RecordAllowed adds an allowed request to the pending status of the profile
+func (r *StatusRecorder) RecordAllowed(profile string, verified bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pendingStatus(profile).RecordAllowed(verified)
+}
+
+func (r *StatusRecorder) add(profile string, delta *miprofile.ManifestIntegrityProfileStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p := r.pendingStatus(profile)
+	// delta is older than the pending one, so merge the pending one into delta
+	delta.Merge(p)
+	r.pending[profile] = delta
+}
+
+func (r *StatusRecorder) pendingStatus(profile string) *miprofile.ManifestIntegrityProfileStatus {
+	p, ok := r.pending[profile]
+	if !ok {
+		p = &miprofile.ManifestIntegrityProfileStatus{}
+		r.pending[profile] = p
+	}
+	return p
+}
+
+// Start flushes pending status every interval until ctx is done
+func (r *StatusRecorder) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Flush(ctx)
+		case <-ctx.Done():
+			// write denials recorded so far before exiting
+			flushCtx, cancel := context.WithTimeout(context.Background(), r.interval)
+			r.Flush(flushCtx)
+			cancel()
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection returns false because every replica records denials which it handled
+func (r *StatusRecorder) NeedLeaderElection() bool {
+	return false
+}
+
+// Flush writes all pending status. Failed ones are kept and retried at the next flush.
+func (r *StatusRecorder) Flush(ctx context.Context) {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = map[string]*miprofile.ManifestIntegrityProfileStatus{}
+	r.mu.Unlock()
+
+	for profile, delta := range pending {
+		err := updateProfileStatus(ctx, r.client, profile, delta)
+		if err == nil {
+			continue
+		}
+		// NotFound of the status update does not always mean the profile is deleted (e.g. no status subresource),
+		// so drop the pending status only when the profile itself is not found
+		if k8serrors.IsNotFound(errors.Cause(err)) && r.isProfileDeleted(ctx, profile) {
+			log.Debug(fmt.Sprintf("ManifestIntegrityProfile `%s` is deleted; drop its pending status", profile))
+			continue
+		}
+		log.Error(err)
+		r.add(profile, delta)
+	}
+}
+
+// isProfileDeleted returns true only if API server confirms the profile does not exist
+func (r *StatusRecorder) isProfileDeleted(ctx context.Context, profile string) bool {
+	_, err := r.client.Get(ctx, profile, metav1.GetOptions{})
+	return k8serrors.IsNotFound(err)
+}
+
+// updateProfileStatus merges delta into the status subresource of the profile, retrying on conflict
+// so that concurrent writers do not lose counts
+func updateProfileStatus(ctx context.Context, client mipclient.ManifestIntegrityProfileInterface, profile string, delta *miprofile.ManifestIntegrityProfileStatus) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		mip, err := client.Get(ctx, profile, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		mip.Status.Merge(delta)
+		_, err = client.UpdateStatus(ctx, mip, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to update status of ManifestIntegrityProfile `%s`", profile))
+	}
+	return nil
+}
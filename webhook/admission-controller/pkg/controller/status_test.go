@@ -0,0 +1,178 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	miprofile "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/apis/manifestintegrityprofile/v1"
+	mipfake "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/client/manifestintegrityprofile/clientset/versioned/fake"
+	admv1 "k8s.io/api/admission/v1"
+	authv1 "k8s.io/api/authentication/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestStatusRecorderFlush(t *testing.T) {
+	mip := &miprofile.ManifestIntegrityProfile{ObjectMeta: metav1.ObjectMeta{Name: "sample-profile"}}
+	mip.Status.DenyCount = 3
+	client := mipfake.NewSimpleClientset(mip)
+
+	// the first status update conflicts with another writer
+	conflicted := false
+	client.PrependReactor("update", "manifestintegrityprofiles", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "status" {
+			t.Errorf("status should be updated via the status subresource")
+		}
+		if !conflicted {
+			conflicted = true
+			gr := schema.GroupResource{Group: "apis.integrityshield.io", Resource: "manifestintegrityprofiles"}
+			return true, nil, k8serrors.NewConflict(gr, "sample-profile", fmt.Errorf("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+
+	recorder := newStatusRecorder(client.ApisV1().ManifestIntegrityProfiles(), 0)
+	for i := 0; i < 15; i++ {
+		req := admission.Request{AdmissionRequest: admv1.AdmissionRequest{
+			Namespace: "sample-ns",
+			Name:      fmt.Sprintf("sample-cm-%d", i),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Operation: admv1.Create,
+			UserInfo:  authv1.UserInfo{Username: fmt.Sprintf("user-%d", i%2)},
+		}}
+		recorder.RecordDenial("sample-profile", req, "no signature found", shield.ReasonNoSignature)
+		recorder.RecordDenial("deleted-profile", req, "no signature found", shield.ReasonNoSignature)
+		recorder.RecordAllowed("sample-profile", i%3 == 0)
+	}
+	recorder.Flush(context.Background())
+	if !conflicted {
+		t.Errorf("status should be updated via the conflicting reactor")
+	}
+
+	updated, err := client.ApisV1().ManifestIntegrityProfiles().Get(context.Background(), "sample-profile", metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if updated.Status.DenyCount != 18 {
+		t.Errorf("unexpected deny count: got: %d\nwant: %d", updated.Status.DenyCount, 18)
+	}
+	if updated.Status.AllowCount != 15 || updated.Status.VerifiedCount != 5 || updated.Status.LastVerifiedTime == "" {
+		t.Errorf("unexpected allowed count: got: %d (verified: %d, last: %s)\nwant: 15 (verified: 5)", updated.Status.AllowCount, updated.Status.VerifiedCount, updated.Status.LastVerifiedTime)
+	}
+	expectedStats := miprofile.DenyStatistics{
+		ByUser:      map[string]int{"user-0": 8, "user-1": 7},
+		ByKind:      map[string]int{"ConfigMap": 15},
+		ByNamespace: map[string]int{"sample-ns": 15},
+		ByReason:    map[string]int{shield.ReasonNoSignature: 15},
+	}
+	if !reflect.DeepEqual(updated.Status.DenyStatistics, expectedStats) {
+		t.Errorf("unexpected deny statistics: got: %v\nwant: %v", updated.Status.DenyStatistics, expectedStats)
+	}
+	if len(updated.Status.Violations) != 10 || updated.Status.Violations[0].Name != "sample-cm-14" {
+		t.Errorf("violations should be the latest 10 events: got: %d events, first: %s", len(updated.Status.Violations), updated.Status.Violations[0].Name)
+	} else if v := updated.Status.Violations[0]; v.User != "user-0" || v.Operation != "CREATE" || v.Reason != shield.ReasonNoSignature {
+		t.Errorf("unexpected violation detail: got: %v", v)
+	}
+	// status of deleted profiles is dropped, so nothing is left
+	if len(recorder.pending) != 0 {
+		t.Errorf("unexpected pending status after flush: got: %v", recorder.pending)
+	}
+}
+
+func TestStatusRecorderFlushKeepsStatusOnUpdateNotFound(t *testing.T) {
+	mip := &miprofile.ManifestIntegrityProfile{ObjectMeta: metav1.ObjectMeta{Name: "sample-profile"}}
+	client := mipfake.NewSimpleClientset(mip)
+
+	// the status subresource is not found while the profile exists
+	statusNotFound := true
+	client.PrependReactor("update", "manifestintegrityprofiles", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if statusNotFound {
+			gr := schema.GroupResource{Group: "apis.integrityshield.io", Resource: "manifestintegrityprofiles"}
+			return true, nil, k8serrors.NewNotFound(gr, "sample-profile")
+		}
+		return false, nil, nil
+	})
+
+	recorder := newStatusRecorder(client.ApisV1().ManifestIntegrityProfiles(), 0)
+	req := admission.Request{AdmissionRequest: admv1.AdmissionRequest{Namespace: "sample-ns", Name: "sample-cm"}}
+	recorder.RecordDenial("sample-profile", req, "no signature found", shield.ReasonNoSignature)
+	recorder.RecordDenial("deleted-profile", req, "no signature found", shield.ReasonNoSignature)
+	recorder.Flush(context.Background())
+	if _, ok := recorder.pending["sample-profile"]; !ok {
+		t.Errorf("pending status should be kept while the profile exists")
+	}
+	if _, ok := recorder.pending["deleted-profile"]; ok {
+		t.Errorf("pending status of deleted profile should be dropped")
+	}
+
+	statusNotFound = false
+	recorder.Flush(context.Background())
+	updated, err := client.ApisV1().ManifestIntegrityProfiles().Get(context.Background(), "sample-profile", metav1.GetOptions{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if updated.Status.DenyCount != 1 {
+		t.Errorf("unexpected deny count: got: %d\nwant: %d", updated.Status.DenyCount, 1)
+	}
+}
+
+func TestStatusRecorderAddKeepsViolationOrder(t *testing.T) {
+	violation := func(name, timestamp string) *miprofile.ViolationDetail {
+		return &miprofile.ViolationDetail{Name: name, Timestamp: timestamp}
+	}
+	recorder := newStatusRecorder(nil, 0)
+	// violations recorded while the failed delta was being written
+	recorder.pending["sample-profile"] = &miprofile.ManifestIntegrityProfileStatus{
+		DenyCount: 2,
+		Violations: []*miprofile.ViolationDetail{
+			violation("cm-4", "2021-08-01 10:00:04"),
+			violation("cm-2", "2021-08-01 10:00:02"),
+		},
+	}
+	failed := &miprofile.ManifestIntegrityProfileStatus{
+		DenyCount: 2,
+		Violations: []*miprofile.ViolationDetail{
+			violation("cm-3", "2021-08-01 10:00:03"),
+			violation("cm-1", "2021-08-01 10:00:01"),
+		},
+	}
+	recorder.add("sample-profile", failed)
+
+	merged := recorder.pending["sample-profile"]
+	if merged.DenyCount != 4 {
+		t.Errorf("unexpected deny count: got: %d\nwant: %d", merged.DenyCount, 4)
+	}
+	names := []string{}
+	for _, v := range merged.Violations {
+		names = append(names, v.Name)
+	}
+	want := []string{"cm-4", "cm-3", "cm-2", "cm-1"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("violations should be newest first: got: %v\nwant: %v", names, want)
+	}
+}
@@ -20,10 +20,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	miprofile "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/apis/manifestintegrityprofile/v1"
 	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
 	"github.com/ghodss/yaml"
 	"github.com/pkg/errors"
@@ -40,6 +41,18 @@ const defaultPodNamespace = "k8s-manifest-sigstore"
 const defaultControllerConfigName = "admission-controller-config"
 const logLevelEnvKey = "LOG_LEVEL"
 
+// webhook paths of the admission controller. Namespaced and cluster scope resources have their own paths
+// so that webhook configurations can have an entry for each scope; the paths without scope serve both.
+const (
+	ValidateResourcePath           = "/validate-resource"
+	ValidateNamespacedResourcePath = "/validate-namespaced-resource"
+	ValidateClusterResourcePath    = "/validate-cluster-resource"
+	ValidateProfilePath            = "/validate-profile"
+	MutateResourcePath             = "/mutate-resource"
+	MutateNamespacedResourcePath   = "/mutate-namespaced-resource"
+	MutateClusterResourcePath      = "/mutate-cluster-resource"
+)
+
 var logLevelMap = map[string]log.Level{
 	"panic": log.PanicLevel,
 	"fatal": log.FatalLevel,
@@ -53,6 +66,8 @@ var logLevelMap = map[string]log.Level{
 type AccumulatedResult struct {
 	Allow   bool
 	Message string
+	// Overridden is a list of profiles whose denials are overridden by an allow-override profile
+	Overridden []string
 }
 
 func init() {
@@ -73,16 +88,20 @@ func init() {
 	log.Info("initialized cosign.")
 }
 
-func ProcessRequest(req admission.Request) admission.Response {
+func ProcessRequest(ctx context.Context, req admission.Request) admission.Response {
 	// load ac2 config
 	config, err := loadAdmissionControllerConfig()
+	if err == nil && config == nil {
+		err = errors.New("admission controller config is empty")
+	}
 	if err != nil {
-		log.Errorf("failed to load admission controller config; %s", err.Error())
-		return admission.Allowed("error but allow for development")
+		// the failure policy of the latest loaded config is used
+		return failureResponse(req, loadLastConfig(), errorReasonConfig, err)
 	}
+	storeLastConfig(config)
 
 	// isScope check
-	inScopeNamespace := config.InScopeNamespaceSelector.Match(req.Namespace)
+	inScopeNamespace := isInScopeNamespace(config, req.Namespace)
 	if !inScopeNamespace {
 		return admission.Allowed("this namespace is out of scope")
 	}
@@ -95,50 +114,20 @@ func ProcessRequest(req admission.Request) admission.Response {
 	// load constraints
 	constraints, err := LoadConstraints()
 	if err != nil {
-		log.Errorf("failed to load constratints; %s", err.Error())
-		return admission.Allowed("error but allow for development")
+		return failureResponse(req, config, errorReasonConstraint, err)
 	}
 
-	results := []shield.ResultFromRequestHandler{}
-
-	for _, constraint := range constraints {
-
-		//match check: kind, namespace, label
-		isMatched := matchCheck(req, constraint.Spec.Match)
-		if !isMatched {
-			r := shield.ResultFromRequestHandler{
-				Allow:   true,
-				Message: "not protected",
-				Profile: constraint.Name,
-			}
-			results = append(results, r)
-			continue
-		}
-
-		// pick parameters from constaint
-		paramObj := GetParametersFromConstraint(constraint.Spec)
-
-		// call request handler & receive result from request handler (allow, message)
-		r := shield.RequestHandler(req, paramObj)
-
-		r.Profile = constraint.Name
-		results = append(results, *r)
-	}
+	// evaluate constraints concurrently until the deadline of the request
+	ctx, cancel := withRequestDeadline(ctx)
+	defer cancel()
+	results, timedOut := evaluateConstraints(ctx, req, constraints, config)
 
 	// accumulate results from constraints
 	ar := getAccumulatedResult(results)
 
-	// mode check
-	isDetectMode := acconfig.CheckIfDetectOnly(config.Mode)
-	if !ar.Allow && isDetectMode {
-		ar.Allow = true
-		msg := "allowed by detection mode: " + ar.Message
-		ar.Message = msg
-	}
-
 	// update status
-	if config.SideEffect.UpdateMIPStatusForDeniedRequest {
-		updateConstraints(isDetectMode, req, results)
+	if config.SideEffect.UpdateMIPStatusForDeniedRequest || config.SideEffect.UpdateMIPStatusForAllowedRequest {
+		updateConstraints(req, results, ar, config.SideEffect)
 	}
 
 	// log
@@ -151,65 +140,121 @@ func ProcessRequest(req admission.Request) admission.Response {
 	}).Info(ar.Message)
 
 	// return admission response
+	var res admission.Response
 	if ar.Allow {
-		return admission.Allowed(ar.Message)
+		res = admission.Allowed(ar.Message)
 	} else {
-		return admission.Denied(ar.Message)
+		res = admission.Denied(ar.Message)
+	}
+	if timedOut {
+		res.Result.Reason = metav1.StatusReason(ReasonVerificationTimeout)
 	}
+	return res
 }
 
 func loadAdmissionControllerConfig() (*acconfig.AdmissionControllerConfig, error) {
-	namespace := os.Getenv("POD_NAMESPACE")
-	if namespace == "" {
-		namespace = defaultPodNamespace
-	}
-	configName := os.Getenv("CONTROLLER_CONFIG_NAME")
-	if configName == "" {
-		configName = defaultControllerConfigName
-	}
-	configKey := os.Getenv("CONTROLLER_CONFIG_KEY")
-	if configKey == "" {
-		configKey = defaultConfigKeyInConfigMap
+	if sharedCache != nil && sharedCache.HasSynced() {
+		return sharedCache.AdmissionControllerConfig()
 	}
+	namespace, configName, configKey := getAdmissionControllerConfigRef()
 	// load
 	config, err := kubeutil.GetKubeConfig()
 	if err != nil {
-		return nil, nil
+		return nil, errors.Wrap(err, "failed to get kube config")
 	}
 	clientset, err := kubeclient.NewForConfig(config)
 	if err != nil {
-		log.Error(err)
-		return nil, nil
+		return nil, errors.Wrap(err, "failed to create a kubernetes clientset")
 	}
 	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.Background(), configName, metav1.GetOptions{})
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("failed to get a configmap `%s` in `%s` namespace", configName, namespace))
 	}
+	return parseAdmissionControllerConfig(cm.Data, configKey)
+}
 
-	cfgBytes, found := cm.Data[configKey]
+func getAdmissionControllerConfigRef() (string, string, string) {
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		namespace = defaultPodNamespace
+	}
+	configName := os.Getenv("CONTROLLER_CONFIG_NAME")
+	if configName == "" {
+		configName = defaultControllerConfigName
+	}
+	configKey := os.Getenv("CONTROLLER_CONFIG_KEY")
+	if configKey == "" {
+		configKey = defaultConfigKeyInConfigMap
+	}
+	return namespace, configName, configKey
+}
+
+func parseAdmissionControllerConfig(data map[string]string, configKey string) (*acconfig.AdmissionControllerConfig, error) {
+	cfgBytes, found := data[configKey]
 	if !found {
 		return nil, errors.New(fmt.Sprintf("`%s` is not found in configmap", configKey))
 	}
 	var sc *acconfig.AdmissionControllerConfig
-	err = yaml.Unmarshal([]byte(cfgBytes), &sc)
+	err := yaml.Unmarshal([]byte(cfgBytes), &sc)
 	if err != nil {
 		return sc, errors.Wrap(err, fmt.Sprintf("failed to unmarshal config.yaml into %T", sc))
 	}
 	return sc, nil
 }
 
-func getAccumulatedResult(results []shield.ResultFromRequestHandler) *AccumulatedResult {
+// getAccumulatedResult combines results of profiles into a single decision.
+//
+//   - Profiles in `disabled` mode are not evaluated, so they do not have results.
+//   - Denials of profiles in `detect` mode never deny the request. If the admission controller config is in
+//     `detect` mode, profiles in `enforce` mode are already evaluated in `detect` mode.
+//   - A matched allow-override profile in `enforce` mode overrides denials of profiles
+//     whose priority is the same as or lower than the priority of the override profile.
+//   - The request is denied if any denial of profiles in `enforce` mode remains, otherwise it is allowed.
+//
+// Messages are sorted by priority (higher first) and then by profile name,
+// so that they do not depend on the order of profiles.
+func getAccumulatedResult(results []ProfileResult) *AccumulatedResult {
+	sorted := make([]ProfileResult, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority > sorted[j].Priority
+		}
+		return sorted[i].Profile < sorted[j].Profile
+	})
+
+	// the highest priority of matched allow-override profiles
+	overrideProfile := ""
+	overridePriority := 0
+	for _, result := range sorted {
+		if result.AllowOverride && result.Matched && result.Mode == miprofile.ProfileModeEnforce {
+			overrideProfile = result.Profile
+			overridePriority = result.Priority
+			break
+		}
+	}
+
 	denyMessages := []string{}
+	detectMessages := []string{}
+	overriddenMessages := []string{}
 	allowMessages := []string{}
 	accumulatedRes := &AccumulatedResult{}
-	for _, result := range results {
-		if !result.Allow {
-			msg := "[" + result.Profile + "]" + result.Message
-			denyMessages = append(denyMessages, msg)
-		} else {
-			msg := "[" + result.Profile + "]" + result.Message
+	for _, result := range sorted {
+		msg := "[" + result.Profile + "]" + result.Message
+		if result.Allow {
 			allowMessages = append(allowMessages, msg)
+			continue
 		}
+		if result.Mode == miprofile.ProfileModeDetect {
+			detectMessages = append(detectMessages, msg)
+			continue
+		}
+		if overrideProfile != "" && result.Priority <= overridePriority {
+			overriddenMessages = append(overriddenMessages, msg)
+			accumulatedRes.Overridden = append(accumulatedRes.Overridden, result.Profile)
+			continue
+		}
+		denyMessages = append(denyMessages, msg)
 	}
 	if len(denyMessages) != 0 {
 		accumulatedRes.Allow = false
@@ -217,6 +262,16 @@ func getAccumulatedResult(results []shield.ResultFromRequestHandler) *Accumulate
 		return accumulatedRes
 	}
 	accumulatedRes.Allow = true
-	accumulatedRes.Message = strings.Join(allowMessages, ";")
+	messages := []string{}
+	if len(overriddenMessages) != 0 {
+		messages = append(messages, fmt.Sprintf("allowed by override profile `%s`: %s", overrideProfile, strings.Join(overriddenMessages, ";")))
+	}
+	if len(detectMessages) != 0 {
+		messages = append(messages, "allowed by detection mode: "+strings.Join(detectMessages, ";"))
+	}
+	if len(messages) == 0 {
+		messages = allowMessages
+	}
+	accumulatedRes.Message = strings.Join(messages, ";")
 	return accumulatedRes
 }
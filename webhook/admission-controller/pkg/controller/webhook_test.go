@@ -0,0 +1,279 @@
+//
+// Copyright 2020 IBM Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	k8smnfconfig "github.com/IBM/integrity-shield/shield/pkg/config"
+	"github.com/IBM/integrity-shield/shield/pkg/shield"
+	miprofile "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/apis/manifestintegrityprofile/v1"
+	acconfig "github.com/IBM/integrity-shield/webhook/admission-controller/pkg/config"
+	admv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func testProfileResult(profile string, allow bool, mode string, priority int, override bool) ProfileResult {
+	pr := ProfileResult{
+		Mode:          mode,
+		Priority:      priority,
+		AllowOverride: override,
+		Matched:       true,
+	}
+	pr.Profile = profile
+	pr.Allow = allow
+	if allow {
+		pr.Message = "allowed"
+	} else {
+		pr.Message = "denied"
+	}
+	return pr
+}
+
+func TestGetAccumulatedResult(t *testing.T) {
+	enforce := miprofile.ProfileModeEnforce
+	detect := miprofile.ProfileModeDetect
+	testcases := []struct {
+		name       string
+		results    []ProfileResult
+		allow      bool
+		message    string
+		overridden []string
+	}{
+		{
+			name: "all allowed",
+			results: []ProfileResult{
+				testProfileResult("b", true, enforce, 0, false),
+				testProfileResult("a", true, enforce, 0, false),
+			},
+			allow:   true,
+			message: "[a]allowed;[b]allowed",
+		},
+		{
+			name: "denied by enforce profile",
+			results: []ProfileResult{
+				testProfileResult("a", true, enforce, 0, false),
+				testProfileResult("b", false, enforce, 0, false),
+			},
+			allow:   false,
+			message: "[b]denied",
+		},
+		{
+			name: "denial of detect profile does not deny",
+			results: []ProfileResult{
+				testProfileResult("a", true, enforce, 0, false),
+				testProfileResult("b", false, detect, 0, false),
+			},
+			allow:   true,
+			message: "allowed by detection mode: [b]denied",
+		},
+		{
+			name: "override profile takes precedence over the same or lower priority",
+			results: []ProfileResult{
+				testProfileResult("a", false, enforce, 0, false),
+				testProfileResult("b", false, enforce, 10, false),
+				testProfileResult("break-glass", true, enforce, 10, true),
+			},
+			allow:      true,
+			message:    "allowed by override profile `break-glass`: [b]denied;[a]denied",
+			overridden: []string{"b", "a"},
+		},
+		{
+			name: "override profile does not take precedence over higher priority",
+			results: []ProfileResult{
+				testProfileResult("a", false, enforce, 0, false),
+				testProfileResult("b", false, enforce, 20, false),
+				testProfileResult("break-glass", true, enforce, 10, true),
+			},
+			allow:      false,
+			message:    "[b]denied",
+			overridden: []string{"a"},
+		},
+		{
+			name: "override profile in detect mode does not override",
+			results: []ProfileResult{
+				testProfileResult("a", false, enforce, 0, false),
+				testProfileResult("break-glass", true, detect, 10, true),
+			},
+			allow:   false,
+			message: "[a]denied",
+		},
+	}
+	for _, tc := range testcases {
+		ar := getAccumulatedResult(tc.results)
+		if ar.Allow != tc.allow || ar.Message != tc.message {
+			t.Errorf("%s: got: %v, %s\nwant: %v, %s", tc.name, ar.Allow, ar.Message, tc.allow, tc.message)
+			continue
+		}
+		if strings.Join(ar.Overridden, ",") != strings.Join(tc.overridden, ",") {
+			t.Errorf("%s: overridden profiles: got: %v\nwant: %v", tc.name, ar.Overridden, tc.overridden)
+		}
+	}
+}
+
+func TestEvaluateConstraintsModes(t *testing.T) {
+	req := admission.Request{AdmissionRequest: admv1.AdmissionRequest{
+		Namespace: "sample-ns",
+		Name:      "sample-cm",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+	}}
+	profile := func(name, mode string, override bool, namespaces ...string) miprofile.ManifestIntegrityProfile {
+		mip := miprofile.ManifestIntegrityProfile{}
+		mip.Name = name
+		mip.Spec.Mode = mode
+		mip.Spec.AllowOverride = override
+		mip.Spec.Match.Namespaces = namespaces
+		return mip
+	}
+	constraints := []miprofile.ManifestIntegrityProfile{
+		profile("disabled", miprofile.ProfileModeDisabled, false, "sample-ns"),
+		profile("other-ns", "", false, "other-ns"),
+		profile("break-glass", "", true, "sample-ns"),
+		profile("enforced", miprofile.ProfileModeEnforce, false, "other-ns"),
+	}
+	config := &acconfig.AdmissionControllerConfig{Mode: "detect"}
+	results, timedOut := evaluateConstraints(context.Background(), req, constraints, config)
+	if timedOut {
+		t.Errorf("evaluation should not time out")
+		return
+	}
+	if len(results) != 3 {
+		t.Errorf("disabled profile should not have a result: got: %v", results)
+		return
+	}
+	if results[0].Profile != "other-ns" || results[0].Matched || !results[0].Allow {
+		t.Errorf("unexpected result of unmatched profile: got: %v", results[0])
+	}
+	if results[1].Profile != "break-glass" || !results[1].Matched || !results[1].Allow {
+		t.Errorf("unexpected result of override profile: got: %v", results[1])
+	}
+	// the mode of admission controller config is the default of profiles
+	if results[1].Mode != miprofile.ProfileModeDetect {
+		t.Errorf("unexpected mode: got: %s\nwant: %s", results[1].Mode, miprofile.ProfileModeDetect)
+	}
+	// detect mode of admission controller config caps profiles in enforce mode
+	if results[2].Profile != "enforced" || results[2].Mode != miprofile.ProfileModeDetect {
+		t.Errorf("unexpected mode of enforce profile: got: %v\nwant: %s", results[2], miprofile.ProfileModeDetect)
+	}
+	denied := testProfileResult("enforced", false, results[2].Mode, 0, false)
+	if ar := getAccumulatedResult([]ProfileResult{denied}); !ar.Allow {
+		t.Errorf("request should not be denied in detect mode: got: %v", ar.Message)
+	}
+}
+
+func TestEvaluateConstraintsTimeout(t *testing.T) {
+	setFailurePolicyEnv(t, "")
+	// occupy all slots so that the request handler cannot start before the deadline
+	for i := 0; i < cap(handlerSemaphore); i++ {
+		handlerSemaphore <- struct{}{}
+	}
+	defer func() {
+		for i := 0; i < cap(handlerSemaphore); i++ {
+			<-handlerSemaphore
+		}
+	}()
+
+	req := admission.Request{AdmissionRequest: admv1.AdmissionRequest{
+		Namespace: "sample-ns",
+		Name:      "sample-cm",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+	}}
+	mip := miprofile.ManifestIntegrityProfile{}
+	mip.Name = "sample-profile"
+	mip.Spec.Match.Namespaces = []string{"sample-ns"}
+	constraints := []miprofile.ManifestIntegrityProfile{mip}
+
+	testcases := []struct {
+		name          string
+		failurePolicy string
+		allow         bool
+	}{
+		{name: "fail open", failurePolicy: acconfig.FailurePolicyOpen, allow: true},
+		{name: "fail closed", failurePolicy: acconfig.FailurePolicyClosed, allow: false},
+	}
+	for _, tc := range testcases {
+		config := &acconfig.AdmissionControllerConfig{FailurePolicy: tc.failurePolicy}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		results, timedOut := evaluateConstraints(ctx, req, constraints, config)
+		cancel()
+		if !timedOut {
+			t.Errorf("%s: evaluation should time out", tc.name)
+			continue
+		}
+		if len(results) != 1 {
+			t.Errorf("%s: unexpected results: got: %v", tc.name, results)
+			continue
+		}
+		r := results[0]
+		if r.Profile != "sample-profile" || r.Allow != tc.allow || r.Reason != ReasonVerificationTimeout {
+			t.Errorf("%s: got: %v\nwant: allow %v with reason %s", tc.name, r, tc.allow, ReasonVerificationTimeout)
+		}
+	}
+}
+
+func TestEvaluateConstraintsReleaseSlotsAtDeadline(t *testing.T) {
+	// request handler which does not finish before the deadline
+	release := make(chan struct{})
+	defer close(release)
+	orgRequestHandler := requestHandler
+	requestHandler = func(req admission.Request, paramObj *k8smnfconfig.ParameterObject) *shield.ResultFromRequestHandler {
+		<-release
+		return &shield.ResultFromRequestHandler{Allow: true}
+	}
+	defer func() { requestHandler = orgRequestHandler }()
+
+	req := admission.Request{AdmissionRequest: admv1.AdmissionRequest{
+		Namespace: "sample-ns",
+		Name:      "sample-cm",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+	}}
+	mip := miprofile.ManifestIntegrityProfile{}
+	mip.Name = "sample-profile"
+	mip.Spec.Match.Namespaces = []string{"sample-ns"}
+	constraints := []miprofile.ManifestIntegrityProfile{mip, mip}
+	config := &acconfig.AdmissionControllerConfig{FailurePolicy: acconfig.FailurePolicyOpen}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, timedOut := evaluateConstraints(ctx, req, constraints, config)
+	cancel()
+	if !timedOut {
+		t.Errorf("evaluation should time out")
+		return
+	}
+	// abandoned handlers must not keep their slots
+	deadline := time.Now().Add(time.Second)
+	for len(handlerSemaphore) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := len(handlerSemaphore); n != 0 {
+		t.Errorf("slots are held by handlers of the timed out request: got: %d\nwant: 0", n)
+	}
+}
+
+func TestTimeoutResult(t *testing.T) {
+	req := admission.Request{AdmissionRequest: admv1.AdmissionRequest{Namespace: "sample-ns"}}
+	config := &acconfig.AdmissionControllerConfig{FailurePolicy: acconfig.FailurePolicyClosed}
+	r := timeoutResult(req, "profile", config)
+	want := shield.ResultFromRequestHandler{Allow: false, Profile: "profile"}
+	if r.Allow != want.Allow || r.Profile != want.Profile || !strings.HasPrefix(r.Message, ReasonVerificationTimeout) {
+		t.Errorf("got: %v\nwant: %v", r, want)
+	}
+}